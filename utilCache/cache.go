@@ -40,12 +40,13 @@ func (c *Cache) RegisterStoreRedis(addr, pwd string, dbNum int) *Cache {
 	c.cacheManager.DefaultUse(goredis.Name)
 	return c
 }
+
+// RegisterStoreRedisWithClient 所有模式都使用基于 rc 的驱动，支持哨兵、集群、TLS 和 ACL 用户名
+// 值使用 gob 编码，之前通过 goredis 驱动写入的值无法解码，按不存在处理
 func (c *Cache) RegisterStoreRedisWithClient(rc *utilRedis.RedisClient) *Cache {
 	c.cacheManager.UnregisterAll()
-	goRedis := goredis.Connect(rc.Conf())
-	goRedis.WithOptions(cache.WithPrefix(c.cacheKeyPrefix))
-	c.cacheManager.Register(goredis.Name, goRedis)
-	c.cacheManager.DefaultUse(goredis.Name)
+	c.cacheManager.Register(redisStoreName, newRedisStore(rc, c.cacheKeyPrefix))
+	c.cacheManager.DefaultUse(redisStoreName)
 	return c
 }
 
@@ -0,0 +1,125 @@
+package utilCache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/hilaoyu/go-utils/utilRedis"
+)
+
+// redisStore 基于 utilRedis.RedisClient 的缓存驱动，支持单机、哨兵和集群模式
+// 值使用 gob 编码，可以保留 int / int64 / bool 等类型，自定义结构体需要先 gob.Register
+type redisStore struct {
+	rc     *utilRedis.RedisClient
+	prefix string
+}
+
+const redisStoreName = "utilRedis"
+
+type redisStoreValue struct {
+	V interface{}
+}
+
+func newRedisStore(rc *utilRedis.RedisClient, prefix string) *redisStore {
+	return &redisStore{rc: rc, prefix: prefix}
+}
+
+func (s *redisStore) key(key string) string {
+	return s.prefix + key
+}
+
+func (s *redisStore) encode(val interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(&redisStoreValue{V: val}); nil != err {
+		return nil, fmt.Errorf("cache encode error: %+v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *redisStore) decode(data []byte) interface{} {
+	v := redisStoreValue{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v); nil != err {
+		return nil
+	}
+	return v.V
+}
+
+func (s *redisStore) Has(key string) bool {
+	n, err := s.rc.Client.Exists(s.rc.CtxDefault(), s.key(key)).Result()
+	return nil == err && n > 0
+}
+
+func (s *redisStore) Get(key string) interface{} {
+	data, err := s.rc.Client.Get(s.rc.CtxDefault(), s.key(key)).Bytes()
+	if nil != err {
+		return nil
+	}
+	return s.decode(data)
+}
+
+func (s *redisStore) Set(key string, val interface{}, ttl time.Duration) error {
+	data, err := s.encode(val)
+	if nil != err {
+		return err
+	}
+	return s.rc.Client.Set(s.rc.CtxDefault(), s.key(key), data, ttl).Err()
+}
+
+func (s *redisStore) Del(key string) error {
+	return s.rc.Client.Del(s.rc.CtxDefault(), s.key(key)).Err()
+}
+
+// GetMulti 使用 pipeline 逐个读取，集群模式下 key 可以在不同的槽
+func (s *redisStore) GetMulti(keys []string) map[string]interface{} {
+	values := map[string]interface{}{}
+	pipe := s.rc.Client.Pipeline()
+	for _, key := range keys {
+		pipe.Get(s.rc.CtxDefault(), s.key(key))
+	}
+	cmds, _ := pipe.Exec(s.rc.CtxDefault())
+	for i, cmd := range cmds {
+		data, err := cmd.(interface{ Bytes() ([]byte, error) }).Bytes()
+		if nil != err {
+			continue
+		}
+		values[keys[i]] = s.decode(data)
+	}
+	return values
+}
+
+func (s *redisStore) SetMulti(values map[string]interface{}, ttl time.Duration) error {
+	pipe := s.rc.Client.Pipeline()
+	for key, val := range values {
+		data, err := s.encode(val)
+		if nil != err {
+			return err
+		}
+		pipe.Set(s.rc.CtxDefault(), s.key(key), data, ttl)
+	}
+	_, err := pipe.Exec(s.rc.CtxDefault())
+	return err
+}
+
+func (s *redisStore) DelMulti(keys []string) error {
+	pipe := s.rc.Client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(s.rc.CtxDefault(), s.key(key))
+	}
+	_, err := pipe.Exec(s.rc.CtxDefault())
+	return err
+}
+
+// Clear 删除前缀下的所有 key，没有前缀时不执行，避免清空整个库
+func (s *redisStore) Clear() error {
+	if "" == s.prefix {
+		return fmt.Errorf("cache clear requires a key prefix")
+	}
+	return s.rc.DelByPattern(s.prefix + "*")
+}
+
+// Close 客户端由调用方管理，这里不关闭
+func (s *redisStore) Close() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package utilCache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hilaoyu/go-utils/utilRedis"
+)
+
+func newTestRedisCache(t *testing.T, conf utilRedis.RedisConfig) (*miniredis.Miniredis, *Cache) {
+	mr := miniredis.RunT(t)
+	conf.Addrs = []string{mr.Addr()}
+	rc, err := utilRedis.NewRedisClientWithConfig(conf)
+	if nil != err {
+		t.Fatalf("redis: %+v", err)
+	}
+	t.Cleanup(func() { _ = rc.Close() })
+	return mr, NewCache("test:", time.Minute).RegisterStoreRedisWithClient(rc)
+}
+
+func TestRedisStore(t *testing.T) {
+	// 单机和集群模式使用同一个驱动
+	for _, conf := range []utilRedis.RedisConfig{{}, {Mode: utilRedis.REDIS_MODE_CLUSTER}} {
+		mr, c := newTestRedisCache(t, conf)
+		if err := c.Set("int", 42); nil != err {
+			t.Fatalf("set: %+v", err)
+		}
+		_ = c.Set("str", "v", time.Second)
+		_ = c.SetMulti(map[string]interface{}{"bool": true, "int64": int64(7)})
+
+		if !mr.Exists("test:int") || time.Second != mr.TTL("test:str") || time.Minute != mr.TTL("test:bool") {
+			t.Fatalf("%s: key 应带前缀和过期时间: %v", conf.Mode, mr.Keys())
+		}
+		if v, ok := c.GetInt("int"); !ok || 42 != v {
+			t.Fatalf("%s: int = %v", conf.Mode, v)
+		}
+		if v, ok := c.GetInt64("int64"); !ok || 7 != v {
+			t.Fatalf("%s: int64 = %v", conf.Mode, v)
+		}
+		if v, ok := c.GetString("str"); !ok || "v" != v || !c.GetBool("bool") || !c.Has("str") {
+			t.Fatalf("%s: str = %v", conf.Mode, v)
+		}
+		values := c.GetMulti([]string{"int", "str", "none"})
+		if 2 != len(values) || 42 != values["int"] {
+			t.Fatalf("%s: get multi = %v", conf.Mode, values)
+		}
+
+		_ = c.DelMulti([]string{"int", "str"})
+		if c.Has("int") || !c.Has("bool") {
+			t.Fatalf("%s: del multi", conf.Mode)
+		}
+		_ = mr.Set("other", "v")
+		c.ClearAll()
+		if keys := mr.Keys(); 1 != len(keys) || "other" != keys[0] {
+			t.Fatalf("%s: 只应清除前缀下的 key: %v", conf.Mode, keys)
+		}
+	}
+}
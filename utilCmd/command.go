@@ -0,0 +1,344 @@
+package utilCmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandResult 命令执行结果，被信号结束时 ExitCode 为 -1，Signal 为信号名
+type CommandResult struct {
+	Command         string        `json:"command"`
+	Pid             int           `json:"pid"`
+	ExitCode        int           `json:"exit_code"`
+	Signal          string        `json:"signal,omitempty"`
+	Stdout          string        `json:"stdout"`
+	Stderr          string        `json:"stderr"`
+	StdoutTruncated bool          `json:"stdout_truncated,omitempty"`
+	StderrTruncated bool          `json:"stderr_truncated,omitempty"`
+	TimedOut        bool          `json:"timed_out,omitempty"`
+	StartedAt       time.Time     `json:"started_at"`
+	Duration        time.Duration `json:"duration"`
+}
+
+func (r *CommandResult) Success() bool {
+	return 0 == r.ExitCode && "" == r.Signal && !r.TimedOut
+}
+
+// Output 去掉首尾空白的 stdout
+func (r *CommandResult) Output() string {
+	return strings.TrimSpace(r.Stdout)
+}
+
+// Command 命令构建器，默认继承当前进程的环境变量，子进程在独立的进程组中，超时或 ctx 取消时结束整个进程组
+type Command struct {
+	name        string
+	args        []string
+	dir         string
+	env         []string
+	inheritEnv  bool
+	stdin       io.Reader
+	timeout     time.Duration
+	killGrace   time.Duration
+	outputLimit int
+	onStdout    func(line string)
+	onStderr    func(line string)
+	discardOut  bool
+}
+
+func NewCommand(name string, args ...string) *Command {
+	return &Command{
+		name:        name,
+		args:        args,
+		inheritEnv:  true,
+		killGrace:   time.Duration(5) * time.Second,
+		outputLimit: 16 << 20,
+	}
+}
+
+func (c *Command) SetDir(dir string) *Command {
+	c.dir = dir
+	return c
+}
+
+// SetEnv 追加 KEY=VALUE 形式的环境变量，同名时覆盖继承的值
+func (c *Command) SetEnv(env ...string) *Command {
+	c.env = append(c.env, env...)
+	return c
+}
+
+func (c *Command) AddEnv(key string, value string) *Command {
+	c.env = append(c.env, key+"="+value)
+	return c
+}
+
+// SetInheritEnv false 时只使用 SetEnv 设置的环境变量
+func (c *Command) SetInheritEnv(inherit bool) *Command {
+	c.inheritEnv = inherit
+	return c
+}
+
+func (c *Command) SetStdin(stdin io.Reader) *Command {
+	c.stdin = stdin
+	return c
+}
+
+func (c *Command) SetStdinString(stdin string) *Command {
+	c.stdin = strings.NewReader(stdin)
+	return c
+}
+
+// SetTimeout 超过时间后结束进程组，<=0 不限制
+func (c *Command) SetTimeout(timeout time.Duration) *Command {
+	c.timeout = timeout
+	return c
+}
+
+// SetKillGrace 结束时先发送 SIGTERM，等待 grace 后发送 SIGKILL，<=0 时直接 SIGKILL
+func (c *Command) SetKillGrace(grace time.Duration) *Command {
+	c.killGrace = grace
+	return c
+}
+
+// SetOutputLimit stdout 和 stderr 各自最多保留的字节数，超出部分丢弃并标记 Truncated，<=0 不限制
+func (c *Command) SetOutputLimit(limit int) *Command {
+	c.outputLimit = limit
+	return c
+}
+
+// OnStdoutLine 逐行回调 stdout，不含换行符
+func (c *Command) OnStdoutLine(fn func(line string)) *Command {
+	c.onStdout = fn
+	return c
+}
+
+func (c *Command) OnStderrLine(fn func(line string)) *Command {
+	c.onStderr = fn
+	return c
+}
+
+// SetDiscardOutput 不保留输出，只使用行回调
+func (c *Command) SetDiscardOutput(discard bool) *Command {
+	c.discardOut = discard
+	return c
+}
+
+func (c *Command) String() string {
+	return strings.Join(append([]string{c.name}, c.args...), " ")
+}
+
+// Run 执行并等待结束，非 0 退出码不作为 error 返回，通过 CommandResult 判断
+func (c *Command) Run(ctx context.Context) (result *CommandResult, err error) {
+	process, err := c.Start(ctx)
+	if nil != err {
+		return
+	}
+	return process.Wait()
+}
+
+// CommandProcess 已启动的命令
+type CommandProcess struct {
+	command   *Command
+	cmd       *exec.Cmd
+	stdout    *commandOutput
+	stderr    *commandOutput
+	startedAt time.Time
+	timedOut  bool
+	stop      chan struct{}
+	killed    chan struct{}
+	waitOnce  sync.Once
+	result    *CommandResult
+	err       error
+	locker    sync.Mutex
+}
+
+func (c *Command) Start(ctx context.Context) (process *CommandProcess, err error) {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	if "" == c.name {
+		return nil, fmt.Errorf("command name can't be empty")
+	}
+	cmd := buildExec(c.name, c.args...)
+	cmd.Dir = c.dir
+	if c.inheritEnv {
+		cmd.Env = append(os.Environ(), c.env...)
+	} else {
+		cmd.Env = append([]string{}, c.env...)
+	}
+	cmd.Stdin = c.stdin
+	setProcessGroup(cmd)
+	// 孙进程持有输出管道时，进程结束后最多再等待 1 秒
+	cmd.WaitDelay = time.Second
+
+	process = &CommandProcess{
+		command: c,
+		cmd:     cmd,
+		stdout:  newCommandOutput(c.outputLimit, c.onStdout, c.discardOut),
+		stderr:  newCommandOutput(c.outputLimit, c.onStderr, c.discardOut),
+		stop:    make(chan struct{}),
+		killed:  make(chan struct{}),
+	}
+	cmd.Stdout = process.stdout
+	cmd.Stderr = process.stderr
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		go func() {
+			<-process.stop
+			cancel()
+		}()
+	}
+	if err = ctx.Err(); nil != err {
+		close(process.stop)
+		return nil, fmt.Errorf("command %s: %+v", c.name, err)
+	}
+	process.startedAt = time.Now()
+	if err = cmd.Start(); nil != err {
+		close(process.stop)
+		return nil, fmt.Errorf("command %s start error: %+v", c.name, err)
+	}
+	go process.watch(ctx)
+	return
+}
+
+func (p *CommandProcess) watch(ctx context.Context) {
+	defer close(p.killed)
+	select {
+	case <-p.stop:
+		return
+	case <-ctx.Done():
+	}
+	// 只有超时(包括上层 ctx 的截止时间)才算 TimedOut，主动取消不算
+	p.locker.Lock()
+	p.timedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	p.locker.Unlock()
+	if p.command.killGrace > 0 {
+		_ = signalProcessGroup(p.cmd.Process, false)
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(p.command.killGrace):
+		}
+	}
+	_ = signalProcessGroup(p.cmd.Process, true)
+}
+
+func (p *CommandProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+// Signal 向主进程发送信号
+func (p *CommandProcess) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+// Kill 立即结束整个进程组
+func (p *CommandProcess) Kill() error {
+	return signalProcessGroup(p.cmd.Process, true)
+}
+
+// Wait 等待进程结束，可以多次调用
+func (p *CommandProcess) Wait() (result *CommandResult, err error) {
+	p.waitOnce.Do(func() {
+		waitErr := p.cmd.Wait()
+		close(p.stop)
+		<-p.killed
+		p.stdout.flush()
+		p.stderr.flush()
+
+		r := &CommandResult{
+			Command:         p.cmd.String(),
+			Pid:             p.cmd.Process.Pid,
+			ExitCode:        -1,
+			Stdout:          p.stdout.String(),
+			Stderr:          p.stderr.String(),
+			StdoutTruncated: p.stdout.truncated,
+			StderrTruncated: p.stderr.truncated,
+			StartedAt:       p.startedAt,
+			Duration:        time.Since(p.startedAt),
+		}
+		p.locker.Lock()
+		r.TimedOut = p.timedOut
+		p.locker.Unlock()
+		if nil != p.cmd.ProcessState {
+			r.ExitCode = p.cmd.ProcessState.ExitCode()
+			r.Signal = exitSignal(p.cmd.ProcessState)
+		}
+		p.result = r
+		// 退出码和信号记录在结果中，只有无法等待进程时返回错误
+		if nil != waitErr && nil == p.cmd.ProcessState {
+			p.err = fmt.Errorf("command %s wait error: %+v", p.command.name, waitErr)
+		}
+	})
+	return p.result, p.err
+}
+
+// commandOutput 保存输出(可限制大小)并按行回调
+type commandOutput struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+	onLine    func(line string)
+	discard   bool
+	partial   []byte
+	locker    sync.Mutex
+}
+
+func newCommandOutput(limit int, onLine func(line string), discard bool) *commandOutput {
+	return &commandOutput{limit: limit, onLine: onLine, discard: discard}
+}
+
+func (o *commandOutput) Write(b []byte) (n int, err error) {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	if !o.discard {
+		keep := b
+		if o.limit > 0 && o.buf.Len()+len(keep) > o.limit {
+			keep = keep[:o.limit-o.buf.Len()]
+			o.truncated = true
+		}
+		o.buf.Write(keep)
+	}
+	if nil != o.onLine {
+		data := append(o.partial, b...)
+		for {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			o.onLine(strings.TrimSuffix(string(data[:i]), "\r"))
+			data = data[i+1:]
+		}
+		o.partial = append([]byte{}, data...)
+		// 没有换行的超长行直接回调，避免无限增长
+		if o.limit > 0 && len(o.partial) > o.limit {
+			o.onLine(string(o.partial))
+			o.partial = nil
+		}
+	}
+	return len(b), nil
+}
+
+func (o *commandOutput) flush() {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	if nil != o.onLine && len(o.partial) > 0 {
+		o.onLine(strings.TrimSuffix(string(o.partial), "\r"))
+		o.partial = nil
+	}
+}
+
+func (o *commandOutput) String() string {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	return o.buf.String()
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package utilCmd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCommandTimedOut(t *testing.T) {
+	result, err := NewCommand("sleep", "5").SetTimeout(100 * time.Millisecond).Run(context.Background())
+	if nil != err {
+		t.Fatalf("run: %+v", err)
+	}
+	if !result.TimedOut || result.Success() {
+		t.Fatalf("命令超时应设置 TimedOut: %+v", result)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result, err = NewCommand("sleep", "5").Run(ctx)
+	if nil != err {
+		t.Fatalf("run: %+v", err)
+	}
+	if !result.TimedOut {
+		t.Fatalf("上层 ctx 超时应设置 TimedOut: %+v", result)
+	}
+}
+
+func TestCommandCanceledIsNotTimedOut(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	result, err := NewCommand("sleep", "5").SetTimeout(time.Minute).Run(ctx)
+	if nil != err {
+		t.Fatalf("run: %+v", err)
+	}
+	if result.TimedOut || result.Success() {
+		t.Fatalf("主动取消不应设置 TimedOut: %+v", result)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+
+package utilCmd
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup kill 为 false 时发送 SIGTERM
+func signalProcessGroup(process *os.Process, kill bool) error {
+	if nil == process {
+		return nil
+	}
+	sig := syscall.SIGTERM
+	if kill {
+		sig = syscall.SIGKILL
+	}
+	if err := syscall.Kill(-process.Pid, sig); nil != err {
+		return process.Signal(sig)
+	}
+	return nil
+}
+
+func exitSignal(state *os.ProcessState) string {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal().String()
+	}
+	return ""
+}
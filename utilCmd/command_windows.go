@@ -0,0 +1,33 @@
+//go:build windows
+
+package utilCmd
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalProcessGroup Windows 没有 SIGTERM，使用 taskkill /T 结束进程树
+func signalProcessGroup(process *os.Process, kill bool) error {
+	if nil == process {
+		return nil
+	}
+	args := []string{"/T", "/PID", strconv.Itoa(process.Pid)}
+	if kill {
+		args = append([]string{"/F"}, args...)
+	}
+	if err := exec.Command("taskkill", args...).Run(); nil != err && kill {
+		return process.Kill()
+	}
+	return nil
+}
+
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
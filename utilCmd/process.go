@@ -0,0 +1,136 @@
+package utilCmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hilaoyu/go-utils/utils"
+)
+
+type ProcessInfo struct {
+	Pid     int    `json:"pid"`
+	Name    string `json:"name"`
+	Cmdline string `json:"cmdline"`
+}
+
+// FindProcesses 查找进程名等于 name 或命令行包含 name 的进程，不包含当前进程
+// Linux 读取 /proc，其它系统使用 ps / tasklist 的输出在程序内匹配，不经过 shell
+func FindProcesses(name string) (processes []ProcessInfo, err error) {
+	if "" == name {
+		return nil, fmt.Errorf("process name can't be empty")
+	}
+	var all []ProcessInfo
+	switch {
+	case "windows" == utils.RunningOs("windows"):
+		all, err = listProcessesTasklist()
+	case isDir("/proc/self"):
+		all, err = listProcessesProc("/proc")
+	default:
+		all, err = listProcessesPs()
+	}
+	if nil != err {
+		return
+	}
+	self := os.Getpid()
+	for _, p := range all {
+		if p.Pid == self {
+			continue
+		}
+		if p.Name == name || strings.Contains(p.Cmdline, name) {
+			processes = append(processes, p)
+		}
+	}
+	return
+}
+
+// ListProcesses 列出所有进程
+func ListProcesses() ([]ProcessInfo, error) {
+	switch {
+	case "windows" == utils.RunningOs("windows"):
+		return listProcessesTasklist()
+	case isDir("/proc/self"):
+		return listProcessesProc("/proc")
+	}
+	return listProcessesPs()
+}
+
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return nil == err && info.IsDir()
+}
+
+func listProcessesProc(root string) (processes []ProcessInfo, err error) {
+	entries, err := os.ReadDir(root)
+	if nil != err {
+		return nil, fmt.Errorf("read %s error: %+v", root, err)
+	}
+	for _, entry := range entries {
+		pid, e := strconv.Atoi(entry.Name())
+		if nil != e || !entry.IsDir() {
+			continue
+		}
+		// 进程可能在遍历过程中退出，读取失败时跳过
+		comm, e := os.ReadFile(filepath.Join(root, entry.Name(), "comm"))
+		if nil != e {
+			continue
+		}
+		cmdline, _ := os.ReadFile(filepath.Join(root, entry.Name(), "cmdline"))
+		processes = append(processes, ProcessInfo{
+			Pid:     pid,
+			Name:    strings.TrimSpace(string(comm)),
+			Cmdline: strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '}))),
+		})
+	}
+	return
+}
+
+func listProcessesPs() (processes []ProcessInfo, err error) {
+	out, err := exec.Command("ps", "-axo", "pid=,comm=,args=").Output()
+	if nil != err {
+		return nil, fmt.Errorf("ps error: %+v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		pid, e := strconv.Atoi(fields[0])
+		if nil != e {
+			continue
+		}
+		processes = append(processes, ProcessInfo{
+			Pid:     pid,
+			Name:    filepath.Base(fields[1]),
+			Cmdline: strings.Join(fields[2:], " "),
+		})
+	}
+	return
+}
+
+func listProcessesTasklist() (processes []ProcessInfo, err error) {
+	out, err := exec.Command("tasklist", "/FO", "CSV", "/NH").Output()
+	if nil != err {
+		return nil, fmt.Errorf("tasklist error: %+v", err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if nil != err {
+		return nil, fmt.Errorf("parse tasklist error: %+v", err)
+	}
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		pid, e := strconv.Atoi(record[1])
+		if nil != e {
+			continue
+		}
+		processes = append(processes, ProcessInfo{Pid: pid, Name: record[0], Cmdline: record[0]})
+	}
+	return
+}
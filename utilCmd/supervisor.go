@@ -0,0 +1,529 @@
+package utilCmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hilaoyu/go-utils/utilLogger"
+)
+
+const (
+	SUPERVISOR_RESTART_ALWAYS     = "always"
+	SUPERVISOR_RESTART_ON_FAILURE = "on-failure"
+	SUPERVISOR_RESTART_NEVER      = "never"
+
+	SUPERVISOR_STATE_STARTING = "starting"
+	SUPERVISOR_STATE_RUNNING  = "running"
+	SUPERVISOR_STATE_BACKOFF  = "backoff"
+	SUPERVISOR_STATE_STOPPING = "stopping"
+	SUPERVISOR_STATE_STOPPED  = "stopped"
+	SUPERVISOR_STATE_EXITED   = "exited"
+	SUPERVISOR_STATE_FAILED   = "failed"
+)
+
+// SupervisorHealthCheck 检查子进程是否正常，返回 error 视为一次失败
+type SupervisorHealthCheck func(ctx context.Context, pid int) error
+
+type SupervisorStatus struct {
+	Name         string        `json:"name"`
+	State        string        `json:"state"`
+	Pid          int           `json:"pid"`
+	StartedAt    time.Time     `json:"started_at"`
+	Uptime       time.Duration `json:"uptime"`
+	Restarts     int           `json:"restarts"`
+	LastExitCode int           `json:"last_exit_code"`
+	LastSignal   string        `json:"last_signal,omitempty"`
+	LastExitAt   time.Time     `json:"last_exit_at"`
+	LastError    string        `json:"last_error,omitempty"`
+	Healthy      bool          `json:"healthy"`
+}
+
+// SupervisorProcess 被守护的子进程，退出后按策略以指数退避重启
+type SupervisorProcess struct {
+	name          string
+	command       *Command
+	restartPolicy string
+	backoffMin    time.Duration
+	backoffMax    time.Duration
+	resetAfter    time.Duration
+	maxRestarts   int
+	stopTimeout   time.Duration
+
+	healthCheck    SupervisorHealthCheck
+	healthInterval time.Duration
+	healthTimeout  time.Duration
+	healthFailures int
+
+	supervisor *Supervisor
+	status     SupervisorStatus
+	cancel     context.CancelFunc
+	stopping   bool
+	restartNow bool
+	wakeup     chan struct{}
+	done       chan struct{}
+	locker     sync.Mutex
+}
+
+func NewSupervisorProcess(name string, command *Command) *SupervisorProcess {
+	return &SupervisorProcess{
+		name:           name,
+		command:        command,
+		restartPolicy:  SUPERVISOR_RESTART_ALWAYS,
+		backoffMin:     time.Second,
+		backoffMax:     time.Duration(1) * time.Minute,
+		resetAfter:     time.Duration(1) * time.Minute,
+		stopTimeout:    time.Duration(10) * time.Second,
+		healthInterval: time.Duration(10) * time.Second,
+		healthTimeout:  time.Duration(5) * time.Second,
+		healthFailures: 3,
+		status:         SupervisorStatus{Name: name, State: SUPERVISOR_STATE_STOPPED},
+	}
+}
+
+func (p *SupervisorProcess) Name() string {
+	return p.name
+}
+
+// SetRestartPolicy SUPERVISOR_RESTART_ALWAYS / ON_FAILURE / NEVER
+func (p *SupervisorProcess) SetRestartPolicy(policy string) *SupervisorProcess {
+	p.restartPolicy = policy
+	return p
+}
+
+// SetBackoff 重启等待时间从 min 开始翻倍直到 max，连续运行超过 resetAfter 后重新从 min 开始
+func (p *SupervisorProcess) SetBackoff(min time.Duration, max time.Duration, resetAfter time.Duration) *SupervisorProcess {
+	p.backoffMin = min
+	p.backoffMax = max
+	p.resetAfter = resetAfter
+	return p
+}
+
+// SetMaxRestarts 超过次数后不再重启，状态为 failed，<=0 不限制
+func (p *SupervisorProcess) SetMaxRestarts(max int) *SupervisorProcess {
+	p.maxRestarts = max
+	return p
+}
+
+// SetStopTimeout 停止时发送 SIGTERM 后等待的时间，超时发送 SIGKILL
+func (p *SupervisorProcess) SetStopTimeout(timeout time.Duration) *SupervisorProcess {
+	p.stopTimeout = timeout
+	return p
+}
+
+// SetHealthCheck 连续失败 failures 次后重启子进程
+func (p *SupervisorProcess) SetHealthCheck(interval time.Duration, timeout time.Duration, failures int, check SupervisorHealthCheck) *SupervisorProcess {
+	p.healthInterval = interval
+	p.healthTimeout = timeout
+	p.healthFailures = failures
+	p.healthCheck = check
+	return p
+}
+
+func (p *SupervisorProcess) Status() SupervisorStatus {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	s := p.status
+	if SUPERVISOR_STATE_RUNNING == s.State {
+		s.Uptime = time.Since(s.StartedAt)
+	}
+	return s
+}
+
+// Supervisor 启动并守护一组命名的子进程，子进程输出按行写入日志
+type Supervisor struct {
+	processes map[string]*SupervisorProcess
+	logger    *utilLogger.Logger
+	started   bool
+	locker    sync.Mutex
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{processes: map[string]*SupervisorProcess{}}
+}
+
+// SetLogger 不设置时使用 utilLogger 的默认 logger
+func (s *Supervisor) SetLogger(logger *utilLogger.Logger) *Supervisor {
+	s.logger = logger
+	return s
+}
+
+// Add 添加子进程，Supervisor 已经启动时立即启动
+func (s *Supervisor) Add(p *SupervisorProcess) error {
+	if nil == p || nil == p.command {
+		return fmt.Errorf("supervisor process command can't be nil")
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if _, ok := s.processes[p.name]; ok {
+		return fmt.Errorf("supervisor process %s already exists", p.name)
+	}
+	if nil != p.supervisor {
+		return fmt.Errorf("supervisor process %s already added", p.name)
+	}
+	p.supervisor = s
+	s.processes[p.name] = p
+	if s.started {
+		p.start()
+	}
+	return nil
+}
+
+// Start 启动所有未运行的子进程
+func (s *Supervisor) Start() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.started = true
+	for _, p := range s.processes {
+		p.start()
+	}
+}
+
+// StartProcess 启动已停止的子进程
+func (s *Supervisor) StartProcess(name string) error {
+	p, err := s.get(name)
+	if nil != err {
+		return err
+	}
+	p.start()
+	return nil
+}
+
+func (s *Supervisor) get(name string) (*SupervisorProcess, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	p, ok := s.processes[name]
+	if !ok {
+		return nil, fmt.Errorf("supervisor process %s not found", name)
+	}
+	return p, nil
+}
+
+// Stop 优雅停止子进程并等待退出，停止后不再重启
+func (s *Supervisor) Stop(name string) error {
+	p, err := s.get(name)
+	if nil != err {
+		return err
+	}
+	p.stop()
+	return nil
+}
+
+// StopAll 并发停止所有子进程
+func (s *Supervisor) StopAll() {
+	s.locker.Lock()
+	s.started = false
+	processes := make([]*SupervisorProcess, 0, len(s.processes))
+	for _, p := range s.processes {
+		processes = append(processes, p)
+	}
+	s.locker.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, p := range processes {
+		wg.Go(p.stop)
+	}
+	wg.Wait()
+}
+
+// Restart 优雅停止当前进程后立即重新启动，不等待退避时间
+func (s *Supervisor) Restart(name string) error {
+	p, err := s.get(name)
+	if nil != err {
+		return err
+	}
+	p.locker.Lock()
+	if nil == p.done {
+		p.locker.Unlock()
+		p.start()
+		return nil
+	}
+	p.restartNow = true
+	cancel := p.cancel
+	p.locker.Unlock()
+	p.notify()
+	if nil != cancel {
+		cancel()
+	}
+	return nil
+}
+
+// Remove 停止并移除子进程
+func (s *Supervisor) Remove(name string) error {
+	p, err := s.get(name)
+	if nil != err {
+		return err
+	}
+	p.stop()
+	s.locker.Lock()
+	delete(s.processes, name)
+	s.locker.Unlock()
+	p.supervisor = nil
+	return nil
+}
+
+func (s *Supervisor) Status(name string) (status SupervisorStatus, err error) {
+	p, err := s.get(name)
+	if nil != err {
+		return
+	}
+	return p.Status(), nil
+}
+
+// Statuses 按名称排序的所有子进程状态
+func (s *Supervisor) Statuses() (statuses []SupervisorStatus) {
+	s.locker.Lock()
+	for _, p := range s.processes {
+		statuses = append(statuses, p.Status())
+	}
+	s.locker.Unlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return
+}
+
+func (s *Supervisor) logInfo(format string, a ...any) {
+	if nil == s.logger {
+		utilLogger.InfoF(format, a...)
+		return
+	}
+	s.logger.InfoF(format, a...)
+}
+
+func (s *Supervisor) logWarn(format string, a ...any) {
+	if nil == s.logger {
+		utilLogger.WarnF(format, a...)
+		return
+	}
+	s.logger.WarnF(format, a...)
+}
+
+func (s *Supervisor) logError(format string, a ...any) {
+	if nil == s.logger {
+		utilLogger.ErrorF(format, a...)
+		return
+	}
+	s.logger.ErrorF(format, a...)
+}
+
+func (p *SupervisorProcess) start() {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if nil != p.done {
+		return
+	}
+	p.stopping = false
+	p.restartNow = false
+	p.status.State = SUPERVISOR_STATE_STARTING
+	p.wakeup = make(chan struct{}, 1)
+	p.done = make(chan struct{})
+	go p.loop(p.done)
+}
+
+func (p *SupervisorProcess) stop() {
+	p.locker.Lock()
+	done := p.done
+	if nil == done {
+		p.locker.Unlock()
+		return
+	}
+	p.stopping = true
+	cancel := p.cancel
+	if SUPERVISOR_STATE_RUNNING == p.status.State {
+		p.status.State = SUPERVISOR_STATE_STOPPING
+	}
+	p.locker.Unlock()
+	p.notify()
+	if nil != cancel {
+		cancel()
+	}
+	<-done
+}
+
+func (p *SupervisorProcess) notify() {
+	select {
+	case p.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// runCommand 复制命令配置，输出只转发到日志，不在内存中保留
+func (p *SupervisorProcess) runCommand() *Command {
+	c := *p.command
+	c.killGrace = p.stopTimeout
+	c.discardOut = true
+	onStdout, onStderr := p.command.onStdout, p.command.onStderr
+	c.onStdout = func(line string) {
+		p.supervisor.logInfo("[%s] %s", p.name, line)
+		if nil != onStdout {
+			onStdout(line)
+		}
+	}
+	c.onStderr = func(line string) {
+		p.supervisor.logWarn("[%s] %s", p.name, line)
+		if nil != onStderr {
+			onStderr(line)
+		}
+	}
+	return &c
+}
+
+func (p *SupervisorProcess) loop(done chan struct{}) {
+	defer func() {
+		p.locker.Lock()
+		p.done = nil
+		p.cancel = nil
+		p.locker.Unlock()
+		close(done)
+	}()
+
+	backoff := p.backoffMin
+	for {
+		p.locker.Lock()
+		if p.stopping {
+			p.status.State = SUPERVISOR_STATE_STOPPED
+			p.locker.Unlock()
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		p.cancel = cancel
+		p.restartNow = false
+		select {
+		case <-p.wakeup:
+		default:
+		}
+		p.locker.Unlock()
+
+		result, err := p.runOnce(ctx)
+		cancel()
+
+		p.locker.Lock()
+		p.cancel = nil
+		p.status.Pid = 0
+		p.status.Healthy = false
+		p.status.LastExitAt = time.Now()
+		if nil != err {
+			p.status.LastError = err.Error()
+		} else {
+			p.status.LastExitCode = result.ExitCode
+			p.status.LastSignal = result.Signal
+		}
+		if p.stopping {
+			p.status.State = SUPERVISOR_STATE_STOPPED
+			p.locker.Unlock()
+			p.supervisor.logInfo("supervisor process %s stopped", p.name)
+			return
+		}
+		restartNow := p.restartNow
+		succeeded := nil == err && 0 == result.ExitCode && "" == result.Signal
+		if !restartNow {
+			switch {
+			case SUPERVISOR_RESTART_NEVER == p.restartPolicy,
+				SUPERVISOR_RESTART_ON_FAILURE == p.restartPolicy && succeeded && !result.TimedOut:
+				p.status.State = SUPERVISOR_STATE_EXITED
+				p.locker.Unlock()
+				p.supervisor.logInfo("supervisor process %s exited", p.name)
+				return
+			case p.maxRestarts > 0 && p.status.Restarts >= p.maxRestarts:
+				p.status.State = SUPERVISOR_STATE_FAILED
+				p.locker.Unlock()
+				p.supervisor.logError("supervisor process %s failed after %d restarts", p.name, p.status.Restarts)
+				return
+			}
+		}
+		if nil == err && time.Since(p.status.StartedAt) >= p.resetAfter {
+			backoff = p.backoffMin
+		}
+		p.status.State = SUPERVISOR_STATE_BACKOFF
+		p.locker.Unlock()
+
+		if nil != err {
+			p.supervisor.logError("supervisor process %s start error: %+v", p.name, err)
+		} else {
+			p.supervisor.logWarn("supervisor process %s exited with code %d %s, restart in %s", p.name, result.ExitCode, result.Signal, backoff)
+		}
+		if !restartNow {
+			select {
+			case <-p.wakeup:
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, p.backoffMax)
+		}
+
+		p.locker.Lock()
+		if !p.stopping {
+			p.status.Restarts++
+		}
+		p.locker.Unlock()
+	}
+}
+
+func (p *SupervisorProcess) runOnce(ctx context.Context) (result *CommandResult, err error) {
+	process, err := p.runCommand().Start(ctx)
+	if nil != err {
+		return
+	}
+	p.locker.Lock()
+	p.status.State = SUPERVISOR_STATE_RUNNING
+	if p.stopping {
+		p.status.State = SUPERVISOR_STATE_STOPPING
+	}
+	p.status.Pid = process.Pid()
+	p.status.StartedAt = time.Now()
+	p.status.Healthy = true
+	p.locker.Unlock()
+	p.supervisor.logInfo("supervisor process %s started, pid %d", p.name, process.Pid())
+
+	if nil != p.healthCheck && p.healthInterval > 0 {
+		checkCtx, checkCancel := context.WithCancel(ctx)
+		defer checkCancel()
+		go p.checkHealth(checkCtx, process.Pid())
+	}
+	return process.Wait()
+}
+
+// checkHealth 连续失败达到次数后优雅结束进程，由 loop 重启
+func (p *SupervisorProcess) checkHealth(ctx context.Context, pid int) {
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, p.healthTimeout)
+		err := p.healthCheck(checkCtx, pid)
+		cancel()
+		if nil != ctx.Err() {
+			return
+		}
+		if nil == err {
+			failures = 0
+			p.locker.Lock()
+			p.status.Healthy = true
+			p.locker.Unlock()
+			continue
+		}
+		failures++
+		p.locker.Lock()
+		p.status.Healthy = false
+		p.status.LastError = fmt.Sprintf("health check: %+v", err)
+		cancelRun := p.cancel
+		if failures >= max(p.healthFailures, 1) {
+			p.restartNow = true
+		}
+		restart := p.restartNow
+		p.locker.Unlock()
+		p.supervisor.logWarn("supervisor process %s health check failed(%d): %+v", p.name, failures, err)
+		if restart && nil != cancelRun {
+			p.supervisor.logError("supervisor process %s unhealthy, restarting", p.name)
+			cancelRun()
+			return
+		}
+	}
+}
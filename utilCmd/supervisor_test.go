@@ -0,0 +1,153 @@
+//go:build !windows
+
+package utilCmd
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testSupervisorLines 记录子进程每行输出及时间
+type testSupervisorLines struct {
+	lines  []string
+	times  []time.Time
+	locker sync.Mutex
+}
+
+func (l *testSupervisorLines) add(line string) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	l.lines = append(l.lines, line)
+	l.times = append(l.times, time.Now())
+}
+
+func (l *testSupervisorLines) get() ([]string, []time.Time) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	return append([]string{}, l.lines...), append([]time.Time{}, l.times...)
+}
+
+func newTestSupervisor(t *testing.T, p *SupervisorProcess) *Supervisor {
+	s := NewSupervisor()
+	if err := s.Add(p); nil != err {
+		t.Fatalf("add: %+v", err)
+	}
+	s.Start()
+	t.Cleanup(s.StopAll)
+	return s
+}
+
+func waitSupervisor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSupervisorRestartPolicy(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		policy string
+		runs   int
+		state  string
+	}{
+		{"always", "echo run; exit 0", SUPERVISOR_RESTART_ALWAYS, 3, SUPERVISOR_STATE_BACKOFF},
+		{"on-failure failed", "echo run; exit 3", SUPERVISOR_RESTART_ON_FAILURE, 3, SUPERVISOR_STATE_BACKOFF},
+		{"on-failure succeeded", "echo run; exit 0", SUPERVISOR_RESTART_ON_FAILURE, 1, SUPERVISOR_STATE_EXITED},
+		{"never", "echo run; exit 3", SUPERVISOR_RESTART_NEVER, 1, SUPERVISOR_STATE_EXITED},
+	}
+	for _, c := range cases {
+		lines := &testSupervisorLines{}
+		p := NewSupervisorProcess(c.name, NewCommand("sh", "-c", c.script).OnStdoutLine(lines.add)).
+			SetRestartPolicy(c.policy).SetBackoff(10*time.Millisecond, 10*time.Millisecond, time.Minute)
+		s := newTestSupervisor(t, p)
+		waitSupervisor(t, 5*time.Second, c.name, func() bool {
+			got, _ := lines.get()
+			return len(got) >= c.runs && (SUPERVISOR_STATE_BACKOFF == c.state || c.state == p.Status().State)
+		})
+		if SUPERVISOR_STATE_EXITED == c.state {
+			time.Sleep(50 * time.Millisecond)
+			if got, _ := lines.get(); c.runs != len(got) {
+				t.Fatalf("%s 不应重启: %d 次", c.name, len(got))
+			}
+		}
+		s.StopAll()
+	}
+}
+
+func TestSupervisorBackoffAndMaxRestarts(t *testing.T) {
+	lines := &testSupervisorLines{}
+	p := NewSupervisorProcess("p", NewCommand("sh", "-c", "echo run; exit 1").OnStdoutLine(lines.add)).
+		SetBackoff(50*time.Millisecond, 200*time.Millisecond, time.Minute).SetMaxRestarts(4)
+	newTestSupervisor(t, p)
+	waitSupervisor(t, 5*time.Second, "failed", func() bool {
+		return SUPERVISOR_STATE_FAILED == p.Status().State
+	})
+
+	status := p.Status()
+	got, times := lines.get()
+	if 4 != status.Restarts || 5 != len(got) || 1 != status.LastExitCode {
+		t.Fatalf("达到最大重启次数后应停止: runs %d %+v", len(got), status)
+	}
+	// 退避时间 50ms、100ms、200ms、200ms
+	want := []time.Duration{50, 100, 200, 200}
+	for i := 1; i < len(times); i++ {
+		gap := times[i].Sub(times[i-1])
+		if gap < want[i-1]*time.Millisecond || gap > want[i-1]*time.Millisecond+150*time.Millisecond {
+			t.Fatalf("第 %d 次重启间隔 %s, want %dms", i, gap, want[i-1])
+		}
+	}
+}
+
+func TestSupervisorStopSignalsProcessGroup(t *testing.T) {
+	lines := &testSupervisorLines{}
+	// 后台的 sleep 与 sh 在同一个进程组中
+	p := NewSupervisorProcess("p", NewCommand("sh", "-c", "sleep 30 & echo $!; wait").OnStdoutLine(lines.add))
+	s := newTestSupervisor(t, p)
+	waitSupervisor(t, 5*time.Second, "started", func() bool {
+		got, _ := lines.get()
+		return 1 == len(got)
+	})
+	got, _ := lines.get()
+	child, _ := strconv.Atoi(strings.TrimSpace(got[0]))
+
+	started := time.Now()
+	if err := s.Stop("p"); nil != err {
+		t.Fatalf("stop: %+v", err)
+	}
+	status := p.Status()
+	if SUPERVISOR_STATE_STOPPED != status.State || time.Since(started) > 5*time.Second {
+		t.Fatalf("status = %+v", status)
+	}
+	waitSupervisor(t, 3*time.Second, "child exited", func() bool {
+		return syscall.ESRCH == syscall.Kill(child, 0)
+	})
+}
+
+func TestSupervisorStopTimeoutKills(t *testing.T) {
+	lines := &testSupervisorLines{}
+	// 忽略 SIGTERM，只能被 SIGKILL 结束
+	p := NewSupervisorProcess("p", NewCommand("sh", "-c", "trap '' TERM; echo ready; while true; do sleep 0.05; done").OnStdoutLine(lines.add)).
+		SetStopTimeout(200 * time.Millisecond)
+	s := newTestSupervisor(t, p)
+	waitSupervisor(t, 5*time.Second, "started", func() bool {
+		got, _ := lines.get()
+		return 1 == len(got)
+	})
+
+	started := time.Now()
+	_ = s.Stop("p")
+	elapsed := time.Since(started)
+	status := p.Status()
+	if elapsed < 200*time.Millisecond || elapsed > 3*time.Second || "killed" != status.LastSignal {
+		t.Fatalf("SIGTERM 超时后应发送 SIGKILL: %s %+v", elapsed, status)
+	}
+}
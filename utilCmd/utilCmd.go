@@ -29,11 +29,15 @@ func RunCommand(wait bool, name string, args ...string) (string, error) {
 		return strings.TrimSpace(string(result)), err
 	} else {
 		err := cmdExec.Start()
+		if nil == err {
+			// 回收子进程，避免僵尸进程
+			go cmdExec.Wait()
+		}
 		return "", err
 	}
 }
 
-// 根据进程名判断进程是否运行
+// 根据进程名判断进程是否运行，threads 为至少需要的进程数
 func HasRunning(serverName string, threads ...int) bool {
 
 	checkThreads := 1
@@ -43,23 +47,10 @@ func HasRunning(serverName string, threads ...int) bool {
 	if checkThreads <= 0 {
 		checkThreads = 1
 	}
-	cmd := "sh"
-	cmdArgs := []string{"-c", "ps -ef  --cols 1000 | grep \"" + serverName + "\" | grep -v grep"}
-	//filepath.Join(utils.GetSelfPath(),"hasRunSuricata.sh")
-	if "windows" == utils.RunningOs("windows") {
-		cmd = `tasklist /fi "imagename eq ` + serverName + `" /FO TABLE /NH`
-		cmdArgs = []string{}
-	}
-
-	result, err := RunCommand(true, cmd, cmdArgs...)
 
+	processes, err := FindProcesses(serverName)
 	if err != nil {
 		return false
 	}
-	if "windows" == utils.RunningOs("windows") {
-		return strings.HasPrefix(result, "信息: 没有运行的任务匹配指定标准")
-	}
-
-	results := strings.Split(result, "\n")
-	return len(results) >= checkThreads
+	return len(processes) >= checkThreads
 }
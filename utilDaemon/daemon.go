@@ -0,0 +1,222 @@
+package utilDaemon
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/hilaoyu/go-utils/utilLogger"
+)
+
+// Daemon 进程生命周期：pid 文件单实例锁，SIGHUP 重新加载，SIGUSR1 重新打开日志，SIGTERM/SIGINT 优雅退出，并通知 systemd
+type Daemon struct {
+	pidfilePath string
+	pidfile     *Pidfile
+	logger      *utilLogger.Logger
+	watchdog    bool
+
+	onReload []func() error
+	onReopen []func() error
+	onStop   []func()
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	signals chan os.Signal
+	done    chan struct{}
+	once    sync.Once
+	locker  sync.Mutex
+}
+
+func NewDaemon() *Daemon {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Daemon{
+		watchdog: true,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// SetPidfile 为空时不使用 pid 文件
+func (d *Daemon) SetPidfile(path string) *Daemon {
+	d.pidfilePath = path
+	return d
+}
+
+// SetLogger 收到重新打开日志的信号时调用 logger.Reopen
+func (d *Daemon) SetLogger(logger *utilLogger.Logger) *Daemon {
+	d.logger = logger
+	return d
+}
+
+// SetWatchdog 是否按 WATCHDOG_USEC 的一半间隔自动发送 WATCHDOG=1
+func (d *Daemon) SetWatchdog(enable bool) *Daemon {
+	d.watchdog = enable
+	return d
+}
+
+func (d *Daemon) OnReload(fn func() error) *Daemon {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.onReload = append(d.onReload, fn)
+	return d
+}
+
+func (d *Daemon) OnReopen(fn func() error) *Daemon {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.onReopen = append(d.onReopen, fn)
+	return d
+}
+
+// OnStop 退出时按添加的相反顺序调用
+func (d *Daemon) OnStop(fn func()) *Daemon {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	d.onStop = append(d.onStop, fn)
+	return d
+}
+
+// Context 收到退出信号或调用 Stop 后取消
+func (d *Daemon) Context() context.Context {
+	return d.ctx
+}
+
+// Start 获取 pid 文件锁并安装信号处理，初始化完成后调用 Ready 通知 systemd
+func (d *Daemon) Start() (err error) {
+	if "" != d.pidfilePath {
+		if d.pidfile, err = AcquirePidfile(d.pidfilePath); nil != err {
+			return
+		}
+	}
+	d.signals = make(chan os.Signal, 4)
+	signal.Notify(d.signals, append(append(append([]os.Signal{}, stopSignals...), reloadSignals...), reopenSignals...)...)
+	go d.handleSignals()
+	if interval := SdWatchdogInterval(); d.watchdog && interval > 0 {
+		go d.pingWatchdog(interval / 2)
+	}
+	return nil
+}
+
+func (d *Daemon) Ready() {
+	if _, err := SdNotifyReady(); nil != err {
+		d.logError("daemon notify ready error: %+v", err)
+	}
+}
+
+func (d *Daemon) Stop() {
+	d.cancel()
+}
+
+// Wait 等待退出信号，然后通知 systemd、执行 OnStop 并释放 pid 文件
+func (d *Daemon) Wait() {
+	<-d.ctx.Done()
+	d.once.Do(func() {
+		_, _ = SdNotifyStopping()
+		if nil != d.signals {
+			signal.Stop(d.signals)
+		}
+		d.locker.Lock()
+		onStop := append([]func(){}, d.onStop...)
+		d.locker.Unlock()
+		for i := len(onStop) - 1; i >= 0; i-- {
+			onStop[i]()
+		}
+		if nil != d.pidfile {
+			if err := d.pidfile.Release(); nil != err {
+				d.logError("daemon release pidfile error: %+v", err)
+			}
+		}
+		close(d.done)
+	})
+	<-d.done
+}
+
+// Reload 执行 OnReload，与收到 SIGHUP 相同
+func (d *Daemon) Reload() {
+	_, _ = SdNotifyReloading()
+	d.logInfo("daemon reloading")
+	d.runHooks("reload", d.onReload)
+	d.Ready()
+}
+
+// Reopen 重新打开 logger 的文件并执行 OnReopen，与收到 SIGUSR1 相同
+func (d *Daemon) Reopen() {
+	if nil != d.logger {
+		if err := d.logger.Reopen(); nil != err {
+			d.logError("daemon reopen logger error: %+v", err)
+		}
+	}
+	d.runHooks("reopen", d.onReopen)
+}
+
+func (d *Daemon) runHooks(name string, hooks []func() error) {
+	d.locker.Lock()
+	hooks = append([]func() error{}, hooks...)
+	d.locker.Unlock()
+	for _, fn := range hooks {
+		if err := fn(); nil != err {
+			d.logError("daemon %s error: %+v", name, err)
+		}
+	}
+}
+
+func (d *Daemon) handleSignals() {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case sig := <-d.signals:
+			switch {
+			case containsSignal(stopSignals, sig):
+				d.logInfo("daemon received %s, stopping", sig)
+				d.cancel()
+				return
+			case containsSignal(reloadSignals, sig):
+				d.Reload()
+			case containsSignal(reopenSignals, sig):
+				d.Reopen()
+			}
+		}
+	}
+}
+
+func (d *Daemon) pingWatchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := SdNotifyWatchdog(); nil != err {
+				d.logError("daemon notify watchdog error: %+v", err)
+			}
+		}
+	}
+}
+
+func containsSignal(signals []os.Signal, sig os.Signal) bool {
+	for _, s := range signals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Daemon) logInfo(format string, a ...any) {
+	if nil == d.logger {
+		return
+	}
+	d.logger.InfoF(format, a...)
+}
+
+func (d *Daemon) logError(format string, a ...any) {
+	if nil == d.logger {
+		return
+	}
+	d.logger.ErrorF(format, a...)
+}
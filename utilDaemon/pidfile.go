@@ -0,0 +1,120 @@
+package utilDaemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var ErrPidfileLocked = errors.New("pidfile locked by another process")
+
+// Pidfile 持有排它锁的 pid 文件，进程退出后锁由系统释放，残留的文件会在下次获取时覆盖
+type Pidfile struct {
+	path string
+	file *os.File
+}
+
+// AcquirePidfile 获取 pid 文件锁并写入当前 pid，已被其它进程持有时返回包含 ErrPidfileLocked 的错误
+func AcquirePidfile(path string) (p *Pidfile, err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); nil != err {
+		return nil, fmt.Errorf("create pidfile dir error: %+v", err)
+	}
+	// 持有者释放时会删除文件，加锁后文件已被替换则重试
+	for i := 0; i < 10; i++ {
+		file, e := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if nil != e {
+			return nil, fmt.Errorf("open pidfile %s error: %+v", path, e)
+		}
+		locked, e := lockFile(file)
+		if nil != e {
+			_ = file.Close()
+			return nil, fmt.Errorf("lock pidfile %s error: %+v", path, e)
+		}
+		if !locked {
+			pid, _ := readPid(file)
+			_ = file.Close()
+			return nil, fmt.Errorf("%w: %s pid %d", ErrPidfileLocked, path, pid)
+		}
+		if !sameFile(file, path) {
+			_ = file.Close()
+			continue
+		}
+		p = &Pidfile{path: path, file: file}
+		if err = p.write(os.Getpid()); nil != err {
+			_ = p.Release()
+			return nil, err
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("lock pidfile %s error: file keeps changing", path)
+}
+
+// ReadPidfile 读取 pid 文件中的 pid，running 表示文件锁仍被持有
+func ReadPidfile(path string) (pid int, running bool, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if nil != err {
+		return 0, false, err
+	}
+	defer file.Close()
+	pid, err = readPid(file)
+	locked, e := lockFile(file)
+	if nil != e {
+		return pid, false, fmt.Errorf("lock pidfile %s error: %+v", path, e)
+	}
+	return pid, !locked, err
+}
+
+func readPid(file *os.File) (pid int, err error) {
+	b := make([]byte, 32)
+	n, err := file.ReadAt(b, 0)
+	if n <= 0 {
+		return 0, fmt.Errorf("read pidfile error: %+v", err)
+	}
+	pid, err = strconv.Atoi(strings.TrimSpace(string(b[:n])))
+	if nil != err {
+		return 0, fmt.Errorf("invalid pidfile content: %+v", err)
+	}
+	return pid, nil
+}
+
+func sameFile(file *os.File, path string) bool {
+	fi, err := file.Stat()
+	if nil != err {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if nil != err {
+		return false
+	}
+	return os.SameFile(fi, pi)
+}
+
+func (p *Pidfile) write(pid int) (err error) {
+	if err = p.file.Truncate(0); nil != err {
+		return fmt.Errorf("write pidfile %s error: %+v", p.path, err)
+	}
+	if _, err = p.file.WriteAt([]byte(strconv.Itoa(pid)+"\n"), 0); nil != err {
+		return fmt.Errorf("write pidfile %s error: %+v", p.path, err)
+	}
+	return p.file.Sync()
+}
+
+func (p *Pidfile) Path() string {
+	return p.path
+}
+
+// Release 删除 pid 文件并释放锁
+func (p *Pidfile) Release() (err error) {
+	if nil == p.file {
+		return nil
+	}
+	if sameFile(p.file, p.path) {
+		_ = os.Remove(p.path)
+	}
+	err = p.file.Close()
+	p.file = nil
+	return
+}
@@ -0,0 +1,109 @@
+package utilDaemon
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testPidfileHelperEnv = "UTIL_DAEMON_TEST_PIDFILE"
+
+// TestPidfileHelperProcess 作为子进程运行，持有 pid 文件锁直到 stdin 关闭
+func TestPidfileHelperProcess(t *testing.T) {
+	path := os.Getenv(testPidfileHelperEnv)
+	if "" == path {
+		t.Skip("helper process")
+	}
+	p, err := AcquirePidfile(path)
+	if nil != err {
+		os.Exit(2)
+	}
+	_, _ = os.Stdout.WriteString("locked\n")
+	_, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+	_ = p.Release()
+	os.Exit(0)
+}
+
+// startTestPidfileHolder 启动持有 pid 文件锁的子进程
+func startTestPidfileHolder(t *testing.T, path string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPidfileHelperProcess$")
+	cmd.Env = append(os.Environ(), testPidfileHelperEnv+"="+path)
+	stdout, _ := cmd.StdoutPipe()
+	if _, err := cmd.StdinPipe(); nil != err {
+		t.Fatalf("stdin: %+v", err)
+	}
+	if err := cmd.Start(); nil != err {
+		t.Fatalf("start helper: %+v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if nil != err || "locked" != strings.TrimSpace(line) {
+		t.Fatalf("helper: %q %+v", line, err)
+	}
+	return cmd
+}
+
+func TestPidfileContention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "app.pid")
+	p, err := AcquirePidfile(path)
+	if nil != err {
+		t.Fatalf("acquire: %+v", err)
+	}
+	// 同一进程再次打开文件也会被锁排斥
+	if _, err = AcquirePidfile(path); !errors.Is(err, ErrPidfileLocked) {
+		t.Fatalf("重复获取应返回 ErrPidfileLocked: %+v", err)
+	}
+	pid, running, err := ReadPidfile(path)
+	if nil != err || !running || os.Getpid() != pid {
+		t.Fatalf("read: %d %v %+v", pid, running, err)
+	}
+
+	if err = p.Release(); nil != err {
+		t.Fatalf("release: %+v", err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("释放后应删除 pid 文件: %+v", err)
+	}
+	p, err = AcquirePidfile(path)
+	if nil != err {
+		t.Fatalf("释放后应能重新获取: %+v", err)
+	}
+	_ = p.Release()
+}
+
+func TestPidfileContentionAcrossProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.pid")
+	holder := startTestPidfileHolder(t, path)
+
+	_, err := AcquirePidfile(path)
+	if !errors.Is(err, ErrPidfileLocked) || !strings.Contains(err.Error(), "pid "+strconv.Itoa(holder.Process.Pid)) {
+		t.Fatalf("其它进程持有时应返回 ErrPidfileLocked: %+v", err)
+	}
+	pid, running, err := ReadPidfile(path)
+	if nil != err || !running || holder.Process.Pid != pid {
+		t.Fatalf("read: %d %v %+v", pid, running, err)
+	}
+
+	// 持有者被杀死后锁由系统释放，残留的文件被覆盖
+	_ = holder.Process.Kill()
+	_ = holder.Wait()
+	if pid, running, err = ReadPidfile(path); nil != err || running || holder.Process.Pid != pid {
+		t.Fatalf("持有者退出后: %d %v %+v", pid, running, err)
+	}
+	p, err := AcquirePidfile(path)
+	if nil != err {
+		t.Fatalf("acquire: %+v", err)
+	}
+	defer p.Release()
+	if pid, running, err = ReadPidfile(path); nil != err || !running || os.Getpid() != pid {
+		t.Fatalf("read: %d %v %+v", pid, running, err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package utilDaemon
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile 非阻塞加排它锁，已被其它进程持有时返回 false
+func lockFile(file *os.File) (locked bool, err error) {
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return nil == err, err
+}
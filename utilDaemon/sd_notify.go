@@ -0,0 +1,71 @@
+package utilDaemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	SD_NOTIFY_READY     = "READY=1"
+	SD_NOTIFY_RELOADING = "RELOADING=1"
+	SD_NOTIFY_STOPPING  = "STOPPING=1"
+	SD_NOTIFY_WATCHDOG  = "WATCHDOG=1"
+)
+
+// SdNotify 向 systemd 的 NOTIFY_SOCKET 发送状态，没有设置 NOTIFY_SOCKET 时 sent 为 false 且不返回错误
+// 多个状态以换行分隔，如 "READY=1\nSTATUS=serving"
+func SdNotify(state string) (sent bool, err error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if "" == socket {
+		return false, nil
+	}
+	// @ 开头为 Linux 抽象命名空间
+	if '@' == socket[0] {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if nil != err {
+		return false, fmt.Errorf("sd_notify dial error: %+v", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); nil != err {
+		return false, fmt.Errorf("sd_notify write error: %+v", err)
+	}
+	return true, nil
+}
+
+func SdNotifyReady() (bool, error) {
+	return SdNotify(SD_NOTIFY_READY)
+}
+
+func SdNotifyStopping() (bool, error) {
+	return SdNotify(SD_NOTIFY_STOPPING)
+}
+
+// SdNotifyReloading 重新加载完成后需要再发送 READY
+func SdNotifyReloading() (bool, error) {
+	return SdNotify(SD_NOTIFY_RELOADING)
+}
+
+func SdNotifyWatchdog() (bool, error) {
+	return SdNotify(SD_NOTIFY_WATCHDOG)
+}
+
+func SdNotifyStatus(status string) (bool, error) {
+	return SdNotify("STATUS=" + status)
+}
+
+// SdWatchdogInterval systemd 要求的看门狗间隔(WATCHDOG_USEC)，未启用或 WATCHDOG_PID 不是当前进程时返回 0
+func SdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if nil != err || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); "" != pid && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
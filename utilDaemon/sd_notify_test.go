@@ -0,0 +1,119 @@
+//go:build !windows
+
+package utilDaemon
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestNotifySocket 监听 unixgram 并设置 NOTIFY_SOCKET，返回收到的状态
+func newTestNotifySocket(t *testing.T) <-chan string {
+	// unix socket 路径长度有限制，不使用 t.TempDir
+	dir, err := os.MkdirTemp("", "sd")
+	if nil != err {
+		t.Fatalf("mkdir: %+v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if nil != err {
+		t.Fatalf("listen: %+v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	states := make(chan string, 64)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, e := conn.Read(buf)
+			if nil != e {
+				return
+			}
+			states <- string(buf[:n])
+		}
+	}()
+	return states
+}
+
+func waitNotifyState(t *testing.T, states <-chan string, want string) {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case state := <-states:
+			if want == state {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("没有收到 %q", want)
+		}
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := SdNotifyReady(); sent || nil != err {
+		t.Fatalf("没有 NOTIFY_SOCKET 时不应发送: %v %+v", sent, err)
+	}
+
+	states := newTestNotifySocket(t)
+	if sent, err := SdNotify("READY=1\nSTATUS=serving"); !sent || nil != err {
+		t.Fatalf("notify: %v %+v", sent, err)
+	}
+	if state := <-states; "READY=1\nSTATUS=serving" != state {
+		t.Fatalf("state = %q", state)
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if 2*time.Second != SdWatchdogInterval() {
+		t.Fatalf("interval = %s", SdWatchdogInterval())
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if 0 != SdWatchdogInterval() {
+		t.Fatalf("WATCHDOG_PID 不是当前进程时应返回 0")
+	}
+}
+
+func TestDaemonNotifyReadyWatchdogStopping(t *testing.T) {
+	states := newTestNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	d := NewDaemon().SetPidfile(filepath.Join(t.TempDir(), "daemon.pid"))
+	if err := d.Start(); nil != err {
+		t.Fatalf("start: %+v", err)
+	}
+	d.Ready()
+	waitNotifyState(t, states, SD_NOTIFY_READY)
+	waitNotifyState(t, states, SD_NOTIFY_WATCHDOG)
+
+	d.Reload()
+	waitNotifyState(t, states, SD_NOTIFY_RELOADING)
+	waitNotifyState(t, states, SD_NOTIFY_READY)
+
+	d.Stop()
+	d.Wait()
+	waitNotifyState(t, states, SD_NOTIFY_STOPPING)
+
+	// 退出后不再发送 WATCHDOG
+	time.Sleep(100 * time.Millisecond)
+	for len(states) > 0 {
+		<-states
+	}
+	time.Sleep(150 * time.Millisecond)
+	select {
+	case state := <-states:
+		if strings.Contains(state, SD_NOTIFY_WATCHDOG) {
+			t.Fatalf("退出后仍在发送 WATCHDOG")
+		}
+	default:
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package utilDaemon
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	stopSignals   = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+	reloadSignals = []os.Signal{syscall.SIGHUP}
+	reopenSignals = []os.Signal{syscall.SIGUSR1}
+)
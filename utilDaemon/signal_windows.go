@@ -0,0 +1,15 @@
+//go:build windows
+
+package utilDaemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// Windows 只能收到 Ctrl+C / 关闭等事件，重新加载和重新打开日志只能通过 Reload / Reopen 调用
+var (
+	stopSignals   = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	reloadSignals = []os.Signal{}
+	reopenSignals = []os.Signal{}
+)
@@ -224,7 +224,7 @@ func (esClient *ElasticsearchClient) SaveData(indexName string, data []interface
 			errMsg += fmt.Sprintf("%s : %s ;", errItem.Error.Type, errItem.Error.Reason)
 		}
 
-		err = fmt.Errorf(errFormat, indexName, fmt.Errorf(errMsg))
+		err = fmt.Errorf(errFormat, indexName, errors.New(errMsg))
 		return
 	}
 
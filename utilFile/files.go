@@ -228,8 +228,6 @@ func ReadLines(file string) (lines []string, err error) {
 		broken = false
 		return
 	})
-
-	return
 }
 func ReadLinesWithFilter(file string, handle func(line string) (matched bool, broken bool)) (lines []string, err error) {
 	fd, err := os.Open(file)
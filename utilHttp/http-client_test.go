@@ -0,0 +1,120 @@
+package utilHttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/hilaoyu/go-utils/utilProxy"
+)
+
+func TestHttpProxyForwardsPlainHttp(t *testing.T) {
+	var locker sync.Mutex
+	var methods []string
+	var uris []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locker.Lock()
+		methods = append(methods, r.Method)
+		uris = append(uris, r.RequestURI)
+		locker.Unlock()
+		if "Basic dTpw" != r.Header.Get("Proxy-Authorization") {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		_, _ = io.WriteString(w, "proxied")
+	}))
+	defer proxy.Close()
+
+	client := NewHttpClient("http://example.invalid").UseProxyUrl("http://u:p@" + proxy.Listener.Addr().String())
+	resp, err := client.Request(http.MethodGet, "/path", nil)
+	if nil != err {
+		t.Fatalf("request: %+v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if http.StatusOK != resp.StatusCode || "proxied" != string(body) {
+		t.Fatalf("response = %d %q", resp.StatusCode, body)
+	}
+	locker.Lock()
+	defer locker.Unlock()
+	if 1 != len(methods) || http.MethodGet != methods[0] || "http://example.invalid/path" != uris[0] {
+		t.Fatalf("普通 http 请求应以绝对 URL 转发给代理而不是 CONNECT: %v %v", methods, uris)
+	}
+}
+
+// newTestTlsProxy https 代理，CONNECT 时连接目标并转发，其它请求直接返回 proxied
+func newTestTlsProxy(t *testing.T) (proxy *httptest.Server, pool *x509.CertPool) {
+	proxy = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if http.MethodConnect != r.Method {
+			_, _ = io.WriteString(w, "proxied "+r.RequestURI)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if nil != err {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if nil != err {
+			_ = target.Close()
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+		go func() {
+			_, _ = io.Copy(target, conn)
+			_ = target.Close()
+		}()
+		_, _ = io.Copy(conn, target)
+		_ = conn.Close()
+	}))
+	t.Cleanup(proxy.Close)
+	// httptest 的证书是自签名的，作为私有 CA 使用
+	pool = x509.NewCertPool()
+	pool.AddCert(proxy.Certificate())
+	return
+}
+
+func TestHttpsProxyUsesProxyTlsConfig(t *testing.T) {
+	proxy, pool := newTestTlsProxy(t)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "target")
+	}))
+	defer target.Close()
+	tlsTarget := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "tls target")
+	}))
+	defer tlsTarget.Close()
+
+	p, err := utilProxy.NewProxyHttp(proxy.Listener.Addr().String(), "", "", &tls.Config{RootCAs: pool})
+	if nil != err {
+		t.Fatalf("proxy: %+v", err)
+	}
+	cases := []struct {
+		baseUrl   string
+		sslVerify bool
+		want      string
+	}{
+		{"http://example.invalid", true, "proxied http://example.invalid/path"},
+		// 目标证书不受信任，只关闭目标的校验，代理仍使用私有 CA 校验
+		{tlsTarget.URL, false, "tls target"},
+	}
+	for _, c := range cases {
+		client := NewHttpClient(c.baseUrl).SetSslVerify(c.sslVerify).UseProxy(p)
+		body, err := client.RequestPlain(http.MethodGet, "/path", nil)
+		if nil != err || c.want != string(body) {
+			t.Fatalf("%s = %q %+v, want %q", c.baseUrl, body, err, c.want)
+		}
+	}
+
+	// 代理的 tlsConfig 不信任私有 CA 时握手失败，说明使用的是代理自己的 tlsConfig
+	untrusted, _ := utilProxy.NewProxyHttp(proxy.Listener.Addr().String(), "", "", &tls.Config{})
+	client := NewHttpClient(target.URL).SetSslVerify(false).UseProxy(untrusted)
+	if _, err = client.RequestPlain(http.MethodGet, "/path", nil); nil == err {
+		t.Fatalf("代理证书不受信任时应失败")
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/hilaoyu/go-utils/utilLogger"
+	"github.com/hilaoyu/go-utils/utilSsl"
 )
 
 type FilteringLogger struct {
@@ -72,6 +73,18 @@ func (s *HttpServer) VerifyClientSsl(caFile string) *HttpServer {
 	return s
 }
 
+// SetContext ctx 取消时 Run 关闭服务并返回，可使用 utilDaemon.Daemon 的 Context
+func (s *HttpServer) SetContext(ctx context.Context) *HttpServer {
+	s.ctx = ctx
+	return s
+}
+
+// UseAcme 为 SslAcme 的监听地址提供证书，并在所有监听地址上响应 http-01 验证
+func (s *HttpServer) UseAcme(acmeClient *utilSsl.AcmeClient) *HttpServer {
+	s.acmeClient = acmeClient
+	return s
+}
+
 func (s *HttpServer) Run(logger *utilLogger.Logger, addresses ...*ServerListenAddr) {
 	var err error
 	if nil == logger {
@@ -112,7 +125,11 @@ func (s *HttpServer) Run(logger *utilLogger.Logger, addresses ...*ServerListenAd
 		s.server.TLSConfig = tlsConfig
 	}
 
-	quit := make(chan os.Signal)
+	if nil != s.acmeClient {
+		s.server.Handler = s.acmeClient.HttpHandler(s.server.Handler)
+	}
+
+	quit := make(chan os.Signal, len(s.listenAddresses)*2)
 	for _, listenAddr := range s.listenAddresses {
 		listenAddr.Network = strings.ToLower(listenAddr.Network)
 		var listener net.Listener
@@ -129,7 +146,23 @@ func (s *HttpServer) Run(logger *utilLogger.Logger, addresses ...*ServerListenAd
 				quit <- os.Interrupt
 			}
 		}
-		if "" != listenAddr.SslServerCertFile && "" != listenAddr.SslServerKeyFile {
+		if listenAddr.SslAcme {
+			if nil == s.acmeClient {
+				logger.ErrorF("server serv Tls: %s://%s , acme client not set\n", listenAddr.Network, listenAddr.Addr)
+				continue
+			}
+			tlsConfig := s.acmeClient.TlsConfig()
+			if nil != s.server.TLSConfig {
+				tlsConfig = s.server.TLSConfig.Clone()
+				tlsConfig.GetCertificate = s.acmeClient.GetCertificate
+			}
+			logger.InfoF("server serv Tls(acme): %s://%s\n", listenAddr.Network, listenAddr.Addr)
+			go func() {
+				err = s.server.Serve(tls.NewListener(listener, tlsConfig))
+				err = fmt.Errorf("server serv TLS : %s://%s ,errpr: %v", listenAddr.Network, listenAddr.Addr, err)
+				quit <- os.Interrupt
+			}()
+		} else if "" != listenAddr.SslServerCertFile && "" != listenAddr.SslServerKeyFile {
 			logger.InfoF("server serv Tls: %s://%s\n", listenAddr.Network, listenAddr.Addr)
 			go func() {
 				err = s.server.ServeTLS(listener, listenAddr.SslServerCertFile, listenAddr.SslServerKeyFile)
@@ -158,7 +191,14 @@ func (s *HttpServer) Run(logger *utilLogger.Logger, addresses ...*ServerListenAd
 		logger.ErrorF("%v\n", err)
 	}
 
-	<-quit
+	if nil != s.ctx {
+		select {
+		case <-quit:
+		case <-s.ctx.Done():
+		}
+	} else {
+		<-quit
+	}
 	s.Shutdown()
 	return
 }
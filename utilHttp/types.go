@@ -1,6 +1,7 @@
 package utilHttp
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"net/url"
@@ -9,6 +10,7 @@ import (
 	"github.com/hilaoyu/go-utils/utilEnc"
 	"github.com/hilaoyu/go-utils/utilLogger"
 	"github.com/hilaoyu/go-utils/utilProxy"
+	"github.com/hilaoyu/go-utils/utilSsl"
 )
 
 type ServerListenAddr struct {
@@ -18,12 +20,15 @@ type ServerListenAddr struct {
 	Gid               int    `json:"gid,omitempty"`
 	SslServerCertFile string `json:"ssl_server_cert_file,omitempty"`
 	SslServerKeyFile  string `json:"ssl_server_key_file,omitempty"`
+	SslAcme           bool   `json:"ssl_acme,omitempty"`
 }
 
 type HttpServer struct {
+	ctx                   context.Context
 	listenAddresses       []*ServerListenAddr
 	server                *http.Server
 	sslVerifyClientCaFile string
+	acmeClient            *utilSsl.AcmeClient
 
 	logger    *utilLogger.Logger
 	listeners []net.Listener
@@ -46,11 +51,7 @@ type HttpClient struct {
 	needEncData map[string]interface{}
 	headers     map[string]string
 
-	useProxy            string
-	proxySocks5         utilProxy.UtilProxy
-	proxySocks5Addr     string
-	proxySocks5user     string
-	proxySocks5Password string
+	proxy utilProxy.UtilProxy
 
 	encryptor   utilEnc.ApiDataEncryptor
 	aesEncAppId string
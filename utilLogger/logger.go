@@ -1,6 +1,7 @@
 package utilLogger
 
 import (
+	"errors"
 	"fmt"
 	"github.com/hilaoyu/go-utils/utilTime"
 	"github.com/natefinch/lumberjack"
@@ -8,6 +9,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"sync"
 )
 
 const (
@@ -142,7 +144,10 @@ func (l *Logger) AddConsoleWriter() (err error) {
 }
 
 func (l *Logger) AddFileWriter(dest string) (err error) {
-	w, err := os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	w, err := NewFileWriter(dest)
+	if err != nil {
+		return
+	}
 	l.writers = append(l.writers, w)
 	return
 }
@@ -159,6 +164,21 @@ func (l *Logger) GetLevelWriter() io.Writer {
 	return l.levelWriter
 }
 
+// Reopen 重新打开文件 writer，配合 logrotate 等外部工具移动日志文件后使用
+func (l *Logger) Reopen() (err error) {
+	var errs []error
+	for _, w := range l.writers {
+		switch writer := w.(type) {
+		case *FileWriter:
+			errs = append(errs, writer.Reopen())
+		case *lumberjack.Logger:
+			// lumberjack 关闭后下次写入时重新打开
+			errs = append(errs, writer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (l *Logger) Init(force bool) *Logger {
 	if "" == l.timeFormat {
 		l.timeFormat = utilTime.GetTimeFormat()
@@ -261,3 +281,47 @@ func NewFileRotationWriter(dir string, name string, maxBackups int, maxSize int,
 
 	return
 }
+
+// FileWriter 追加写入文件，Reopen 后写入新文件
+type FileWriter struct {
+	path   string
+	file   *os.File
+	locker sync.Mutex
+}
+
+func NewFileWriter(dest string) (w *FileWriter, err error) {
+	w = &FileWriter{path: dest}
+	w.file, err = w.open()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (w *FileWriter) open() (*os.File, error) {
+	return os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+}
+
+func (w *FileWriter) Write(b []byte) (n int, err error) {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	return w.file.Write(b)
+}
+
+func (w *FileWriter) Reopen() (err error) {
+	file, err := w.open()
+	if err != nil {
+		return fmt.Errorf("reopen log file %s error: %+v", w.path, err)
+	}
+	w.locker.Lock()
+	old := w.file
+	w.file = file
+	w.locker.Unlock()
+	return old.Close()
+}
+
+func (w *FileWriter) Close() error {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	return w.file.Close()
+}
@@ -195,13 +195,13 @@ func (mc *MongodbClient) CollectionAggregate(collectionName string, group bson.D
 	pipeline := mongo.Pipeline{}
 
 	if nil != filter {
-		pipeline = append(pipeline, bson.D{bson.E{"$match", filter}})
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$match", Value: filter}})
 	}
 	if nil != group {
-		pipeline = append(pipeline, bson.D{bson.E{"$group", group}})
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$group", Value: group}})
 	}
 	if nil != sort {
-		pipeline = append(pipeline, bson.D{bson.E{"$sort", sort}})
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$sort", Value: sort}})
 	}
 
 	/**/
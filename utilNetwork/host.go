@@ -0,0 +1,492 @@
+package utilNetwork
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HostRouteFlagUp      = 0x0001
+	HostRouteFlagGateway = 0x0002
+	HostRouteFlagHost    = 0x0004
+	HostRouteFlagReject  = 0x0200
+
+	HostProcRouteV4  = "/proc/net/route"
+	HostProcRouteV6  = "/proc/net/ipv6_route"
+	HostResolvConf   = "/etc/resolv.conf"
+	hostRouteV6Field = 10
+)
+
+type HostAddr struct {
+	Ip     netip.Addr   `json:"ip"`
+	Prefix netip.Prefix `json:"prefix"`
+}
+
+func (a HostAddr) String() string {
+	return netip.PrefixFrom(a.Ip, a.Prefix.Bits()).String()
+}
+
+type HostInterface struct {
+	Index        int        `json:"index"`
+	Name         string     `json:"name"`
+	Mac          string     `json:"mac"`
+	Mtu          int        `json:"mtu"`
+	Flags        []string   `json:"flags"`
+	Up           bool       `json:"up"`
+	Loopback     bool       `json:"loopback"`
+	PointToPoint bool       `json:"point_to_point"`
+	Addrs        []HostAddr `json:"addrs"`
+}
+
+func (i *HostInterface) V4Addrs() (addrs []HostAddr) {
+	for _, addr := range i.Addrs {
+		if addr.Ip.Is4() {
+			addrs = append(addrs, addr)
+		}
+	}
+	return
+}
+
+func (i *HostInterface) V6Addrs() (addrs []HostAddr) {
+	for _, addr := range i.Addrs {
+		if addr.Ip.Is6() {
+			addrs = append(addrs, addr)
+		}
+	}
+	return
+}
+
+// HostInterfaces 返回所有网卡及其地址(带前缀长度)
+func HostInterfaces() (interfaces []*HostInterface, err error) {
+	netInterfaces, err := net.Interfaces()
+	if nil != err {
+		return nil, fmt.Errorf("get interfaces error: %+v", err)
+	}
+	for _, netInterface := range netInterfaces {
+		iface := &HostInterface{
+			Index:        netInterface.Index,
+			Name:         netInterface.Name,
+			Mac:          netInterface.HardwareAddr.String(),
+			Mtu:          netInterface.MTU,
+			Up:           0 != netInterface.Flags&net.FlagUp,
+			Loopback:     0 != netInterface.Flags&net.FlagLoopback,
+			PointToPoint: 0 != netInterface.Flags&net.FlagPointToPoint,
+		}
+		if 0 != netInterface.Flags {
+			iface.Flags = strings.Split(netInterface.Flags.String(), "|")
+		}
+		addrs, e := netInterface.Addrs()
+		if nil == e {
+			for _, addr := range addrs {
+				ipNet, ok := addr.(*net.IPNet)
+				if !ok {
+					continue
+				}
+				ip, ok := netip.AddrFromSlice(ipNet.IP)
+				if !ok {
+					continue
+				}
+				ip = ip.Unmap()
+				ones, _ := ipNet.Mask.Size()
+				if ip.Is4() && ones > 32 {
+					ones -= 96
+				}
+				iface.Addrs = append(iface.Addrs, HostAddr{Ip: ip, Prefix: netip.PrefixFrom(ip, ones).Masked()})
+			}
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return
+}
+
+func HostInterfaceByName(name string) (iface *HostInterface, err error) {
+	interfaces, err := HostInterfaces()
+	if nil != err {
+		return
+	}
+	for _, i := range interfaces {
+		if name == i.Name {
+			return i, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s not found", name)
+}
+
+type HostRoute struct {
+	Iface       string       `json:"iface"`
+	Destination netip.Prefix `json:"destination"`
+	Gateway     netip.Addr   `json:"gateway"`
+	Metric      int          `json:"metric"`
+	Mtu         int          `json:"mtu"`
+	Flags       int          `json:"flags"`
+}
+
+func (r *HostRoute) Up() bool {
+	return 0 != r.Flags&HostRouteFlagUp
+}
+
+func (r *HostRoute) IsDefault() bool {
+	return 0 == r.Destination.Bits()
+}
+
+func (r *HostRoute) IsReject() bool {
+	return 0 != r.Flags&HostRouteFlagReject
+}
+
+func (r *HostRoute) String() string {
+	s := r.Destination.String()
+	if r.Gateway.IsValid() && !r.Gateway.IsUnspecified() {
+		s += " via " + r.Gateway.String()
+	}
+	return s + " dev " + r.Iface + " metric " + strconv.Itoa(r.Metric)
+}
+
+// HostRoutes 读取 /proc/net/route 和 /proc/net/ipv6_route，仅支持 Linux
+func HostRoutes() (routes []*HostRoute, err error) {
+	v4, err := readHostRoutes(HostProcRouteV4, ParseProcRouteV4)
+	if nil != err {
+		return
+	}
+	v6, err := readHostRoutes(HostProcRouteV6, ParseProcRouteV6)
+	if nil != err {
+		if !os.IsNotExist(err) {
+			return
+		}
+		// 关闭了 IPv6 的内核没有这个文件
+		err = nil
+	}
+	return append(v4, v6...), nil
+}
+
+func readHostRoutes(file string, parse func(reader io.Reader) ([]*HostRoute, error)) (routes []*HostRoute, err error) {
+	f, err := os.Open(file)
+	if nil != err {
+		return
+	}
+	defer f.Close()
+	return parse(f)
+}
+
+// ParseProcRouteV4 解析 /proc/net/route 格式，地址为主机字节序的十六进制
+func ParseProcRouteV4(reader io.Reader) (routes []*HostRoute, err error) {
+	scanner := bufio.NewScanner(reader)
+	header := true
+	for scanner.Scan() {
+		if header {
+			header = false
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		dst, e1 := parseProcRouteV4Addr(fields[1])
+		gw, e2 := parseProcRouteV4Addr(fields[2])
+		mask, e3 := parseProcRouteV4Addr(fields[7])
+		flags, e4 := strconv.ParseInt(fields[3], 16, 64)
+		if nil != e1 || nil != e2 || nil != e3 || nil != e4 {
+			return nil, fmt.Errorf("route line error: %s", scanner.Text())
+		}
+		maskBytes := mask.As4()
+		ones, _ := net.IPMask(maskBytes[:]).Size()
+		route := &HostRoute{
+			Iface:       fields[0],
+			Destination: netip.PrefixFrom(dst, ones).Masked(),
+			Gateway:     gw,
+			Flags:       int(flags),
+		}
+		route.Metric, _ = strconv.Atoi(fields[6])
+		if len(fields) > 8 {
+			route.Mtu, _ = strconv.Atoi(fields[8])
+		}
+		routes = append(routes, route)
+	}
+	if err = scanner.Err(); nil != err {
+		return nil, fmt.Errorf("read route error: %+v", err)
+	}
+	return
+}
+
+func parseProcRouteV4Addr(s string) (addr netip.Addr, err error) {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if nil != err {
+		return
+	}
+	var b [4]byte
+	binary.NativeEndian.PutUint32(b[:], uint32(v))
+	return netip.AddrFrom4(b), nil
+}
+
+// ParseProcRouteV6 解析 /proc/net/ipv6_route 格式
+// dst dst_len src src_len gateway metric refcnt use flags iface
+func ParseProcRouteV6(reader io.Reader) (routes []*HostRoute, err error) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < hostRouteV6Field {
+			continue
+		}
+		dst, e1 := parseProcRouteV6Addr(fields[0])
+		bits, e2 := strconv.ParseUint(fields[1], 16, 8)
+		gw, e3 := parseProcRouteV6Addr(fields[4])
+		metric, e4 := strconv.ParseUint(fields[5], 16, 32)
+		flags, e5 := strconv.ParseUint(fields[8], 16, 32)
+		if nil != e1 || nil != e2 || nil != e3 || nil != e4 || nil != e5 || bits > 128 {
+			return nil, fmt.Errorf("ipv6 route line error: %s", scanner.Text())
+		}
+		routes = append(routes, &HostRoute{
+			Iface:       fields[9],
+			Destination: netip.PrefixFrom(dst, int(bits)).Masked(),
+			Gateway:     gw,
+			Metric:      int(metric),
+			Flags:       int(flags),
+		})
+	}
+	if err = scanner.Err(); nil != err {
+		return nil, fmt.Errorf("read ipv6 route error: %+v", err)
+	}
+	return
+}
+
+func parseProcRouteV6Addr(s string) (addr netip.Addr, err error) {
+	b, err := hex.DecodeString(s)
+	if nil != err {
+		return
+	}
+	if 16 != len(b) {
+		err = fmt.Errorf("invalid ipv6 %s", s)
+		return
+	}
+	return netip.AddrFrom16([16]byte(b)), nil
+}
+
+// HostDefaultGateway 返回 metric 最小的默认路由，ipv6 为 true 时取 IPv6
+func HostDefaultGateway(ipv6 bool) (route *HostRoute, err error) {
+	routes, err := HostRoutes()
+	if nil != err {
+		return
+	}
+	for _, r := range routes {
+		if !r.IsDefault() || !r.Up() || r.IsReject() || ipv6 != r.Destination.Addr().Is6() {
+			continue
+		}
+		if !r.Gateway.IsValid() || r.Gateway.IsUnspecified() {
+			// 点对点设备的默认路由没有网关
+			if 0 != r.Flags&HostRouteFlagGateway {
+				continue
+			}
+		}
+		if nil == route || r.Metric < route.Metric {
+			route = r
+		}
+	}
+	if nil == route {
+		err = fmt.Errorf("default gateway not found")
+	}
+	return
+}
+
+// HostRouteTo 按最长前缀匹配返回到 ip 的路由
+func HostRouteTo(ip string) (route *HostRoute, err error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if nil != err {
+		return
+	}
+	addr = addr.Unmap()
+	routes, err := HostRoutes()
+	if nil != err {
+		return
+	}
+	for _, r := range routes {
+		if !r.Up() || r.IsReject() || !r.Destination.Contains(addr) {
+			continue
+		}
+		if nil == route || r.Destination.Bits() > route.Destination.Bits() ||
+			(r.Destination.Bits() == route.Destination.Bits() && r.Metric < route.Metric) {
+			route = r
+		}
+	}
+	if nil == route {
+		err = fmt.Errorf("no route to %s", ip)
+	}
+	return
+}
+
+type HostDnsConfig struct {
+	Nameservers []string `json:"nameservers"`
+	Search      []string `json:"search"`
+	Options     []string `json:"options"`
+}
+
+// HostResolvConfig 读取 resolv.conf，file 为空时使用 /etc/resolv.conf
+func HostResolvConfig(file ...string) (conf *HostDnsConfig, err error) {
+	path := HostResolvConf
+	if len(file) > 0 && "" != file[0] {
+		path = file[0]
+	}
+	f, err := os.Open(path)
+	if nil != err {
+		return
+	}
+	defer f.Close()
+	return ParseResolvConf(f)
+}
+
+func ParseResolvConf(reader io.Reader) (conf *HostDnsConfig, err error) {
+	conf = &HostDnsConfig{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if "" == line || '#' == line[0] || ';' == line[0] {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			conf.Nameservers = append(conf.Nameservers, fields[1])
+		case "domain":
+			conf.Search = []string{fields[1]}
+		case "search":
+			conf.Search = fields[1:]
+		case "options":
+			conf.Options = append(conf.Options, fields[1:]...)
+		}
+	}
+	if err = scanner.Err(); nil != err {
+		return nil, fmt.Errorf("read resolv.conf error: %+v", err)
+	}
+	return
+}
+
+type HostAddrChange struct {
+	Iface string   `json:"iface"`
+	Addr  HostAddr `json:"addr"`
+	Added bool     `json:"added"`
+}
+
+// HostAddrWatcher 定时检查网卡地址，有新增或删除时回调
+type HostAddrWatcher struct {
+	interval time.Duration
+	handlers []func(changes []HostAddrChange)
+	last     map[string]HostAddr
+	locker   sync.Mutex
+	stop     chan struct{}
+	stopOnce sync.Once
+	started  bool
+}
+
+func NewHostAddrWatcher(interval time.Duration) *HostAddrWatcher {
+	if interval <= 0 {
+		interval = time.Duration(5) * time.Second
+	}
+	return &HostAddrWatcher{
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (w *HostAddrWatcher) OnChange(fn func(changes []HostAddrChange)) *HostAddrWatcher {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	w.handlers = append(w.handlers, fn)
+	return w
+}
+
+// Start 记录当前地址作为基准后开始检查
+func (w *HostAddrWatcher) Start() (err error) {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	if w.started {
+		return
+	}
+	w.last, err = hostAddrSnapshot()
+	if nil != err {
+		return
+	}
+	w.started = true
+	go w.loop()
+	return
+}
+
+func (w *HostAddrWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+// Check 立即检查一次，返回变化
+func (w *HostAddrWatcher) Check() (changes []HostAddrChange, err error) {
+	current, err := hostAddrSnapshot()
+	if nil != err {
+		return
+	}
+	w.locker.Lock()
+	for key, addr := range current {
+		if _, ok := w.last[key]; !ok {
+			changes = append(changes, HostAddrChange{Iface: strings.SplitN(key, "|", 2)[0], Addr: addr, Added: true})
+		}
+	}
+	for key, addr := range w.last {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, HostAddrChange{Iface: strings.SplitN(key, "|", 2)[0], Addr: addr})
+		}
+	}
+	w.last = current
+	handlers := append([]func(changes []HostAddrChange){}, w.handlers...)
+	w.locker.Unlock()
+
+	if len(changes) == 0 {
+		return
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Iface != changes[j].Iface {
+			return changes[i].Iface < changes[j].Iface
+		}
+		return changes[i].Addr.Ip.Less(changes[j].Addr.Ip)
+	})
+	for _, handler := range handlers {
+		handler(changes)
+	}
+	return
+}
+
+func (w *HostAddrWatcher) loop() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+		_, _ = w.Check()
+	}
+}
+
+func hostAddrSnapshot() (snapshot map[string]HostAddr, err error) {
+	interfaces, err := HostInterfaces()
+	if nil != err {
+		return
+	}
+	snapshot = map[string]HostAddr{}
+	for _, iface := range interfaces {
+		for _, addr := range iface.Addrs {
+			snapshot[iface.Name+"|"+addr.String()] = addr
+		}
+	}
+	return
+}
@@ -0,0 +1,152 @@
+package utilNetwork
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// procRouteHex 按 /proc/net/route 的主机字节序编码地址
+func procRouteHex(ip string) string {
+	b := netip.MustParseAddr(ip).As4()
+	return fmt.Sprintf("%08X", binary.NativeEndian.Uint32(b[:]))
+}
+
+func TestParseProcRouteV4(t *testing.T) {
+	line := func(iface, dst, gw, flags, metric, mask, mtu string) string {
+		return strings.Join([]string{iface, procRouteHex(dst), procRouteHex(gw), flags, "0", "0", metric, procRouteHex(mask), mtu, "0", "0"}, "\t")
+	}
+	data := strings.Join([]string{
+		"Iface\tDestination\tGateway\tFlags\tRefCnt\tUse\tMetric\tMask\tMTU\tWindow\tIRTT",
+		line("eth0", "0.0.0.0", "192.168.1.1", "0003", "100", "0.0.0.0", "0"),
+		line("eth0", "192.168.1.0", "0.0.0.0", "0001", "100", "255.255.255.0", "1500"),
+		line("eth1", "10.8.0.0", "0.0.0.0", "0201", "0", "255.255.0.0", "0"),
+		"short line",
+	}, "\n")
+	routes, err := ParseProcRouteV4(strings.NewReader(data))
+	if nil != err || 3 != len(routes) {
+		t.Fatalf("routes = %d %+v", len(routes), err)
+	}
+	cases := []struct {
+		want    string
+		def     bool
+		reject  bool
+		mtu     int
+		gateway bool
+	}{
+		{"0.0.0.0/0 via 192.168.1.1 dev eth0 metric 100", true, false, 0, true},
+		{"192.168.1.0/24 dev eth0 metric 100", false, false, 1500, false},
+		{"10.8.0.0/16 dev eth1 metric 0", false, true, 0, false},
+	}
+	for i, c := range cases {
+		r := routes[i]
+		if c.want != r.String() || c.def != r.IsDefault() || c.reject != r.IsReject() || !r.Up() || c.mtu != r.Mtu || c.gateway != (0 != r.Flags&HostRouteFlagGateway) {
+			t.Fatalf("route %d = %s %+v", i, r.String(), r)
+		}
+	}
+
+	if _, err = ParseProcRouteV4(strings.NewReader("header\neth0 zz 00000000 0001 0 0 0 00000000")); nil == err {
+		t.Fatalf("地址无法解析时应返回错误")
+	}
+}
+
+func TestParseProcRouteV6(t *testing.T) {
+	data := strings.Join([]string{
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003 eth0",
+		"20010db8000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001 eth0",
+		"00000000000000000000000000000001 80 00000000000000000000000000000000 00 00000000000000000000000000000000 00000000 00000002 00000000 80200001 lo",
+	}, "\n")
+	routes, err := ParseProcRouteV6(strings.NewReader(data))
+	if nil != err || 3 != len(routes) {
+		t.Fatalf("routes = %d %+v", len(routes), err)
+	}
+	want := []string{
+		"::/0 via fe80::1 dev eth0 metric 1024",
+		"2001:db8::/64 dev eth0 metric 256",
+		"::1/128 dev lo metric 0",
+	}
+	for i, r := range routes {
+		if want[i] != r.String() {
+			t.Fatalf("route %d = %s, want %s", i, r.String(), want[i])
+		}
+	}
+	if !routes[0].IsDefault() || routes[1].IsDefault() {
+		t.Fatalf("default 判断错误")
+	}
+
+	bad := []string{
+		"0000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 00000000 00000000 00000000 00000001 eth0",
+		"00000000000000000000000000000000 81 00000000000000000000000000000000 00 00000000000000000000000000000000 00000000 00000000 00000000 00000001 eth0",
+	}
+	for _, line := range bad {
+		if _, err = ParseProcRouteV6(strings.NewReader(line)); nil == err {
+			t.Fatalf("%s 应返回错误", line)
+		}
+	}
+}
+
+func TestParseResolvConf(t *testing.T) {
+	cases := []struct {
+		data        string
+		nameservers string
+		search      string
+		options     string
+	}{
+		{"nameserver 1.1.1.1\nnameserver ::1\n", "1.1.1.1,::1", "", ""},
+		{"# comment\n; comment\n\ndomain example.com\nsearch a.com b.com\n", "", "a.com,b.com", ""},
+		{"search a.com\ndomain example.com\n", "", "example.com", ""},
+		{"options ndots:2 timeout:1\noptions rotate\nnameserver\n", "", "", "ndots:2,timeout:1,rotate"},
+	}
+	for _, c := range cases {
+		conf, err := ParseResolvConf(strings.NewReader(c.data))
+		if nil != err {
+			t.Fatalf("%q: %+v", c.data, err)
+		}
+		if c.nameservers != strings.Join(conf.Nameservers, ",") || c.search != strings.Join(conf.Search, ",") || c.options != strings.Join(conf.Options, ",") {
+			t.Fatalf("%q = %+v", c.data, conf)
+		}
+	}
+}
+
+func TestHostInterfacesAndAddrWatcher(t *testing.T) {
+	interfaces, err := HostInterfaces()
+	if nil != err {
+		t.Fatalf("interfaces: %+v", err)
+	}
+	var loopback *HostInterface
+	for _, iface := range interfaces {
+		if iface.Loopback {
+			loopback = iface
+		}
+	}
+	if nil == loopback || 0 == len(loopback.V4Addrs()) {
+		t.Skip("没有带 IPv4 地址的回环网卡")
+	}
+	if iface, e := HostInterfaceByName(loopback.Name); nil != e || loopback.Index != iface.Index {
+		t.Fatalf("by name: %+v", e)
+	}
+
+	var notified []HostAddrChange
+	w := NewHostAddrWatcher(0).OnChange(func(changes []HostAddrChange) {
+		notified = append(notified, changes...)
+	})
+	if changes, e := w.Check(); nil != e || 0 == len(changes) || !changes[0].Added {
+		t.Fatalf("首次检查应报告全部地址为新增: %+v %+v", changes, e)
+	}
+	if changes, _ := w.Check(); 0 != len(changes) {
+		t.Fatalf("地址没有变化: %+v", changes)
+	}
+
+	// 模拟一个已经被删除的地址
+	removed := HostAddr{Ip: netip.MustParseAddr("203.0.113.9"), Prefix: netip.MustParsePrefix("203.0.113.0/24")}
+	w.locker.Lock()
+	w.last[loopback.Name+"|"+removed.String()] = removed
+	w.locker.Unlock()
+	notified = nil
+	changes, _ := w.Check()
+	if 1 != len(changes) || changes[0].Added || removed != changes[0].Addr || loopback.Name != changes[0].Iface || 1 != len(notified) {
+		t.Fatalf("应报告删除的地址: %+v %+v", changes, notified)
+	}
+}
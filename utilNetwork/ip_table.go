@@ -0,0 +1,465 @@
+package utilNetwork
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/oschwald/maxminddb-golang"
+	"io"
+	"math/bits"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// IpTable CIDR 到任意值的最长前缀匹配表，IPv4 与 IPv6 分别使用一棵压缩二叉前缀树
+// 查询只加读锁，Reload 在新表中加载完成后原子替换，加载失败时保留旧数据
+type IpTable[V any] struct {
+	trie   atomic.Pointer[ipTrie[V]]
+	locker sync.Mutex
+}
+
+type ipTrie[V any] struct {
+	v4     *ipTrieNode[V]
+	v6     *ipTrieNode[V]
+	size   int
+	locker sync.RWMutex
+}
+
+// ipTrieNode key 为 128 位，IPv4 地址放在高 32 位
+type ipTrieNode[V any] struct {
+	hi       uint64
+	lo       uint64
+	bits     int
+	has      bool
+	value    V
+	children [2]*ipTrieNode[V]
+}
+
+func NewIpTable[V any]() *IpTable[V] {
+	t := &IpTable[V]{}
+	t.trie.Store(&ipTrie[V]{})
+	return t
+}
+
+// Insert cidr 支持单个地址、CIDR 和 a-b 范围，范围会拆分为多个 CIDR
+func (t *IpTable[V]) Insert(cidr string, value V) (err error) {
+	r, err := ParseIpRange(cidr)
+	if nil != err {
+		return
+	}
+	t.InsertPrefix(value, r.Prefixes()...)
+	return
+}
+
+func (t *IpTable[V]) InsertPrefix(value V, prefixes ...netip.Prefix) *IpTable[V] {
+	trie := t.trie.Load()
+	trie.locker.Lock()
+	defer trie.locker.Unlock()
+	for _, prefix := range prefixes {
+		trie.insert(prefix, value)
+	}
+	return t
+}
+
+// Delete 删除完全相同的 CIDR
+func (t *IpTable[V]) Delete(cidr string) (deleted bool) {
+	r, err := ParseIpRange(cidr)
+	if nil != err {
+		return
+	}
+	trie := t.trie.Load()
+	trie.locker.Lock()
+	defer trie.locker.Unlock()
+	for _, prefix := range r.Prefixes() {
+		if trie.delete(prefix) {
+			deleted = true
+		}
+	}
+	return
+}
+
+func (t *IpTable[V]) Lookup(ip string) (value V, ok bool) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if nil != err {
+		return
+	}
+	_, value, ok = t.LookupAddr(addr)
+	return
+}
+
+// LookupAddr 返回匹配到的最长前缀及其值
+func (t *IpTable[V]) LookupAddr(addr netip.Addr) (prefix netip.Prefix, value V, ok bool) {
+	if !addr.IsValid() {
+		return
+	}
+	trie := t.trie.Load()
+	trie.locker.RLock()
+	defer trie.locker.RUnlock()
+	node := trie.lookup(addr.Unmap())
+	if nil == node {
+		return
+	}
+	return node.prefix(addr.Unmap().Is4()), node.value, true
+}
+
+func (t *IpTable[V]) Contains(ip string) bool {
+	_, ok := t.Lookup(ip)
+	return ok
+}
+
+func (t *IpTable[V]) Len() int {
+	trie := t.trie.Load()
+	trie.locker.RLock()
+	defer trie.locker.RUnlock()
+	return trie.size
+}
+
+// Walk 按地址顺序遍历，先 IPv4 后 IPv6，fn 返回 false 时停止
+func (t *IpTable[V]) Walk(fn func(prefix netip.Prefix, value V) bool) {
+	trie := t.trie.Load()
+	trie.locker.RLock()
+	defer trie.locker.RUnlock()
+	if walkIpTrie(trie.v4, true, fn) {
+		walkIpTrie(trie.v6, false, fn)
+	}
+}
+
+// Reload 在新表中执行 load，成功后原子替换当前数据
+func (t *IpTable[V]) Reload(load func(table *IpTable[V]) error) (err error) {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	tmp := NewIpTable[V]()
+	if err = load(tmp); nil != err {
+		return
+	}
+	t.trie.Store(tmp.trie.Load())
+	return
+}
+
+// LoadCsv 第一列为 CIDR、地址或 a-b 范围，第一列无法解析的行(如表头)和 # 开头的行被跳过
+// parse 为空时 V 必须是 string，取第二列
+func (t *IpTable[V]) LoadCsv(reader io.Reader, parse func(record []string) (V, error)) (err error) {
+	if nil == parse {
+		parse = func(record []string) (value V, err error) {
+			v := ""
+			if len(record) > 1 {
+				v = strings.TrimSpace(record[1])
+			}
+			if s, ok := any(&value).(*string); ok {
+				*s = v
+				return
+			}
+			err = fmt.Errorf("csv parse func required for %T", value)
+			return
+		}
+	}
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	line := 0
+	for {
+		record, e := r.Read()
+		if io.EOF == e {
+			return
+		}
+		line++
+		if nil != e {
+			return fmt.Errorf("csv line %d error: %+v", line, e)
+		}
+		if len(record) == 0 {
+			continue
+		}
+		ipRange, e := ParseIpRange(record[0])
+		if nil != e {
+			continue
+		}
+		value, e := parse(record)
+		if nil != e {
+			return fmt.Errorf("csv line %d error: %+v", line, e)
+		}
+		t.InsertPrefix(value, ipRange.Prefixes()...)
+	}
+}
+
+func (t *IpTable[V]) LoadCsvFile(file string, parse func(record []string) (V, error)) (err error) {
+	f, err := os.Open(file)
+	if nil != err {
+		return
+	}
+	defer f.Close()
+	return t.LoadCsv(f, parse)
+}
+
+// LoadJson 支持 {"10.0.0.0/8": value} 和 [{"cidr": "10.0.0.0/8", "value": value}] 两种格式
+func (t *IpTable[V]) LoadJson(reader io.Reader) (err error) {
+	data, err := io.ReadAll(reader)
+	if nil != err {
+		return
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && '[' == data[0] {
+		var items []struct {
+			Cidr  string `json:"cidr"`
+			Value V      `json:"value"`
+		}
+		if err = json.Unmarshal(data, &items); nil != err {
+			return fmt.Errorf("ip table json error: %+v", err)
+		}
+		for _, item := range items {
+			if err = t.Insert(item.Cidr, item.Value); nil != err {
+				return fmt.Errorf("ip table json %s error: %+v", item.Cidr, err)
+			}
+		}
+		return
+	}
+	items := map[string]V{}
+	if err = json.Unmarshal(data, &items); nil != err {
+		return fmt.Errorf("ip table json error: %+v", err)
+	}
+	for cidr, value := range items {
+		if err = t.Insert(cidr, value); nil != err {
+			return fmt.Errorf("ip table json %s error: %+v", cidr, err)
+		}
+	}
+	return
+}
+
+func (t *IpTable[V]) LoadJsonFile(file string) (err error) {
+	f, err := os.Open(file)
+	if nil != err {
+		return
+	}
+	defer f.Close()
+	return t.LoadJson(f)
+}
+
+// LoadMmdbFile 加载 MaxMind MMDB 格式的本地文件(如 GeoLite2-Country、GeoLite2-ASN)，每条记录解码为 V
+// V 使用 maxminddb 标签，如 struct{ Country struct{ IsoCode string `maxminddb:"iso_code"` } `maxminddb:"country"` }
+func (t *IpTable[V]) LoadMmdbFile(file string) (err error) {
+	reader, err := maxminddb.Open(file)
+	if nil != err {
+		return fmt.Errorf("open mmdb %s error: %+v", file, err)
+	}
+	defer reader.Close()
+
+	networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var value V
+		ipNet, e := networks.Network(&value)
+		if nil != e {
+			return fmt.Errorf("mmdb %s decode error: %+v", file, e)
+		}
+		prefix, ok := ipNetToPrefix(ipNet)
+		if !ok {
+			continue
+		}
+		t.InsertPrefix(value, prefix)
+	}
+	if err = networks.Err(); nil != err {
+		err = fmt.Errorf("mmdb %s error: %+v", file, err)
+	}
+	return
+}
+
+func ipNetToPrefix(ipNet *net.IPNet) (prefix netip.Prefix, ok bool) {
+	if nil == ipNet {
+		return
+	}
+	addr, ok := netip.AddrFromSlice(ipNet.IP)
+	if !ok {
+		return
+	}
+	ones, _ := ipNet.Mask.Size()
+	if addr.Is4In6() {
+		addr = addr.Unmap()
+		ones -= 96
+		if ones < 0 {
+			return prefix, false
+		}
+	}
+	return netip.PrefixFrom(addr, ones).Masked(), true
+}
+
+func ipTrieKey(addr netip.Addr) (hi uint64, lo uint64) {
+	if addr.Is4() {
+		b := addr.As4()
+		return uint64(b[0])<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32, 0
+	}
+	return addrToUint128(addr)
+}
+
+func ipTrieBit(hi uint64, lo uint64, i int) int {
+	if i < 64 {
+		return int(hi>>(63-uint(i))) & 1
+	}
+	return int(lo>>(127-uint(i))) & 1
+}
+
+func ipTrieMask(hi uint64, lo uint64, n int) (uint64, uint64) {
+	switch {
+	case n <= 0:
+		return 0, 0
+	case n < 64:
+		return hi &^ (^uint64(0) >> uint(n)), 0
+	case n == 64:
+		return hi, 0
+	case n < 128:
+		return hi, lo &^ (^uint64(0) >> uint(n-64))
+	}
+	return hi, lo
+}
+
+func ipTrieCommon(aHi, aLo, bHi, bLo uint64, max int) (n int) {
+	if x := aHi ^ bHi; x != 0 {
+		n = bits.LeadingZeros64(x)
+	} else {
+		n = 64 + bits.LeadingZeros64(aLo^bLo)
+	}
+	if n > max {
+		n = max
+	}
+	return
+}
+
+func (n *ipTrieNode[V]) prefix(is4 bool) netip.Prefix {
+	if is4 {
+		v := uint32(n.hi >> 32)
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}), n.bits)
+	}
+	return netip.PrefixFrom(uint128ToAddr(n.hi, n.lo, false), n.bits)
+}
+
+func (trie *ipTrie[V]) root(is4 bool) **ipTrieNode[V] {
+	if is4 {
+		return &trie.v4
+	}
+	return &trie.v6
+}
+
+func (trie *ipTrie[V]) insert(prefix netip.Prefix, value V) {
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+	hi, lo := ipTrieKey(addr)
+	length := prefix.Bits()
+	link := trie.root(addr.Is4())
+	for {
+		node := *link
+		if nil == node {
+			*link = &ipTrieNode[V]{hi: hi, lo: lo, bits: length, has: true, value: value}
+			trie.size++
+			return
+		}
+		common := ipTrieCommon(node.hi, node.lo, hi, lo, min(node.bits, length))
+		if common == node.bits {
+			if length == node.bits {
+				if !node.has {
+					trie.size++
+				}
+				node.has, node.value = true, value
+				return
+			}
+			link = &node.children[ipTrieBit(hi, lo, node.bits)]
+			continue
+		}
+		if common == length {
+			// 新前缀是 node 的上级
+			parent := &ipTrieNode[V]{hi: hi, lo: lo, bits: length, has: true, value: value}
+			parent.children[ipTrieBit(node.hi, node.lo, length)] = node
+			*link = parent
+			trie.size++
+			return
+		}
+		forkHi, forkLo := ipTrieMask(hi, lo, common)
+		fork := &ipTrieNode[V]{hi: forkHi, lo: forkLo, bits: common}
+		fork.children[ipTrieBit(hi, lo, common)] = &ipTrieNode[V]{hi: hi, lo: lo, bits: length, has: true, value: value}
+		fork.children[ipTrieBit(node.hi, node.lo, common)] = node
+		*link = fork
+		trie.size++
+		return
+	}
+}
+
+func (trie *ipTrie[V]) delete(prefix netip.Prefix) bool {
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+	hi, lo := ipTrieKey(addr)
+	length := prefix.Bits()
+	link := trie.root(addr.Is4())
+	var parentLink **ipTrieNode[V]
+	for {
+		node := *link
+		if nil == node || node.bits > length || ipTrieCommon(node.hi, node.lo, hi, lo, node.bits) < node.bits {
+			return false
+		}
+		if node.bits < length {
+			parentLink = link
+			link = &node.children[ipTrieBit(hi, lo, node.bits)]
+			continue
+		}
+		if !node.has {
+			return false
+		}
+		var zero V
+		node.has, node.value = false, zero
+		trie.size--
+		// 去掉不再需要的中间节点
+		switch {
+		case nil == node.children[0] && nil == node.children[1]:
+			*link = nil
+			if nil != parentLink {
+				compactIpTrie(parentLink)
+			}
+		case nil == node.children[0] || nil == node.children[1]:
+			compactIpTrie(link)
+		}
+		return true
+	}
+}
+
+func compactIpTrie[V any](link **ipTrieNode[V]) {
+	node := *link
+	if nil == node || node.has {
+		return
+	}
+	switch {
+	case nil == node.children[0]:
+		*link = node.children[1]
+	case nil == node.children[1]:
+		*link = node.children[0]
+	}
+}
+
+func (trie *ipTrie[V]) lookup(addr netip.Addr) (best *ipTrieNode[V]) {
+	hi, lo := ipTrieKey(addr)
+	node := *trie.root(addr.Is4())
+	maxBits := addr.BitLen()
+	for nil != node {
+		if ipTrieCommon(node.hi, node.lo, hi, lo, node.bits) < node.bits {
+			return
+		}
+		if node.has {
+			best = node
+		}
+		if node.bits >= maxBits {
+			return
+		}
+		node = node.children[ipTrieBit(hi, lo, node.bits)]
+	}
+	return
+}
+
+func walkIpTrie[V any](node *ipTrieNode[V], is4 bool, fn func(prefix netip.Prefix, value V) bool) bool {
+	if nil == node {
+		return true
+	}
+	if node.has && !fn(node.prefix(is4), node.value) {
+		return false
+	}
+	return walkIpTrie(node.children[0], is4, fn) && walkIpTrie(node.children[1], is4, fn)
+}
@@ -0,0 +1,130 @@
+package utilNetwork
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIpResolver 从请求中解析客户端地址
+type ClientIpResolver func(r *http.Request) netip.Addr
+
+// NewClientIpResolver 只有直连地址在 trustedProxies 中时才使用 X-Forwarded-For/X-Real-Ip，
+// X-Forwarded-For 从右往左跳过可信代理，取第一个不可信的地址，避免客户端伪造
+func NewClientIpResolver(trustedProxies ...string) (resolver ClientIpResolver, err error) {
+	trusted, err := NewPrefixSet(trustedProxies...)
+	if nil != err {
+		return
+	}
+	resolver = func(r *http.Request) netip.Addr {
+		remote := parseHostAddr(r.RemoteAddr)
+		if !remote.IsValid() || !trusted.Contains(remote) {
+			return remote
+		}
+		forwarded := r.Header.Values("X-Forwarded-For")
+		var hops []string
+		for _, value := range forwarded {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr := parseHostAddr(hops[i])
+			if !addr.IsValid() {
+				break
+			}
+			if !trusted.Contains(addr) {
+				return addr
+			}
+			remote = addr
+		}
+		if addr := parseHostAddr(r.Header.Get("X-Real-Ip")); addr.IsValid() && len(hops) == 0 {
+			return addr
+		}
+		return remote
+	}
+	return
+}
+
+func parseHostAddr(s string) netip.Addr {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); nil == err {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if nil != err {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+type IpAclOptions struct {
+	// Resolver 为空时使用 RemoteAddr
+	Resolver ClientIpResolver
+	// DefaultAllow 表中没有匹配项时是否允许
+	DefaultAllow bool
+	// OnDeny 为空时返回 403
+	OnDeny func(w http.ResponseWriter, r *http.Request, ip netip.Addr)
+}
+
+// IpAclMiddleware 根据表中的最长前缀匹配结果决定是否允许，true 为允许，false 为拒绝
+func IpAclMiddleware(table *IpTable[bool], opts IpAclOptions) func(next http.Handler) http.Handler {
+	resolver := opts.Resolver
+	if nil == resolver {
+		resolver, _ = NewClientIpResolver()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolver(r)
+			allow := opts.DefaultAllow
+			if _, v, ok := table.LookupAddr(ip); ok {
+				allow = v
+			}
+			if allow {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if nil != opts.OnDeny {
+				opts.OnDeny(w, r, ip)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}
+}
+
+type ipTableContextKey struct{}
+
+type ipTableContextValue struct {
+	ip    netip.Addr
+	value any
+	ok    bool
+}
+
+// IpTableMiddleware 查询客户端地址并把结果放入请求 context，通过 IpTableValue 读取，用于 geo/ASN 标记
+func IpTableMiddleware[V any](table *IpTable[V], resolver ClientIpResolver) func(next http.Handler) http.Handler {
+	if nil == resolver {
+		resolver, _ = NewClientIpResolver()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolver(r)
+			_, value, ok := table.LookupAddr(ip)
+			ctx := context.WithValue(r.Context(), ipTableContextKey{}, &ipTableContextValue{ip: ip, value: value, ok: ok})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// IpTableValue 返回 IpTableMiddleware 解析的客户端地址和查询结果
+func IpTableValue[V any](ctx context.Context) (ip netip.Addr, value V, ok bool) {
+	v, _ := ctx.Value(ipTableContextKey{}).(*ipTableContextValue)
+	if nil == v {
+		return
+	}
+	ip = v.ip
+	if value, ok = v.value.(V); ok {
+		ok = v.ok
+	}
+	return
+}
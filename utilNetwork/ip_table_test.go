@@ -0,0 +1,232 @@
+package utilNetwork
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestIpTableLookup(t *testing.T) {
+	table := NewIpTable[string]()
+	for cidr, value := range map[string]string{
+		"0.0.0.0/0":                  "default",
+		"10.0.0.0/8":                 "10/8",
+		"10.1.0.0/16":                "10.1/16",
+		"10.1.2.3":                   "host",
+		"192.168.0.10-192.168.0.20":  "range",
+		"fd00::/8":                   "ula",
+		"fd00:1::/32":                "fd00:1/32",
+		"2001:db8::1-2001:db8::ffff": "doc",
+	} {
+		if err := table.Insert(cidr, value); nil != err {
+			t.Fatalf("insert %s: %+v", cidr, err)
+		}
+	}
+	cases := []struct {
+		ip     string
+		want   string
+		prefix string
+	}{
+		{"10.1.2.3", "host", "10.1.2.3/32"},
+		{"10.1.2.4", "10.1/16", "10.1.0.0/16"},
+		{"10.2.0.1", "10/8", "10.0.0.0/8"},
+		{"::ffff:10.2.0.1", "10/8", "10.0.0.0/8"},
+		{"8.8.8.8", "default", "0.0.0.0/0"},
+		{"192.168.0.9", "default", "0.0.0.0/0"},
+		{"192.168.0.16", "range", "192.168.0.16/30"},
+		{"192.168.0.20", "range", "192.168.0.20/32"},
+		{"fd00:1:2::1", "fd00:1/32", "fd00:1::/32"},
+		{"fd99::1", "ula", "fd00::/8"},
+		{"2001:db8::2", "doc", "2001:db8::2/127"},
+		{"2001:db8::1:0", "", ""},
+		{"bad", "", ""},
+	}
+	for _, c := range cases {
+		value, ok := table.Lookup(c.ip)
+		if c.want != value || ("" != c.want) != ok {
+			t.Fatalf("Lookup(%s) = %q %v, want %q", c.ip, value, ok, c.want)
+		}
+		if "" == c.prefix {
+			continue
+		}
+		if prefix, _, _ := table.LookupAddr(netip.MustParseAddr(c.ip)); c.prefix != prefix.String() {
+			t.Fatalf("LookupAddr(%s) prefix = %s, want %s", c.ip, prefix.String(), c.prefix)
+		}
+	}
+
+	if !table.Delete("10.1.0.0/16") || table.Delete("10.1.0.0/17") {
+		t.Fatalf("Delete 只删除完全相同的 CIDR")
+	}
+	if value, _ := table.Lookup("10.1.2.4"); "10/8" != value {
+		t.Fatalf("删除后应匹配到上一级: %s", value)
+	}
+	if value, _ := table.Lookup("10.1.2.3"); "host" != value {
+		t.Fatalf("删除中间节点后子节点应保留: %s", value)
+	}
+}
+
+func TestIpTableWalkAndLen(t *testing.T) {
+	table := NewIpTable[int]()
+	items := []string{"fd00::/8", "10.1.0.0/16", "10.0.0.0/8", "1.0.0.0/24", "::1"}
+	for i, cidr := range items {
+		_ = table.Insert(cidr, i)
+	}
+	table.InsertPrefix(9, netip.MustParsePrefix("10.0.0.0/8"))
+	if len(items) != table.Len() {
+		t.Fatalf("重复插入不应增加数量: %d", table.Len())
+	}
+	var walked []string
+	table.Walk(func(prefix netip.Prefix, value int) bool {
+		walked = append(walked, fmt.Sprintf("%s=%d", prefix.String(), value))
+		return true
+	})
+	if want := "1.0.0.0/24=3,10.0.0.0/8=9,10.1.0.0/16=1,::1/128=4,fd00::/8=0"; want != strings.Join(walked, ",") {
+		t.Fatalf("walk = %v, want %s", walked, want)
+	}
+	count := 0
+	table.Walk(func(prefix netip.Prefix, value int) bool {
+		count++
+		return count < 2
+	})
+	if 2 != count {
+		t.Fatalf("fn 返回 false 时应停止遍历: %d", count)
+	}
+}
+
+func TestIpTableReloadAndLoad(t *testing.T) {
+	table := NewIpTable[string]()
+	err := table.Reload(func(tmp *IpTable[string]) error {
+		return tmp.LoadCsv(strings.NewReader("cidr,name\n# comment\n10.0.0.0/8,private\n 1.1.1.1 , dns\n"), nil)
+	})
+	if nil != err || 2 != table.Len() {
+		t.Fatalf("load csv: %d %+v", table.Len(), err)
+	}
+	if value, _ := table.Lookup("1.1.1.1"); "dns" != value {
+		t.Fatalf("csv 值 = %q", value)
+	}
+
+	err = table.Reload(func(tmp *IpTable[string]) error {
+		_ = tmp.Insert("8.8.8.8", "google")
+		return tmp.LoadJson(strings.NewReader(`{"bad cidr": "x"}`))
+	})
+	if nil == err || !table.Contains("10.1.1.1") || table.Contains("8.8.8.8") {
+		t.Fatalf("加载失败时应保留旧数据: %+v", err)
+	}
+
+	cases := []string{
+		`{"10.0.0.0/8": "a", "fd00::/8": "b"}`,
+		`[{"cidr": "10.0.0.0/8", "value": "a"}, {"cidr": "fd00::/8", "value": "b"}]`,
+	}
+	for _, data := range cases {
+		err = table.Reload(func(tmp *IpTable[string]) error {
+			return tmp.LoadJson(strings.NewReader(data))
+		})
+		if nil != err || 2 != table.Len() {
+			t.Fatalf("load json %s: %d %+v", data, table.Len(), err)
+		}
+		if value, _ := table.Lookup("fd00::1"); "b" != value {
+			t.Fatalf("json 值 = %q", value)
+		}
+	}
+
+	ints := NewIpTable[int]()
+	if err = ints.LoadCsv(strings.NewReader("10.0.0.0/8,1\n"), nil); nil == err {
+		t.Fatalf("V 不是 string 且没有 parse 时应返回错误")
+	}
+}
+
+func TestClientIpResolver(t *testing.T) {
+	resolver, err := NewClientIpResolver("10.0.0.0/8", "::1")
+	if nil != err {
+		t.Fatalf("resolver: %+v", err)
+	}
+	cases := []struct {
+		remote    string
+		forwarded []string
+		realIp    string
+		want      string
+	}{
+		{"1.2.3.4:1000", []string{"5.6.7.8"}, "", "1.2.3.4"},
+		{"10.0.0.1:1000", []string{"5.6.7.8"}, "", "5.6.7.8"},
+		{"10.0.0.1:1000", []string{"6.6.6.6, 5.6.7.8, 10.0.0.2"}, "", "5.6.7.8"},
+		{"10.0.0.1:1000", []string{"6.6.6.6", "10.0.0.3"}, "", "6.6.6.6"},
+		{"10.0.0.1:1000", []string{"10.0.0.3"}, "", "10.0.0.3"},
+		{"10.0.0.1:1000", []string{"garbage, 5.6.7.8"}, "", "5.6.7.8"},
+		{"10.0.0.1:1000", nil, "7.7.7.7", "7.7.7.7"},
+		{"[::1]:1000", []string{"[2001:db8::1]:443"}, "", "2001:db8::1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remote
+		for _, v := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if "" != c.realIp {
+			r.Header.Set("X-Real-Ip", c.realIp)
+		}
+		if got := resolver(r); c.want != got.String() {
+			t.Fatalf("%s %v = %s, want %s", c.remote, c.forwarded, got.String(), c.want)
+		}
+	}
+}
+
+func TestIpAclMiddleware(t *testing.T) {
+	table := NewIpTable[bool]()
+	_ = table.Insert("10.0.0.0/8", true)
+	_ = table.Insert("10.9.0.0/16", false)
+	handler := IpAclMiddleware(table, IpAclOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	cases := map[string]int{
+		"10.1.1.1:1": http.StatusNoContent,
+		"10.9.1.1:1": http.StatusForbidden,
+		"8.8.8.8:1":  http.StatusForbidden,
+	}
+	for remote, want := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if want != w.Code {
+			t.Fatalf("%s = %d, want %d", remote, w.Code, want)
+		}
+	}
+
+	var value string
+	tagged := NewIpTable[string]()
+	_ = tagged.Insert("10.0.0.0/8", "lan")
+	IpTableMiddleware(tagged, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, value, _ = IpTableValue[string](r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), &http.Request{RemoteAddr: "10.1.1.1:1", Header: http.Header{}})
+	if "lan" != value {
+		t.Fatalf("IpTableValue = %q", value)
+	}
+}
+
+func TestIpNetToPrefix(t *testing.T) {
+	cases := []struct {
+		cidr string
+		want string
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8"},
+		{"::ffff:10.0.0.0/104", "10.0.0.0/8"},
+		{"::/64", "::/64"},
+		{"2001:db8::/32", "2001:db8::/32"},
+	}
+	for _, c := range cases {
+		_, ipNet, err := net.ParseCIDR(c.cidr)
+		if nil != err {
+			t.Fatalf("%s: %+v", c.cidr, err)
+		}
+		if prefix, ok := ipNetToPrefix(ipNet); !ok || c.want != prefix.String() {
+			t.Fatalf("ipNetToPrefix(%s) = %s %v, want %s", c.cidr, prefix.String(), ok, c.want)
+		}
+	}
+	if _, ok := ipNetToPrefix(nil); ok {
+		t.Fatalf("nil 应返回 false")
+	}
+}
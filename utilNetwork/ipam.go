@@ -13,18 +13,24 @@ type UtilIpAm struct {
 	locker sync.Mutex
 }
 
+// NewUtilIpAm 内存位图管理，主机位超过 24 时(如 IPv6 /64)请使用 IpAm
 func NewUtilIpAm(netCidr string) (ipAm *UtilIpAm, err error) {
 	utilNet, err := NewUtilNet(netCidr)
 	if nil != err {
 		return
 	}
+	if utilNet.HostBits() > IpAmDenseMaxHostBits {
+		err = fmt.Errorf("network %s too large for bitmap, host bits must <= %d", netCidr, IpAmDenseMaxHostBits)
+		return
+	}
 	netIpCount := uint(utilNet.IpCount())
 	ipSet := bitset.New(netIpCount)
-	if nil != utilNet.NetworkAddress() {
+	// /31、/32 没有网络地址和广播地址，全部可用
+	if nil != utilNet.NetworkAddress() && utilNet.HostBits() > 1 {
 		ipSet.Set(0) //网络地址设置为已使用
 	}
 
-	if nil != utilNet.BroadcastAddress() {
+	if nil != utilNet.BroadcastAddress() && utilNet.HostBits() > 1 {
 		ipSet.Set(netIpCount - 1) //广播地址设置为已使用
 	}
 
@@ -37,6 +43,11 @@ func NewUtilIpAm(netCidr string) (ipAm *UtilIpAm, err error) {
 }
 
 func (ia *UtilIpAm) FindAvailableIp() (ip net.IP, err error) {
+	ia.locker.Lock()
+	defer ia.locker.Unlock()
+	return ia.findAvailableIp()
+}
+func (ia *UtilIpAm) findAvailableIp() (ip net.IP, err error) {
 	pos := uint(0)
 	found := false
 	pos, found = ia.ipSet.NextClear(pos)
@@ -44,7 +55,7 @@ func (ia *UtilIpAm) FindAvailableIp() (ip net.IP, err error) {
 		err = fmt.Errorf("no ip available")
 		return
 	}
-	ip = net.ParseIP(ia.net.GetIpByPosition(uint32(pos)))
+	ip = ia.net.IpByPosition(uint32(pos))
 	return
 }
 func (ia *UtilIpAm) FindAvailableIpAndUse() (ip net.IP, err error) {
@@ -52,37 +63,53 @@ func (ia *UtilIpAm) FindAvailableIpAndUse() (ip net.IP, err error) {
 	defer func() {
 		ia.locker.Unlock()
 	}()
-	ip, err = ia.FindAvailableIp()
+	ip, err = ia.findAvailableIp()
 	if nil != err {
 		return
 	}
-	ia.UseIp(ip)
+	ia.ipSet.Set(uint(ia.net.IpPosition(ip)))
 	return
 }
-func (ia *UtilIpAm) UseIp(ip net.IP) {
-	if nil == ip {
-		return
+func (ia *UtilIpAm) UseIp(ip net.IP) (err error) {
+	if !ia.net.Contains(ip) {
+		return fmt.Errorf("ip %v not in %s", ip, ia.net.String())
 	}
-	pos := ia.net.IpPosition(ip)
-	ia.ipSet.Set(uint(pos))
+	ia.locker.Lock()
+	defer ia.locker.Unlock()
+	ia.ipSet.Set(uint(ia.net.IpPosition(ip)))
+	return
 }
-func (ia *UtilIpAm) UseIpStr(ipStr string) {
-	ia.UseIp(net.ParseIP(ipStr))
+func (ia *UtilIpAm) UseIpStr(ipStr string) (err error) {
+	return ia.UseIp(net.ParseIP(ipStr))
 }
-func (ia *UtilIpAm) UnUseIp(ip net.IP) {
-	if nil == ip {
-		return
+func (ia *UtilIpAm) UnUseIp(ip net.IP) (err error) {
+	if !ia.net.Contains(ip) {
+		return fmt.Errorf("ip %v not in %s", ip, ia.net.String())
 	}
-	pos := ia.net.IpPosition(ip)
-	ia.ipSet.Clear(uint(pos))
+	ia.locker.Lock()
+	defer ia.locker.Unlock()
+	ia.ipSet.Clear(uint(ia.net.IpPosition(ip)))
+	return
 }
-func (ia *UtilIpAm) UnUseIpStr(ipStr string) {
-	ia.UnUseIp(net.ParseIP(ipStr))
+func (ia *UtilIpAm) UnUseIpStr(ipStr string) (err error) {
+	return ia.UnUseIp(net.ParseIP(ipStr))
+}
+func (ia *UtilIpAm) IsUsed(ip net.IP) bool {
+	if !ia.net.Contains(ip) {
+		return false
+	}
+	ia.locker.Lock()
+	defer ia.locker.Unlock()
+	return ia.ipSet.Test(uint(ia.net.IpPosition(ip)))
 }
 
 func (ia *UtilIpAm) UsedIpCount() uint32 {
+	ia.locker.Lock()
+	defer ia.locker.Unlock()
 	return uint32(ia.ipSet.Count())
 }
 func (ia *UtilIpAm) AvailableIpCount() uint32 {
+	ia.locker.Lock()
+	defer ia.locker.Unlock()
 	return uint32(ia.ipSet.Len() - ia.ipSet.Count())
 }
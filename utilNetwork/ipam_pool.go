@@ -0,0 +1,755 @@
+package utilNetwork
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	IpAmStrategyFirstFree = "first-free"
+	IpAmStrategyRandom    = "random"
+	// IpAmStrategySticky 优先分配 owner 上次使用的地址，没有时从 owner 的哈希位置开始查找
+	IpAmStrategySticky = "sticky"
+)
+
+// 稀疏地址池和随机分配时最多尝试的次数
+const ipAmProbeAttempts = 4096
+
+type IpAmPoolConfig struct {
+	Name string `json:"name" yaml:"name"`
+	Cidr string `json:"cidr" yaml:"cidr"`
+	// Gateway 为空且 ReserveGateway 为 true 时保留第一个可用地址作为网关
+	Gateway        string `json:"gateway" yaml:"gateway"`
+	ReserveGateway bool   `json:"reserve_gateway" yaml:"reserve_gateway"`
+	// Exclude 不参与分配的地址，支持单个地址、CIDR 和 10.0.0.1-10.0.0.9 形式的范围
+	Exclude []string `json:"exclude" yaml:"exclude"`
+	// Strategy 为空时位图地址池使用 first-free，稀疏地址池使用 random
+	Strategy string `json:"strategy" yaml:"strategy"`
+	// LeaseTtl 默认租约时长，0 为永不过期
+	LeaseTtl time.Duration `json:"lease_ttl" yaml:"lease_ttl"`
+}
+
+type IpAmRequest struct {
+	Owner  string            `json:"owner"`
+	Labels map[string]string `json:"labels"`
+	// Ip 不为空时分配指定地址
+	Ip string `json:"ip"`
+	// Ttl 为 0 时使用地址池的 LeaseTtl
+	Ttl time.Duration `json:"ttl"`
+}
+
+type IpAmPoolStats struct {
+	Name     string `json:"name"`
+	Cidr     string `json:"cidr"`
+	Gateway  string `json:"gateway,omitempty"`
+	Capacity uint64 `json:"capacity"`
+	Leased   int    `json:"leased"`
+	Expired  int    `json:"expired"`
+}
+
+type IpAmPool struct {
+	conf     IpAmPoolConfig
+	store    IpAmStore
+	prefix   netip.Prefix
+	hostBits int
+	baseHi   uint64
+	baseLo   uint64
+	first    uint64
+	last     uint64
+	exclude  [][2]uint64
+	gateway  netip.Addr
+	dense    bool
+}
+
+// NewIpAmPool 主机位不能超过 64，store 为空时使用内存存储
+func NewIpAmPool(ctx context.Context, conf IpAmPoolConfig, store IpAmStore) (pool *IpAmPool, err error) {
+	if "" == conf.Name {
+		conf.Name = conf.Cidr
+	}
+	if nil == store {
+		store = NewIpAmMemoryStore()
+	}
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(conf.Cidr))
+	if nil != err {
+		err = fmt.Errorf("ipam pool %s cidr error: %+v", conf.Name, err)
+		return
+	}
+	prefix = prefix.Masked()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 64 {
+		err = fmt.Errorf("ipam pool %s: host bits must <= 64, got %d", conf.Name, hostBits)
+		return
+	}
+
+	pool = &IpAmPool{
+		conf:     conf,
+		store:    store,
+		prefix:   prefix,
+		hostBits: hostBits,
+		dense:    hostBits <= IpAmDenseMaxHostBits,
+	}
+	pool.baseHi, pool.baseLo = addrToUint128(prefix.Addr())
+	maxOffset := uint64(math.MaxUint64)
+	if hostBits < 64 {
+		maxOffset = uint64(1)<<uint(hostBits) - 1
+	}
+	// IPv4 排除网络地址和广播地址，IPv6 排除子网路由器任播地址，/31、/32、/127、/128 全部可用
+	pool.last = maxOffset
+	if hostBits >= 2 {
+		pool.first = 1
+		if prefix.Addr().Is4() {
+			pool.last = maxOffset - 1
+		}
+	}
+
+	for _, item := range conf.Exclude {
+		from, to, e := pool.parseRange(item)
+		if nil != e {
+			err = fmt.Errorf("ipam pool %s exclude %s error: %+v", conf.Name, item, e)
+			return
+		}
+		pool.exclude = append(pool.exclude, [2]uint64{from, to})
+	}
+	if "" != conf.Gateway {
+		offset, e := pool.offsetOf(conf.Gateway)
+		if nil != e {
+			err = fmt.Errorf("ipam pool %s gateway error: %+v", conf.Name, e)
+			return
+		}
+		pool.gateway = pool.ipAt(offset)
+		pool.exclude = append(pool.exclude, [2]uint64{offset, offset})
+	} else if conf.ReserveGateway {
+		pool.gateway = pool.ipAt(pool.first)
+		pool.exclude = append(pool.exclude, [2]uint64{pool.first, pool.first})
+	}
+
+	err = pool.initBits(ctx)
+	return
+}
+
+// initBits 把不可分配的地址写入位图，多个节点重复执行不影响已有租约
+func (p *IpAmPool) initBits(ctx context.Context) (err error) {
+	if !p.dense {
+		return
+	}
+	ranges := append([][2]uint64{}, p.exclude...)
+	if p.first > 0 {
+		ranges = append(ranges, [2]uint64{0, p.first - 1})
+	}
+	maxOffset := uint64(1)<<uint(p.hostBits) - 1
+	if p.last < maxOffset {
+		ranges = append(ranges, [2]uint64{p.last + 1, maxOffset})
+	}
+	for _, r := range ranges {
+		if err = p.store.FillBits(ctx, p.conf.Name, r[0], r[1]-r[0]+1, true); nil != err {
+			return
+		}
+	}
+	return
+}
+
+func (p *IpAmPool) Name() string {
+	return p.conf.Name
+}
+func (p *IpAmPool) Cidr() string {
+	return p.prefix.String()
+}
+
+// Gateway 未保留网关时返回空字符串
+func (p *IpAmPool) Gateway() string {
+	if !p.gateway.IsValid() {
+		return ""
+	}
+	return p.gateway.String()
+}
+
+func (p *IpAmPool) Contains(ip string) bool {
+	_, err := p.offsetOf(ip)
+	return nil == err
+}
+
+// Allocate 分配一个地址
+func (p *IpAmPool) Allocate(ctx context.Context, req *IpAmRequest) (lease *IpAmLease, err error) {
+	if nil == req {
+		req = &IpAmRequest{}
+	}
+	if "" != req.Ip {
+		return p.allocateIp(ctx, req)
+	}
+
+	strategy := p.conf.Strategy
+	if "" == strategy {
+		strategy = IpAmStrategyFirstFree
+		if !p.dense {
+			strategy = IpAmStrategyRandom
+		}
+	}
+
+	switch strategy {
+	case IpAmStrategySticky:
+		if "" != req.Owner {
+			if lease, err = p.allocateSticky(ctx, req); nil != err || nil != lease {
+				return
+			}
+		}
+	case IpAmStrategyRandom:
+		if lease, err = p.allocateRandom(ctx, req); nil != err || nil != lease {
+			return
+		}
+	}
+
+	if !p.dense {
+		lease, err = p.probe(ctx, p.first, req)
+	} else {
+		lease, err = p.allocateFirstFree(ctx, req)
+		if nil == err && nil == lease {
+			// 回收过期租约后再试一次
+			if n, _ := p.Reclaim(ctx); n > 0 {
+				lease, err = p.allocateFirstFree(ctx, req)
+			}
+		}
+	}
+	if nil == err && nil == lease {
+		err = fmt.Errorf("ipam pool %s: no ip available", p.conf.Name)
+	}
+	return
+}
+
+func (p *IpAmPool) allocateIp(ctx context.Context, req *IpAmRequest) (lease *IpAmLease, err error) {
+	offset, err := p.offsetOf(req.Ip)
+	if nil != err {
+		return
+	}
+	if !p.usable(offset) {
+		err = fmt.Errorf("ipam pool %s: ip %s is reserved", p.conf.Name, req.Ip)
+		return
+	}
+	lease, err = p.claim(ctx, offset, req)
+	if nil != err || nil != lease {
+		return
+	}
+	existing, err := p.store.Get(ctx, p.conf.Name, offset)
+	if nil != err {
+		return
+	}
+	if nil != existing && "" != req.Owner && existing.Owner == req.Owner {
+		return existing, nil
+	}
+	err = fmt.Errorf("ipam pool %s: ip %s already in use", p.conf.Name, req.Ip)
+	return
+}
+
+func (p *IpAmPool) allocateSticky(ctx context.Context, req *IpAmRequest) (lease *IpAmLease, err error) {
+	offset, found, err := p.store.OwnerOffset(ctx, p.conf.Name, req.Owner)
+	if nil != err {
+		return
+	}
+	if found && p.usable(offset) {
+		if lease, err = p.claim(ctx, offset, req); nil != err || nil != lease {
+			return
+		}
+		existing, e := p.store.Get(ctx, p.conf.Name, offset)
+		if nil != e {
+			return nil, e
+		}
+		if nil != existing && existing.Owner == req.Owner {
+			return existing, nil
+		}
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(req.Owner))
+	return p.probe(ctx, p.first+h.Sum64()%p.span(), req)
+}
+
+func (p *IpAmPool) allocateRandom(ctx context.Context, req *IpAmRequest) (lease *IpAmLease, err error) {
+	for i := 0; i < ipAmProbeAttempts; i++ {
+		offset := p.first + rand.Uint64N(p.span())
+		if !p.usable(offset) {
+			continue
+		}
+		if lease, err = p.claim(ctx, offset, req); nil != err || nil != lease {
+			return
+		}
+	}
+	return
+}
+
+func (p *IpAmPool) allocateFirstFree(ctx context.Context, req *IpAmRequest) (lease *IpAmLease, err error) {
+	start := p.first
+	for start <= p.last {
+		offset, found, e := p.store.FindFree(ctx, p.conf.Name, start, p.last+1)
+		if nil != e || !found {
+			return nil, e
+		}
+		if p.usable(offset) {
+			if lease, err = p.claim(ctx, offset, req); nil != err || nil != lease {
+				return
+			}
+		}
+		// 被其他节点抢先占用，继续向后查找
+		start = offset + 1
+	}
+	return
+}
+
+// probe 从 start 开始依次尝试，到达末尾后从 first 继续
+func (p *IpAmPool) probe(ctx context.Context, start uint64, req *IpAmRequest) (lease *IpAmLease, err error) {
+	offset := start
+	for i := 0; i < ipAmProbeAttempts; i++ {
+		if p.usable(offset) {
+			if lease, err = p.claim(ctx, offset, req); nil != err || nil != lease {
+				return
+			}
+		}
+		if offset >= p.last {
+			offset = p.first
+		} else {
+			offset++
+		}
+	}
+	return
+}
+
+// claim 占用成功返回租约，已被占用且未过期时返回 nil
+func (p *IpAmPool) claim(ctx context.Context, offset uint64, req *IpAmRequest) (lease *IpAmLease, err error) {
+	now := time.Now()
+	lease = &IpAmLease{
+		Pool:      p.conf.Name,
+		Ip:        p.ipAt(offset).String(),
+		Offset:    offset,
+		Owner:     req.Owner,
+		Labels:    req.Labels,
+		CreatedAt: now,
+	}
+	if ttl := p.ttl(req.Ttl); ttl > 0 {
+		lease.ExpiresAt = now.Add(ttl)
+	}
+
+	ok, err := p.store.Claim(ctx, p.conf.Name, lease)
+	if nil != err {
+		return nil, err
+	}
+	if !ok {
+		existing, e := p.store.Get(ctx, p.conf.Name, offset)
+		if nil != e || nil == existing || !existing.Expired(now) {
+			return nil, e
+		}
+		// 其他节点可能已续期或回收后重新占用，只释放读取到的这个过期租约
+		if ok, err = p.store.ReleaseLease(ctx, p.conf.Name, existing); nil != err || !ok {
+			return nil, err
+		}
+		if ok, err = p.store.Claim(ctx, p.conf.Name, lease); nil != err || !ok {
+			return nil, err
+		}
+	}
+	if "" != req.Owner {
+		err = p.store.SetOwnerOffset(ctx, p.conf.Name, req.Owner, offset)
+	}
+	return
+}
+
+func (p *IpAmPool) ttl(ttl time.Duration) time.Duration {
+	if ttl > 0 {
+		return ttl
+	}
+	return p.conf.LeaseTtl
+}
+
+func (p *IpAmPool) Release(ctx context.Context, ip string) (err error) {
+	offset, err := p.offsetOf(ip)
+	if nil != err {
+		return
+	}
+	return p.store.Release(ctx, p.conf.Name, offset)
+}
+
+// Renew 延长租约，ttl 为 0 时使用地址池的 LeaseTtl
+func (p *IpAmPool) Renew(ctx context.Context, ip string, ttl time.Duration) (lease *IpAmLease, err error) {
+	lease, err = p.Get(ctx, ip)
+	if nil != err {
+		return
+	}
+	if nil == lease {
+		err = fmt.Errorf("ipam pool %s: lease %s not found", p.conf.Name, ip)
+		return
+	}
+	lease.ExpiresAt = time.Time{}
+	if ttl = p.ttl(ttl); ttl > 0 {
+		lease.ExpiresAt = time.Now().Add(ttl)
+	}
+	err = p.store.Update(ctx, p.conf.Name, lease)
+	return
+}
+
+// Get 未分配时返回 nil
+func (p *IpAmPool) Get(ctx context.Context, ip string) (lease *IpAmLease, err error) {
+	offset, err := p.offsetOf(ip)
+	if nil != err {
+		return
+	}
+	return p.store.Get(ctx, p.conf.Name, offset)
+}
+
+func (p *IpAmPool) Leases(ctx context.Context) (leases []*IpAmLease, err error) {
+	leases, err = p.store.List(ctx, p.conf.Name)
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].Offset < leases[j].Offset
+	})
+	return
+}
+
+// OwnerLeases 返回 owner 持有的租约
+func (p *IpAmPool) OwnerLeases(ctx context.Context, owner string) (leases []*IpAmLease, err error) {
+	all, err := p.Leases(ctx)
+	for _, lease := range all {
+		if lease.Owner == owner {
+			leases = append(leases, lease)
+		}
+	}
+	return
+}
+
+// Reclaim 释放已过期的租约
+func (p *IpAmPool) Reclaim(ctx context.Context) (count int, err error) {
+	leases, err := p.store.List(ctx, p.conf.Name)
+	if nil != err {
+		return
+	}
+	now := time.Now()
+	for _, lease := range leases {
+		if !lease.Expired(now) {
+			continue
+		}
+		ok, e := p.store.ReleaseLease(ctx, p.conf.Name, lease)
+		if nil != e {
+			err = e
+			return
+		}
+		if ok {
+			count++
+		}
+	}
+	return
+}
+
+func (p *IpAmPool) Stats(ctx context.Context) (stats *IpAmPoolStats, err error) {
+	leases, err := p.store.List(ctx, p.conf.Name)
+	if nil != err {
+		return
+	}
+	stats = &IpAmPoolStats{
+		Name:     p.conf.Name,
+		Cidr:     p.prefix.String(),
+		Gateway:  p.Gateway(),
+		Capacity: p.span(),
+		Leased:   len(leases),
+	}
+	for _, r := range p.excludeMerged() {
+		if n := r[1] - r[0] + 1; n <= stats.Capacity {
+			stats.Capacity -= n
+		}
+	}
+	now := time.Now()
+	for _, lease := range leases {
+		if lease.Expired(now) {
+			stats.Expired++
+		}
+	}
+	return
+}
+
+// span 可分配范围内的地址数量，/64 时为 2^64-1
+func (p *IpAmPool) span() uint64 {
+	if p.last-p.first == math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return p.last - p.first + 1
+}
+
+// excludeMerged 截断到可分配范围并合并重叠的排除范围，避免重复扣减
+func (p *IpAmPool) excludeMerged() (merged [][2]uint64) {
+	ranges := make([][2]uint64, 0, len(p.exclude))
+	for _, r := range p.exclude {
+		if r[1] < p.first || r[0] > p.last {
+			continue
+		}
+		ranges = append(ranges, [2]uint64{max(r[0], p.first), min(r[1], p.last)})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1] {
+			merged[n-1][1] = max(merged[n-1][1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return
+}
+
+func (p *IpAmPool) usable(offset uint64) bool {
+	if offset < p.first || offset > p.last {
+		return false
+	}
+	for _, r := range p.exclude {
+		if offset >= r[0] && offset <= r[1] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *IpAmPool) offsetOf(ip string) (offset uint64, err error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if nil != err {
+		return
+	}
+	addr = addr.Unmap()
+	if !p.prefix.Contains(addr) {
+		err = fmt.Errorf("ip %s not in %s", ip, p.prefix.String())
+		return
+	}
+	_, lo := addrToUint128(addr)
+	offset = lo - p.baseLo
+	return
+}
+
+func (p *IpAmPool) ipAt(offset uint64) netip.Addr {
+	return uint128ToAddr(p.baseHi, p.baseLo+offset, p.prefix.Addr().Is4())
+}
+
+// parseRange 解析单个地址、CIDR 或 a-b 范围，超出地址池的部分被截断
+func (p *IpAmPool) parseRange(s string) (from uint64, to uint64, err error) {
+	s = strings.TrimSpace(s)
+	var start, end netip.Addr
+	if a, b, ok := strings.Cut(s, "-"); ok {
+		if start, err = netip.ParseAddr(strings.TrimSpace(a)); nil != err {
+			return
+		}
+		if end, err = netip.ParseAddr(strings.TrimSpace(b)); nil != err {
+			return
+		}
+	} else if strings.Contains(s, "/") {
+		prefix, e := netip.ParsePrefix(s)
+		if nil != e {
+			return 0, 0, e
+		}
+		start, end = prefixRange(prefix.Masked())
+	} else {
+		if start, err = netip.ParseAddr(s); nil != err {
+			return
+		}
+		end = start
+	}
+	start, end = start.Unmap(), end.Unmap()
+	if end.Less(start) {
+		start, end = end, start
+	}
+	poolStart, poolEnd := prefixRange(p.prefix)
+	if start.BitLen() != poolStart.BitLen() || end.Less(poolStart) || poolEnd.Less(start) {
+		err = fmt.Errorf("not in %s", p.prefix.String())
+		return
+	}
+	if start.Less(poolStart) {
+		start = poolStart
+	}
+	if poolEnd.Less(end) {
+		end = poolEnd
+	}
+	_, startLo := addrToUint128(start)
+	_, endLo := addrToUint128(end)
+	return startLo - p.baseLo, endLo - p.baseLo, nil
+}
+
+func prefixRange(prefix netip.Prefix) (start netip.Addr, end netip.Addr) {
+	start = prefix.Addr()
+	hi, lo := addrToUint128(start)
+	hostBits := start.BitLen() - prefix.Bits()
+	switch {
+	case hostBits >= 128:
+		hi, lo = math.MaxUint64, math.MaxUint64
+	case hostBits >= 64:
+		hi |= uint64(1)<<uint(hostBits-64) - 1
+		lo = math.MaxUint64
+	default:
+		lo |= uint64(1)<<uint(hostBits) - 1
+	}
+	end = uint128ToAddr(hi, lo, start.Is4())
+	return
+}
+
+func addrToUint128(addr netip.Addr) (hi uint64, lo uint64) {
+	b := addr.As16()
+	return binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+}
+
+func uint128ToAddr(hi uint64, lo uint64, is4 bool) netip.Addr {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], hi)
+	binary.BigEndian.PutUint64(b[8:], lo)
+	addr := netip.AddrFrom16(b)
+	if is4 {
+		addr = addr.Unmap()
+	}
+	return addr
+}
+
+// IpAm 管理多个地址池，所有地址池共用同一个存储
+type IpAm struct {
+	store  IpAmStore
+	pools  []*IpAmPool
+	locker sync.RWMutex
+}
+
+// NewIpAm store 为空时使用内存存储，使用 IpAmRedisStore 时多个节点可以共享地址池
+func NewIpAm(store IpAmStore) *IpAm {
+	if nil == store {
+		store = NewIpAmMemoryStore()
+	}
+	return &IpAm{store: store}
+}
+
+// AddPool 名称不能重复，网段不能与已有地址池重叠
+func (a *IpAm) AddPool(ctx context.Context, conf IpAmPoolConfig) (pool *IpAmPool, err error) {
+	pool, err = NewIpAmPool(ctx, conf, a.store)
+	if nil != err {
+		return
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	for _, p := range a.pools {
+		if p.conf.Name == pool.conf.Name {
+			return nil, fmt.Errorf("ipam pool %s already exists", pool.conf.Name)
+		}
+		if p.prefix.Overlaps(pool.prefix) {
+			return nil, fmt.Errorf("ipam pool %s overlaps %s", pool.conf.Name, p.conf.Name)
+		}
+	}
+	a.pools = append(a.pools, pool)
+	return
+}
+
+func (a *IpAm) RemovePool(name string) {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	for i, p := range a.pools {
+		if p.conf.Name == name {
+			a.pools = append(a.pools[:i], a.pools[i+1:]...)
+			return
+		}
+	}
+}
+
+func (a *IpAm) Pool(name string) *IpAmPool {
+	a.locker.RLock()
+	defer a.locker.RUnlock()
+	for _, p := range a.pools {
+		if p.conf.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (a *IpAm) Pools() []*IpAmPool {
+	a.locker.RLock()
+	defer a.locker.RUnlock()
+	return append([]*IpAmPool{}, a.pools...)
+}
+
+// PoolOf 返回包含 ip 的地址池
+func (a *IpAm) PoolOf(ip string) *IpAmPool {
+	for _, p := range a.Pools() {
+		if p.Contains(ip) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Allocate 按 pools 的顺序(为空时按添加顺序)依次尝试，指定 req.Ip 时使用包含该地址的地址池
+func (a *IpAm) Allocate(ctx context.Context, req *IpAmRequest, pools ...string) (lease *IpAmLease, err error) {
+	if nil != req && "" != req.Ip {
+		p := a.PoolOf(req.Ip)
+		if nil == p {
+			return nil, fmt.Errorf("ipam: no pool contains %s", req.Ip)
+		}
+		return p.Allocate(ctx, req)
+	}
+	candidates := a.Pools()
+	if len(pools) > 0 {
+		candidates = nil
+		for _, name := range pools {
+			p := a.Pool(name)
+			if nil == p {
+				return nil, fmt.Errorf("ipam pool %s not found", name)
+			}
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("ipam: no pool")
+	}
+	for _, p := range candidates {
+		lease, err = p.Allocate(ctx, req)
+		if nil == err {
+			return
+		}
+	}
+	return
+}
+
+func (a *IpAm) Release(ctx context.Context, ip string) (err error) {
+	p := a.PoolOf(ip)
+	if nil == p {
+		return fmt.Errorf("ipam: no pool contains %s", ip)
+	}
+	return p.Release(ctx, ip)
+}
+
+func (a *IpAm) Renew(ctx context.Context, ip string, ttl time.Duration) (lease *IpAmLease, err error) {
+	p := a.PoolOf(ip)
+	if nil == p {
+		return nil, fmt.Errorf("ipam: no pool contains %s", ip)
+	}
+	return p.Renew(ctx, ip, ttl)
+}
+
+func (a *IpAm) Lookup(ctx context.Context, ip string) (lease *IpAmLease, err error) {
+	p := a.PoolOf(ip)
+	if nil == p {
+		return nil, fmt.Errorf("ipam: no pool contains %s", ip)
+	}
+	return p.Get(ctx, ip)
+}
+
+// Reclaim 回收所有地址池中过期的租约
+func (a *IpAm) Reclaim(ctx context.Context) (count int, err error) {
+	for _, p := range a.Pools() {
+		n, e := p.Reclaim(ctx)
+		count += n
+		if nil != e {
+			err = e
+		}
+	}
+	return
+}
+
+func (a *IpAm) Stats(ctx context.Context) (stats []*IpAmPoolStats, err error) {
+	for _, p := range a.Pools() {
+		s, e := p.Stats(ctx)
+		if nil != e {
+			return nil, e
+		}
+		stats = append(stats, s)
+	}
+	return
+}
@@ -0,0 +1,32 @@
+package utilNetwork
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIpAmPoolStatsCapacity(t *testing.T) {
+	cases := []struct {
+		cidr     string
+		exclude  []string
+		gateway  string
+		capacity uint64
+	}{
+		{"10.0.0.0/24", nil, "", 254},
+		{"10.0.0.0/24", []string{"10.0.0.10-10.0.0.19", "10.0.0.15-10.0.0.24"}, "", 254 - 15},
+		{"10.0.0.0/24", []string{"10.0.0.0/28", "10.0.0.1-10.0.0.3"}, "10.0.0.2", 254 - 15},
+		{"10.0.0.0/24", []string{"10.0.0.250-10.0.0.255"}, "10.0.0.1", 254 - 5 - 1},
+		{"10.0.0.0/31", nil, "", 2},
+		{"fd00::/120", []string{"fd00::10/124", "fd00::18"}, "", 255 - 16},
+	}
+	for _, c := range cases {
+		pool, err := NewIpAmPool(context.Background(), IpAmPoolConfig{Cidr: c.cidr, Exclude: c.exclude, Gateway: c.gateway}, nil)
+		if nil != err {
+			t.Fatalf("%s: %+v", c.cidr, err)
+		}
+		stats, err := pool.Stats(context.Background())
+		if nil != err || c.capacity != stats.Capacity {
+			t.Fatalf("%s %v capacity = %d %+v, want %d", c.cidr, c.exclude, stats.Capacity, err, c.capacity)
+		}
+	}
+}
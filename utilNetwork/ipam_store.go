@@ -0,0 +1,441 @@
+package utilNetwork
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bits-and-blooms/bitset"
+	"github.com/hilaoyu/go-utils/utilRedis"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// IpAmDenseMaxHostBits 主机位不超过该值的地址池使用位图查找空闲地址，更大的地址池(如 IPv6 /64)按偏移稀疏分配
+const IpAmDenseMaxHostBits = 24
+
+const ipAmDenseMaxOffset = uint64(1)<<IpAmDenseMaxHostBits - 1
+
+// FindFree 每次读取的位图字节数
+const ipAmRedisFindChunk = uint64(64 * 1024)
+
+type IpAmLease struct {
+	Pool      string            `json:"pool"`
+	Ip        string            `json:"ip"`
+	Offset    uint64            `json:"offset"`
+	Owner     string            `json:"owner,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Expired ExpiresAt 为零值时永不过期
+func (l *IpAmLease) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && now.After(l.ExpiresAt)
+}
+
+// IpAmStore 地址池状态存储，多个节点共用同一个存储即可共享地址池
+// 地址以相对网络地址的偏移表示，偏移不超过 2^24 的地址同时维护位图，用于 FindFree
+type IpAmStore interface {
+	// FillBits 把位图 [start, start+length) 设置为已用或空闲，用于保留和排除地址
+	FillBits(ctx context.Context, pool string, start uint64, length uint64, used bool) error
+	// FindFree 在位图 [start, end) 中查找第一个空闲偏移
+	FindFree(ctx context.Context, pool string, start uint64, end uint64) (offset uint64, found bool, err error)
+	// Claim 原子占用 lease.Offset，已被占用时返回 false
+	Claim(ctx context.Context, pool string, lease *IpAmLease) (ok bool, err error)
+	Release(ctx context.Context, pool string, offset uint64) error
+	// ReleaseLease 存储中的租约仍与 lease 相同(Owner 和 ExpiresAt 一致)时才释放，用于回收过期租约时避免释放别人刚续期或重新占用的租约
+	ReleaseLease(ctx context.Context, pool string, lease *IpAmLease) (ok bool, err error)
+	// Get 不存在时返回 nil
+	Get(ctx context.Context, pool string, offset uint64) (*IpAmLease, error)
+	Update(ctx context.Context, pool string, lease *IpAmLease) error
+	List(ctx context.Context, pool string) ([]*IpAmLease, error)
+	// OwnerOffset 返回 owner 最近一次使用的偏移，用于 sticky 分配
+	OwnerOffset(ctx context.Context, pool string, owner string) (offset uint64, found bool, err error)
+	SetOwnerOffset(ctx context.Context, pool string, owner string, offset uint64) error
+}
+
+type ipAmMemoryPool struct {
+	bits   *bitset.BitSet
+	leases map[uint64]*IpAmLease
+	owners map[string]uint64
+}
+
+type IpAmMemoryStore struct {
+	pools  map[string]*ipAmMemoryPool
+	locker sync.Mutex
+}
+
+func NewIpAmMemoryStore() *IpAmMemoryStore {
+	return &IpAmMemoryStore{pools: map[string]*ipAmMemoryPool{}}
+}
+
+func (s *IpAmMemoryStore) pool(name string) *ipAmMemoryPool {
+	p, ok := s.pools[name]
+	if !ok {
+		p = &ipAmMemoryPool{bits: bitset.New(0), leases: map[uint64]*IpAmLease{}, owners: map[string]uint64{}}
+		s.pools[name] = p
+	}
+	return p
+}
+
+func (s *IpAmMemoryStore) FillBits(ctx context.Context, pool string, start uint64, length uint64, used bool) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	p := s.pool(pool)
+	for i := start; i < start+length && i <= ipAmDenseMaxOffset; i++ {
+		p.bits.SetTo(uint(i), used)
+	}
+	return nil
+}
+
+func (s *IpAmMemoryStore) FindFree(ctx context.Context, pool string, start uint64, end uint64) (offset uint64, found bool, err error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	pos, ok := s.pool(pool).bits.NextClear(uint(start))
+	if !ok {
+		// 位图未增长到的部分都是空闲
+		pos = s.pool(pool).bits.Len()
+		if pos < uint(start) {
+			pos = uint(start)
+		}
+	}
+	if uint64(pos) >= end {
+		return
+	}
+	return uint64(pos), true, nil
+}
+
+func (s *IpAmMemoryStore) Claim(ctx context.Context, pool string, lease *IpAmLease) (ok bool, err error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	p := s.pool(pool)
+	if _, exists := p.leases[lease.Offset]; exists {
+		return false, nil
+	}
+	copied := *lease
+	p.leases[lease.Offset] = &copied
+	if lease.Offset <= ipAmDenseMaxOffset {
+		p.bits.Set(uint(lease.Offset))
+	}
+	return true, nil
+}
+
+func (s *IpAmMemoryStore) Release(ctx context.Context, pool string, offset uint64) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	p := s.pool(pool)
+	if _, exists := p.leases[offset]; !exists {
+		return nil
+	}
+	delete(p.leases, offset)
+	if offset <= ipAmDenseMaxOffset {
+		p.bits.Clear(uint(offset))
+	}
+	return nil
+}
+
+func (s *IpAmMemoryStore) ReleaseLease(ctx context.Context, pool string, lease *IpAmLease) (ok bool, err error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	p := s.pool(pool)
+	existing, exists := p.leases[lease.Offset]
+	if !exists || existing.Owner != lease.Owner || !existing.ExpiresAt.Equal(lease.ExpiresAt) {
+		return false, nil
+	}
+	delete(p.leases, lease.Offset)
+	if lease.Offset <= ipAmDenseMaxOffset {
+		p.bits.Clear(uint(lease.Offset))
+	}
+	return true, nil
+}
+
+func (s *IpAmMemoryStore) Get(ctx context.Context, pool string, offset uint64) (*IpAmLease, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	lease, ok := s.pool(pool).leases[offset]
+	if !ok {
+		return nil, nil
+	}
+	copied := *lease
+	return &copied, nil
+}
+
+func (s *IpAmMemoryStore) Update(ctx context.Context, pool string, lease *IpAmLease) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	p := s.pool(pool)
+	if _, ok := p.leases[lease.Offset]; !ok {
+		return fmt.Errorf("lease %s not found", lease.Ip)
+	}
+	copied := *lease
+	p.leases[lease.Offset] = &copied
+	return nil
+}
+
+func (s *IpAmMemoryStore) List(ctx context.Context, pool string) (leases []*IpAmLease, err error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for _, lease := range s.pool(pool).leases {
+		copied := *lease
+		leases = append(leases, &copied)
+	}
+	return
+}
+
+func (s *IpAmMemoryStore) OwnerOffset(ctx context.Context, pool string, owner string) (offset uint64, found bool, err error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	offset, found = s.pool(pool).owners[owner]
+	return
+}
+
+func (s *IpAmMemoryStore) SetOwnerOffset(ctx context.Context, pool string, owner string, offset uint64) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.pool(pool).owners[owner] = offset
+	return nil
+}
+
+// IpAmRedisStore 每个地址池使用 prefix{pool}:bits 位图、prefix{pool}:leases 和 prefix{pool}:owners 两个 hash
+// 地址池名作为 hash tag，集群模式下同一地址池的 key 位于同一个 slot，脚本可以同时操作
+type IpAmRedisStore struct {
+	rc     *utilRedis.RedisClient
+	prefix string
+}
+
+var ipAmRedisClaimScript = redis.NewScript(`
+if redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('SETBIT', KEYS[2], ARGV[1], 1)
+end
+return 1
+`)
+
+var ipAmRedisReleaseScript = redis.NewScript(`
+if redis.call('HDEL', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SETBIT', KEYS[2], ARGV[1], 0)
+end
+return 1
+`)
+
+// 比较 owner 和 expires_at 后再删除
+var ipAmRedisReleaseLeaseScript = redis.NewScript(`
+local data = redis.call('HGET', KEYS[1], ARGV[1])
+if not data then
+	return 0
+end
+local lease = cjson.decode(data)
+if (lease.owner or '') ~= ARGV[3] or (lease.expires_at or '') ~= ARGV[4] then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SETBIT', KEYS[2], ARGV[1], 0)
+end
+return 1
+`)
+
+var ipAmRedisUpdateScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+func NewIpAmRedisStore(rc *utilRedis.RedisClient, prefix string) *IpAmRedisStore {
+	if "" == prefix {
+		prefix = "utilIpAm_"
+	}
+	return &IpAmRedisStore{rc: rc, prefix: prefix}
+}
+
+func (s *IpAmRedisStore) keys(pool string) (bits string, leases string, owners string) {
+	base := s.prefix + "{" + pool + "}"
+	return base + ":bits", base + ":leases", base + ":owners"
+}
+
+func (s *IpAmRedisStore) FillBits(ctx context.Context, pool string, start uint64, length uint64, used bool) (err error) {
+	if 0 == length || start > ipAmDenseMaxOffset {
+		return
+	}
+	if start+length-1 > ipAmDenseMaxOffset {
+		length = ipAmDenseMaxOffset - start + 1
+	}
+	bits, _, _ := s.keys(pool)
+	val := int8(0)
+	if used {
+		val = 1
+	}
+	err = s.rc.BitFillContext(ctx, bits, val, int64(start), int64(length))
+	if nil != err {
+		err = fmt.Errorf("ipam redis fill bits error: %+v", err)
+	}
+	return
+}
+
+// FindFree 按 ipAmRedisFindChunk 字节分段 GETRANGE 读取位图，在本地查找空闲位。
+// 没有复用 RedisClient.BitFindSpaceStep：它在一个 Lua 脚本里逐位调用 BITPOS，
+// /8 地址池最多要执行 2^24 次，期间阻塞整个 Redis；并且依赖 BITPOS 的 BIT 模式(Redis 7+)
+func (s *IpAmRedisStore) FindFree(ctx context.Context, pool string, start uint64, end uint64) (offset uint64, found bool, err error) {
+	if end > ipAmDenseMaxOffset+1 {
+		end = ipAmDenseMaxOffset + 1
+	}
+	if start >= end {
+		return
+	}
+	bits, _, _ := s.keys(pool)
+	for from := start / 8; from*8 < end; from += ipAmRedisFindChunk {
+		data, e := s.rc.GetRange(ctx, bits, int64(from), int64(from+ipAmRedisFindChunk-1)).Bytes()
+		if nil != e && !utilRedis.ErrorIsNil(e) {
+			err = fmt.Errorf("ipam redis find free error: %+v", e)
+			return
+		}
+		for i, b := range data {
+			if 0xff == b {
+				continue
+			}
+			for j := uint64(0); j < 8; j++ {
+				position := (from+uint64(i))*8 + j
+				if position < start || 0 != b&(0x80>>j) {
+					continue
+				}
+				if position >= end {
+					return
+				}
+				return position, true, nil
+			}
+		}
+		if uint64(len(data)) < ipAmRedisFindChunk {
+			// 位图末尾之后都是空闲
+			position := (from + uint64(len(data))) * 8
+			if position < start {
+				position = start
+			}
+			if position >= end {
+				return
+			}
+			return position, true, nil
+		}
+	}
+	return
+}
+
+func (s *IpAmRedisStore) Claim(ctx context.Context, pool string, lease *IpAmLease) (ok bool, err error) {
+	data, err := json.Marshal(lease)
+	if nil != err {
+		return
+	}
+	bits, leases, _ := s.keys(pool)
+	n, err := ipAmRedisClaimScript.Run(ctx, s.rc, []string{leases, bits}, strconv.FormatUint(lease.Offset, 10), string(data), denseFlag(lease.Offset)).Int()
+	if nil != err {
+		err = fmt.Errorf("ipam redis claim error: %+v", err)
+		return
+	}
+	return 1 == n, nil
+}
+
+func (s *IpAmRedisStore) Release(ctx context.Context, pool string, offset uint64) (err error) {
+	bits, leases, _ := s.keys(pool)
+	err = ipAmRedisReleaseScript.Run(ctx, s.rc, []string{leases, bits}, strconv.FormatUint(offset, 10), denseFlag(offset)).Err()
+	if nil != err {
+		err = fmt.Errorf("ipam redis release error: %+v", err)
+	}
+	return
+}
+
+func (s *IpAmRedisStore) ReleaseLease(ctx context.Context, pool string, lease *IpAmLease) (ok bool, err error) {
+	bits, leases, _ := s.keys(pool)
+	n, err := ipAmRedisReleaseLeaseScript.Run(ctx, s.rc, []string{leases, bits}, strconv.FormatUint(lease.Offset, 10), denseFlag(lease.Offset), lease.Owner, lease.ExpiresAt.Format(time.RFC3339Nano)).Int()
+	if nil != err {
+		err = fmt.Errorf("ipam redis release error: %+v", err)
+		return
+	}
+	return 1 == n, nil
+}
+
+func (s *IpAmRedisStore) Get(ctx context.Context, pool string, offset uint64) (lease *IpAmLease, err error) {
+	_, leases, _ := s.keys(pool)
+	data, err := s.rc.HGet(ctx, leases, strconv.FormatUint(offset, 10)).Result()
+	if utilRedis.ErrorIsNil(err) {
+		return nil, nil
+	}
+	if nil != err {
+		err = fmt.Errorf("ipam redis get error: %+v", err)
+		return
+	}
+	lease = &IpAmLease{}
+	err = json.Unmarshal([]byte(data), lease)
+	return
+}
+
+func (s *IpAmRedisStore) Update(ctx context.Context, pool string, lease *IpAmLease) (err error) {
+	data, err := json.Marshal(lease)
+	if nil != err {
+		return
+	}
+	_, leases, _ := s.keys(pool)
+	n, err := ipAmRedisUpdateScript.Run(ctx, s.rc, []string{leases}, strconv.FormatUint(lease.Offset, 10), string(data)).Int()
+	if nil != err {
+		return fmt.Errorf("ipam redis update error: %+v", err)
+	}
+	if 0 == n {
+		return fmt.Errorf("lease %s not found", lease.Ip)
+	}
+	return
+}
+
+func (s *IpAmRedisStore) List(ctx context.Context, pool string) (leases []*IpAmLease, err error) {
+	_, key, _ := s.keys(pool)
+	all, err := s.rc.HGetAll(ctx, key).Result()
+	if nil != err {
+		err = fmt.Errorf("ipam redis list error: %+v", err)
+		return
+	}
+	for _, data := range all {
+		lease := &IpAmLease{}
+		if e := json.Unmarshal([]byte(data), lease); nil != e {
+			continue
+		}
+		leases = append(leases, lease)
+	}
+	return
+}
+
+func (s *IpAmRedisStore) OwnerOffset(ctx context.Context, pool string, owner string) (offset uint64, found bool, err error) {
+	_, _, owners := s.keys(pool)
+	data, err := s.rc.HGet(ctx, owners, owner).Result()
+	if utilRedis.ErrorIsNil(err) {
+		return 0, false, nil
+	}
+	if nil != err {
+		err = fmt.Errorf("ipam redis owner error: %+v", err)
+		return
+	}
+	offset, err = strconv.ParseUint(data, 10, 64)
+	found = nil == err
+	return
+}
+
+func (s *IpAmRedisStore) SetOwnerOffset(ctx context.Context, pool string, owner string, offset uint64) (err error) {
+	_, _, owners := s.keys(pool)
+	err = s.rc.HSet(ctx, owners, owner, strconv.FormatUint(offset, 10)).Err()
+	if nil != err {
+		err = fmt.Errorf("ipam redis set owner error: %+v", err)
+	}
+	return
+}
+
+func denseFlag(offset uint64) int {
+	if offset <= ipAmDenseMaxOffset {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package utilNetwork
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hilaoyu/go-utils/utilRedis"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestIpAmRedisStore(t *testing.T) *IpAmRedisStore {
+	mr := miniredis.RunT(t)
+	rc, err := utilRedis.NewRedisClient(mr.Addr(), "", 0, 5, 5)
+	if nil != err {
+		t.Fatalf("redis: %+v", err)
+	}
+	t.Cleanup(func() { _ = rc.Close() })
+	return NewIpAmRedisStore(rc, "test_")
+}
+
+func TestIpAmRedisStoreKeysUseHashTag(t *testing.T) {
+	s := newTestIpAmRedisStore(t)
+	bits, leases, owners := s.keys("pool-a")
+	for _, key := range []string{bits, leases, owners} {
+		if !strings.HasPrefix(key, "test_{pool-a}:") {
+			t.Fatalf("key %s 没有使用地址池名作为 hash tag", key)
+		}
+	}
+}
+
+func TestIpAmRedisStoreFindFree(t *testing.T) {
+	s := newTestIpAmRedisStore(t)
+	ctx := context.Background()
+	bits, _, _ := s.keys("p")
+
+	offset, found, err := s.FindFree(ctx, "p", 5, 100)
+	if nil != err || !found || 5 != offset {
+		t.Fatalf("空位图应返回 start: %d %v %+v", offset, found, err)
+	}
+
+	// 第一段全部占用，空闲位跨过分段边界
+	used := int(ipAmRedisFindChunk) + 2
+	if _, err = s.rc.SetRange(ctx, bits, 0, strings.Repeat("\xff", used)).Result(); nil != err {
+		t.Fatalf("setrange: %+v", err)
+	}
+	if _, err = s.rc.SetRange(ctx, bits, int64(used), "\xe0").Result(); nil != err {
+		t.Fatalf("setrange: %+v", err)
+	}
+	offset, found, err = s.FindFree(ctx, "p", 0, ipAmDenseMaxOffset+1)
+	if nil != err || !found || uint64(used*8+3) != offset {
+		t.Fatalf("FindFree = %d %v %+v, want %d", offset, found, err, used*8+3)
+	}
+
+	// end 之前没有空闲位
+	if _, found, err = s.FindFree(ctx, "p", 0, uint64(used*8+3)); nil != err || found {
+		t.Fatalf("[0, end) 全部占用时不应找到空闲位: %v %+v", found, err)
+	}
+
+	// 位图末尾之后都是空闲
+	offset, found, err = s.FindFree(ctx, "p", uint64(used*8+8), ipAmDenseMaxOffset+1)
+	if nil != err || !found || uint64(used*8+8) != offset {
+		t.Fatalf("位图末尾之后应空闲: %d %v %+v", offset, found, err)
+	}
+}
+
+func TestIpAmRedisStoreReleaseLease(t *testing.T) {
+	s := newTestIpAmRedisStore(t)
+	ctx := context.Background()
+	now := time.Now()
+	expired := &IpAmLease{Pool: "p", Ip: "10.0.0.7", Offset: 7, Owner: "alice", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)}
+	if ok, err := s.Claim(ctx, "p", expired); nil != err || !ok {
+		t.Fatalf("claim: %v %+v", ok, err)
+	}
+	read, err := s.Get(ctx, "p", 7)
+	if nil != err || nil == read || !read.Expired(now) {
+		t.Fatalf("get: %+v %+v", read, err)
+	}
+
+	// 读取之后被续期，不能再按过期释放
+	renewed := *read
+	renewed.ExpiresAt = now.Add(time.Hour)
+	if err = s.Update(ctx, "p", &renewed); nil != err {
+		t.Fatalf("update: %+v", err)
+	}
+	if ok, err := s.ReleaseLease(ctx, "p", read); nil != err || ok {
+		t.Fatalf("租约已续期时不应释放: %v %+v", ok, err)
+	}
+
+	// 被其他 owner 重新占用
+	taken := renewed
+	taken.Owner = "bob"
+	if err = s.Update(ctx, "p", &taken); nil != err {
+		t.Fatalf("update: %+v", err)
+	}
+	if ok, err := s.ReleaseLease(ctx, "p", &renewed); nil != err || ok {
+		t.Fatalf("owner 不同时不应释放: %v %+v", ok, err)
+	}
+
+	current, _ := s.Get(ctx, "p", 7)
+	if ok, err := s.ReleaseLease(ctx, "p", current); nil != err || !ok {
+		t.Fatalf("租约未变化时应释放: %v %+v", ok, err)
+	}
+	if lease, _ := s.Get(ctx, "p", 7); nil != lease {
+		t.Fatalf("释放后仍存在: %+v", lease)
+	}
+	if offset, found, err := s.FindFree(ctx, "p", 7, 8); nil != err || !found || 7 != offset {
+		t.Fatalf("释放后位图应清除: %d %v %+v", offset, found, err)
+	}
+}
+
+func TestIpAmPoolReclaimOnlyExpired(t *testing.T) {
+	ctx := context.Background()
+	store := NewIpAmMemoryStore()
+	pool, err := NewIpAmPool(ctx, IpAmPoolConfig{Name: "p", Cidr: "10.0.0.0/29"}, store)
+	if nil != err {
+		t.Fatalf("pool: %+v", err)
+	}
+	short, err := pool.Allocate(ctx, &IpAmRequest{Owner: "a", Ttl: time.Millisecond})
+	if nil != err {
+		t.Fatalf("allocate: %+v", err)
+	}
+	if _, err = pool.Allocate(ctx, &IpAmRequest{Owner: "b", Ttl: time.Hour}); nil != err {
+		t.Fatalf("allocate: %+v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	count, err := pool.Reclaim(ctx)
+	if nil != err || 1 != count {
+		t.Fatalf("reclaim = %d, %+v", count, err)
+	}
+	if lease, _ := pool.Get(ctx, short.Ip); nil != lease {
+		t.Fatalf("过期租约未回收: %+v", lease)
+	}
+}
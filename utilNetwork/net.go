@@ -3,6 +3,7 @@ package utilNetwork
 import (
 	"github.com/c-robinson/iplib"
 	"math"
+	"math/big"
 	"net"
 )
 
@@ -27,11 +28,25 @@ func (un *UtilNet) MaskSize() (size int) {
 	size, _ = un.Mask().Size()
 	return
 }
+
+// IpCount 地址数量，超过 uint32 范围(如 IPv6)时返回 math.MaxUint32，准确数量使用 IpCountBig
 func (un *UtilNet) IpCount() (count uint32) {
-	size, bl := un.Mask().Size()
-	count = uint32(math.Pow(2, float64(bl-size)))
+	if un.HostBits() >= 32 {
+		return math.MaxUint32
+	}
+	count = uint32(1) << uint(un.HostBits())
 	return
 }
+func (un *UtilNet) IpCountBig() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(un.HostBits()))
+}
+func (un *UtilNet) HostBits() int {
+	size, bl := un.Mask().Size()
+	return bl - size
+}
+func (un *UtilNet) Contains(ip net.IP) bool {
+	return nil != ip && un.Net.Contains(ip)
+}
 func (un *UtilNet) GetIpByPosition(position ...uint32) string {
 	var p = uint32(0)
 	if len(position) > 0 {
@@ -42,6 +57,11 @@ func (un *UtilNet) GetIpByPosition(position ...uint32) string {
 	}
 	return iplib.IncrementIPBy(un.FirstAddress(), p).String()
 }
+
+// IpByPosition IpPosition 的逆运算，按相对网络地址的偏移计算
+func (un *UtilNet) IpByPosition(position uint32) net.IP {
+	return iplib.IncrementIPBy(un.IP(), position)
+}
 func (un *UtilNet) GetIpByPositionReverse(position ...uint32) string {
 	var p = uint32(0)
 	if len(position) > 0 {
@@ -0,0 +1,59 @@
+package utilNetwork
+
+import (
+	"testing"
+)
+
+func TestUtilNetGetIpByPosition(t *testing.T) {
+	cases := []struct {
+		cidr     string
+		position uint32
+		want     string
+	}{
+		{"10.0.0.0/24", 0, "10.0.0.1"},
+		{"10.0.0.0/24", 1, "10.0.0.1"},
+		{"10.0.0.0/24", 2, "10.0.0.2"},
+		{"10.0.0.0/31", 1, "10.0.0.0"},
+		{"10.0.0.0/31", 2, "10.0.0.1"},
+		{"10.0.0.5/32", 0, "10.0.0.5"},
+		{"fd00::/120", 0, "fd00::"},
+		{"fd00::/120", 1, "fd00::"},
+		{"fd00::/120", 2, "fd00::1"},
+	}
+	for _, c := range cases {
+		un, err := NewUtilNet(c.cidr)
+		if nil != err {
+			t.Fatalf("%s: %+v", c.cidr, err)
+		}
+		if got := un.GetIpByPosition(c.position); c.want != got {
+			t.Fatalf("%s GetIpByPosition(%d) = %s, want %s", c.cidr, c.position, got, c.want)
+		}
+	}
+}
+
+func TestUtilIpAmFindAvailableIpAndUse(t *testing.T) {
+	cases := []struct {
+		cidr string
+		want []string
+	}{
+		{"10.0.0.0/30", []string{"10.0.0.1", "10.0.0.2"}},
+		{"10.0.0.0/31", []string{"10.0.0.0", "10.0.0.1"}},
+		{"10.0.0.5/32", []string{"10.0.0.5"}},
+		{"fd00::/126", []string{"fd00::", "fd00::1", "fd00::2", "fd00::3"}},
+	}
+	for _, c := range cases {
+		ipAm, err := NewUtilIpAm(c.cidr)
+		if nil != err {
+			t.Fatalf("%s: %+v", c.cidr, err)
+		}
+		for _, want := range c.want {
+			ip, e := ipAm.FindAvailableIpAndUse()
+			if nil != e || want != ip.String() {
+				t.Fatalf("%s 分配 = %v %+v, want %s", c.cidr, ip, e, want)
+			}
+		}
+		if ip, e := ipAm.FindAvailableIpAndUse(); nil == e {
+			t.Fatalf("%s 地址用完后仍分配了 %v", c.cidr, ip)
+		}
+	}
+}
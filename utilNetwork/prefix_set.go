@@ -0,0 +1,424 @@
+package utilNetwork
+
+import (
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// SplitPrefixMaxCount SplitPrefix 最多返回的子网数量
+const SplitPrefixMaxCount = 1 << 16
+
+type IpRange struct {
+	From netip.Addr `json:"from"`
+	To   netip.Addr `json:"to"`
+}
+
+// ParseIpRange 支持单个地址、CIDR 和 10.0.0.1-10.0.0.9 形式的范围
+func ParseIpRange(s string) (r IpRange, err error) {
+	s = strings.TrimSpace(s)
+	if a, b, ok := strings.Cut(s, "-"); ok {
+		if r.From, err = netip.ParseAddr(strings.TrimSpace(a)); nil != err {
+			return
+		}
+		if r.To, err = netip.ParseAddr(strings.TrimSpace(b)); nil != err {
+			return
+		}
+		r.From, r.To = r.From.Unmap(), r.To.Unmap()
+		if r.From.BitLen() != r.To.BitLen() {
+			err = fmt.Errorf("ip range %s mixes ipv4 and ipv6", s)
+			return
+		}
+		if r.To.Less(r.From) {
+			r.From, r.To = r.To, r.From
+		}
+		return
+	}
+	if strings.Contains(s, "/") {
+		prefix, e := netip.ParsePrefix(s)
+		if nil != e {
+			return r, e
+		}
+		return PrefixToRange(prefix), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if nil != err {
+		return
+	}
+	addr = addr.Unmap()
+	return IpRange{From: addr, To: addr}, nil
+}
+
+func PrefixToRange(prefix netip.Prefix) IpRange {
+	from, to := prefixRange(prefix.Masked())
+	return IpRange{From: from, To: to}
+}
+
+func (r IpRange) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.BitLen() == r.From.BitLen() && !addr.Less(r.From) && !r.To.Less(addr)
+}
+
+// Prefixes 转换为最少数量的 CIDR
+func (r IpRange) Prefixes() (prefixes []netip.Prefix) {
+	from := r.From
+	for {
+		hostBits := trailingZeros(from)
+		var prefix netip.Prefix
+		var end netip.Addr
+		for ; ; hostBits-- {
+			prefix = netip.PrefixFrom(from, from.BitLen()-hostBits)
+			_, end = prefixRange(prefix)
+			if !r.To.Less(end) {
+				break
+			}
+		}
+		prefixes = append(prefixes, prefix)
+		if end == r.To {
+			return
+		}
+		from = end.Next()
+	}
+}
+
+func (r IpRange) String() string {
+	if r.From == r.To {
+		return r.From.String()
+	}
+	return r.From.String() + "-" + r.To.String()
+}
+
+// PrefixSet 地址集合，内部保存排序并合并后的地址范围，IPv4 与 IPv6 可以混合
+type PrefixSet struct {
+	ranges []IpRange
+}
+
+// NewPrefixSet items 支持单个地址、CIDR 和 a-b 范围
+func NewPrefixSet(items ...string) (set *PrefixSet, err error) {
+	set = &PrefixSet{}
+	err = set.Add(items...)
+	return
+}
+
+func (s *PrefixSet) Add(items ...string) (err error) {
+	for _, item := range items {
+		if "" == strings.TrimSpace(item) {
+			continue
+		}
+		r, e := ParseIpRange(item)
+		if nil != e {
+			return fmt.Errorf("parse %s error: %+v", item, e)
+		}
+		s.ranges = append(s.ranges, r)
+	}
+	s.ranges = normalizeRanges(s.ranges)
+	return
+}
+
+func (s *PrefixSet) AddPrefix(prefixes ...netip.Prefix) *PrefixSet {
+	for _, prefix := range prefixes {
+		s.ranges = append(s.ranges, PrefixToRange(prefix))
+	}
+	s.ranges = normalizeRanges(s.ranges)
+	return s
+}
+
+func (s *PrefixSet) AddRange(ranges ...IpRange) *PrefixSet {
+	s.ranges = normalizeRanges(append(s.ranges, ranges...))
+	return s
+}
+
+func (s *PrefixSet) Remove(items ...string) (err error) {
+	other, err := NewPrefixSet(items...)
+	if nil != err {
+		return
+	}
+	s.ranges = subtractRanges(s.ranges, other.ranges)
+	return
+}
+
+func (s *PrefixSet) RemovePrefix(prefixes ...netip.Prefix) *PrefixSet {
+	other := (&PrefixSet{}).AddPrefix(prefixes...)
+	s.ranges = subtractRanges(s.ranges, other.ranges)
+	return s
+}
+
+func (s *PrefixSet) Clone() *PrefixSet {
+	return &PrefixSet{ranges: append([]IpRange{}, s.ranges...)}
+}
+
+// Union 返回新集合，不修改 s 和 other
+func (s *PrefixSet) Union(other *PrefixSet) *PrefixSet {
+	return &PrefixSet{ranges: normalizeRanges(append(append([]IpRange{}, s.ranges...), other.ranges...))}
+}
+
+func (s *PrefixSet) Intersect(other *PrefixSet) *PrefixSet {
+	var result []IpRange
+	for _, a := range s.ranges {
+		for _, b := range other.ranges {
+			if r, ok := intersectRange(a, b); ok {
+				result = append(result, r)
+			}
+		}
+	}
+	return &PrefixSet{ranges: normalizeRanges(result)}
+}
+
+// Subtract 返回 s 中不属于 other 的部分，如 allowed.Subtract(blocked)
+func (s *PrefixSet) Subtract(other *PrefixSet) *PrefixSet {
+	return &PrefixSet{ranges: subtractRanges(s.ranges, other.ranges)}
+}
+
+func (s *PrefixSet) Overlaps(other *PrefixSet) bool {
+	for _, a := range s.ranges {
+		for _, b := range other.ranges {
+			if _, ok := intersectRange(a, b); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *PrefixSet) OverlapsPrefix(prefix netip.Prefix) bool {
+	return s.Overlaps((&PrefixSet{}).AddPrefix(prefix))
+}
+
+func (s *PrefixSet) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return !s.ranges[i].To.Less(addr)
+	})
+	return i < len(s.ranges) && s.ranges[i].Contains(addr)
+}
+
+func (s *PrefixSet) ContainsIp(ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	return nil == err && s.Contains(addr)
+}
+
+// ContainsPrefix prefix 整个包含在集合中时返回 true
+func (s *PrefixSet) ContainsPrefix(prefix netip.Prefix) bool {
+	r := PrefixToRange(prefix)
+	for _, item := range s.ranges {
+		if item.Contains(r.From) {
+			return item.Contains(r.To)
+		}
+	}
+	return false
+}
+
+func (s *PrefixSet) IsEmpty() bool {
+	return len(s.ranges) == 0
+}
+
+func (s *PrefixSet) Ranges() []IpRange {
+	return append([]IpRange{}, s.ranges...)
+}
+
+// Prefixes 聚合后的最小 CIDR 列表
+func (s *PrefixSet) Prefixes() (prefixes []netip.Prefix) {
+	for _, r := range s.ranges {
+		prefixes = append(prefixes, r.Prefixes()...)
+	}
+	return
+}
+
+func (s *PrefixSet) Strings() (items []string) {
+	for _, prefix := range s.Prefixes() {
+		items = append(items, prefix.String())
+	}
+	return
+}
+
+// FindFree 返回集合中第一个完整包含的 /bits 子网，ipv6 指定地址族
+func (s *PrefixSet) FindFree(bits int, ipv6 bool) (prefix netip.Prefix, found bool) {
+	for _, r := range s.ranges {
+		if r.From.Is6() != ipv6 || bits < 0 || bits > r.From.BitLen() {
+			continue
+		}
+		start, ok := alignUp(r.From, r.From.BitLen()-bits)
+		if !ok || r.To.Less(start) {
+			continue
+		}
+		prefix = netip.PrefixFrom(start, bits)
+		_, end := prefixRange(prefix)
+		if !r.To.Less(end) {
+			return prefix, true
+		}
+	}
+	return
+}
+
+// AggregatePrefixes 把 CIDR 列表合并为最小形式
+func AggregatePrefixes(items ...string) (prefixes []string, err error) {
+	set, err := NewPrefixSet(items...)
+	if nil != err {
+		return
+	}
+	return set.Strings(), nil
+}
+
+// RangeToPrefixes 把 from-to 范围转换为 CIDR 列表
+func RangeToPrefixes(from string, to string) (prefixes []string, err error) {
+	r, err := ParseIpRange(from + "-" + to)
+	if nil != err {
+		return
+	}
+	for _, prefix := range r.Prefixes() {
+		prefixes = append(prefixes, prefix.String())
+	}
+	return
+}
+
+// PrefixesOverlap 两个 CIDR 是否有重叠
+func PrefixesOverlap(a string, b string) (overlap bool, err error) {
+	pa, err := netip.ParsePrefix(a)
+	if nil != err {
+		return
+	}
+	pb, err := netip.ParsePrefix(b)
+	if nil != err {
+		return
+	}
+	return pa.Overlaps(pb), nil
+}
+
+// SplitPrefix 把 prefix 拆分为 /bits 的子网，数量超过 SplitPrefixMaxCount 时返回错误
+func SplitPrefix(prefix netip.Prefix, bits int) (subnets []netip.Prefix, err error) {
+	prefix = prefix.Masked()
+	if bits < prefix.Bits() || bits > prefix.Addr().BitLen() {
+		err = fmt.Errorf("can't split %s into /%d", prefix.String(), bits)
+		return
+	}
+	if bits-prefix.Bits() > 16 {
+		err = fmt.Errorf("split %s into /%d exceeds %d subnets", prefix.String(), bits, SplitPrefixMaxCount)
+		return
+	}
+	_, last := prefixRange(prefix)
+	start := prefix.Addr()
+	for {
+		subnet := netip.PrefixFrom(start, bits)
+		subnets = append(subnets, subnet)
+		_, end := prefixRange(subnet)
+		if end == last {
+			return
+		}
+		start = end.Next()
+	}
+}
+
+// Prefix 转换为 netip.Prefix
+func (un *UtilNet) Prefix() netip.Prefix {
+	addr, _ := netip.AddrFromSlice(un.IP())
+	addr = addr.Unmap()
+	if un.Version() == 6 {
+		addr = netip.AddrFrom16(addr.As16())
+	}
+	return netip.PrefixFrom(addr, un.MaskSize()).Masked()
+}
+
+// Split 拆分为 /bits 的子网
+func (un *UtilNet) Split(bits int) (subnets []*UtilNet, err error) {
+	prefixes, err := SplitPrefix(un.Prefix(), bits)
+	if nil != err {
+		return
+	}
+	for _, prefix := range prefixes {
+		subnet, e := NewUtilNet(prefix.String())
+		if nil != e {
+			return nil, e
+		}
+		subnets = append(subnets, subnet)
+	}
+	return
+}
+
+func normalizeRanges(ranges []IpRange) (result []IpRange) {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := append([]IpRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].From.Less(sorted[j].From)
+	})
+	cur := sorted[0]
+	for _, r := range sorted[1:] {
+		if r.From.BitLen() == cur.To.BitLen() && (!cur.To.Less(r.From) || cur.To.Next() == r.From) {
+			if cur.To.Less(r.To) {
+				cur.To = r.To
+			}
+			continue
+		}
+		result = append(result, cur)
+		cur = r
+	}
+	return append(result, cur)
+}
+
+func intersectRange(a IpRange, b IpRange) (r IpRange, ok bool) {
+	if a.From.BitLen() != b.From.BitLen() {
+		return
+	}
+	r.From, r.To = a.From, a.To
+	if r.From.Less(b.From) {
+		r.From = b.From
+	}
+	if b.To.Less(r.To) {
+		r.To = b.To
+	}
+	ok = !r.To.Less(r.From)
+	return
+}
+
+func subtractRanges(ranges []IpRange, remove []IpRange) (result []IpRange) {
+	for _, r := range ranges {
+		pieces := []IpRange{r}
+		for _, x := range remove {
+			var next []IpRange
+			for _, piece := range pieces {
+				if _, ok := intersectRange(piece, x); !ok {
+					next = append(next, piece)
+					continue
+				}
+				if piece.From.Less(x.From) {
+					next = append(next, IpRange{From: piece.From, To: x.From.Prev()})
+				}
+				if x.To.Less(piece.To) {
+					next = append(next, IpRange{From: x.To.Next(), To: piece.To})
+				}
+			}
+			pieces = next
+		}
+		result = append(result, pieces...)
+	}
+	return normalizeRanges(result)
+}
+
+// trailingZeros 地址末尾 0 的位数，不超过地址位数
+func trailingZeros(addr netip.Addr) int {
+	hi, lo := addrToUint128(addr)
+	n := 128
+	if lo != 0 {
+		n = bits.TrailingZeros64(lo)
+	} else if hi != 0 {
+		n = 64 + bits.TrailingZeros64(hi)
+	}
+	if n > addr.BitLen() {
+		n = addr.BitLen()
+	}
+	return n
+}
+
+// alignUp 把地址向上对齐到 2^hostBits 的边界，溢出时返回 false
+func alignUp(addr netip.Addr, hostBits int) (aligned netip.Addr, ok bool) {
+	prefix := netip.PrefixFrom(addr, addr.BitLen()-hostBits).Masked()
+	if prefix.Addr() == addr {
+		return addr, true
+	}
+	_, end := prefixRange(prefix)
+	aligned = end.Next()
+	return aligned, aligned.IsValid()
+}
@@ -0,0 +1,167 @@
+package utilNetwork
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestAggregatePrefixes(t *testing.T) {
+	cases := []struct {
+		items []string
+		want  string
+	}{
+		{[]string{"10.0.0.0/25", "10.0.0.128/25"}, "10.0.0.0/24"},
+		{[]string{"10.0.0.0/24", "10.0.0.64/26"}, "10.0.0.0/24"},
+		{[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, "10.0.0.1/32,10.0.0.2/31"},
+		{[]string{"10.0.0.0-10.0.0.9"}, "10.0.0.0/29,10.0.0.8/31"},
+		{[]string{"::ffff:10.0.0.1", "10.0.0.0"}, "10.0.0.0/31"},
+		{[]string{"fd00::/65", "fd00:0:0:0:8000::/65", "10.0.0.0/8"}, "10.0.0.0/8,fd00::/64"},
+		{[]string{"0.0.0.0/0", "10.0.0.0/8"}, "0.0.0.0/0"},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		got, err := AggregatePrefixes(c.items...)
+		if nil != err || c.want != strings.Join(got, ",") {
+			t.Fatalf("AggregatePrefixes(%v) = %v %+v, want %s", c.items, got, err, c.want)
+		}
+	}
+	if _, err := AggregatePrefixes("10.0.0.1-fd00::1"); nil == err {
+		t.Fatalf("IPv4 与 IPv6 混合的范围应返回错误")
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	cases := []struct {
+		from string
+		to   string
+		want string
+	}{
+		{"10.0.0.0", "10.0.0.255", "10.0.0.0/24"},
+		{"10.0.0.255", "10.0.0.0", "10.0.0.0/24"},
+		{"10.0.0.5", "10.0.0.5", "10.0.0.5/32"},
+		{"10.0.0.1", "10.0.0.6", "10.0.0.1/32,10.0.0.2/31,10.0.0.4/31,10.0.0.6/32"},
+		{"0.0.0.0", "255.255.255.255", "0.0.0.0/0"},
+		{"fd00::", "fd00::ffff", "fd00::/112"},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "::/0"},
+	}
+	for _, c := range cases {
+		got, err := RangeToPrefixes(c.from, c.to)
+		if nil != err || c.want != strings.Join(got, ",") {
+			t.Fatalf("RangeToPrefixes(%s, %s) = %v %+v, want %s", c.from, c.to, got, err, c.want)
+		}
+	}
+}
+
+func TestPrefixSetOperations(t *testing.T) {
+	a, _ := NewPrefixSet("10.0.0.0/24", "fd00::/64")
+	b, _ := NewPrefixSet("10.0.0.128/25", "10.0.1.0/24")
+	cases := []struct {
+		name string
+		set  *PrefixSet
+		want string
+	}{
+		{"union", a.Union(b), "10.0.0.0/23,fd00::/64"},
+		{"intersect", a.Intersect(b), "10.0.0.128/25"},
+		{"subtract", a.Subtract(b), "10.0.0.0/25,fd00::/64"},
+		{"subtract all", b.Subtract(a.Union(b)), ""},
+	}
+	for _, c := range cases {
+		if got := strings.Join(c.set.Strings(), ","); c.want != got {
+			t.Fatalf("%s = %s, want %s", c.name, got, c.want)
+		}
+	}
+	if got := strings.Join(a.Strings(), ","); "10.0.0.0/24,fd00::/64" != got {
+		t.Fatalf("集合运算不应修改原集合: %s", got)
+	}
+
+	if err := a.Remove("10.0.0.10-10.0.0.20"); nil != err {
+		t.Fatalf("remove: %+v", err)
+	}
+	contains := map[string]bool{"10.0.0.9": true, "10.0.0.10": false, "10.0.0.20": false, "10.0.0.21": true, "::ffff:10.0.0.1": true, "fd00::1": true, "fd01::1": false, "bad": false}
+	for ip, want := range contains {
+		if got := a.ContainsIp(ip); want != got {
+			t.Fatalf("ContainsIp(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if !a.Overlaps(b) || a.OverlapsPrefix(netip.MustParsePrefix("10.0.0.12/30")) || !a.ContainsPrefix(netip.MustParsePrefix("10.0.0.24/29")) || a.ContainsPrefix(netip.MustParsePrefix("10.0.0.0/28")) {
+		t.Fatalf("overlaps/contains prefix 结果错误: %v", a.Strings())
+	}
+}
+
+func TestPrefixSetFindFree(t *testing.T) {
+	cases := []struct {
+		items []string
+		bits  int
+		ipv6  bool
+		want  string
+	}{
+		{[]string{"10.0.0.0/24"}, 26, false, "10.0.0.0/26"},
+		{[]string{"10.0.0.1-10.0.0.255"}, 26, false, "10.0.0.64/26"},
+		{[]string{"10.0.0.1-10.0.0.126"}, 26, false, ""},
+		{[]string{"10.0.0.3-10.0.0.5", "10.0.0.8/29"}, 29, false, "10.0.0.8/29"},
+		{[]string{"10.0.0.0/24"}, 26, true, ""},
+		{[]string{"10.0.0.0/24", "fd00::1-fd00::ff"}, 121, true, "fd00::80/121"},
+		{[]string{"255.255.255.255"}, 31, false, ""},
+	}
+	for _, c := range cases {
+		set, err := NewPrefixSet(c.items...)
+		if nil != err {
+			t.Fatalf("%v: %+v", c.items, err)
+		}
+		prefix, found := set.FindFree(c.bits, c.ipv6)
+		got := ""
+		if found {
+			got = prefix.String()
+		}
+		if c.want != got {
+			t.Fatalf("FindFree(%v, /%d) = %s, want %s", c.items, c.bits, got, c.want)
+		}
+	}
+}
+
+func TestSplitPrefix(t *testing.T) {
+	cases := []struct {
+		prefix string
+		bits   int
+		count  int
+		first  string
+		last   string
+		err    bool
+	}{
+		{"10.0.0.0/24", 26, 4, "10.0.0.0/26", "10.0.0.192/26", false},
+		{"10.0.0.5/24", 24, 1, "10.0.0.0/24", "10.0.0.0/24", false},
+		{"fd00::/48", 64, 1 << 16, "fd00::/64", "fd00:0:0:ffff::/64", false},
+		{"10.0.0.0/24", 23, 0, "", "", true},
+		{"10.0.0.0/24", 33, 0, "", "", true},
+		{"10.0.0.0/8", 25, 0, "", "", true},
+	}
+	for _, c := range cases {
+		subnets, err := SplitPrefix(netip.MustParsePrefix(c.prefix), c.bits)
+		if c.err {
+			if nil == err {
+				t.Fatalf("SplitPrefix(%s, %d) 应返回错误", c.prefix, c.bits)
+			}
+			continue
+		}
+		if nil != err || c.count != len(subnets) || c.first != subnets[0].String() || c.last != subnets[len(subnets)-1].String() {
+			t.Fatalf("SplitPrefix(%s, %d) = %d 个 %+v", c.prefix, c.bits, len(subnets), err)
+		}
+	}
+}
+
+func TestPrefixesOverlap(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"10.0.0.0/8", "10.1.0.0/16", true},
+		{"10.0.0.0/24", "10.0.1.0/24", false},
+		{"10.0.0.0/24", "fd00::/8", false},
+	}
+	for _, c := range cases {
+		if got, err := PrefixesOverlap(c.a, c.b); nil != err || c.want != got {
+			t.Fatalf("PrefixesOverlap(%s, %s) = %v %+v", c.a, c.b, got, err)
+		}
+	}
+}
@@ -0,0 +1,610 @@
+package utilNetwork
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProbeDuration JSON 输出为毫秒
+type ProbeDuration time.Duration
+
+func (d ProbeDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(math.Round(float64(d)/float64(time.Microsecond)) / 1000)
+}
+
+func (d *ProbeDuration) UnmarshalJSON(data []byte) (err error) {
+	var ms float64
+	if err = json.Unmarshal(data, &ms); nil != err {
+		return
+	}
+	*d = ProbeDuration(ms * float64(time.Millisecond))
+	return
+}
+
+func (d ProbeDuration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d ProbeDuration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// ProxyDialer utilProxy.UtilProxy 实现了该接口，这里只依赖 DialContext 避免循环引用
+type ProxyDialer interface {
+	DialContext(ctx context.Context, network string, addr string) (conn net.Conn, err error)
+}
+
+// Prober 原生的 TCP/TLS/HTTP 探测，设置 proxy 后通过代理连接
+type Prober struct {
+	timeout   time.Duration
+	proxy     ProxyDialer
+	tlsConfig *tls.Config
+	userAgent string
+	bodyLimit int64
+}
+
+func NewProber() *Prober {
+	return &Prober{
+		timeout:   time.Duration(10) * time.Second,
+		userAgent: "go-utils-prober",
+		bodyLimit: 1 << 20,
+	}
+}
+
+func (p *Prober) SetTimeout(timeout time.Duration) *Prober {
+	p.timeout = timeout
+	return p
+}
+
+func (p *Prober) SetProxy(proxy ProxyDialer) *Prober {
+	p.proxy = proxy
+	return p
+}
+
+// SetTlsConfig 用于指定根证书或客户端证书，TlsProbe 的证书校验结果记录在结果中，不会导致探测失败
+func (p *Prober) SetTlsConfig(tlsConfig *tls.Config) *Prober {
+	p.tlsConfig = tlsConfig
+	return p
+}
+
+func (p *Prober) SetUserAgent(userAgent string) *Prober {
+	p.userAgent = userAgent
+	return p
+}
+
+// SetBodyLimit HTTP 探测读取响应体的最大字节数，TotalTime 包含读取时间
+func (p *Prober) SetBodyLimit(limit int64) *Prober {
+	p.bodyLimit = limit
+	return p
+}
+
+func (p *Prober) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	if p.timeout > 0 {
+		return context.WithTimeout(ctx, p.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (p *Prober) dial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if nil != p.proxy {
+		return p.proxy.DialContext(ctx, network, addr)
+	}
+	dialer := &net.Dialer{}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+type TcpProbeResult struct {
+	Address     string        `json:"address"`
+	Success     bool          `json:"success"`
+	Error       string        `json:"error,omitempty"`
+	LocalAddr   string        `json:"local_addr,omitempty"`
+	RemoteAddr  string        `json:"remote_addr,omitempty"`
+	ConnectTime ProbeDuration `json:"connect_time"`
+	StartedAt   time.Time     `json:"started_at"`
+}
+
+func (p *Prober) TcpProbe(ctx context.Context, addr string) (result *TcpProbeResult) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	result = &TcpProbeResult{Address: addr, StartedAt: time.Now()}
+	conn, err := p.dial(ctx, "tcp", addr)
+	result.ConnectTime = ProbeDuration(time.Since(result.StartedAt))
+	if nil != err {
+		result.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+	result.Success = true
+	result.LocalAddr = conn.LocalAddr().String()
+	result.RemoteAddr = conn.RemoteAddr().String()
+	return
+}
+
+type ProbeCertInfo struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	DnsNames     []string  `json:"dns_names,omitempty"`
+	IpAddresses  []string  `json:"ip_addresses,omitempty"`
+	SerialNumber string    `json:"serial_number"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	DaysLeft     int       `json:"days_left"`
+	IsCa         bool      `json:"is_ca"`
+	Sha256       string    `json:"sha256"`
+}
+
+func newProbeCertInfo(cert *x509.Certificate) ProbeCertInfo {
+	sum := sha256.Sum256(cert.Raw)
+	info := ProbeCertInfo{
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		DnsNames:     cert.DNSNames,
+		SerialNumber: cert.SerialNumber.Text(16),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		DaysLeft:     int(math.Floor(time.Until(cert.NotAfter).Hours() / 24)),
+		IsCa:         cert.IsCA,
+		Sha256:       hex.EncodeToString(sum[:]),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IpAddresses = append(info.IpAddresses, ip.String())
+	}
+	return info
+}
+
+type TlsProbeResult struct {
+	Address            string          `json:"address"`
+	ServerName         string          `json:"server_name"`
+	Success            bool            `json:"success"`
+	Error              string          `json:"error,omitempty"`
+	ConnectTime        ProbeDuration   `json:"connect_time"`
+	HandshakeTime      ProbeDuration   `json:"handshake_time"`
+	Version            string          `json:"version,omitempty"`
+	CipherSuite        string          `json:"cipher_suite,omitempty"`
+	NegotiatedProtocol string          `json:"negotiated_protocol,omitempty"`
+	Verified           bool            `json:"verified"`
+	VerifyError        string          `json:"verify_error,omitempty"`
+	Certificates       []ProbeCertInfo `json:"certificates,omitempty"`
+	StartedAt          time.Time       `json:"started_at"`
+}
+
+// Leaf 服务端证书
+func (r *TlsProbeResult) Leaf() *ProbeCertInfo {
+	if len(r.Certificates) == 0 {
+		return nil
+	}
+	return &r.Certificates[0]
+}
+
+// TlsProbe serverName 为空时使用 addr 中的主机名
+func (p *Prober) TlsProbe(ctx context.Context, addr string, serverName string, nextProtos ...string) (result *TlsProbeResult) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	if "" == serverName {
+		serverName, _, _ = net.SplitHostPort(addr)
+	}
+	result = &TlsProbeResult{Address: addr, ServerName: serverName, StartedAt: time.Now()}
+	conn, err := p.dial(ctx, "tcp", addr)
+	result.ConnectTime = ProbeDuration(time.Since(result.StartedAt))
+	if nil != err {
+		result.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+
+	tlsConn, err := p.tlsHandshake(ctx, conn, serverName, nextProtos, result)
+	if nil != err {
+		result.Error = err.Error()
+		return
+	}
+	defer tlsConn.Close()
+	result.Success = true
+	return
+}
+
+func (p *Prober) tlsHandshake(ctx context.Context, conn net.Conn, serverName string, nextProtos []string, result *TlsProbeResult) (tlsConn *tls.Conn, err error) {
+	config := &tls.Config{}
+	if nil != p.tlsConfig {
+		config = p.tlsConfig.Clone()
+	}
+	roots := config.RootCAs
+	// 自行校验证书，握手本身不因证书问题失败
+	config.InsecureSkipVerify = true
+	config.ServerName = serverName
+	if len(nextProtos) > 0 {
+		config.NextProtos = nextProtos
+	}
+	tlsConn = tls.Client(conn, config)
+	start := time.Now()
+	err = tlsConn.HandshakeContext(ctx)
+	result.HandshakeTime = ProbeDuration(time.Since(start))
+	if nil != err {
+		return nil, err
+	}
+	state := tlsConn.ConnectionState()
+	result.Version = tls.VersionName(state.Version)
+	result.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	result.NegotiatedProtocol = state.NegotiatedProtocol
+	for _, cert := range state.PeerCertificates {
+		result.Certificates = append(result.Certificates, newProbeCertInfo(cert))
+	}
+	if len(state.PeerCertificates) == 0 {
+		result.VerifyError = "no peer certificates"
+		return
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, e := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if nil != e {
+		result.VerifyError = e.Error()
+	} else {
+		result.Verified = true
+	}
+	return
+}
+
+type HttpProbeResult struct {
+	Url           string          `json:"url"`
+	Method        string          `json:"method"`
+	Success       bool            `json:"success"`
+	Error         string          `json:"error,omitempty"`
+	StatusCode    int             `json:"status_code,omitempty"`
+	Proto         string          `json:"proto,omitempty"`
+	RemoteAddr    string          `json:"remote_addr,omitempty"`
+	ContentLength int64           `json:"content_length"`
+	Location      string          `json:"location,omitempty"`
+	DnsTime       ProbeDuration   `json:"dns_time"`
+	ConnectTime   ProbeDuration   `json:"connect_time"`
+	TlsTime       ProbeDuration   `json:"tls_time"`
+	TtfbTime      ProbeDuration   `json:"ttfb_time"`
+	TotalTime     ProbeDuration   `json:"total_time"`
+	Tls           *TlsProbeResult `json:"tls,omitempty"`
+	StartedAt     time.Time       `json:"started_at"`
+}
+
+// HttpProbe 不跟随跳转，状态码小于 500 视为成功；TtfbTime 从发出请求开始计算
+func (p *Prober) HttpProbe(ctx context.Context, method string, url string, header ...http.Header) (result *HttpProbeResult) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	if "" == method {
+		method = http.MethodGet
+	}
+	result = &HttpProbeResult{Url: url, Method: method, StartedAt: time.Now()}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if nil != err {
+		result.Error = err.Error()
+		return
+	}
+	for _, h := range header {
+		for k, values := range h {
+			for _, v := range values {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+	if "" == req.Header.Get("User-Agent") && "" != p.userAgent {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+
+	var dnsStart, connectStart, tlsStart time.Time
+	var locker sync.Mutex
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			locker.Lock()
+			dnsStart = time.Now()
+			locker.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			locker.Lock()
+			result.DnsTime = ProbeDuration(time.Since(dnsStart))
+			locker.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			locker.Lock()
+			if connectStart.IsZero() {
+				connectStart = time.Now()
+			}
+			locker.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			locker.Lock()
+			result.ConnectTime = ProbeDuration(time.Since(connectStart))
+			locker.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			locker.Lock()
+			tlsStart = time.Now()
+			locker.Unlock()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, _ error) {
+			locker.Lock()
+			result.TlsTime = ProbeDuration(time.Since(tlsStart))
+			locker.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			locker.Lock()
+			result.RemoteAddr = info.Conn.RemoteAddr().String()
+			locker.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			locker.Lock()
+			result.TtfbTime = ProbeDuration(time.Since(result.StartedAt))
+			locker.Unlock()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	tlsConfig := &tls.Config{}
+	if nil != p.tlsConfig {
+		tlsConfig = p.tlsConfig.Clone()
+	}
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig:   tlsConfig,
+		ForceAttemptHTTP2: true,
+	}
+	if nil != p.proxy {
+		// 通过代理连接时 DNS 由代理解析，ConnectTime 为建立代理连接的时间
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			start := time.Now()
+			conn, e := p.proxy.DialContext(ctx, network, addr)
+			locker.Lock()
+			result.ConnectTime = ProbeDuration(time.Since(start))
+			locker.Unlock()
+			return conn, e
+		}
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if nil != err {
+		locker.Lock()
+		result.TotalTime = ProbeDuration(time.Since(result.StartedAt))
+		result.Error = err.Error()
+		locker.Unlock()
+		return
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, p.bodyLimit))
+	resp.Body.Close()
+
+	locker.Lock()
+	defer locker.Unlock()
+	result.TotalTime = ProbeDuration(time.Since(result.StartedAt))
+	result.StatusCode = resp.StatusCode
+	result.Proto = resp.Proto
+	result.Location = resp.Header.Get("Location")
+	result.ContentLength = resp.ContentLength
+	if result.ContentLength < 0 {
+		result.ContentLength = n
+	}
+	if nil != resp.TLS {
+		result.Tls = &TlsProbeResult{
+			Address:            result.RemoteAddr,
+			ServerName:         resp.TLS.ServerName,
+			Success:            true,
+			ConnectTime:        result.ConnectTime,
+			HandshakeTime:      result.TlsTime,
+			Version:            tls.VersionName(resp.TLS.Version),
+			CipherSuite:        tls.CipherSuiteName(resp.TLS.CipherSuite),
+			NegotiatedProtocol: resp.TLS.NegotiatedProtocol,
+			Verified:           !tlsConfig.InsecureSkipVerify,
+			StartedAt:          result.StartedAt,
+		}
+		if "" == result.Tls.ServerName {
+			result.Tls.ServerName = req.URL.Hostname()
+		}
+		for _, cert := range resp.TLS.PeerCertificates {
+			result.Tls.Certificates = append(result.Tls.Certificates, newProbeCertInfo(cert))
+		}
+	}
+	if nil != err {
+		result.Error = fmt.Sprintf("read body error: %+v", err)
+		return
+	}
+	result.Success = resp.StatusCode < 500
+	return
+}
+
+type LatencyStats struct {
+	Address  string        `json:"address"`
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	Loss     float64       `json:"loss"`
+	Min      ProbeDuration `json:"min"`
+	Max      ProbeDuration `json:"max"`
+	Avg      ProbeDuration `json:"avg"`
+	StdDev   ProbeDuration `json:"std_dev"`
+	Errors   []string      `json:"errors,omitempty"`
+}
+
+// TcpPing 以 TCP 建连时间测量延迟，不需要 ICMP 权限
+func (p *Prober) TcpPing(ctx context.Context, addr string, count int, interval time.Duration) (stats *LatencyStats) {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	if count <= 0 {
+		count = 4
+	}
+	stats = &LatencyStats{Address: addr}
+	var samples []float64
+	for i := 0; i < count; i++ {
+		if i > 0 && interval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+		if nil != ctx.Err() {
+			break
+		}
+		stats.Sent++
+		r := p.TcpProbe(ctx, addr)
+		if !r.Success {
+			stats.Errors = append(stats.Errors, r.Error)
+			continue
+		}
+		stats.Received++
+		samples = append(samples, float64(r.ConnectTime))
+	}
+	if stats.Sent > 0 {
+		stats.Loss = float64(stats.Sent-stats.Received) / float64(stats.Sent)
+	}
+	if len(samples) == 0 {
+		return
+	}
+	minV, maxV, sum := samples[0], samples[0], 0.0
+	for _, s := range samples {
+		minV = math.Min(minV, s)
+		maxV = math.Max(maxV, s)
+		sum += s
+	}
+	avg := sum / float64(len(samples))
+	variance := 0.0
+	for _, s := range samples {
+		variance += (s - avg) * (s - avg)
+	}
+	stats.Min = ProbeDuration(minV)
+	stats.Max = ProbeDuration(maxV)
+	stats.Avg = ProbeDuration(avg)
+	stats.StdDev = ProbeDuration(math.Sqrt(variance / float64(len(samples))))
+	return
+}
+
+type PortScanResult struct {
+	Port        int           `json:"port"`
+	Open        bool          `json:"open"`
+	Error       string        `json:"error,omitempty"`
+	ConnectTime ProbeDuration `json:"connect_time"`
+}
+
+// PortScan 并发扫描 host 的端口，concurrency 为并发数，rate 为每秒最多发起的连接数(<=0 不限制)
+// 结果按端口排序，ctx 取消时返回已完成的部分
+func (p *Prober) PortScan(ctx context.Context, host string, ports []int, concurrency int, rate int) (results []*PortScanResult) {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	if concurrency <= 0 {
+		concurrency = 100
+	}
+	var ticker *time.Ticker
+	if rate > 0 {
+		// rate 超过 1e9 时间隔为 0，NewTicker 会 panic
+		interval := time.Second / time.Duration(rate)
+		if interval < time.Nanosecond {
+			interval = time.Nanosecond
+		}
+		ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+	}
+
+	jobs := make(chan int)
+	var locker sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(ports); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := range jobs {
+				r := p.TcpProbe(ctx, net.JoinHostPort(host, strconv.Itoa(port)))
+				result := &PortScanResult{Port: port, Open: r.Success, ConnectTime: r.ConnectTime}
+				if !r.Success {
+					result.Error = r.Error
+				}
+				locker.Lock()
+				results = append(results, result)
+				locker.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, port := range ports {
+		if nil != ticker {
+			select {
+			case <-ctx.Done():
+				break feed
+			case <-ticker.C:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- port:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Port < results[j].Port
+	})
+	return
+}
+
+// OpenPorts 只返回开放的端口
+func (p *Prober) OpenPorts(ctx context.Context, host string, ports []int, concurrency int, rate int) (open []int) {
+	for _, r := range p.PortScan(ctx, host, ports, concurrency, rate) {
+		if r.Open {
+			open = append(open, r.Port)
+		}
+	}
+	return
+}
+
+// ParsePorts 解析 "22,80,8000-8100" 格式，结果去重排序
+func ParsePorts(spec string) (ports []int, err error) {
+	seen := map[int]bool{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if "" == item {
+			continue
+		}
+		from, to := item, item
+		if i := strings.Index(item, "-"); i > 0 {
+			from, to = item[:i], item[i+1:]
+		}
+		start, e1 := strconv.Atoi(strings.TrimSpace(from))
+		end, e2 := strconv.Atoi(strings.TrimSpace(to))
+		if nil != e1 || nil != e2 || start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range %s", item)
+		}
+		for port := start; port <= end; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	sort.Ints(ports)
+	return
+}
@@ -0,0 +1,21 @@
+package utilNetwork
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestPortScanHugeRate(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatalf("listen: %+v", err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	open := NewProber().OpenPorts(context.Background(), "127.0.0.1", []int{port}, 1, 2000000000)
+	if 1 != len(open) || port != open[0] {
+		t.Fatalf("open ports = %v, want [%d]", open, port)
+	}
+}
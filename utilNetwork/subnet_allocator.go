@@ -0,0 +1,193 @@
+package utilNetwork
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SubnetAllocatorStore 子网分配的持久化，Load 在启动时恢复已分配的子网(子网 -> owner)
+type SubnetAllocatorStore interface {
+	Load(ctx context.Context, parent string) (map[string]string, error)
+	Save(ctx context.Context, parent string, subnet string, owner string) error
+	Delete(ctx context.Context, parent string, subnet string) error
+}
+
+type SubnetAllocation struct {
+	Subnet string `json:"subnet"`
+	Owner  string `json:"owner"`
+}
+
+// SubnetAllocator 从父网段中按大小分配不重叠的子网
+type SubnetAllocator struct {
+	parent    netip.Prefix
+	store     SubnetAllocatorStore
+	exclude   *PrefixSet
+	allocated map[netip.Prefix]string
+	locker    sync.Mutex
+}
+
+// NewSubnetAllocator store 为空时只在内存中记录，exclude 为不参与分配的地址
+func NewSubnetAllocator(parent string, store SubnetAllocatorStore, exclude ...string) (allocator *SubnetAllocator, err error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(parent))
+	if nil != err {
+		err = fmt.Errorf("subnet allocator parent error: %+v", err)
+		return
+	}
+	excludeSet, err := NewPrefixSet(exclude...)
+	if nil != err {
+		return
+	}
+	allocator = &SubnetAllocator{
+		parent:    prefix.Masked(),
+		store:     store,
+		exclude:   excludeSet,
+		allocated: map[netip.Prefix]string{},
+	}
+	return
+}
+
+// Load 从 store 恢复已分配的子网，不在父网段中的记录被忽略
+func (a *SubnetAllocator) Load(ctx context.Context) (err error) {
+	if nil == a.store {
+		return
+	}
+	items, err := a.store.Load(ctx, a.parent.String())
+	if nil != err {
+		return fmt.Errorf("subnet allocator load error: %+v", err)
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	a.allocated = map[netip.Prefix]string{}
+	for subnet, owner := range items {
+		prefix, e := netip.ParsePrefix(subnet)
+		if nil != e || !a.inParent(prefix) {
+			continue
+		}
+		a.allocated[prefix.Masked()] = owner
+	}
+	return
+}
+
+func (a *SubnetAllocator) Parent() string {
+	return a.parent.String()
+}
+
+// Allocate 分配下一个空闲的 /bits 子网
+func (a *SubnetAllocator) Allocate(ctx context.Context, bits int, owner string) (subnet netip.Prefix, err error) {
+	if bits < a.parent.Bits() || bits > a.parent.Addr().BitLen() {
+		err = fmt.Errorf("can't allocate /%d from %s", bits, a.parent.String())
+		return
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	subnet, found := a.free().FindFree(bits, a.parent.Addr().Is6())
+	if !found {
+		err = fmt.Errorf("no free /%d in %s", bits, a.parent.String())
+		return
+	}
+	err = a.save(ctx, subnet, owner)
+	return
+}
+
+// Reserve 占用指定子网，与已分配的子网重叠时返回错误
+func (a *SubnetAllocator) Reserve(ctx context.Context, subnet string, owner string) (err error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(subnet))
+	if nil != err {
+		return
+	}
+	prefix = prefix.Masked()
+	if !a.inParent(prefix) {
+		return fmt.Errorf("%s not in %s", prefix.String(), a.parent.String())
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	if !a.free().ContainsPrefix(prefix) {
+		return fmt.Errorf("%s overlaps allocated or excluded subnets", prefix.String())
+	}
+	return a.save(ctx, prefix, owner)
+}
+
+func (a *SubnetAllocator) Release(ctx context.Context, subnet string) (err error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(subnet))
+	if nil != err {
+		return
+	}
+	prefix = prefix.Masked()
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	if _, ok := a.allocated[prefix]; !ok {
+		return
+	}
+	if nil != a.store {
+		if err = a.store.Delete(ctx, a.parent.String(), prefix.String()); nil != err {
+			return fmt.Errorf("subnet allocator delete error: %+v", err)
+		}
+	}
+	delete(a.allocated, prefix)
+	return
+}
+
+// Owner 返回包含 ip 或子网的已分配子网及其 owner
+func (a *SubnetAllocator) Owner(ipOrSubnet string) (subnet string, owner string, found bool) {
+	r, err := ParseIpRange(ipOrSubnet)
+	if nil != err {
+		return
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	for prefix, o := range a.allocated {
+		if prefix.Contains(r.From) && prefix.Contains(r.To) {
+			return prefix.String(), o, true
+		}
+	}
+	return
+}
+
+func (a *SubnetAllocator) Allocations() (allocations []SubnetAllocation) {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	prefixes := make([]netip.Prefix, 0, len(a.allocated))
+	for prefix := range a.allocated {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return prefixes[i].Addr().Less(prefixes[j].Addr())
+	})
+	for _, prefix := range prefixes {
+		allocations = append(allocations, SubnetAllocation{Subnet: prefix.String(), Owner: a.allocated[prefix]})
+	}
+	return
+}
+
+// Free 返回剩余可分配的地址
+func (a *SubnetAllocator) Free() *PrefixSet {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	return a.free()
+}
+
+func (a *SubnetAllocator) free() *PrefixSet {
+	used := (&PrefixSet{}).AddRange(a.exclude.Ranges()...)
+	for prefix := range a.allocated {
+		used.AddPrefix(prefix)
+	}
+	return (&PrefixSet{}).AddPrefix(a.parent).Subtract(used)
+}
+
+func (a *SubnetAllocator) save(ctx context.Context, subnet netip.Prefix, owner string) (err error) {
+	if nil != a.store {
+		if err = a.store.Save(ctx, a.parent.String(), subnet.String(), owner); nil != err {
+			return fmt.Errorf("subnet allocator save error: %+v", err)
+		}
+	}
+	a.allocated[subnet] = owner
+	return
+}
+
+func (a *SubnetAllocator) inParent(prefix netip.Prefix) bool {
+	return prefix.Addr().BitLen() == a.parent.Addr().BitLen() && prefix.Bits() >= a.parent.Bits() && a.parent.Contains(prefix.Addr())
+}
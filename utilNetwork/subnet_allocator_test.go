@@ -0,0 +1,98 @@
+package utilNetwork
+
+import (
+	"context"
+	"testing"
+)
+
+type testSubnetStore struct {
+	items map[string]map[string]string
+}
+
+func (s *testSubnetStore) Load(ctx context.Context, parent string) (map[string]string, error) {
+	return s.items[parent], nil
+}
+func (s *testSubnetStore) Save(ctx context.Context, parent string, subnet string, owner string) error {
+	if nil == s.items[parent] {
+		s.items[parent] = map[string]string{}
+	}
+	s.items[parent][subnet] = owner
+	return nil
+}
+func (s *testSubnetStore) Delete(ctx context.Context, parent string, subnet string) error {
+	delete(s.items[parent], subnet)
+	return nil
+}
+
+func TestSubnetAllocator(t *testing.T) {
+	ctx := context.Background()
+	store := &testSubnetStore{items: map[string]map[string]string{}}
+	allocator, err := NewSubnetAllocator("10.0.0.0/24", store, "10.0.0.0/28")
+	if nil != err {
+		t.Fatalf("allocator: %+v", err)
+	}
+
+	steps := []struct {
+		bits  int
+		owner string
+		want  string
+	}{
+		{28, "a", "10.0.0.16/28"},
+		{26, "b", "10.0.0.64/26"},
+		{27, "c", "10.0.0.32/27"},
+		{25, "d", "10.0.0.128/25"},
+		{28, "e", ""},
+		{23, "f", ""},
+	}
+	for _, step := range steps {
+		subnet, e := allocator.Allocate(ctx, step.bits, step.owner)
+		if "" == step.want {
+			if nil == e {
+				t.Fatalf("/%d 应分配失败，实际分配了 %s", step.bits, subnet.String())
+			}
+			continue
+		}
+		if nil != e || step.want != subnet.String() {
+			t.Fatalf("Allocate(/%d) = %s %+v, want %s", step.bits, subnet.String(), e, step.want)
+		}
+	}
+
+	if subnet, owner, found := allocator.Owner("10.0.0.70"); !found || "10.0.0.64/26" != subnet || "b" != owner {
+		t.Fatalf("owner = %s %s %v", subnet, owner, found)
+	}
+	if _, _, found := allocator.Owner("10.0.0.1"); found {
+		t.Fatalf("排除的地址不应有 owner")
+	}
+	if err = allocator.Reserve(ctx, "10.0.0.96/28", "x"); nil == err {
+		t.Fatalf("与已分配子网重叠时 Reserve 应失败")
+	}
+	if err = allocator.Reserve(ctx, "10.1.0.0/28", "x"); nil == err {
+		t.Fatalf("不在父网段中时 Reserve 应失败")
+	}
+
+	if err = allocator.Release(ctx, "10.0.0.64/26"); nil != err {
+		t.Fatalf("release: %+v", err)
+	}
+	if err = allocator.Reserve(ctx, "10.0.0.96/28", "x"); nil != err {
+		t.Fatalf("释放后 Reserve 应成功: %+v", err)
+	}
+
+	// 从 store 恢复
+	restored, _ := NewSubnetAllocator("10.0.0.0/24", store, "10.0.0.0/28")
+	if err = restored.Load(ctx); nil != err {
+		t.Fatalf("load: %+v", err)
+	}
+	allocations := restored.Allocations()
+	want := []string{"10.0.0.16/28", "10.0.0.32/27", "10.0.0.96/28", "10.0.0.128/25"}
+	if len(want) != len(allocations) {
+		t.Fatalf("allocations = %+v", allocations)
+	}
+	for i, allocation := range allocations {
+		if want[i] != allocation.Subnet {
+			t.Fatalf("allocations = %+v", allocations)
+		}
+	}
+	if subnet, e := restored.Allocate(ctx, 27, "y"); nil != e || "10.0.0.64/27" != subnet.String() {
+		t.Fatalf("恢复后 Allocate = %s %+v", subnet.String(), e)
+	}
+}
@@ -0,0 +1,392 @@
+package utilOvpnManagement
+
+import (
+	"context"
+	"fmt"
+	"github.com/hilaoyu/go-utils/utilNetwork"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientAuthorizer 处理 CLIENT:CONNECT/REAUTH，event.Type 区分首次连接与重新认证，event.Client.Env 中包含 common_name、username、password、untrusted_ip 等
+// 返回 err 时按拒绝处理
+type ClientAuthorizer func(ctx context.Context, event *Event) (result *ClientAuthResult, err error)
+
+// ClientAuthResult 认证结果，Config 为允许时下发给该客户端的配置行，如 ifconfig-push、push "route ..."、iroute
+type ClientAuthResult struct {
+	Allow        bool     `json:"allow"`
+	Reason       string   `json:"reason,omitempty"`
+	ClientReason string   `json:"client_reason,omitempty"`
+	Config       []string `json:"config,omitempty"`
+}
+
+func AllowClient(config ...string) *ClientAuthResult {
+	return &ClientAuthResult{Allow: true, Config: config}
+}
+
+// DenyClient reason 记录在服务端日志，clientReason 不为空时通过 AUTH_FAILED 发给客户端
+func DenyClient(reason string, clientReason ...string) *ClientAuthResult {
+	r := &ClientAuthResult{Reason: reason}
+	if len(clientReason) > 0 {
+		r.ClientReason = clientReason[0]
+	}
+	return r
+}
+
+func (r *ClientAuthResult) AddConfig(lines ...string) *ClientAuthResult {
+	r.Config = append(r.Config, lines...)
+	return r
+}
+
+// Push 添加 push "option"
+func (r *ClientAuthResult) Push(option string) *ClientAuthResult {
+	return r.AddConfig(fmt.Sprintf("push \"%s\"", strings.ReplaceAll(option, "\"", "\\\"")))
+}
+
+// Route 给客户端推送路由，cidr 如 10.10.0.0/16
+func (r *ClientAuthResult) Route(cidr string) *ClientAuthResult {
+	ip, ipNet := utilNetwork.Parse(cidr)
+	if nil == ipNet {
+		return r.Push("route " + cidr)
+	}
+	if nil == ip.To4() {
+		return r.Push("route-ipv6 " + ipNet.String())
+	}
+	return r.Push(fmt.Sprintf("route %s %s", ipNet.IP.String(), net.IP(ipNet.Mask).String()))
+}
+
+// Iroute 客户端后面的子网，服务端需要同时配置对应的 route
+func (r *ClientAuthResult) Iroute(cidr string) *ClientAuthResult {
+	ip, ipNet := utilNetwork.Parse(cidr)
+	if nil == ipNet {
+		return r.AddConfig("iroute " + cidr)
+	}
+	if nil == ip.To4() {
+		return r.AddConfig("iroute-ipv6 " + ipNet.String())
+	}
+	return r.AddConfig(fmt.Sprintf("iroute %s %s", ipNet.IP.String(), net.IP(ipNet.Mask).String()))
+}
+
+// UseClientAuth 配合服务端 management-client-auth 使用，由 authorizer 决定是否允许客户端连接
+// 需要 Start 保持长连接，每个请求在单独的协程中处理，timeout 内未返回按拒绝处理
+func (m *OpenVpnManagement) UseClientAuth(authorizer ClientAuthorizer, timeout ...time.Duration) *OpenVpnManagement {
+	authTimeout := time.Duration(30) * time.Second
+	if len(timeout) > 0 && timeout[0] > 0 {
+		authTimeout = timeout[0]
+	}
+	return m.OnEvent(func(event *Event) {
+		if nil == event.Client || (EventClientConnect != event.Type && EventClientReauth != event.Type) {
+			return
+		}
+		go m.clientAuth(authorizer, event, authTimeout)
+	})
+}
+
+func (m *OpenVpnManagement) clientAuth(authorizer ClientAuthorizer, event *Event, timeout time.Duration) {
+	m.locker.RLock()
+	parent := m.stopCtx
+	m.locker.RUnlock()
+	if nil == parent {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	result, err := m.authorize(ctx, authorizer, event)
+	if nil != err {
+		result = DenyClient(fmt.Sprintf("authorizer error: %v", err))
+	} else if nil == result {
+		result = DenyClient("authorizer returned no result")
+	}
+
+	// 回复使用独立的超时，授权函数耗尽 ctx 时仍能发送 client-deny
+	replyCtx, replyCancel := context.WithTimeout(parent, m.replyTimeout())
+	defer replyCancel()
+	if result.Allow {
+		if EventClientReauth == event.Type && len(result.Config) == 0 {
+			_ = m.ClientAuthNt(replyCtx, event.Client.ClientId, event.Client.KeyId)
+			return
+		}
+		_ = m.ClientAuth(replyCtx, event.Client.ClientId, event.Client.KeyId, result.Config...)
+		return
+	}
+	_ = m.ClientDeny(replyCtx, event.Client.ClientId, event.Client.KeyId, result.Reason, result.ClientReason)
+}
+
+func (m *OpenVpnManagement) authorize(ctx context.Context, authorizer ClientAuthorizer, event *Event) (result *ClientAuthResult, err error) {
+	type authReturn struct {
+		result *ClientAuthResult
+		err    error
+	}
+	done := make(chan authReturn, 1)
+	go func() {
+		defer func() {
+			if p := recover(); nil != p {
+				done <- authReturn{err: fmt.Errorf("panic: %v", p)}
+			}
+		}()
+		r, e := authorizer(ctx, event)
+		done <- authReturn{result: r, err: e}
+	}()
+	select {
+	case ret := <-done:
+		return ret.result, ret.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("authorize timeout: %v", ctx.Err())
+	}
+}
+
+func (m *OpenVpnManagement) replyTimeout() time.Duration {
+	if m.Timeout > 0 {
+		return m.Timeout
+	}
+	return time.Duration(10) * time.Second
+}
+
+// ClientAuth 允许客户端连接并下发配置行
+func (m *OpenVpnManagement) ClientAuth(ctx context.Context, clientId int, keyId int, config ...string) (err error) {
+	lines := []string{fmt.Sprintf("client-auth %d %d", clientId, keyId)}
+	for _, line := range config {
+		// 每个配置项只能占一行，且不能提前出现 END
+		line = strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(line))
+		if "" == line || "END" == line {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "END")
+	_, err = m.RunCommandContext(ctx, strings.Join(lines, "\n"), "")
+	if nil != err {
+		err = fmt.Errorf("client-auth %d error: %+v", clientId, err)
+	}
+	return
+}
+
+// ClientAuthNt 允许客户端连接，不下发配置
+func (m *OpenVpnManagement) ClientAuthNt(ctx context.Context, clientId int, keyId int) (err error) {
+	_, err = m.RunCommandContext(ctx, fmt.Sprintf("client-auth-nt %d %d", clientId, keyId), "")
+	if nil != err {
+		err = fmt.Errorf("client-auth-nt %d error: %+v", clientId, err)
+	}
+	return
+}
+
+// ClientDeny 拒绝客户端连接
+func (m *OpenVpnManagement) ClientDeny(ctx context.Context, clientId int, keyId int, reason string, clientReason ...string) (err error) {
+	if "" == reason {
+		reason = "denied"
+	}
+	cmd := fmt.Sprintf("client-deny %d %d %s", clientId, keyId, quoteArg(reason))
+	if len(clientReason) > 0 && "" != clientReason[0] {
+		cmd += " " + quoteArg(clientReason[0])
+	}
+	_, err = m.RunCommandContext(ctx, cmd, "")
+	if nil != err {
+		err = fmt.Errorf("client-deny %d error: %+v", clientId, err)
+	}
+	return
+}
+
+func quoteArg(s string) string {
+	s = strings.NewReplacer("\r", " ", "\n", " ", "\\", "\\\\", "\"", "\\\"").Replace(s)
+	return "\"" + s + "\""
+}
+
+// ClientIpPool 基于 utilNetwork.UtilIpAm 给客户端分配固定或动态虚拟 IP，生成 ifconfig-push，适用于 topology subnet
+// key 一般使用 common_name 或 username，客户端断开后需要调用 Release 回收
+type ClientIpPool struct {
+	cidr    string
+	ipAm    *utilNetwork.UtilIpAm
+	netmask string
+	ipv6    bool
+	bits    int
+	static  map[string]string
+	leases  map[string]string
+	clients map[string]map[int]bool
+	locker  sync.Mutex
+}
+
+func NewClientIpPool(cidr string) (pool *ClientIpPool, err error) {
+	ipAm, err := utilNetwork.NewUtilIpAm(cidr)
+	if nil != err {
+		err = fmt.Errorf("client ip pool cidr error: %+v", err)
+		return
+	}
+	ip, _ := utilNetwork.Parse(cidr)
+	bits, _ := utilNetwork.GetNetMaskSize(cidr)
+	netmask, _ := utilNetwork.GetNetMask(cidr)
+	pool = &ClientIpPool{
+		cidr:    cidr,
+		ipAm:    ipAm,
+		netmask: netmask,
+		ipv6:    nil == ip.To4(),
+		bits:    bits,
+		static:  map[string]string{},
+		leases:  map[string]string{},
+		clients: map[string]map[int]bool{},
+	}
+	return
+}
+
+// Reserve 保留不参与分配的地址，如服务端自身的地址
+func (p *ClientIpPool) Reserve(ips ...string) *ClientIpPool {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	for _, ip := range ips {
+		if utilNetwork.IpInCidr(ip, p.cidr) {
+			p.ipAm.UseIpStr(ip)
+		}
+	}
+	return p
+}
+
+// SetStatic 给 key 指定固定地址
+func (p *ClientIpPool) SetStatic(key string, ip string) (err error) {
+	if !utilNetwork.IpInCidr(ip, p.cidr) {
+		return fmt.Errorf("ip %s not in %s", ip, p.cidr)
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	for k, v := range p.static {
+		if v == ip && k != key {
+			return fmt.Errorf("ip %s already assigned to %s", ip, k)
+		}
+	}
+	for k, v := range p.leases {
+		if v == ip && k != key {
+			return fmt.Errorf("ip %s already leased to %s", ip, k)
+		}
+	}
+	if old, ok := p.static[key]; ok && old != ip {
+		p.ipAm.UnUseIpStr(old)
+	}
+	p.static[key] = ip
+	p.ipAm.UseIpStr(ip)
+	return
+}
+
+func (p *ClientIpPool) RemoveStatic(key string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	ip, ok := p.static[key]
+	if !ok {
+		return
+	}
+	delete(p.static, key)
+	if p.leases[key] != ip {
+		p.ipAm.UnUseIpStr(ip)
+	}
+}
+
+// Allocate 返回 key 的固定地址或已分配的地址，没有时从池中分配一个新地址
+func (p *ClientIpPool) Allocate(key string) (ip string, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if ip = p.static[key]; "" != ip {
+		p.leases[key] = ip
+		return
+	}
+	if ip = p.leases[key]; "" != ip {
+		return
+	}
+	newIp, err := p.ipAm.FindAvailableIpAndUse()
+	if nil != err {
+		err = fmt.Errorf("client ip pool %s: %+v", p.cidr, err)
+		return
+	}
+	ip = newIp.String()
+	p.leases[key] = ip
+	return
+}
+
+// Release 回收 key 的动态地址，固定地址保持占用
+func (p *ClientIpPool) Release(key string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	delete(p.clients, key)
+	p.release(key)
+}
+
+// BindClient 记录 clientId 的连接正在使用 key 的地址
+func (p *ClientIpPool) BindClient(key string, clientId int) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if nil == p.clients[key] {
+		p.clients[key] = map[int]bool{}
+	}
+	p.clients[key][clientId] = true
+}
+
+// ReleaseClient clientId 的连接断开，key 没有其他连接在使用时才回收地址
+// 同一个 common_name 可能有多个连接(duplicate-cn 或新连接先于旧连接断开)
+func (p *ClientIpPool) ReleaseClient(key string, clientId int) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if ids, ok := p.clients[key]; ok {
+		if !ids[clientId] {
+			return
+		}
+		delete(ids, clientId)
+		if len(ids) > 0 {
+			return
+		}
+		delete(p.clients, key)
+	}
+	p.release(key)
+}
+
+func (p *ClientIpPool) release(key string) {
+	ip, ok := p.leases[key]
+	if !ok {
+		return
+	}
+	delete(p.leases, key)
+	if p.static[key] != ip {
+		p.ipAm.UnUseIpStr(ip)
+	}
+}
+
+func (p *ClientIpPool) Lookup(key string) string {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.leases[key]
+}
+
+// IfconfigPush 分配地址并返回对应的 ifconfig-push 或 ifconfig-ipv6-push 配置行
+func (p *ClientIpPool) IfconfigPush(key string) (line string, err error) {
+	ip, err := p.Allocate(key)
+	if nil != err {
+		return
+	}
+	if p.ipv6 {
+		line = fmt.Sprintf("ifconfig-ipv6-push %s/%d", ip, p.bits)
+		return
+	}
+	line = fmt.Sprintf("ifconfig-push %s %s", ip, p.netmask)
+	return
+}
+
+// ReleaseOnDisconnect 客户端断开时按 keyFunc 回收地址，keyFunc 为空时使用 common_name
+// CLIENT:CONNECT/REAUTH 时记录连接的 client id，只有 key 的最后一个连接断开才回收
+func (p *ClientIpPool) ReleaseOnDisconnect(m *OpenVpnManagement, keyFunc ...func(client *ClientEvent) string) *ClientIpPool {
+	key := func(client *ClientEvent) string {
+		return client.CommonName()
+	}
+	if len(keyFunc) > 0 && nil != keyFunc[0] {
+		key = keyFunc[0]
+	}
+	m.OnEvent(func(event *Event) {
+		if nil == event.Client {
+			return
+		}
+		switch event.Type {
+		case EventClientConnect, EventClientReauth:
+			p.BindClient(key(event.Client), event.Client.ClientId)
+		case EventClientDisconnect:
+			p.ReleaseClient(key(event.Client), event.Client.ClientId)
+		}
+	})
+	return p
+}
@@ -0,0 +1,56 @@
+package utilOvpnManagement
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientIpPoolReleaseOnDisconnect(t *testing.T) {
+	pool, err := NewClientIpPool("10.8.0.0/24")
+	if nil != err {
+		t.Fatalf("pool: %+v", err)
+	}
+	pool.Reserve("10.8.0.0", "10.8.0.1")
+	ip, err := pool.Allocate("alice")
+	if nil != err {
+		t.Fatalf("allocate: %+v", err)
+	}
+
+	// 同一个 common_name 的两个连接，旧连接先断开
+	steps := make(chan string, 4)
+	f := newFakeManagement(t, "", fakeCommands)
+	f.locker.Lock()
+	f.onConnect = func(conn net.Conn) {
+		for line := range steps {
+			_, _ = conn.Write([]byte(strings.ReplaceAll(line, "\n", "\r\n") + "\r\n"))
+		}
+	}
+	f.locker.Unlock()
+
+	recorder := newEventRecorder()
+	m := NewOpenVpnManagement(f.addr(), "", time.Second)
+	pool.ReleaseOnDisconnect(m)
+	m.OnEvent(recorder.handle)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.Start(ctx)
+	defer m.Close()
+	defer close(steps)
+
+	steps <- ">CLIENT:CONNECT,1,1\n>CLIENT:ENV,common_name=alice\n>CLIENT:ENV,END"
+	steps <- ">CLIENT:CONNECT,2,1\n>CLIENT:ENV,common_name=alice\n>CLIENT:ENV,END"
+	steps <- ">CLIENT:DISCONNECT,1\n>CLIENT:ENV,common_name=alice\n>CLIENT:ENV,END"
+	recorder.wait(t, EventClientDisconnect, 1, 3*time.Second)
+	if pool.Lookup("alice") != ip {
+		t.Fatalf("仍有连接在使用时不应回收地址")
+	}
+
+	steps <- ">CLIENT:DISCONNECT,2\n>CLIENT:ENV,common_name=alice\n>CLIENT:ENV,END"
+	recorder.wait(t, EventClientDisconnect, 2, 3*time.Second)
+	if "" != pool.Lookup("alice") {
+		t.Fatalf("最后一个连接断开后应回收地址")
+	}
+}
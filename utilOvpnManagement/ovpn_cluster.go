@@ -0,0 +1,628 @@
+package utilOvpnManagement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hilaoyu/go-utils/utilLogger"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type OvpnClusterMetrics struct {
+	Nodes           int                         `json:"nodes"`
+	NodesOnline     int                         `json:"nodes_online"`
+	Clients         int                         `json:"clients"`
+	Users           int                         `json:"users"`
+	BytesReceived   int64                       `json:"bytes_received"`
+	BytesSent       int64                       `json:"bytes_sent"`
+	SessionsStarted uint64                      `json:"sessions_started"`
+	SessionsEnded   uint64                      `json:"sessions_ended"`
+	SessionsKicked  uint64                      `json:"sessions_kicked"`
+	StoreErrors     uint64                      `json:"store_errors"`
+	NodeMetrics     map[string]*OvpnNodeMetrics `json:"node_metrics"`
+}
+
+type OvpnNodeMetrics struct {
+	Online        bool      `json:"online"`
+	Clients       int       `json:"clients"`
+	BytesReceived int64     `json:"bytes_received"`
+	BytesSent     int64     `json:"bytes_sent"`
+	LastSyncAt    time.Time `json:"last_sync_at"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// OvpnCluster 管理多个 OpenVPN 节点，通过各节点的 CLIENT:ESTABLISHED/DISCONNECT 通知跟踪在线会话
+// 管理连接(重新)建立后用 status 与节点同步，会话开始和结束时写入 ClientSessionStore
+type OvpnCluster struct {
+	nodes    map[string]*ovpnClusterNode
+	sessions map[string]*ClientSession
+	locker   sync.RWMutex
+
+	store        ClientSessionStore
+	storeQueue   chan *ClientSession
+	sessionLimit int
+	logger       *utilLogger.Logger
+
+	stop   context.CancelFunc
+	runCtx context.Context
+
+	sessionsStarted atomic.Uint64
+	sessionsEnded   atomic.Uint64
+	sessionsKicked  atomic.Uint64
+	storeErrors     atomic.Uint64
+}
+
+type ovpnClusterNode struct {
+	id         string
+	m          *OpenVpnManagement
+	lastSyncAt time.Time
+	lastError  string
+}
+
+func NewOvpnCluster() *OvpnCluster {
+	return &OvpnCluster{
+		nodes:    map[string]*ovpnClusterNode{},
+		sessions: map[string]*ClientSession{},
+	}
+}
+
+func (c *OvpnCluster) SetSessionStore(store ClientSessionStore) *OvpnCluster {
+	c.store = store
+	return c
+}
+
+// SetSessionLimit 每个用户在所有节点上的最大并发会话数，超出时断开最早的会话，0 为不限制
+func (c *OvpnCluster) SetSessionLimit(limit int) *OvpnCluster {
+	c.sessionLimit = limit
+	return c
+}
+
+func (c *OvpnCluster) SetLogger(logger *utilLogger.Logger) *OvpnCluster {
+	c.logger = logger
+	return c
+}
+
+// AddNode 添加节点，集群已启动时同时启动该节点的长连接
+func (c *OvpnCluster) AddNode(nodeId string, m *OpenVpnManagement) *OvpnCluster {
+	node := &ovpnClusterNode{id: nodeId, m: m}
+	m.OnEvent(func(event *Event) {
+		c.handleEvent(node, event)
+	})
+
+	c.locker.Lock()
+	old := c.nodes[nodeId]
+	c.nodes[nodeId] = node
+	ctx := c.runCtx
+	c.locker.Unlock()
+
+	if nil != old {
+		c.removeNode(old, "node replaced")
+	}
+	if nil != ctx {
+		m.Start(ctx)
+	}
+	return c
+}
+
+func (c *OvpnCluster) RemoveNode(nodeId string) {
+	c.locker.Lock()
+	node := c.nodes[nodeId]
+	delete(c.nodes, nodeId)
+	c.locker.Unlock()
+	if nil != node {
+		c.removeNode(node, "node removed")
+	}
+}
+
+func (c *OvpnCluster) removeNode(node *ovpnClusterNode, reason string) {
+	node.m.Stop()
+	c.endNodeSessions(node.id, nil, reason)
+}
+
+func (c *OvpnCluster) Node(nodeId string) *OpenVpnManagement {
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	if node, ok := c.nodes[nodeId]; ok {
+		return node.m
+	}
+	return nil
+}
+
+func (c *OvpnCluster) NodeIds() (ids []string) {
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	for id := range c.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return
+}
+
+// Start 启动所有节点的长连接，ctx 取消或 Stop 后退出
+func (c *OvpnCluster) Start(ctx context.Context) {
+	c.locker.Lock()
+	if nil != c.stop {
+		c.locker.Unlock()
+		return
+	}
+	ctx, c.stop = context.WithCancel(ctx)
+	c.runCtx = ctx
+	c.storeQueue = make(chan *ClientSession, 4096)
+	nodes := c.nodeList()
+	queue := c.storeQueue
+	c.locker.Unlock()
+
+	go c.storeLoop(ctx, queue)
+	for _, node := range nodes {
+		node.m.Start(ctx)
+	}
+}
+
+func (c *OvpnCluster) Stop() {
+	c.locker.Lock()
+	stop := c.stop
+	c.stop = nil
+	c.runCtx = nil
+	nodes := c.nodeList()
+	c.locker.Unlock()
+	if nil == stop {
+		return
+	}
+	for _, node := range nodes {
+		node.m.Stop()
+	}
+	stop()
+}
+
+func (c *OvpnCluster) nodeList() (nodes []*ovpnClusterNode) {
+	for _, node := range c.nodes {
+		nodes = append(nodes, node)
+	}
+	return
+}
+
+func (c *OvpnCluster) handleEvent(node *ovpnClusterNode, event *Event) {
+	c.locker.RLock()
+	current := c.nodes[node.id] == node
+	c.locker.RUnlock()
+	if !current {
+		return
+	}
+
+	switch event.Type {
+	case EventManagementConnected:
+		_ = c.SyncNode(node.id)
+	case EventClientEstablished:
+		if nil != event.Client {
+			c.startSession(node.id, event.Client, event.Time)
+		}
+	case EventClientDisconnect:
+		if nil != event.Client {
+			c.endClientSession(node.id, event.Client, event.Time)
+		}
+	case EventByteCountClient:
+		if nil != event.ByteCount {
+			c.locker.Lock()
+			if s, ok := c.sessions[sessionKey(node.id, event.ByteCount.ClientId)]; ok {
+				s.BytesReceived = event.ByteCount.BytesIn
+				s.BytesSent = event.ByteCount.BytesOut
+			}
+			c.locker.Unlock()
+		}
+	}
+}
+
+func sessionKey(nodeId string, clientId int) string {
+	return nodeId + "/" + strconv.Itoa(clientId)
+}
+
+func sessionId(nodeId string, clientId int, start time.Time) string {
+	return fmt.Sprintf("%s-%d-%d", nodeId, clientId, start.Unix())
+}
+
+func (c *OvpnCluster) startSession(nodeId string, client *ClientEvent, now time.Time) {
+	start := now
+	if ts, _ := strconv.ParseInt(client.Env["time_unix"], 10, 64); ts > 0 {
+		start = time.Unix(ts, 0)
+	}
+	realAddress := client.RealIp()
+	if port := client.Env["untrusted_port"]; "" != port && "" != realAddress {
+		realAddress = realAddress + ":" + port
+	}
+	s := &ClientSession{
+		Id:               sessionId(nodeId, client.ClientId, start),
+		NodeId:           nodeId,
+		ClientId:         client.ClientId,
+		CommonName:       client.CommonName(),
+		Username:         client.Username(),
+		RealAddress:      realAddress,
+		VirtualAddress:   client.VirtualAddress(),
+		VirtualV6Address: client.Env["ifconfig_pool_remote_ip6"],
+		StartAt:          start,
+	}
+	c.addSession(s)
+}
+
+func (c *OvpnCluster) addSession(s *ClientSession) {
+	key := sessionKey(s.NodeId, s.ClientId)
+	c.locker.Lock()
+	old := c.sessions[key]
+	c.sessions[key] = s
+	c.locker.Unlock()
+
+	// 同一 client id 上还有旧会话说明漏掉了 DISCONNECT
+	if nil != old && old.Id != s.Id {
+		c.finishSession(old, time.Now(), "replaced")
+	}
+	if nil == old || old.Id != s.Id {
+		c.sessionsStarted.Add(1)
+		c.save(s)
+	}
+	c.enforceLimit(s.User())
+}
+
+func (c *OvpnCluster) endClientSession(nodeId string, client *ClientEvent, now time.Time) {
+	key := sessionKey(nodeId, client.ClientId)
+	c.locker.Lock()
+	s, ok := c.sessions[key]
+	if ok {
+		delete(c.sessions, key)
+		s.BytesReceived = client.BytesReceived()
+		s.BytesSent = client.BytesSent()
+	}
+	c.locker.Unlock()
+	if !ok {
+		return
+	}
+	if d, _ := strconv.ParseInt(client.Env["time_duration"], 10, 64); d > 0 {
+		now = s.StartAt.Add(time.Duration(d) * time.Second)
+	}
+	c.finishSession(s, now, "disconnect")
+}
+
+// endNodeSessions 结束节点上不在 keep 中的会话
+func (c *OvpnCluster) endNodeSessions(nodeId string, keep map[string]bool, reason string) {
+	var ended []*ClientSession
+	c.locker.Lock()
+	for key, s := range c.sessions {
+		if s.NodeId == nodeId && !keep[key] {
+			delete(c.sessions, key)
+			ended = append(ended, s)
+		}
+	}
+	c.locker.Unlock()
+	now := time.Now()
+	for _, s := range ended {
+		c.finishSession(s, now, reason)
+	}
+}
+
+func (c *OvpnCluster) finishSession(s *ClientSession, end time.Time, reason string) {
+	s.EndAt = &end
+	s.EndReason = reason
+	c.sessionsEnded.Add(1)
+	c.save(s)
+}
+
+func (c *OvpnCluster) save(s *ClientSession) {
+	if nil == c.store {
+		return
+	}
+	c.locker.RLock()
+	queue := c.storeQueue
+	copied := *s
+	c.locker.RUnlock()
+	if nil == queue {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10)*time.Second)
+		defer cancel()
+		c.storeSave(ctx, &copied)
+		return
+	}
+	select {
+	case queue <- &copied:
+	default:
+		c.storeErrors.Add(1)
+		c.logError(fmt.Sprintf("ovpn cluster: session store queue full, drop %s", s.Id))
+	}
+}
+
+func (c *OvpnCluster) storeLoop(ctx context.Context, queue chan *ClientSession) {
+	for {
+		select {
+		case <-ctx.Done():
+			// 退出前尽量写完已排队的会话
+			for {
+				select {
+				case s := <-queue:
+					saveCtx, cancel := context.WithTimeout(context.Background(), time.Duration(10)*time.Second)
+					c.storeSave(saveCtx, s)
+					cancel()
+				default:
+					return
+				}
+			}
+		case s := <-queue:
+			saveCtx, cancel := context.WithTimeout(ctx, time.Duration(10)*time.Second)
+			c.storeSave(saveCtx, s)
+			cancel()
+		}
+	}
+}
+
+func (c *OvpnCluster) storeSave(ctx context.Context, s *ClientSession) {
+	if err := c.store.SaveSession(ctx, s); nil != err {
+		c.storeErrors.Add(1)
+		c.logError(fmt.Sprintf("ovpn cluster: %+v", err))
+	}
+}
+
+func (c *OvpnCluster) logError(msg string) {
+	if nil == c.logger {
+		return
+	}
+	c.logger.Error(msg)
+}
+
+// enforceLimit 用户会话数超过限制时断开最早的会话
+func (c *OvpnCluster) enforceLimit(user string) {
+	if c.sessionLimit <= 0 || "" == user {
+		return
+	}
+	sessions := c.UserSessions(user)
+	if len(sessions) <= c.sessionLimit {
+		return
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartAt.Before(sessions[j].StartAt)
+	})
+	for _, s := range sessions[:len(sessions)-c.sessionLimit] {
+		go func(s *ClientSession) {
+			if err := c.kickSession(s); nil != err {
+				c.logError(fmt.Sprintf("ovpn cluster: kick %s on %s error: %+v", s.User(), s.NodeId, err))
+			}
+		}(s)
+	}
+}
+
+func (c *OvpnCluster) kickSession(s *ClientSession) (err error) {
+	m := c.Node(s.NodeId)
+	if nil == m {
+		return fmt.Errorf("node %s not found", s.NodeId)
+	}
+	err = m.KickClient(strconv.Itoa(s.ClientId))
+	if nil == err {
+		c.sessionsKicked.Add(1)
+	}
+	return
+}
+
+// SessionLimitAuthorizer 包装 ClientAuthorizer，用户已达到会话上限时直接拒绝新连接，next 为空时其他情况都允许
+func (c *OvpnCluster) SessionLimitAuthorizer(next ClientAuthorizer) ClientAuthorizer {
+	return func(ctx context.Context, event *Event) (result *ClientAuthResult, err error) {
+		if EventClientConnect == event.Type && c.sessionLimit > 0 {
+			user := event.Client.Username()
+			if "" == user {
+				user = event.Client.CommonName()
+			}
+			if len(c.UserSessions(user)) >= c.sessionLimit {
+				return DenyClient(fmt.Sprintf("session limit %d reached", c.sessionLimit), "too many sessions"), nil
+			}
+		}
+		if nil == next {
+			return AllowClient(), nil
+		}
+		return next(ctx, event)
+	}
+}
+
+// SyncNode 用 status 同步节点的在线会话
+func (c *OvpnCluster) SyncNode(nodeId string) (err error) {
+	c.locker.RLock()
+	node := c.nodes[nodeId]
+	c.locker.RUnlock()
+	if nil == node {
+		return fmt.Errorf("node %s not found", nodeId)
+	}
+	info, err := node.m.GetStatus()
+
+	c.locker.Lock()
+	node.lastSyncAt = time.Now()
+	node.lastError = ""
+	if nil != err {
+		node.lastError = err.Error()
+	}
+	c.locker.Unlock()
+	if nil != err {
+		return fmt.Errorf("sync node %s error: %+v", nodeId, err)
+	}
+
+	keep := map[string]bool{}
+	for _, client := range info.Clients {
+		key := sessionKey(nodeId, client.ClientId)
+		keep[key] = true
+		start := client.ConnectedAt
+		if start.IsZero() {
+			start = time.Now()
+		}
+		c.locker.Lock()
+		s, ok := c.sessions[key]
+		// status 的连接时间与 ESTABLISHED 的 time_unix 可能相差几秒，明显更晚时才是节点重启后复用的 client id
+		if ok && !start.After(s.StartAt.Add(time.Minute)) {
+			s.BytesReceived = client.BytesReceived
+			s.BytesSent = client.BytesSent
+			c.locker.Unlock()
+			continue
+		}
+		c.locker.Unlock()
+		c.addSession(&ClientSession{
+			Id:               sessionId(nodeId, client.ClientId, start),
+			NodeId:           nodeId,
+			ClientId:         client.ClientId,
+			CommonName:       client.CommonName,
+			Username:         client.Username,
+			RealAddress:      client.RealAddress,
+			VirtualAddress:   client.VirtualAddress,
+			VirtualV6Address: client.VirtualV6Address,
+			StartAt:          start,
+			BytesReceived:    client.BytesReceived,
+			BytesSent:        client.BytesSent,
+		})
+	}
+	c.endNodeSessions(nodeId, keep, "missing")
+	return
+}
+
+// Sync 同步所有节点
+func (c *OvpnCluster) Sync() (err error) {
+	var errs []error
+	for _, id := range c.NodeIds() {
+		if e := c.SyncNode(id); nil != e {
+			errs = append(errs, e)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status 并发获取所有节点的 status，失败的节点记录在 errs 中
+func (c *OvpnCluster) Status() (infos []*StatusInfo, errs map[string]error) {
+	errs = map[string]error{}
+	var locker sync.Mutex
+	var wg sync.WaitGroup
+	for _, id := range c.NodeIds() {
+		m := c.Node(id)
+		if nil == m {
+			continue
+		}
+		wg.Add(1)
+		go func(id string, m *OpenVpnManagement) {
+			defer wg.Done()
+			info, err := m.GetStatus()
+			locker.Lock()
+			defer locker.Unlock()
+			if nil != err {
+				errs[id] = err
+				return
+			}
+			info.NodeId = id
+			infos = append(infos, info)
+		}(id, m)
+	}
+	wg.Wait()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].NodeId < infos[j].NodeId
+	})
+	return
+}
+
+// Sessions 返回所有在线会话的副本
+func (c *OvpnCluster) Sessions() (sessions []*ClientSession) {
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	for _, s := range c.sessions {
+		copied := *s
+		sessions = append(sessions, &copied)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartAt.Before(sessions[j].StartAt)
+	})
+	return
+}
+
+// UserSessions 返回用户(username 或 common name)的在线会话
+func (c *OvpnCluster) UserSessions(user string) (sessions []*ClientSession) {
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	for _, s := range c.sessions {
+		if s.User() == user || s.CommonName == user {
+			copied := *s
+			sessions = append(sessions, &copied)
+		}
+	}
+	return
+}
+
+// KickUser 断开用户在所有节点上的会话，返回断开的数量
+func (c *OvpnCluster) KickUser(user string) (count int, err error) {
+	var errs []error
+	for _, s := range c.UserSessions(user) {
+		if e := c.kickSession(s); nil != e {
+			errs = append(errs, fmt.Errorf("%s: %+v", s.NodeId, e))
+			continue
+		}
+		count++
+	}
+	err = errors.Join(errs...)
+	return
+}
+
+// KickCommonName 在每个节点上执行 kill {common name}，不依赖本地跟踪的会话
+func (c *OvpnCluster) KickCommonName(name string) (count int, err error) {
+	var errs []error
+	var locker sync.Mutex
+	var wg sync.WaitGroup
+	for _, id := range c.NodeIds() {
+		m := c.Node(id)
+		if nil == m {
+			continue
+		}
+		wg.Add(1)
+		go func(id string, m *OpenVpnManagement) {
+			defer wg.Done()
+			n, e := m.KillByCommonName(name)
+			locker.Lock()
+			defer locker.Unlock()
+			if nil != e {
+				errs = append(errs, fmt.Errorf("%s: %+v", id, e))
+				return
+			}
+			count += n
+		}(id, m)
+	}
+	wg.Wait()
+	c.sessionsKicked.Add(uint64(count))
+	err = errors.Join(errs...)
+	return
+}
+
+// Metrics 根据本地跟踪的会话汇总，不访问节点
+func (c *OvpnCluster) Metrics() (metrics *OvpnClusterMetrics) {
+	metrics = &OvpnClusterMetrics{
+		SessionsStarted: c.sessionsStarted.Load(),
+		SessionsEnded:   c.sessionsEnded.Load(),
+		SessionsKicked:  c.sessionsKicked.Load(),
+		StoreErrors:     c.storeErrors.Load(),
+		NodeMetrics:     map[string]*OvpnNodeMetrics{},
+	}
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	for id, node := range c.nodes {
+		nm := &OvpnNodeMetrics{
+			Online:     node.m.Running(),
+			LastSyncAt: node.lastSyncAt,
+			LastError:  node.lastError,
+		}
+		if nm.Online {
+			metrics.NodesOnline++
+		}
+		metrics.NodeMetrics[id] = nm
+	}
+	metrics.Nodes = len(c.nodes)
+	users := map[string]bool{}
+	for _, s := range c.sessions {
+		users[s.User()] = true
+		metrics.Clients++
+		metrics.BytesReceived += s.BytesReceived
+		metrics.BytesSent += s.BytesSent
+		if nm, ok := metrics.NodeMetrics[s.NodeId]; ok {
+			nm.Clients++
+			nm.BytesReceived += s.BytesReceived
+			nm.BytesSent += s.BytesSent
+		}
+	}
+	metrics.Users = len(users)
+	return
+}
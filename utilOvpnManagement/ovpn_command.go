@@ -0,0 +1,308 @@
+package utilOvpnManagement
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type LoadStats struct {
+	Clients  int   `json:"clients"`
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+}
+
+type VersionInfo struct {
+	OpenVpn    string `json:"openvpn"`
+	Management int    `json:"management"`
+	Raw        string `json:"raw"`
+}
+
+// ClientPacketFilter client-pf 规则，Accept 为该段的默认动作，规则中 Allow 为 true 时对应 +，否则 -
+// 服务端需要配置 management-client-pf，OpenVPN 2.6 起已移除
+type ClientPacketFilter struct {
+	ClientsAccept bool           `json:"clients_accept"`
+	Clients       []ClientPfRule `json:"clients"`
+	SubnetsAccept bool           `json:"subnets_accept"`
+	Subnets       []ClientPfRule `json:"subnets"`
+}
+
+type ClientPfRule struct {
+	Allow  bool   `json:"allow"`
+	Target string `json:"target"`
+}
+
+// LoadStats 执行 load-stats，输出如 SUCCESS: nclients=1,bytesin=123,bytesout=456
+func (m *OpenVpnManagement) LoadStats() (stats *LoadStats, err error) {
+	values, err := m.successValues("load-stats")
+	if nil != err {
+		return
+	}
+	stats = &LoadStats{}
+	stats.Clients, _ = strconv.Atoi(values["nclients"])
+	stats.BytesIn, _ = strconv.ParseInt(values["bytesin"], 10, 64)
+	stats.BytesOut, _ = strconv.ParseInt(values["bytesout"], 10, 64)
+	return
+}
+
+// SetByteCount 每 interval 秒产生一次 BYTECOUNT/BYTECOUNT_CLI 通知，0 为关闭
+func (m *OpenVpnManagement) SetByteCount(interval int) (err error) {
+	_, err = m.RunCommand(fmt.Sprintf("bytecount %d", interval), "")
+	return
+}
+
+// State 返回当前状态
+func (m *OpenVpnManagement) State() (state *StateEvent, err error) {
+	states, err := m.stateLines("state")
+	if nil != err {
+		return
+	}
+	if len(states) == 0 {
+		err = fmt.Errorf("state 无输出")
+		return
+	}
+	state = states[len(states)-1]
+	return
+}
+
+// StateHistory 返回最近 n 条状态，n <= 0 时返回全部
+func (m *OpenVpnManagement) StateHistory(n int) (states []*StateEvent, err error) {
+	return m.stateLines(historyCommand("state", n))
+}
+
+// SetState 开启或关闭 STATE 实时通知
+func (m *OpenVpnManagement) SetState(on bool) (err error) {
+	_, err = m.RunCommand("state "+onOff(on), "")
+	return
+}
+
+func (m *OpenVpnManagement) stateLines(cmd string) (states []*StateEvent, err error) {
+	raw, err := m.RunCommand(cmd, "")
+	if nil != err {
+		return
+	}
+	for _, line := range historyLines(raw) {
+		states = append(states, parseState(line))
+	}
+	return
+}
+
+// Version 执行 version，输出如 OpenVPN Version: OpenVPN 2.6.8 ... / Management Interface Version: 5
+func (m *OpenVpnManagement) Version() (info *VersionInfo, err error) {
+	raw, err := m.RunCommand("version", "")
+	if nil != err {
+		return
+	}
+	info = &VersionInfo{Raw: raw}
+	for _, line := range strings.Split(raw, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(name, "OpenVPN Version"):
+			info.OpenVpn = value
+		case strings.HasPrefix(name, "Management"):
+			info.Management, _ = strconv.Atoi(value)
+		}
+	}
+	return
+}
+
+// Pid 返回 OpenVPN 进程 pid
+func (m *OpenVpnManagement) Pid() (pid int, err error) {
+	values, err := m.successValues("pid")
+	if nil != err {
+		return
+	}
+	pid, err = strconv.Atoi(values["pid"])
+	if nil != err {
+		err = fmt.Errorf("解析 pid 失败: %+v", err)
+	}
+	return
+}
+
+// HoldRelease 释放 management-hold，让 OpenVPN 继续启动
+func (m *OpenVpnManagement) HoldRelease() (err error) {
+	_, err = m.RunCommand("hold release", "")
+	return
+}
+
+func (m *OpenVpnManagement) SetHold(on bool) (err error) {
+	_, err = m.RunCommand("hold "+onOff(on), "")
+	return
+}
+
+// Hold 查询 hold 标志
+func (m *OpenVpnManagement) Hold() (on bool, err error) {
+	values, err := m.successValues("hold")
+	if nil != err {
+		return
+	}
+	on = "1" == values["hold"]
+	return
+}
+
+// Signal 发送信号，如 SIGHUP、SIGTERM、SIGUSR1、SIGUSR2
+func (m *OpenVpnManagement) Signal(signal string) (err error) {
+	signal = strings.ToUpper(strings.TrimSpace(signal))
+	switch signal {
+	case "SIGHUP", "SIGTERM", "SIGUSR1", "SIGUSR2":
+	default:
+		return fmt.Errorf("不支持的信号: %s", signal)
+	}
+	_, err = m.RunCommand("signal "+signal, "")
+	return
+}
+
+// KillByAddress 断开指定真实地址的客户端，proto 为空时使用 udp，返回断开的数量
+func (m *OpenVpnManagement) KillByAddress(ip string, port int, proto ...string) (count int, err error) {
+	p := "udp"
+	if len(proto) > 0 && "" != proto[0] {
+		p = proto[0]
+	}
+	return m.kill(fmt.Sprintf("%s:%s:%d", p, ip, port))
+}
+
+// KillByCommonName 断开 common name 对应的所有客户端，返回断开的数量
+func (m *OpenVpnManagement) KillByCommonName(name string) (count int, err error) {
+	return m.kill(name)
+}
+
+// kill 输出如 SUCCESS: common name 'xx' found, 1 client(s) killed
+func (m *OpenVpnManagement) kill(target string) (count int, err error) {
+	raw, err := m.RunCommand("kill "+target, "")
+	if nil != err {
+		return
+	}
+	for _, field := range strings.Fields(successMessage(raw)) {
+		if strings.HasSuffix(field, "client(s)") {
+			break
+		}
+		if v, e := strconv.Atoi(strings.TrimSuffix(field, ",")); nil == e {
+			count = v
+		}
+	}
+	return
+}
+
+// SetLog 开启或关闭 LOG 实时通知
+func (m *OpenVpnManagement) SetLog(on bool) (err error) {
+	_, err = m.RunCommand("log "+onOff(on), "")
+	return
+}
+
+// LogHistory 返回最近 n 条日志，n <= 0 时返回全部
+func (m *OpenVpnManagement) LogHistory(n int) (logs []*LogEvent, err error) {
+	raw, err := m.RunCommand(historyCommand("log", n), "")
+	if nil != err {
+		return
+	}
+	for _, line := range historyLines(raw) {
+		logs = append(logs, parseLog(line))
+	}
+	return
+}
+
+// Verb 查询日志级别
+func (m *OpenVpnManagement) Verb() (level int, err error) {
+	values, err := m.successValues("verb")
+	if nil != err {
+		return
+	}
+	level, _ = strconv.Atoi(values["verb"])
+	return
+}
+
+func (m *OpenVpnManagement) SetVerb(level int) (err error) {
+	_, err = m.RunCommand(fmt.Sprintf("verb %d", level), "")
+	return
+}
+
+// ClientPf 给客户端设置包过滤规则
+func (m *OpenVpnManagement) ClientPf(clientId int, pf *ClientPacketFilter) (err error) {
+	if nil == pf {
+		return fmt.Errorf("client-pf 规则不能为空")
+	}
+	lines := []string{fmt.Sprintf("client-pf %d", clientId)}
+	lines = append(lines, fmt.Sprintf("[CLIENTS %s]", acceptDrop(pf.ClientsAccept)))
+	lines = append(lines, pfRules(pf.Clients)...)
+	lines = append(lines, fmt.Sprintf("[SUBNETS %s]", acceptDrop(pf.SubnetsAccept)))
+	lines = append(lines, pfRules(pf.Subnets)...)
+	lines = append(lines, "[END]", "END")
+	_, err = m.RunCommand(strings.Join(lines, "\n"), "")
+	return
+}
+
+func pfRules(rules []ClientPfRule) (lines []string) {
+	for _, rule := range rules {
+		target := strings.TrimSpace(rule.Target)
+		if "" == target {
+			continue
+		}
+		if rule.Allow {
+			lines = append(lines, "+"+target)
+		} else {
+			lines = append(lines, "-"+target)
+		}
+	}
+	return
+}
+
+func acceptDrop(accept bool) string {
+	if accept {
+		return "ACCEPT"
+	}
+	return "DROP"
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func historyCommand(cmd string, n int) string {
+	if n <= 0 {
+		return cmd + " all"
+	}
+	return fmt.Sprintf("%s %d", cmd, n)
+}
+
+func historyLines(raw string) (lines []string) {
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if "" == line || "END" == line || strings.HasPrefix(line, "SUCCESS:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return
+}
+
+func successMessage(raw string) string {
+	for _, line := range strings.Split(raw, "\n") {
+		if strings.HasPrefix(line, "SUCCESS:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "SUCCESS:"))
+		}
+	}
+	return ""
+}
+
+// successValues 解析 SUCCESS: k1=v1,k2=v2 形式的输出
+func (m *OpenVpnManagement) successValues(cmd string) (values map[string]string, err error) {
+	raw, err := m.RunCommand(cmd, "")
+	if nil != err {
+		return
+	}
+	values = map[string]string{}
+	for _, pair := range strings.Split(successMessage(raw), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok {
+			values[k] = v
+		}
+	}
+	return
+}
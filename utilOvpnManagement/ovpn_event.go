@@ -0,0 +1,215 @@
+package utilOvpnManagement
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 管理接口以 > 开头的异步通知
+const (
+	EventClientConnect     = "CLIENT:CONNECT"
+	EventClientReauth      = "CLIENT:REAUTH"
+	EventClientEstablished = "CLIENT:ESTABLISHED"
+	EventClientDisconnect  = "CLIENT:DISCONNECT"
+	EventClientAddress     = "CLIENT:ADDRESS"
+	EventClientCrResponse  = "CLIENT:CR_RESPONSE"
+	EventByteCount         = "BYTECOUNT"
+	EventByteCountClient   = "BYTECOUNT_CLI"
+	EventState             = "STATE"
+	EventLog               = "LOG"
+	EventEcho              = "ECHO"
+	EventInfo              = "INFO"
+	EventHold              = "HOLD"
+	EventFatal             = "FATAL"
+	EventNeedOk            = "NEED-OK"
+	EventNeedStr           = "NEED-STR"
+	EventPassword          = "PASSWORD"
+
+	// 管理连接本身的状态变化，由本包产生
+	EventManagementConnected    = "MANAGEMENT:CONNECTED"
+	EventManagementDisconnected = "MANAGEMENT:DISCONNECTED"
+)
+
+type Event struct {
+	Type      string          `json:"type"`
+	Raw       string          `json:"raw"`
+	Time      time.Time       `json:"time"`
+	Client    *ClientEvent    `json:"client,omitempty"`
+	ByteCount *ByteCountEvent `json:"byte_count,omitempty"`
+	State     *StateEvent     `json:"state,omitempty"`
+	Log       *LogEvent       `json:"log,omitempty"`
+}
+
+// ClientEvent CONNECT/REAUTH/ESTABLISHED/DISCONNECT 附带 >CLIENT:ENV 环境变量
+type ClientEvent struct {
+	ClientId   int               `json:"client_id"`
+	KeyId      int               `json:"key_id"`
+	Address    string            `json:"address,omitempty"`
+	Primary    bool              `json:"primary,omitempty"`
+	CrResponse string            `json:"cr_response,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+}
+
+func (c *ClientEvent) CommonName() string {
+	return c.Env["common_name"]
+}
+func (c *ClientEvent) Username() string {
+	return c.Env["username"]
+}
+func (c *ClientEvent) Password() string {
+	return c.Env["password"]
+}
+func (c *ClientEvent) RealIp() string {
+	if ip := c.Env["untrusted_ip"]; "" != ip {
+		return ip
+	}
+	return c.Env["untrusted_ip6"]
+}
+func (c *ClientEvent) VirtualAddress() string {
+	return c.Env["ifconfig_pool_remote_ip"]
+}
+func (c *ClientEvent) BytesReceived() int64 {
+	v, _ := strconv.ParseInt(c.Env["bytes_received"], 10, 64)
+	return v
+}
+func (c *ClientEvent) BytesSent() int64 {
+	v, _ := strconv.ParseInt(c.Env["bytes_sent"], 10, 64)
+	return v
+}
+
+// ByteCountEvent 客户端模式的 BYTECOUNT 中 ClientId 为 -1
+type ByteCountEvent struct {
+	ClientId int   `json:"client_id"`
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+}
+
+type StateEvent struct {
+	Time        time.Time `json:"time"`
+	State       string    `json:"state"`
+	Description string    `json:"description"`
+	LocalIp     string    `json:"local_ip"`
+	RemoteIp    string    `json:"remote_ip"`
+	RemotePort  string    `json:"remote_port"`
+	LocalAddr   string    `json:"local_addr"`
+	LocalPort   string    `json:"local_port"`
+	LocalIpv6   string    `json:"local_ipv6"`
+}
+
+type LogEvent struct {
+	Time    time.Time `json:"time"`
+	Flags   string    `json:"flags"`
+	Message string    `json:"message"`
+}
+
+// eventParser 把多行的 CLIENT 通知合并成一个事件
+type eventParser struct {
+	pending *Event
+}
+
+func (p *eventParser) feed(line string) (event *Event) {
+	body := strings.TrimPrefix(line, ">")
+	typ, data, _ := strings.Cut(body, ":")
+
+	if "CLIENT" == typ {
+		sub, args, _ := strings.Cut(data, ",")
+		if "ENV" == sub {
+			if nil == p.pending {
+				return
+			}
+			if "END" == args {
+				event = p.pending
+				p.pending = nil
+				return
+			}
+			k, v, _ := strings.Cut(args, "=")
+			p.pending.Client.Env[k] = v
+			return
+		}
+
+		event = &Event{Type: "CLIENT:" + sub, Raw: body, Time: time.Now(), Client: &ClientEvent{Env: map[string]string{}}}
+		parts := strings.Split(args, ",")
+		event.Client.ClientId = atoi(parts, 0)
+		switch event.Type {
+		case EventClientConnect, EventClientReauth:
+			event.Client.KeyId = atoi(parts, 1)
+			p.pending = event
+			event = nil
+		case EventClientEstablished, EventClientDisconnect:
+			p.pending = event
+			event = nil
+		case EventClientAddress:
+			if len(parts) > 2 {
+				event.Client.Address = parts[1]
+				event.Client.Primary = "1" == parts[2]
+			}
+		case EventClientCrResponse:
+			event.Client.KeyId = atoi(parts, 1)
+			if len(parts) > 2 {
+				event.Client.CrResponse = parts[2]
+			}
+		}
+		return
+	}
+
+	event = &Event{Type: typ, Raw: body, Time: time.Now()}
+	switch typ {
+	case EventByteCount:
+		parts := strings.Split(data, ",")
+		event.ByteCount = &ByteCountEvent{ClientId: -1, BytesIn: atoi64(parts, 0), BytesOut: atoi64(parts, 1)}
+	case EventByteCountClient:
+		parts := strings.Split(data, ",")
+		event.ByteCount = &ByteCountEvent{ClientId: atoi(parts, 0), BytesIn: atoi64(parts, 1), BytesOut: atoi64(parts, 2)}
+	case EventState:
+		event.State = parseState(data)
+	case EventLog:
+		event.Log = parseLog(data)
+	}
+	return
+}
+
+// parseState 解析 {time},{state},{desc},{local ip},{remote ip},{remote port},{local addr},{local port},{local ipv6}
+func parseState(data string) (state *StateEvent) {
+	parts := strings.Split(data, ",")
+	state = &StateEvent{Time: unixTime(parts, 0)}
+	fields := []*string{nil, &state.State, &state.Description, &state.LocalIp, &state.RemoteIp, &state.RemotePort, &state.LocalAddr, &state.LocalPort, &state.LocalIpv6}
+	for i, field := range fields {
+		if nil != field && i < len(parts) {
+			*field = parts[i]
+		}
+	}
+	return
+}
+
+// parseLog 解析 {time},{flags},{message}，message 中可能包含逗号
+func parseLog(data string) (log *LogEvent) {
+	parts := strings.SplitN(data, ",", 3)
+	log = &LogEvent{Time: unixTime(parts, 0)}
+	if len(parts) > 1 {
+		log.Flags = parts[1]
+	}
+	if len(parts) > 2 {
+		log.Message = parts[2]
+	}
+	return
+}
+
+func atoi(parts []string, i int) (v int) {
+	if i < len(parts) {
+		v, _ = strconv.Atoi(strings.TrimSpace(parts[i]))
+	}
+	return
+}
+func atoi64(parts []string, i int) (v int64) {
+	if i < len(parts) {
+		v, _ = strconv.ParseInt(strings.TrimSpace(parts[i]), 10, 64)
+	}
+	return
+}
+func unixTime(parts []string, i int) (t time.Time) {
+	if v := atoi64(parts, i); v > 0 {
+		t = time.Unix(v, 0)
+	}
+	return
+}
@@ -1,50 +1,66 @@
 package utilOvpnManagement
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"net"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Client 表示一个在线用户
 type Client struct {
-	ClientId         int    `json:"client_id"`
-	CommonName       string `json:"common_name"`
-	RealAddress      string `json:"real_address"`
-	VirtualAddress   string `json:"virtual_address"`
-	VirtualV6Address string `json:"virtual_v6_address"`
-	BytesReceived    int64  `json:"bytes_received"`
-	BytesSent        int64  `json:"bytes_sent"`
-	ConnectedSince   string `json:"connected_since"`
-	Username         string `json:"username"`
+	ClientId         int       `json:"client_id"`
+	CommonName       string    `json:"common_name"`
+	RealAddress      string    `json:"real_address"`
+	VirtualAddress   string    `json:"virtual_address"`
+	VirtualV6Address string    `json:"virtual_v6_address"`
+	BytesReceived    int64     `json:"bytes_received"`
+	BytesSent        int64     `json:"bytes_sent"`
+	ConnectedSince   string    `json:"connected_since"`
+	ConnectedAt      time.Time `json:"connected_at"`
+	Username         string    `json:"username"`
+	PeerId           int       `json:"peer_id"`
+	DataCipher       string    `json:"data_cipher,omitempty"`
 }
 
 // Route 表示一个路由表项
 type Route struct {
-	VirtualAddress string `json:"virtual_address"`
-	CommonName     string `json:"common_name"`
-	RealAddress    string `json:"real_address"`
-	LastRef        string `json:"last_ref"`
+	VirtualAddress string    `json:"virtual_address"`
+	CommonName     string    `json:"common_name"`
+	RealAddress    string    `json:"real_address"`
+	LastRef        string    `json:"last_ref"`
+	LastRefAt      time.Time `json:"last_ref_at"`
 }
 
 // StatusInfo 封装状态信息
 type StatusInfo struct {
-	NodeId   string   `json:"node_id,omitempty"`
+	NodeId  string   `json:"node_id,omitempty"`
 	Title   string   `json:"title"`
 	Time    string   `json:"time"`
 	Clients []Client `json:"clients"`
 	Routes  []Route  `json:"routes"`
+
+	UpdatedAt                time.Time         `json:"updated_at"`
+	MaxBcastMcastQueueLength int               `json:"max_bcast_mcast_queue_length"`
+	GlobalStats              map[string]string `json:"global_stats,omitempty"`
 }
 
 type OpenVpnManagement struct {
 	Addr     string
 	Password string
 	Timeout  time.Duration
-	conn     net.Conn
-	reader   *bufio.Reader
+
+	session *ovpnSession
+	stop    context.CancelFunc
+	stopCtx context.Context
+	locker  sync.RWMutex
+
+	initCommands   []string
+	eventBuffer    int
+	events         chan *Event
+	handlers       []func(event *Event)
+	handlersLocker sync.RWMutex
 }
 
 // NewOpenVpnManagement 创建新的 OpenVPN 管理客户端
@@ -58,118 +74,58 @@ func NewOpenVpnManagement(addr, password string, timeOut time.Duration) *OpenVpn
 
 // Connect 建立连接
 func (m *OpenVpnManagement) Connect() (err error) {
-	if nil != m.conn {
+	if nil != m.getSession() {
 		return
 	}
-	conn, err := net.DialTimeout("tcp", m.Addr, m.Timeout)
-	if err != nil {
-		err = fmt.Errorf("连接失败: %v", err)
+	s, err := dialSession(m.Addr, m.Password, m.Timeout, nil)
+	if nil != err {
 		return
 	}
-	m.conn = conn
-	m.reader = bufio.NewReader(conn)
-
-	// 如果需要密码
-	if m.Password != "" {
-		line, _ := m.reader.ReadString(':') // 读取 "Enter Management Password:"
-		if !strings.Contains(strings.ToUpper(line), "PASSWORD") {
-			err = fmt.Errorf("未收到密码提示")
-			return
-		}
-		m.conn.Write([]byte(m.Password + "\n"))
-	}
-
+	m.setSession(s)
 	return nil
 }
 
-// Close 关闭连接
+// Close 关闭连接，长连接模式下同时停止自动重连
 func (m *OpenVpnManagement) Close() {
-	if m.conn != nil {
-		m.conn.Write([]byte("exit\n"))
-		m.conn.Close()
-		m.conn = nil
+	m.Stop()
+	s := m.getSession()
+	if nil != s {
+		s.exit()
+		m.setSession(nil)
 	}
 }
 
-// RunCommand 执行任意命令并返回原始输出
+// RunCommand 执行任意命令并返回原始输出，Start 之后复用长连接，否则每次新建连接
 func (m *OpenVpnManagement) RunCommand(cmd string, endStr string) (result string, err error) {
-	err = m.Connect()
-	if nil != err {
-		return
+	return m.RunCommandContext(context.Background(), cmd, endStr)
+}
+func (m *OpenVpnManagement) RunCommandContext(ctx context.Context, cmd string, endStr string) (result string, err error) {
+	m.locker.RLock()
+	persistent := nil != m.stop
+	m.locker.RUnlock()
+
+	if !persistent {
+		err = m.Connect()
+		if nil != err {
+			return
+		}
+		defer m.Close()
 	}
-	defer m.Close()
 
-	if m.conn == nil {
+	s := m.getSession()
+	if s == nil {
 		return "", fmt.Errorf("未连接")
 	}
-	m.conn.Write([]byte(cmd + "\n"))
-	var output strings.Builder
-
-	for {
-		line, err := m.reader.ReadString('\n')
-		if err != nil {
-			break
-		}
-		output.WriteString(line)
-
-		if "" == endStr || strings.Contains(line, endStr) {
-			break
-		}
+	lines, err := s.command(ctx, cmd, endStr)
+	if len(lines) > 0 {
+		result = strings.Join(lines, "\n") + "\n"
 	}
-	result = output.String()
 	return
 }
 
 // GetStatus 解析 status 2 输出
 func (m *OpenVpnManagement) GetStatus() (info *StatusInfo, err error) {
-	raw, err := m.RunCommand("status 2", "END")
-	if err != nil {
-		return
-	}
-	lines := strings.Split(raw, "\n")
-	info = &StatusInfo{}
-	for _, line := range lines {
-		parts := strings.Split(line, ",")
-		if len(parts) < 2 {
-			continue
-		}
-		switch parts[0] {
-		case "TITLE":
-			info.Title = parts[1]
-		case "TIME":
-			if len(parts) > 1 {
-				info.Time = parts[1]
-			}
-		case "CLIENT_LIST":
-			if len(parts) < 13 {
-				continue
-			}
-			clientId, _ := strconv.Atoi(parts[10])
-			client := Client{
-				ClientId:         clientId,
-				CommonName:       parts[1],
-				RealAddress:      parts[2],
-				VirtualAddress:   parts[3],
-				VirtualV6Address: parts[4],
-				ConnectedSince:   parts[7],
-				Username:         parts[9],
-			}
-			client.BytesReceived, _ = strconv.ParseInt(parts[5], 10, 64)
-			client.BytesSent, _ = strconv.ParseInt(parts[6], 10, 64)
-			info.Clients = append(info.Clients, client)
-		case "ROUTING_TABLE":
-			if len(parts) < 5 {
-				continue
-			}
-			info.Routes = append(info.Routes, Route{
-				VirtualAddress: parts[1],
-				CommonName:     parts[2],
-				RealAddress:    parts[3],
-				LastRef:        parts[4],
-			})
-		}
-	}
-	return
+	return m.GetStatusVersion(2)
 }
 
 // KickClient 根据 client id 断开连接
@@ -0,0 +1,353 @@
+package utilOvpnManagement
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ovpnSession 一条管理连接，读取协程把 > 开头的通知与命令回复分开
+type ovpnSession struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	cmdLocker sync.Mutex
+	waiting   atomic.Bool
+	replies   chan string
+
+	parser  eventParser
+	onEvent func(event *Event) error
+
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+}
+
+func dialSession(addr string, password string, timeout time.Duration, onEvent func(event *Event) error) (s *ovpnSession, err error) {
+	if timeout <= 0 {
+		timeout = time.Duration(10) * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		err = fmt.Errorf("连接失败: %v", err)
+		return
+	}
+	s = &ovpnSession{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+		replies: make(chan string, 256),
+		onEvent: onEvent,
+		done:    make(chan struct{}),
+	}
+
+	// 如果需要密码
+	if password != "" {
+		err = s.login(password)
+		if nil != err {
+			_ = conn.Close()
+			s = nil
+			return
+		}
+	}
+
+	go s.readLoop()
+	return
+}
+
+func (s *ovpnSession) login(password string) (err error) {
+	_ = s.conn.SetDeadline(time.Now().Add(s.timeout))
+	defer s.conn.SetDeadline(time.Time{})
+
+	line, err := s.reader.ReadString(':') // 读取 "ENTER PASSWORD:"
+	if nil != err || !strings.Contains(strings.ToUpper(line), "PASSWORD") {
+		err = fmt.Errorf("未收到密码提示")
+		return
+	}
+	if _, err = s.conn.Write([]byte(password + "\n")); nil != err {
+		return
+	}
+	for {
+		line, err = s.reader.ReadString('\n')
+		if nil != err {
+			err = fmt.Errorf("读取密码验证结果失败: %v", err)
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, ">"):
+			s.dispatch(line)
+		case strings.HasPrefix(line, "SUCCESS:"):
+			return
+		case strings.HasPrefix(line, "ERROR:"):
+			err = fmt.Errorf("密码验证失败: %s", line)
+			return
+		}
+	}
+}
+
+func (s *ovpnSession) readLoop() {
+	defer s.close(nil)
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			s.close(err)
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, ">") {
+			s.dispatch(line)
+			continue
+		}
+		// 没有等待中的命令时丢弃，避免污染下一条命令的输出
+		if s.waiting.Load() {
+			select {
+			case s.replies <- line:
+			case <-time.After(s.timeout):
+			}
+		}
+	}
+}
+
+// dispatch 投递事件失败时关闭连接，由 Start 重连，避免静默丢失 CLIENT:CONNECT 等需要应答的事件
+func (s *ovpnSession) dispatch(line string) {
+	event := s.parser.feed(line)
+	if nil != event && nil != s.onEvent {
+		if err := s.onEvent(event); nil != err {
+			s.close(err)
+		}
+	}
+}
+
+// command 发送命令并读取回复，以 SUCCESS:/ERROR: 开头的行或 END 行结束，endStr 不为空时包含 endStr 的行也视为结束
+func (s *ovpnSession) command(ctx context.Context, cmd string, endStr string) (lines []string, err error) {
+	s.cmdLocker.Lock()
+	defer s.cmdLocker.Unlock()
+
+	for len(s.replies) > 0 {
+		<-s.replies
+	}
+	s.waiting.Store(true)
+	defer s.waiting.Store(false)
+
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err = s.conn.Write([]byte(cmd + "\n")); nil != err {
+		err = fmt.Errorf("发送命令失败: %v", err)
+		return
+	}
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case line := <-s.replies:
+			lines = append(lines, line)
+			if strings.HasPrefix(line, "ERROR:") {
+				err = fmt.Errorf("%s", strings.TrimSpace(strings.TrimPrefix(line, "ERROR:")))
+				return
+			}
+			if strings.HasPrefix(line, "SUCCESS:") || "END" == line || ("" != endStr && strings.Contains(line, endStr)) {
+				return
+			}
+		case <-s.done:
+			err = fmt.Errorf("连接已断开: %v", s.err)
+			return
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-timer.C:
+			err = fmt.Errorf("命令超时: %s", cmd)
+			return
+		}
+	}
+}
+
+func (s *ovpnSession) close(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		_ = s.conn.Close()
+		close(s.done)
+	})
+}
+
+func (s *ovpnSession) exit() {
+	_ = s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = s.conn.Write([]byte("exit\n"))
+	s.close(nil)
+}
+
+// SetInitCommands 设置 Start 每次连接成功后执行的命令，如 "state on"、"bytecount 5"、"log on"
+func (m *OpenVpnManagement) SetInitCommands(cmds ...string) *OpenVpnManagement {
+	m.initCommands = cmds
+	return m
+}
+
+// SetEventBuffer 设置 Events 返回的 channel 缓冲大小，需在 Start 之前调用
+func (m *OpenVpnManagement) SetEventBuffer(size int) *OpenVpnManagement {
+	m.eventBuffer = size
+	return m
+}
+
+// OnEvent 注册事件回调，回调在单独的协程中依次执行，可以在回调中执行命令
+func (m *OpenVpnManagement) OnEvent(handler func(event *Event)) *OpenVpnManagement {
+	m.handlersLocker.Lock()
+	defer m.handlersLocker.Unlock()
+	m.handlers = append(m.handlers, handler)
+	return m
+}
+
+// Events 返回事件 channel，供观察使用，消费不及时缓冲写满时新事件不再写入该 channel，OnEvent 回调不受影响，需要完整处理的事件请用 OnEvent
+func (m *OpenVpnManagement) Events() <-chan *Event {
+	m.handlersLocker.Lock()
+	defer m.handlersLocker.Unlock()
+	if nil == m.events {
+		size := m.eventBuffer
+		if size <= 0 {
+			size = 1024
+		}
+		m.events = make(chan *Event, size)
+	}
+	return m.events
+}
+
+// Start 保持长连接并接收异步通知，断开后按 1s 起的指数退避(最长 30s)自动重连，ctx 取消或 Stop 后退出
+func (m *OpenVpnManagement) Start(ctx context.Context) {
+	m.locker.Lock()
+	if nil != m.stop {
+		m.locker.Unlock()
+		return
+	}
+	ctx, m.stop = context.WithCancel(ctx)
+	m.stopCtx = ctx
+	queue := make(chan *Event, 1024)
+	m.locker.Unlock()
+
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = time.Duration(10) * time.Second
+	}
+	// 队列满时阻塞等待回调消费，超过 timeout 仍未消费则返回错误断开连接，不丢弃事件
+	push := func(event *Event) error {
+		select {
+		case queue <- event:
+			return nil
+		default:
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case queue <- event:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return fmt.Errorf("事件队列已满(%d)，回调处理过慢", cap(queue))
+		}
+	}
+
+	go m.dispatchLoop(ctx, queue)
+	go func() {
+		defer func() {
+			m.locker.Lock()
+			if m.stopCtx == ctx {
+				m.stop = nil
+				m.stopCtx = nil
+			}
+			m.locker.Unlock()
+		}()
+		backoff := time.Second
+		maxBackoff := time.Duration(30) * time.Second
+		for {
+			s, err := dialSession(m.Addr, m.Password, m.Timeout, push)
+			if nil == err {
+				backoff = time.Second
+				m.setSession(s)
+				_ = push(&Event{Type: EventManagementConnected, Time: time.Now()})
+				for _, cmd := range m.initCommands {
+					_, _ = s.command(ctx, cmd, "")
+				}
+				select {
+				case <-s.done:
+				case <-ctx.Done():
+					s.exit()
+				}
+				m.setSession(nil)
+				err = s.err
+			}
+			if nil != ctx.Err() {
+				return
+			}
+			raw := ""
+			if nil != err {
+				raw = err.Error()
+			}
+			_ = push(&Event{Type: EventManagementDisconnected, Raw: raw, Time: time.Now()})
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}()
+}
+
+func (m *OpenVpnManagement) Stop() {
+	m.locker.Lock()
+	stop := m.stop
+	m.stop = nil
+	m.stopCtx = nil
+	m.locker.Unlock()
+	if nil != stop {
+		stop()
+	}
+}
+
+// Running 长连接当前是否可用
+func (m *OpenVpnManagement) Running() bool {
+	return nil != m.getSession()
+}
+
+func (m *OpenVpnManagement) dispatchLoop(ctx context.Context, queue chan *Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-queue:
+			m.handlersLocker.RLock()
+			handlers := m.handlers
+			events := m.events
+			m.handlersLocker.RUnlock()
+			for _, handler := range handlers {
+				handler(event)
+			}
+			if nil != events {
+				select {
+				case events <- event:
+				default:
+				}
+			}
+		}
+	}
+}
+
+func (m *OpenVpnManagement) setSession(s *ovpnSession) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.session = s
+}
+func (m *OpenVpnManagement) getSession() *ovpnSession {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	return m.session
+}
@@ -0,0 +1,97 @@
+package utilOvpnManagement
+
+import (
+	"context"
+	"fmt"
+	"github.com/hilaoyu/go-utils/utilMongodb"
+	"github.com/hilaoyu/go-utils/utilOrm"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// ClientSession 一次客户端连接，Id 由节点、client id 和连接时间生成，同一会话重复保存时覆盖
+type ClientSession struct {
+	Id               string     `gorm:"primaryKey;size:128" json:"id" bson:"_id"`
+	NodeId           string     `gorm:"size:64;index" json:"node_id" bson:"node_id"`
+	ClientId         int        `json:"client_id" bson:"client_id"`
+	CommonName       string     `gorm:"size:255;index" json:"common_name" bson:"common_name"`
+	Username         string     `gorm:"size:255;index" json:"username" bson:"username"`
+	RealAddress      string     `gorm:"size:64" json:"real_address" bson:"real_address"`
+	VirtualAddress   string     `gorm:"size:64" json:"virtual_address" bson:"virtual_address"`
+	VirtualV6Address string     `gorm:"size:64" json:"virtual_v6_address" bson:"virtual_v6_address"`
+	StartAt          time.Time  `gorm:"index" json:"start_at" bson:"start_at"`
+	EndAt            *time.Time `gorm:"index" json:"end_at,omitempty" bson:"end_at,omitempty"`
+	BytesReceived    int64      `json:"bytes_received" bson:"bytes_received"`
+	BytesSent        int64      `json:"bytes_sent" bson:"bytes_sent"`
+	EndReason        string     `gorm:"size:64" json:"end_reason,omitempty" bson:"end_reason,omitempty"`
+}
+
+// User 优先使用 username，没有时使用 common name
+func (s *ClientSession) User() string {
+	if "" != s.Username {
+		return s.Username
+	}
+	return s.CommonName
+}
+
+func (s *ClientSession) Duration() time.Duration {
+	if nil != s.EndAt {
+		return s.EndAt.Sub(s.StartAt)
+	}
+	return time.Since(s.StartAt)
+}
+
+// ClientSessionStore 会话开始和结束时各调用一次 SaveSession，结束时 EndAt 不为空
+type ClientSessionStore interface {
+	SaveSession(ctx context.Context, session *ClientSession) error
+}
+
+type OrmClientSessionStore struct {
+	orm   *utilOrm.UtilGorm
+	table string
+}
+
+func NewOrmClientSessionStore(orm *utilOrm.UtilGorm, table string) *OrmClientSessionStore {
+	if "" == table {
+		table = "ovpn_client_sessions"
+	}
+	return &OrmClientSessionStore{orm: orm, table: table}
+}
+
+// Migrate 创建或更新会话表
+func (s *OrmClientSessionStore) Migrate() (err error) {
+	err = s.orm.Original().Table(s.table).AutoMigrate(&ClientSession{})
+	if nil != err {
+		err = fmt.Errorf("migrate %s error: %+v", s.table, err)
+	}
+	return
+}
+
+func (s *OrmClientSessionStore) SaveSession(ctx context.Context, session *ClientSession) (err error) {
+	err = s.orm.Original().WithContext(ctx).Table(s.table).Save(session).Error
+	if nil != err {
+		err = fmt.Errorf("save session %s error: %+v", session.Id, err)
+	}
+	return
+}
+
+type MongodbClientSessionStore struct {
+	client     *utilMongodb.MongodbClient
+	collection string
+}
+
+func NewMongodbClientSessionStore(client *utilMongodb.MongodbClient, collection string) *MongodbClientSessionStore {
+	if "" == collection {
+		collection = "ovpn_client_sessions"
+	}
+	return &MongodbClientSessionStore{client: client, collection: collection}
+}
+
+func (s *MongodbClientSessionStore) SaveSession(ctx context.Context, session *ClientSession) (err error) {
+	_, err = s.client.Collection(s.collection).ReplaceOne(ctx, bson.M{"_id": session.Id}, session, options.Replace().SetUpsert(true))
+	if nil != err {
+		err = fmt.Errorf("save session %s error: %+v", session.Id, err)
+	}
+	return
+}
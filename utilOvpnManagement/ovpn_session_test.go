@@ -0,0 +1,322 @@
+package utilOvpnManagement
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeManagement 模拟 OpenVPN 管理端口，onCommand 返回原样写回客户端的多行回复
+type fakeManagement struct {
+	ln        net.Listener
+	password  string
+	onConnect func(conn net.Conn)
+	onCommand func(cmd string) string
+
+	locker sync.Mutex
+	conns  []net.Conn
+}
+
+func newFakeManagement(t *testing.T, password string, onCommand func(cmd string) string) *fakeManagement {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatalf("listen: %+v", err)
+	}
+	f := &fakeManagement{ln: ln, password: password, onCommand: onCommand}
+	t.Cleanup(f.close)
+	go f.serve()
+	return f
+}
+
+func (f *fakeManagement) addr() string {
+	return f.ln.Addr().String()
+}
+
+func (f *fakeManagement) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if nil != err {
+			return
+		}
+		f.locker.Lock()
+		f.conns = append(f.conns, conn)
+		f.locker.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeManagement) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if "" != f.password {
+		_, _ = conn.Write([]byte("ENTER PASSWORD:"))
+		line, err := reader.ReadString('\n')
+		if nil != err {
+			return
+		}
+		if strings.TrimSpace(line) != f.password {
+			_, _ = conn.Write([]byte("ERROR: bad password\r\n"))
+			return
+		}
+		_, _ = conn.Write([]byte("SUCCESS: password is correct\r\n"))
+	}
+	_, _ = conn.Write([]byte(">INFO:OpenVPN Management Interface Version 5 -- type 'help' for more info\r\n"))
+	f.locker.Lock()
+	onConnect := f.onConnect
+	f.locker.Unlock()
+	if nil != onConnect {
+		onConnect(conn)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if nil != err {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		if "exit" == cmd {
+			return
+		}
+		reply := "ERROR: unknown command, enter 'help' for more options"
+		if nil != f.onCommand {
+			reply = f.onCommand(cmd)
+		}
+		if _, err = conn.Write([]byte(strings.ReplaceAll(reply, "\n", "\r\n") + "\r\n")); nil != err {
+			return
+		}
+	}
+}
+
+// dropConns 断开所有已建立的连接，模拟 OpenVPN 重启
+func (f *fakeManagement) dropConns() {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	for _, conn := range f.conns {
+		_ = conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeManagement) close() {
+	_ = f.ln.Close()
+	f.dropConns()
+}
+
+func fakeCommands(cmd string) string {
+	switch cmd {
+	case "version":
+		return "OpenVPN Version: OpenVPN 2.6.8 x86_64-pc-linux-gnu\nManagement Interface Version: 5\nEND"
+	case "pid":
+		return "SUCCESS: pid=4242"
+	case "state":
+		return "1700000000,CONNECTED,SUCCESS,10.8.0.1,,,,\nEND"
+	}
+	return "ERROR: unknown command, enter 'help' for more options"
+}
+
+// eventRecorder 收集回调收到的事件，wait 等待指定类型的事件出现
+type eventRecorder struct {
+	locker sync.Mutex
+	events []*Event
+	notify chan struct{}
+}
+
+func newEventRecorder() *eventRecorder {
+	return &eventRecorder{notify: make(chan struct{}, 1)}
+}
+
+func (r *eventRecorder) handle(event *Event) {
+	r.locker.Lock()
+	r.events = append(r.events, event)
+	r.locker.Unlock()
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *eventRecorder) count(typ string) (n int) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for _, event := range r.events {
+		if typ == event.Type {
+			n++
+		}
+	}
+	return
+}
+
+func (r *eventRecorder) find(typ string) *Event {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for _, event := range r.events {
+		if typ == event.Type {
+			return event
+		}
+	}
+	return nil
+}
+
+func (r *eventRecorder) wait(t *testing.T, typ string, n int, timeout time.Duration) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for r.count(typ) < n {
+		select {
+		case <-r.notify:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("等待 %d 个 %s 事件超时，实际 %d 个", n, typ, r.count(typ))
+		}
+	}
+}
+
+func TestCommandFraming(t *testing.T) {
+	f := newFakeManagement(t, "secret", fakeCommands)
+	m := NewOpenVpnManagement(f.addr(), "secret", time.Second)
+
+	info, err := m.Version()
+	if nil != err {
+		t.Fatalf("version: %+v", err)
+	}
+	if "OpenVPN 2.6.8 x86_64-pc-linux-gnu" != info.OpenVpn || 5 != info.Management {
+		t.Fatalf("version 解析错误: %+v", info)
+	}
+	if !strings.HasSuffix(info.Raw, "END\n") {
+		t.Fatalf("命令输出应以 END 行结束: %q", info.Raw)
+	}
+
+	pid, err := m.Pid()
+	if nil != err || 4242 != pid {
+		t.Fatalf("pid = %d, %+v", pid, err)
+	}
+
+	if _, err = m.RunCommand("bogus", ""); nil == err || !strings.Contains(err.Error(), "unknown command") {
+		t.Fatalf("ERROR: 回复应返回错误: %+v", err)
+	}
+
+	if _, err = NewOpenVpnManagement(f.addr(), "wrong", time.Second).Pid(); nil == err {
+		t.Fatalf("密码错误时应返回错误")
+	}
+}
+
+func TestEventsInterleavedWithReplies(t *testing.T) {
+	f := newFakeManagement(t, "", func(cmd string) string {
+		if "version" != cmd {
+			return fakeCommands(cmd)
+		}
+		// 通知穿插在命令回复中间
+		return strings.Join([]string{
+			"OpenVPN Version: OpenVPN 2.6.8 x86_64-pc-linux-gnu",
+			">STATE:1700000000,CONNECTED,SUCCESS,10.8.0.1,,,,",
+			">CLIENT:CONNECT,7,1",
+			">CLIENT:ENV,common_name=alice",
+			">CLIENT:ENV,untrusted_ip=192.0.2.10",
+			"Management Interface Version: 5",
+			">CLIENT:ENV,END",
+			">BYTECOUNT_CLI:7,100,200",
+			"END",
+		}, "\n")
+	})
+	recorder := newEventRecorder()
+	m := NewOpenVpnManagement(f.addr(), "", time.Second).OnEvent(recorder.handle)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.Start(ctx)
+	defer m.Close()
+	recorder.wait(t, EventManagementConnected, 1, 3*time.Second)
+
+	info, err := m.Version()
+	if nil != err {
+		t.Fatalf("version: %+v", err)
+	}
+	if 5 != info.Management || strings.Contains(info.Raw, ">") {
+		t.Fatalf("回复中混入了通知: %q", info.Raw)
+	}
+
+	recorder.wait(t, EventByteCountClient, 1, 3*time.Second)
+	connect := recorder.find(EventClientConnect)
+	if nil == connect || 7 != connect.Client.ClientId || 1 != connect.Client.KeyId {
+		t.Fatalf("CLIENT:CONNECT 解析错误: %+v", connect)
+	}
+	if "alice" != connect.Client.CommonName() || "192.0.2.10" != connect.Client.RealIp() {
+		t.Fatalf("CLIENT:ENV 解析错误: %+v", connect.Client.Env)
+	}
+	if state := recorder.find(EventState); nil == state || "CONNECTED" != state.State.State {
+		t.Fatalf("STATE 解析错误: %+v", state)
+	}
+
+	// 通知之后的命令不受影响
+	pid, err := m.Pid()
+	if nil != err || 4242 != pid {
+		t.Fatalf("pid = %d, %+v", pid, err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	f := newFakeManagement(t, "secret", fakeCommands)
+	recorder := newEventRecorder()
+	m := NewOpenVpnManagement(f.addr(), "secret", time.Second).
+		SetInitCommands("state").
+		OnEvent(recorder.handle)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.Start(ctx)
+	defer m.Close()
+	recorder.wait(t, EventManagementConnected, 1, 3*time.Second)
+
+	f.dropConns()
+	recorder.wait(t, EventManagementDisconnected, 1, 3*time.Second)
+	recorder.wait(t, EventManagementConnected, 2, 5*time.Second)
+
+	state, err := m.State()
+	if nil != err || "CONNECTED" != state.State {
+		t.Fatalf("重连后执行命令失败: %+v, %+v", state, err)
+	}
+
+	m.Stop()
+	f.dropConns()
+	time.Sleep(1500 * time.Millisecond)
+	if n := recorder.count(EventManagementConnected); 2 != n {
+		t.Fatalf("Stop 之后不应再重连，连接事件 %d 次", n)
+	}
+}
+
+func TestEventsNotDroppedWhenHandlerIsSlow(t *testing.T) {
+	const total = 3000
+	f := newFakeManagement(t, "", fakeCommands)
+	f.locker.Lock()
+	f.onConnect = func(conn net.Conn) {
+		w := bufio.NewWriter(conn)
+		for i := 0; i < total; i++ {
+			_, _ = fmt.Fprintf(w, ">LOG:1700000000,I,line %d\r\n", i)
+		}
+		_ = w.Flush()
+	}
+	f.locker.Unlock()
+
+	release := make(chan struct{})
+	var once sync.Once
+	recorder := newEventRecorder()
+	m := NewOpenVpnManagement(f.addr(), "", 3*time.Second).OnEvent(func(event *Event) {
+		// 第一条日志阻塞，使队列写满
+		if EventLog == event.Type {
+			once.Do(func() { <-release })
+		}
+		recorder.handle(event)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.Start(ctx)
+	defer m.Close()
+
+	time.Sleep(500 * time.Millisecond)
+	close(release)
+	recorder.wait(t, EventLog, total, 5*time.Second)
+	if n := recorder.count(EventManagementDisconnected); 0 != n {
+		t.Fatalf("队列在超时前被消费，不应断开连接")
+	}
+}
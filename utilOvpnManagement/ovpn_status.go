@@ -0,0 +1,185 @@
+package utilOvpnManagement
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 各版本 status 输出的默认列，status 2/3 会用 HEADER 行覆盖
+var statusDefaultHeaders = map[string][]string{
+	"CLIENT_LIST":   {"Common Name", "Real Address", "Virtual Address", "Virtual IPv6 Address", "Bytes Received", "Bytes Sent", "Connected Since", "Connected Since (time_t)", "Username", "Client ID", "Peer ID", "Data Channel Cipher"},
+	"ROUTING_TABLE": {"Virtual Address", "Common Name", "Real Address", "Last Ref", "Last Ref (time_t)"},
+}
+
+// status 1 中旧版本的时间格式
+var statusTimeLayouts = []string{"2006-01-02 15:04:05", "Mon Jan _2 15:04:05 2006"}
+
+// GetStatusVersion 执行 status 1/2/3 并解析，版本不在 1-3 时按 2 处理
+func (m *OpenVpnManagement) GetStatusVersion(version int) (info *StatusInfo, err error) {
+	if version < 1 || version > 3 {
+		version = 2
+	}
+	raw, err := m.RunCommand(fmt.Sprintf("status %d", version), "")
+	if err != nil {
+		return
+	}
+	if 1 == version {
+		info = parseStatusV1(raw)
+	} else {
+		sep := ","
+		if 3 == version {
+			sep = "\t"
+		}
+		info = parseStatusV2(raw, sep)
+	}
+	return
+}
+
+func parseStatusV2(raw string, sep string) (info *StatusInfo) {
+	info = &StatusInfo{GlobalStats: map[string]string{}}
+	headers := map[string][]string{}
+	for k, v := range statusDefaultHeaders {
+		headers[k] = v
+	}
+	for _, line := range strings.Split(raw, "\n") {
+		parts := strings.Split(strings.TrimRight(line, "\r"), sep)
+		if len(parts) < 2 {
+			continue
+		}
+		switch parts[0] {
+		case "HEADER":
+			headers[parts[1]] = parts[2:]
+		case "TITLE":
+			info.Title = parts[1]
+		case "TIME":
+			info.Time = parts[1]
+			info.UpdatedAt = unixTime(parts, 2)
+			if info.UpdatedAt.IsZero() {
+				info.UpdatedAt = parseStatusTime(parts[1])
+			}
+		case "CLIENT_LIST":
+			info.Clients = append(info.Clients, newStatusClient(headers["CLIENT_LIST"], parts[1:]))
+		case "ROUTING_TABLE":
+			info.Routes = append(info.Routes, newStatusRoute(headers["ROUTING_TABLE"], parts[1:]))
+		case "GLOBAL_STATS":
+			if len(parts) > 2 {
+				info.setGlobalStat(parts[1], parts[2])
+			}
+		}
+	}
+	return
+}
+
+// parseStatusV1 status 1 按 "OpenVPN CLIENT LIST"、"ROUTING TABLE"、"GLOBAL STATS" 分段，每段第一行为列名
+func parseStatusV1(raw string) (info *StatusInfo) {
+	info = &StatusInfo{GlobalStats: map[string]string{}}
+	section := ""
+	var header []string
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch line {
+		case "", "END":
+			continue
+		case "OpenVPN CLIENT LIST":
+			info.Title = line
+			section, header = "CLIENT_LIST", nil
+			continue
+		case "ROUTING TABLE":
+			section, header = "ROUTING_TABLE", nil
+			continue
+		case "GLOBAL STATS":
+			section, header = "GLOBAL_STATS", nil
+			continue
+		}
+		parts := strings.Split(line, ",")
+		if "Updated" == parts[0] && len(parts) > 1 {
+			info.Time = parts[1]
+			info.UpdatedAt = parseStatusTime(parts[1])
+			continue
+		}
+		switch section {
+		case "CLIENT_LIST", "ROUTING_TABLE":
+			if nil == header {
+				header = parts
+				continue
+			}
+			if "CLIENT_LIST" == section {
+				info.Clients = append(info.Clients, newStatusClient(header, parts))
+			} else {
+				info.Routes = append(info.Routes, newStatusRoute(header, parts))
+			}
+		case "GLOBAL_STATS":
+			if len(parts) > 1 {
+				info.setGlobalStat(parts[0], parts[1])
+			}
+		}
+	}
+	return
+}
+
+func newStatusClient(header []string, parts []string) (client Client) {
+	row := statusRow(header, parts)
+	client = Client{
+		CommonName:       row["Common Name"],
+		RealAddress:      row["Real Address"],
+		VirtualAddress:   row["Virtual Address"],
+		VirtualV6Address: row["Virtual IPv6 Address"],
+		ConnectedSince:   row["Connected Since"],
+		Username:         row["Username"],
+		DataCipher:       row["Data Channel Cipher"],
+	}
+	client.ClientId, _ = strconv.Atoi(row["Client ID"])
+	client.PeerId, _ = strconv.Atoi(row["Peer ID"])
+	client.BytesReceived, _ = strconv.ParseInt(row["Bytes Received"], 10, 64)
+	client.BytesSent, _ = strconv.ParseInt(row["Bytes Sent"], 10, 64)
+	if ts, _ := strconv.ParseInt(row["Connected Since (time_t)"], 10, 64); ts > 0 {
+		client.ConnectedAt = time.Unix(ts, 0)
+	} else {
+		client.ConnectedAt = parseStatusTime(client.ConnectedSince)
+	}
+	return
+}
+
+func newStatusRoute(header []string, parts []string) (route Route) {
+	row := statusRow(header, parts)
+	route = Route{
+		VirtualAddress: row["Virtual Address"],
+		CommonName:     row["Common Name"],
+		RealAddress:    row["Real Address"],
+		LastRef:        row["Last Ref"],
+	}
+	if ts, _ := strconv.ParseInt(row["Last Ref (time_t)"], 10, 64); ts > 0 {
+		route.LastRefAt = time.Unix(ts, 0)
+	} else {
+		route.LastRefAt = parseStatusTime(route.LastRef)
+	}
+	return
+}
+
+func statusRow(header []string, parts []string) (row map[string]string) {
+	row = map[string]string{}
+	for i, name := range header {
+		if i < len(parts) {
+			row[name] = parts[i]
+		}
+	}
+	return
+}
+
+func parseStatusTime(s string) (t time.Time) {
+	for _, layout := range statusTimeLayouts {
+		if v, err := time.ParseInLocation(layout, s, time.Local); nil == err {
+			return v
+		}
+	}
+	return
+}
+
+func (info *StatusInfo) setGlobalStat(name string, value string) {
+	info.GlobalStats[name] = value
+	if "Max bcast/mcast queue length" == name {
+		info.MaxBcastMcastQueueLength, _ = strconv.Atoi(value)
+	}
+}
@@ -0,0 +1,38 @@
+package utilOvpnManagement
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetStatusCommonNameContainsEnd(t *testing.T) {
+	f := newFakeManagement(t, "", func(cmd string) string {
+		if "status 2" != cmd {
+			return fakeCommands(cmd)
+		}
+		return strings.Join([]string{
+			"TITLE,OpenVPN 2.6.8 x86_64-pc-linux-gnu",
+			"TIME,2024-01-01 00:00:00,1704067200",
+			"HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Virtual IPv6 Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t),Username,Client ID,Peer ID,Data Channel Cipher",
+			"CLIENT_LIST,BACKEND01,192.0.2.10:1194,10.8.0.2,,100,200,2024-01-01 00:00:00,1704067200,UNDEF,3,0,AES-256-GCM",
+			"CLIENT_LIST,frontend,192.0.2.11:1194,10.8.0.3,,300,400,2024-01-01 00:00:00,1704067200,UNDEF,4,1,AES-256-GCM",
+			"HEADER,ROUTING_TABLE,Virtual Address,Common Name,Real Address,Last Ref,Last Ref (time_t)",
+			"ROUTING_TABLE,10.8.0.2,BACKEND01,192.0.2.10:1194,2024-01-01 00:00:00,1704067200",
+			"GLOBAL_STATS,Max bcast/mcast queue length,0",
+			"END",
+		}, "\n")
+	})
+	m := NewOpenVpnManagement(f.addr(), "", time.Second)
+
+	info, err := m.GetStatus()
+	if nil != err {
+		t.Fatalf("status: %+v", err)
+	}
+	if 2 != len(info.Clients) || "BACKEND01" != info.Clients[0].CommonName || "frontend" != info.Clients[1].CommonName {
+		t.Fatalf("common name 包含 END 时输出被截断: %+v", info.Clients)
+	}
+	if 1 != len(info.Routes) || 4 != info.Clients[1].ClientId {
+		t.Fatalf("status 解析错误: %+v", info)
+	}
+}
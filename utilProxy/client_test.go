@@ -0,0 +1,45 @@
+package utilProxy
+
+import (
+	"golang.org/x/net/http/httpproxy"
+	"net/url"
+	"testing"
+)
+
+func TestProxyEnvScheme(t *testing.T) {
+	env := NewProxyFromConfig(&httpproxy.Config{
+		HTTPProxy:  "http://http-proxy:3128",
+		HTTPSProxy: "http://https-proxy:3128",
+		NoProxy:    "internal.example",
+	})
+
+	cases := []struct {
+		addr   string
+		scheme string
+		want   string
+	}{
+		{"example.com:80", "", "http-proxy:3128"},
+		{"example.com:443", "", "https-proxy:3128"},
+		{"example.com:8443", "https", "https-proxy:3128"},
+		{"example.com:443", "http", "http-proxy:3128"},
+		{"internal.example:443", "https", ""},
+	}
+	for _, c := range cases {
+		p, err := env.ProxyFor(c.addr, c.scheme)
+		if nil != err {
+			t.Fatalf("%s %s: %+v", c.scheme, c.addr, err)
+		}
+		got := ""
+		if h, ok := p.(*ProxyHttp); ok {
+			got = h.Url().Host
+		}
+		if c.want != got {
+			t.Fatalf("%s %s 使用的代理为 %q, want %q", c.scheme, c.addr, got, c.want)
+		}
+	}
+
+	proxyUrl, err := env.ProxyUrl(&url.URL{Scheme: "https", Host: "example.com:8443"})
+	if nil != err || nil == proxyUrl || "https-proxy:3128" != proxyUrl.Host {
+		t.Fatalf("ProxyUrl = %v, %+v", proxyUrl, err)
+	}
+}
@@ -0,0 +1,314 @@
+package utilProxy
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/hilaoyu/go-utils/utilLogger"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 用户的转发连接池超过该时间未使用时回收
+const httpProxyTransportIdle = time.Duration(5) * time.Minute
+
+// HttpProxyServer HTTP 正向代理，支持 CONNECT(HTTPS 等任意 TCP)和普通 HTTP 请求转发，认证使用 Proxy-Authorization Basic
+type HttpProxyServer struct {
+	proxyServer
+	realm      string
+	transports map[string]*httpProxyTransport
+	lastSweep  time.Time
+}
+
+type httpProxyTransport struct {
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+func NewHttpProxyServer() *HttpProxyServer {
+	return &HttpProxyServer{
+		proxyServer: newProxyServer(),
+		realm:       "proxy",
+		transports:  map[string]*httpProxyTransport{},
+	}
+}
+
+// SetAuthenticator 为空时不需要认证
+func (s *HttpProxyServer) SetAuthenticator(auth ProxyAuthenticator) *HttpProxyServer {
+	s.auth = auth
+	return s
+}
+
+// SetAcl 为空时禁止访问内网地址
+func (s *HttpProxyServer) SetAcl(acl *ProxyAcl) *HttpProxyServer {
+	s.acl = acl
+	return s
+}
+
+// SetAclDisabled 没有设置 ACL 时不使用默认 ACL，目标不在本地解析，由上游代理限制
+func (s *HttpProxyServer) SetAclDisabled(disabled bool) *HttpProxyServer {
+	s.aclDisabled = disabled
+	return s
+}
+
+// SetUpstream 通过上游代理连接目标，如 NewProxySocks5 或 utilSsh.SshClient
+func (s *HttpProxyServer) SetUpstream(upstream UtilProxy) *HttpProxyServer {
+	s.upstream = upstream
+	return s
+}
+
+func (s *HttpProxyServer) SetDialTimeout(timeout time.Duration) *HttpProxyServer {
+	s.dialTimeout = timeout
+	return s
+}
+
+func (s *HttpProxyServer) SetLogger(logger *utilLogger.Logger) *HttpProxyServer {
+	s.logger = logger
+	return s
+}
+
+func (s *HttpProxyServer) SetRealm(realm string) *HttpProxyServer {
+	s.realm = realm
+	return s
+}
+
+func (s *HttpProxyServer) Traffic() *ProxyTraffic {
+	return s.traffic
+}
+
+func (s *HttpProxyServer) Addr() net.Addr {
+	return s.addr()
+}
+
+// ListenAndServe 阻塞直到 ctx 取消或 Close
+func (s *HttpProxyServer) ListenAndServe(ctx context.Context, addr string) (err error) {
+	listener, err := net.Listen("tcp", addr)
+	if nil != err {
+		return fmt.Errorf("http proxy listen %s error: %+v", addr, err)
+	}
+	return s.Serve(ctx, listener)
+}
+
+func (s *HttpProxyServer) Serve(ctx context.Context, listener net.Listener) (err error) {
+	if err = s.start(ctx, listener); nil != err {
+		return
+	}
+	defer close(s.done)
+	defer s.stop()
+	defer s.closeTransports()
+
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: time.Duration(30) * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return s.ctx
+		},
+	}
+	go func() {
+		<-s.ctx.Done()
+		_ = server.Close()
+	}()
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) || nil != s.ctx.Err() {
+		err = nil
+	}
+	return
+}
+
+// Close 停止监听并关闭所有连接，包括 CONNECT 隧道
+func (s *HttpProxyServer) Close() error {
+	s.stop()
+	s.wait()
+	return nil
+}
+
+func (s *HttpProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.proxyAuth(r)
+	if !ok {
+		w.Header().Set("Proxy-Authenticate", fmt.Sprintf("Basic realm=%q", s.realm))
+		http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+		return
+	}
+	if http.MethodConnect == r.Method {
+		s.handleConnect(w, r, user)
+		return
+	}
+	if !r.URL.IsAbs() || "" == r.URL.Host {
+		http.Error(w, "this is a proxy server", http.StatusBadRequest)
+		return
+	}
+	s.handleForward(w, r, user)
+}
+
+func (s *HttpProxyServer) proxyAuth(r *http.Request) (user string, ok bool) {
+	if nil == s.auth {
+		return "", true
+	}
+	value := r.Header.Get("Proxy-Authorization")
+	prefix := "Basic "
+	if len(value) <= len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+		return "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[len(prefix):]))
+	if nil != err {
+		return "", false
+	}
+	user, password, found := strings.Cut(string(decoded), ":")
+	if !found || !s.authenticate(r.Context(), user, password) {
+		return "", false
+	}
+	return user, true
+}
+
+func (s *HttpProxyServer) handleConnect(w http.ResponseWriter, r *http.Request, user string) {
+	target := r.Host
+	if _, _, err := net.SplitHostPort(target); nil != err {
+		target = net.JoinHostPort(target, "443")
+	}
+	remote, err := s.dial(r.Context(), user, target)
+	if nil != err {
+		s.logError("http proxy %s connect %s error: %+v", user, target, err)
+		http.Error(w, err.Error(), httpProxyErrorStatus(err))
+		return
+	}
+	if !s.track(remote) {
+		return
+	}
+	defer s.untrack(remote)
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if nil != err {
+		return
+	}
+	if !s.track(conn) {
+		return
+	}
+	defer s.untrack(conn)
+	if _, err = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); nil != err {
+		return
+	}
+	// 客户端可能在收到 200 之前就发送了数据
+	if n := buf.Reader.Buffered(); n > 0 {
+		data, _ := buf.Reader.Peek(n)
+		if _, err = remote.Write(data); nil != err {
+			return
+		}
+	}
+	proxyPipe(conn, remote)
+}
+
+var httpProxyHopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func httpProxyRemoveHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); "" != name {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range httpProxyHopHeaders {
+		header.Del(name)
+	}
+}
+
+func (s *HttpProxyServer) handleForward(w http.ResponseWriter, r *http.Request, user string) {
+	if "http" != r.URL.Scheme {
+		http.Error(w, "unsupported scheme "+r.URL.Scheme, http.StatusBadRequest)
+		return
+	}
+	// 连接池中的连接已经建立，ACL 需要在每个请求上检查
+	if acl := s.proxyAcl(); nil != acl {
+		target := r.URL.Host
+		if "" == r.URL.Port() {
+			target = net.JoinHostPort(r.URL.Hostname(), "80")
+		}
+		if _, err := acl.Resolve(r.Context(), target); nil != err {
+			http.Error(w, (&proxyAclError{err: err}).Error(), http.StatusForbidden)
+			return
+		}
+	}
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	httpProxyRemoveHopHeaders(out.Header)
+
+	transport := s.forwardTransport(user)
+	resp, err := transport.RoundTrip(out)
+	if nil != err {
+		s.logError("http proxy %s forward %s error: %+v", user, r.URL.Host, err)
+		http.Error(w, err.Error(), httpProxyErrorStatus(err))
+		return
+	}
+	defer resp.Body.Close()
+	httpProxyRemoveHopHeaders(resp.Header)
+	for k, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// forwardTransport 每个用户使用单独的连接池，复用的连接流量也记到该用户，长时间未使用的连接池会被回收
+func (s *HttpProxyServer) forwardTransport(user string) *http.Transport {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= httpProxyTransportIdle {
+		s.lastSweep = now
+		for name, t := range s.transports {
+			if now.Sub(t.lastUsed) >= httpProxyTransportIdle {
+				t.transport.CloseIdleConnections()
+				delete(s.transports, name)
+			}
+		}
+	}
+	t, ok := s.transports[user]
+	if !ok {
+		t = &httpProxyTransport{transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				return s.dial(ctx, user, addr)
+			},
+			MaxIdleConnsPerHost:   4,
+			IdleConnTimeout:       time.Duration(90) * time.Second,
+			ResponseHeaderTimeout: time.Duration(60) * time.Second,
+		}}
+		s.transports[user] = t
+	}
+	t.lastUsed = now
+	return t.transport
+}
+
+func (s *HttpProxyServer) closeTransports() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for user, t := range s.transports {
+		t.transport.CloseIdleConnections()
+		delete(s.transports, user)
+	}
+}
+
+func httpProxyErrorStatus(err error) int {
+	var aclErr *proxyAclError
+	if errors.As(err, &aclErr) {
+		return http.StatusForbidden
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
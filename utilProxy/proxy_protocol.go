@@ -0,0 +1,290 @@
+package utilProxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProxyProtocolV1 = 1
+	ProxyProtocolV2 = 2
+
+	proxyProtocolV1MaxLen = 107
+)
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolHeader PROXY protocol 头，Local 为 true 时(v2 LOCAL 或 v1 UNKNOWN)没有地址信息
+type ProxyProtocolHeader struct {
+	Version     int
+	Local       bool
+	Network     string
+	Source      netip.AddrPort
+	Destination netip.AddrPort
+}
+
+func NewProxyProtocolHeader(network string, source net.Addr, destination net.Addr) *ProxyProtocolHeader {
+	h := &ProxyProtocolHeader{Network: network}
+	src, ok1 := proxyAddrPort(source)
+	dst, ok2 := proxyAddrPort(destination)
+	if !ok1 || !ok2 || src.Addr().Is4() != dst.Addr().Is4() {
+		h.Local = true
+		return h
+	}
+	h.Source, h.Destination = src, dst
+	return h
+}
+
+func proxyAddrPort(addr net.Addr) (ap netip.AddrPort, ok bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ap = a.AddrPort()
+	case *net.UDPAddr:
+		ap = a.AddrPort()
+	default:
+		return
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), ap.Addr().IsValid()
+}
+
+func (h *ProxyProtocolHeader) SourceAddr() net.Addr {
+	if "udp" == h.Network {
+		return net.UDPAddrFromAddrPort(h.Source)
+	}
+	return net.TCPAddrFromAddrPort(h.Source)
+}
+
+func (h *ProxyProtocolHeader) DestinationAddr() net.Addr {
+	if "udp" == h.Network {
+		return net.UDPAddrFromAddrPort(h.Destination)
+	}
+	return net.TCPAddrFromAddrPort(h.Destination)
+}
+
+// Bytes 编码为指定版本，v1 只支持 TCP
+func (h *ProxyProtocolHeader) Bytes(version int) (b []byte, err error) {
+	switch version {
+	case ProxyProtocolV1:
+		if h.Local {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		if "tcp" != h.Network {
+			return nil, fmt.Errorf("proxy protocol v1 only supports tcp")
+		}
+		family := "TCP4"
+		if h.Source.Addr().Is6() {
+			family = "TCP6"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, h.Source.Addr().String(), h.Destination.Addr().String(),
+			h.Source.Port(), h.Destination.Port())), nil
+	case ProxyProtocolV2:
+		b = append([]byte{}, proxyProtocolV2Signature...)
+		if h.Local {
+			return append(b, 0x20, 0x00, 0x00, 0x00), nil
+		}
+		family := byte(0x10)
+		if h.Source.Addr().Is6() {
+			family = 0x20
+		}
+		if "udp" == h.Network {
+			family |= 0x02
+		} else {
+			family |= 0x01
+		}
+		src := h.Source.Addr().AsSlice()
+		dst := h.Destination.Addr().AsSlice()
+		b = append(b, 0x21, family)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(src)+len(dst)+4))
+		b = append(append(b, src...), dst...)
+		b = binary.BigEndian.AppendUint16(b, h.Source.Port())
+		b = binary.BigEndian.AppendUint16(b, h.Destination.Port())
+		return b, nil
+	}
+	return nil, fmt.Errorf("proxy protocol version %d not supported", version)
+}
+
+// ReadProxyProtocol 从 reader 读取 v1 或 v2 头，没有头时返回错误
+func ReadProxyProtocol(reader *bufio.Reader) (h *ProxyProtocolHeader, err error) {
+	peek, err := reader.Peek(len(proxyProtocolV2Signature))
+	if nil == err && bytes.Equal(peek, proxyProtocolV2Signature) {
+		return readProxyProtocolV2(reader)
+	}
+	peek, e := reader.Peek(6)
+	if nil != e {
+		return nil, fmt.Errorf("read proxy protocol error: %+v", e)
+	}
+	if "PROXY " != string(peek) {
+		return nil, fmt.Errorf("proxy protocol header not found")
+	}
+	return readProxyProtocolV1(reader)
+}
+
+func readProxyProtocolV1(reader *bufio.Reader) (h *ProxyProtocolHeader, err error) {
+	var line []byte
+	for len(line) < proxyProtocolV1MaxLen {
+		c, e := reader.ReadByte()
+		if nil != e {
+			return nil, fmt.Errorf("read proxy protocol v1 error: %+v", e)
+		}
+		line = append(line, c)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("proxy protocol v1 header too long")
+	}
+	fields := strings.Fields(string(line))
+	h = &ProxyProtocolHeader{Version: ProxyProtocolV1, Network: "tcp"}
+	if len(fields) >= 2 && "UNKNOWN" == fields[1] {
+		h.Local = true
+		return
+	}
+	if 6 != len(fields) || ("TCP4" != fields[1] && "TCP6" != fields[1]) {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header %q", strings.TrimSpace(string(line)))
+	}
+	src, e1 := netip.ParseAddr(fields[2])
+	dst, e2 := netip.ParseAddr(fields[3])
+	srcPort, e3 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, e4 := strconv.ParseUint(fields[5], 10, 16)
+	if nil != e1 || nil != e2 || nil != e3 || nil != e4 {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header %q", strings.TrimSpace(string(line)))
+	}
+	h.Source = netip.AddrPortFrom(src, uint16(srcPort))
+	h.Destination = netip.AddrPortFrom(dst, uint16(dstPort))
+	return
+}
+
+func readProxyProtocolV2(reader *bufio.Reader) (h *ProxyProtocolHeader, err error) {
+	head := make([]byte, 16)
+	if _, err = io.ReadFull(reader, head); nil != err {
+		return nil, fmt.Errorf("read proxy protocol v2 error: %+v", err)
+	}
+	if 0x20 != head[12]&0xf0 {
+		return nil, fmt.Errorf("proxy protocol v2 version %d not supported", head[12]>>4)
+	}
+	body := make([]byte, int(binary.BigEndian.Uint16(head[14:16])))
+	if _, err = io.ReadFull(reader, body); nil != err {
+		return nil, fmt.Errorf("read proxy protocol v2 error: %+v", err)
+	}
+	h = &ProxyProtocolHeader{Version: ProxyProtocolV2, Network: "tcp"}
+	if 0x00 == head[12]&0x0f {
+		h.Local = true
+		return
+	}
+	if 0x02 == head[13]&0x0f {
+		h.Network = "udp"
+	}
+	addrLen := 0
+	switch head[13] >> 4 {
+	case 0x1:
+		addrLen = 4
+	case 0x2:
+		addrLen = 16
+	default:
+		// UNIX 地址等不支持的地址族按 LOCAL 处理
+		h.Local = true
+		return
+	}
+	if len(body) < addrLen*2+4 {
+		return nil, fmt.Errorf("proxy protocol v2 address too short")
+	}
+	src, _ := netip.AddrFromSlice(body[:addrLen])
+	dst, _ := netip.AddrFromSlice(body[addrLen : addrLen*2])
+	h.Source = netip.AddrPortFrom(src, binary.BigEndian.Uint16(body[addrLen*2:]))
+	h.Destination = netip.AddrPortFrom(dst, binary.BigEndian.Uint16(body[addrLen*2+2:]))
+	return
+}
+
+// ProxyProtocolListener 接受带 PROXY protocol 头的连接，RemoteAddr 返回头中的源地址
+// 头在第一次 Read 或 RemoteAddr 时读取，超时或格式错误时连接的读取返回错误
+type ProxyProtocolListener struct {
+	net.Listener
+	// Optional 为 true 时允许没有头的连接
+	Optional      bool
+	HeaderTimeout time.Duration
+}
+
+func NewProxyProtocolListener(listener net.Listener) *ProxyProtocolListener {
+	return &ProxyProtocolListener{Listener: listener, HeaderTimeout: time.Duration(10) * time.Second}
+}
+
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if nil != err {
+		return nil, err
+	}
+	return &ProxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn), optional: l.Optional, timeout: l.HeaderTimeout}, nil
+}
+
+type ProxyProtocolConn struct {
+	net.Conn
+	reader   *bufio.Reader
+	optional bool
+	timeout  time.Duration
+	once     sync.Once
+	header   *ProxyProtocolHeader
+	err      error
+}
+
+func (c *ProxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		if c.optional {
+			peek, _ := c.reader.Peek(6)
+			if !bytes.HasPrefix(proxyProtocolV2Signature, peek) && "PROXY " != string(peek) {
+				return
+			}
+		}
+		c.header, c.err = ReadProxyProtocol(c.reader)
+	})
+}
+
+// Header 没有头或读取失败时返回 nil
+func (c *ProxyProtocolConn) Header() *ProxyProtocolHeader {
+	c.readHeader()
+	return c.header
+}
+
+func (c *ProxyProtocolConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if nil != c.err {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *ProxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if nil != c.header && !c.header.Local {
+		return c.header.SourceAddr()
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *ProxyProtocolConn) LocalAddr() net.Addr {
+	c.readHeader()
+	if nil != c.header && !c.header.Local {
+		return c.header.DestinationAddr()
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *ProxyProtocolConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
@@ -0,0 +1,141 @@
+package utilProxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocolEncodeParse(t *testing.T) {
+	tcp4 := NewProxyProtocolHeader("tcp", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}, &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 443})
+	tcp6 := NewProxyProtocolHeader("tcp", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}, &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443})
+	udp4 := NewProxyProtocolHeader("udp", &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 5353})
+	mixed := NewProxyProtocolHeader("tcp", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}, &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443})
+	mapped := NewProxyProtocolHeader("tcp", &net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 5000}, &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 443})
+
+	cases := []struct {
+		name    string
+		header  *ProxyProtocolHeader
+		version int
+		v1      string
+		err     bool
+	}{
+		{"tcp4 v1", tcp4, ProxyProtocolV1, "PROXY TCP4 192.0.2.1 198.51.100.1 5000 443\r\n", false},
+		{"tcp6 v1", tcp6, ProxyProtocolV1, "PROXY TCP6 2001:db8::1 2001:db8::2 5000 443\r\n", false},
+		{"local v1", mixed, ProxyProtocolV1, "PROXY UNKNOWN\r\n", false},
+		{"mapped v1", mapped, ProxyProtocolV1, "PROXY TCP4 192.0.2.1 198.51.100.1 5000 443\r\n", false},
+		{"udp v1", udp4, ProxyProtocolV1, "", true},
+		{"tcp4 v2", tcp4, ProxyProtocolV2, "", false},
+		{"tcp6 v2", tcp6, ProxyProtocolV2, "", false},
+		{"udp v2", udp4, ProxyProtocolV2, "", false},
+		{"local v2", mixed, ProxyProtocolV2, "", false},
+		{"v3", tcp4, 3, "", true},
+	}
+	for _, c := range cases {
+		b, err := c.header.Bytes(c.version)
+		if c.err {
+			if nil == err {
+				t.Fatalf("%s 应返回错误", c.name)
+			}
+			continue
+		}
+		if nil != err {
+			t.Fatalf("%s: %+v", c.name, err)
+		}
+		if "" != c.v1 && c.v1 != string(b) {
+			t.Fatalf("%s = %q, want %q", c.name, b, c.v1)
+		}
+
+		// 头后面的数据不应被读取
+		reader := bufio.NewReader(bytes.NewReader(append(b, "payload"...)))
+		parsed, err := ReadProxyProtocol(reader)
+		if nil != err {
+			t.Fatalf("%s parse: %+v", c.name, err)
+		}
+		if rest, _ := io.ReadAll(reader); "payload" != string(rest) {
+			t.Fatalf("%s rest = %q", c.name, rest)
+		}
+		if c.version != parsed.Version || c.header.Local != parsed.Local {
+			t.Fatalf("%s parsed = %+v", c.name, parsed)
+		}
+		if c.header.Local {
+			continue
+		}
+		if c.header.Network != parsed.Network || c.header.Source != parsed.Source || c.header.Destination != parsed.Destination {
+			t.Fatalf("%s parsed = %+v, want %+v", c.name, parsed, c.header)
+		}
+	}
+}
+
+func TestProxyProtocolParseInvalid(t *testing.T) {
+	cases := []string{
+		"GET / HTTP/1.1\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 5000\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 5000 99999\r\n",
+		"PROXY UDP4 192.0.2.1 198.51.100.1 5000 443\r\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+		string(proxyProtocolV2Signature) + "\x31\x11\x00\x00",
+		string(proxyProtocolV2Signature) + "\x21\x11\x00\x04\x00\x00\x00\x00",
+		"PRO",
+	}
+	for _, data := range cases {
+		if h, err := ReadProxyProtocol(bufio.NewReader(strings.NewReader(data))); nil == err {
+			t.Fatalf("%q 应返回错误: %+v", data, h)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatalf("listen: %+v", err)
+	}
+	ppListener := NewProxyProtocolListener(listener)
+	ppListener.Optional = true
+	defer ppListener.Close()
+
+	type accepted struct {
+		remote string
+		data   string
+	}
+	results := make(chan accepted, 2)
+	go func() {
+		for {
+			conn, e := ppListener.Accept()
+			if nil != e {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+				data, _ := io.ReadAll(conn)
+				results <- accepted{remote: conn.RemoteAddr().String(), data: string(data)}
+			}()
+		}
+	}()
+
+	header, _ := NewProxyProtocolHeader("tcp", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}, listener.Addr()).Bytes(ProxyProtocolV2)
+	for _, payload := range [][]byte{append(header, "hello"...), []byte("plain")} {
+		conn, e := net.Dial("tcp", listener.Addr().String())
+		if nil != e {
+			t.Fatalf("dial: %+v", e)
+		}
+		_, _ = conn.Write(payload)
+		_ = conn.Close()
+		result := <-results
+		if "hello" == result.data {
+			if "192.0.2.1:5000" != result.remote {
+				t.Fatalf("remote = %s", result.remote)
+			}
+			continue
+		}
+		if "plain" != result.data || netip.MustParseAddrPort(result.remote).Addr() != netip.MustParseAddr("127.0.0.1") {
+			t.Fatalf("没有头的连接 = %+v", result)
+		}
+	}
+}
@@ -0,0 +1,707 @@
+package utilProxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hilaoyu/go-utils/utilLogger"
+	"hash/fnv"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	RelayBalanceRoundRobin = "round-robin"
+	RelayBalanceLeastConn  = "least-conn"
+	// RelayBalanceHash 按客户端 IP 选择后端(rendezvous hash)，后端增减时只影响部分客户端
+	RelayBalanceHash = "hash"
+
+	// RelayUdpDefaultMaxSessions UDP 默认最多同时转发的客户端数量
+	RelayUdpDefaultMaxSessions = 4096
+	relayUdpMaxPending         = 64
+)
+
+type RelayBackendStats struct {
+	Addr        string    `json:"addr"`
+	Healthy     bool      `json:"healthy"`
+	Connections int64     `json:"connections"`
+	Active      int64     `json:"active"`
+	Failures    int64     `json:"failures"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastCheckAt time.Time `json:"last_check_at"`
+}
+
+type RelayStats struct {
+	Network     string              `json:"network"`
+	Listen      string              `json:"listen"`
+	Connections int64               `json:"connections"`
+	Active      int64               `json:"active"`
+	Rejected    int64               `json:"rejected"`
+	BytesIn     int64               `json:"bytes_in"`
+	BytesOut    int64               `json:"bytes_out"`
+	Backends    []RelayBackendStats `json:"backends"`
+}
+
+type relayBackend struct {
+	addr        string
+	healthy     atomic.Bool
+	connections atomic.Int64
+	active      atomic.Int64
+	failures    atomic.Int64
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+	lastError   atomic.Pointer[string]
+	lastCheckAt atomic.Int64
+}
+
+func (b *relayBackend) setError(err error) {
+	if nil == err {
+		b.lastError.Store(nil)
+		return
+	}
+	msg := err.Error()
+	b.lastError.Store(&msg)
+}
+
+// Relay 端口转发和负载均衡，TCP 连接或 UDP 会话(按客户端地址)转发到后端
+// BytesIn 为客户端发往后端的字节数，BytesOut 为后端返回的字节数
+type Relay struct {
+	network  string
+	listen   string
+	backends []*relayBackend
+	balance  string
+	dialer   UtilProxy
+	logger   *utilLogger.Logger
+
+	idleTimeout         time.Duration
+	dialTimeout         time.Duration
+	healthInterval      time.Duration
+	healthTimeout       time.Duration
+	healthCheck         func(ctx context.Context, addr string) error
+	proxyProtocolSend   int
+	proxyProtocolAccept bool
+	maxUdpSessions      int
+
+	rr          atomic.Uint64
+	connections atomic.Int64
+	active      atomic.Int64
+	rejected    atomic.Int64
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+
+	listener   net.Listener
+	packetConn net.PacketConn
+	ctx        context.Context
+	cancel     context.CancelFunc
+	conns      map[io.Closer]struct{}
+	locker     sync.Mutex
+	wg         sync.WaitGroup
+}
+
+// NewRelay network 为 tcp 或 udp，backends 为 host:port
+func NewRelay(network string, listen string, backends ...string) (relay *Relay, err error) {
+	if "tcp" != network && "udp" != network {
+		return nil, fmt.Errorf("relay network %s not supported", network)
+	}
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("relay backends can't be empty")
+	}
+	relay = &Relay{
+		network:        network,
+		listen:         listen,
+		balance:        RelayBalanceRoundRobin,
+		idleTimeout:    time.Duration(300) * time.Second,
+		dialTimeout:    time.Duration(10) * time.Second,
+		healthInterval: time.Duration(10) * time.Second,
+		healthTimeout:  time.Duration(3) * time.Second,
+		maxUdpSessions: RelayUdpDefaultMaxSessions,
+		conns:          map[io.Closer]struct{}{},
+	}
+	if "udp" == network {
+		relay.idleTimeout = time.Duration(60) * time.Second
+	}
+	for _, addr := range backends {
+		if _, _, err = net.SplitHostPort(addr); nil != err {
+			return nil, fmt.Errorf("relay backend %s error: %+v", addr, err)
+		}
+		backend := &relayBackend{addr: addr}
+		backend.healthy.Store(true)
+		relay.backends = append(relay.backends, backend)
+	}
+	return
+}
+
+func (r *Relay) SetBalance(balance string) *Relay {
+	r.balance = balance
+	return r
+}
+
+// SetDialer 通过代理连接后端，UDP 需要代理支持 udp 网络
+func (r *Relay) SetDialer(dialer UtilProxy) *Relay {
+	r.dialer = dialer
+	return r
+}
+
+func (r *Relay) SetLogger(logger *utilLogger.Logger) *Relay {
+	r.logger = logger
+	return r
+}
+
+// SetIdleTimeout TCP 连接两个方向都没有数据或 UDP 会话没有数据时关闭，<=0 不超时
+func (r *Relay) SetIdleTimeout(timeout time.Duration) *Relay {
+	r.idleTimeout = timeout
+	return r
+}
+
+func (r *Relay) SetDialTimeout(timeout time.Duration) *Relay {
+	r.dialTimeout = timeout
+	return r
+}
+
+// SetHealthCheck interval <=0 时关闭主动检查；check 为空时 TCP 检查能否建立连接，UDP 不检查
+func (r *Relay) SetHealthCheck(interval time.Duration, timeout time.Duration, check ...func(ctx context.Context, addr string) error) *Relay {
+	r.healthInterval = interval
+	r.healthTimeout = timeout
+	if len(check) > 0 {
+		r.healthCheck = check[0]
+	}
+	return r
+}
+
+// SetProxyProtocolSend 连接后端时发送 PROXY protocol 头，version 为 0 不发送，UDP 只支持 v2
+func (r *Relay) SetProxyProtocolSend(version int) *Relay {
+	r.proxyProtocolSend = version
+	return r
+}
+
+// SetProxyProtocolAccept 监听端要求客户端(如前面的负载均衡)发送 PROXY protocol 头，只支持 TCP
+func (r *Relay) SetProxyProtocolAccept(accept bool) *Relay {
+	r.proxyProtocolAccept = accept
+	return r
+}
+
+// SetMaxUdpSessions UDP 最多同时转发的客户端数量，超过时新客户端的数据包被丢弃并计入 Rejected，<=0 不限制
+func (r *Relay) SetMaxUdpSessions(max int) *Relay {
+	r.maxUdpSessions = max
+	return r
+}
+
+func (r *Relay) Addr() net.Addr {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if nil != r.listener {
+		return r.listener.Addr()
+	}
+	if nil != r.packetConn {
+		return r.packetConn.LocalAddr()
+	}
+	return nil
+}
+
+// Start 开始监听，ctx 取消或 Close 时停止
+func (r *Relay) Start(ctx context.Context) (err error) {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	if "udp" == r.network && ProxyProtocolV1 == r.proxyProtocolSend {
+		return fmt.Errorf("proxy protocol v1 doesn't support udp")
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if nil != r.ctx {
+		return fmt.Errorf("relay already started")
+	}
+	if "tcp" == r.network {
+		var listener net.Listener
+		if listener, err = net.Listen("tcp", r.listen); nil != err {
+			return fmt.Errorf("relay listen %s error: %+v", r.listen, err)
+		}
+		if r.proxyProtocolAccept {
+			listener = NewProxyProtocolListener(listener)
+		}
+		r.listener = listener
+	} else {
+		if r.packetConn, err = net.ListenPacket("udp", r.listen); nil != err {
+			return fmt.Errorf("relay listen %s error: %+v", r.listen, err)
+		}
+	}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+
+	r.wg.Add(2)
+	go func() {
+		defer r.wg.Done()
+		<-r.ctx.Done()
+		r.locker.Lock()
+		if nil != r.listener {
+			_ = r.listener.Close()
+		}
+		if nil != r.packetConn {
+			_ = r.packetConn.Close()
+		}
+		for conn := range r.conns {
+			_ = conn.Close()
+		}
+		r.locker.Unlock()
+	}()
+	go func() {
+		defer r.wg.Done()
+		r.healthLoop()
+	}()
+	if "tcp" == r.network {
+		r.wg.Add(1)
+		go r.serveTcp(r.listener)
+	} else {
+		r.wg.Add(1)
+		go r.serveUdp(r.packetConn)
+	}
+	return
+}
+
+// Close 停止监听并关闭所有连接
+func (r *Relay) Close() error {
+	r.locker.Lock()
+	cancel := r.cancel
+	r.locker.Unlock()
+	if nil != cancel {
+		cancel()
+	}
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Relay) Stats() (stats RelayStats) {
+	stats = RelayStats{
+		Network:     r.network,
+		Listen:      r.listen,
+		Connections: r.connections.Load(),
+		Active:      r.active.Load(),
+		Rejected:    r.rejected.Load(),
+		BytesIn:     r.bytesIn.Load(),
+		BytesOut:    r.bytesOut.Load(),
+	}
+	if addr := r.Addr(); nil != addr {
+		stats.Listen = addr.String()
+	}
+	for _, b := range r.backends {
+		bs := RelayBackendStats{
+			Addr:        b.addr,
+			Healthy:     b.healthy.Load(),
+			Connections: b.connections.Load(),
+			Active:      b.active.Load(),
+			Failures:    b.failures.Load(),
+			BytesIn:     b.bytesIn.Load(),
+			BytesOut:    b.bytesOut.Load(),
+		}
+		if msg := b.lastError.Load(); nil != msg {
+			bs.LastError = *msg
+		}
+		if at := b.lastCheckAt.Load(); at > 0 {
+			bs.LastCheckAt = time.Unix(0, at)
+		}
+		stats.Backends = append(stats.Backends, bs)
+	}
+	return
+}
+
+func (r *Relay) track(conn io.Closer) bool {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if nil != r.ctx.Err() {
+		_ = conn.Close()
+		return false
+	}
+	r.conns[conn] = struct{}{}
+	return true
+}
+
+func (r *Relay) untrack(conn io.Closer) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	_ = conn.Close()
+	delete(r.conns, conn)
+}
+
+func (r *Relay) logError(format string, a ...any) {
+	if nil == r.logger {
+		return
+	}
+	r.logger.ErrorF(format, a...)
+}
+
+// candidates 按负载均衡策略排序的健康后端，全部不健康时使用所有后端
+func (r *Relay) candidates(client net.Addr) (backends []*relayBackend) {
+	for _, b := range r.backends {
+		if b.healthy.Load() {
+			backends = append(backends, b)
+		}
+	}
+	if len(backends) == 0 {
+		backends = append(backends, r.backends...)
+	}
+	switch r.balance {
+	case RelayBalanceLeastConn:
+		sort.SliceStable(backends, func(i, j int) bool {
+			return backends[i].active.Load() < backends[j].active.Load()
+		})
+	case RelayBalanceHash:
+		key := ""
+		if nil != client {
+			key = client.String()
+			if host, _, err := net.SplitHostPort(key); nil == err {
+				key = host
+			}
+		}
+		scores := make(map[*relayBackend]uint64, len(backends))
+		for _, b := range backends {
+			h := fnv.New64a()
+			_, _ = h.Write([]byte(key + "|" + b.addr))
+			scores[b] = h.Sum64()
+		}
+		sort.SliceStable(backends, func(i, j int) bool {
+			return scores[backends[i]] > scores[backends[j]]
+		})
+	default:
+		start := int(r.rr.Add(1)-1) % len(backends)
+		backends = append(backends[start:], backends[:start]...)
+	}
+	return
+}
+
+func (r *Relay) dial(ctx context.Context, network string, addr string) (conn net.Conn, err error) {
+	if r.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.dialTimeout)
+		defer cancel()
+	}
+	return proxyDialForward(ctx, r.dialer, network, addr)
+}
+
+// connect 依次尝试候选后端，连接失败的后端标记为不健康，等待健康检查恢复
+func (r *Relay) connect(network string, client net.Addr) (backend *relayBackend, conn net.Conn, err error) {
+	for _, backend = range r.candidates(client) {
+		conn, err = r.dial(r.ctx, network, backend.addr)
+		if nil == err {
+			return
+		}
+		backend.failures.Add(1)
+		backend.setError(err)
+		if r.healthInterval > 0 {
+			backend.healthy.Store(false)
+		}
+		r.logError("relay %s dial backend %s error: %+v", r.listen, backend.addr, err)
+		if nil != r.ctx.Err() {
+			break
+		}
+	}
+	return nil, nil, fmt.Errorf("relay %s: no backend available: %+v", r.listen, err)
+}
+
+func (r *Relay) healthLoop() {
+	if r.healthInterval <= 0 {
+		return
+	}
+	check := r.healthCheck
+	if nil == check {
+		if "udp" == r.network {
+			return
+		}
+		check = func(ctx context.Context, addr string) error {
+			conn, err := r.dial(ctx, "tcp", addr)
+			if nil != err {
+				return err
+			}
+			return conn.Close()
+		}
+	}
+	ticker := time.NewTicker(r.healthInterval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, b := range r.backends {
+			wg.Add(1)
+			go func(b *relayBackend) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(r.ctx, r.healthTimeout)
+				defer cancel()
+				err := check(ctx, b.addr)
+				b.lastCheckAt.Store(time.Now().UnixNano())
+				if nil != r.ctx.Err() {
+					return
+				}
+				if nil != err {
+					b.setError(err)
+					if b.healthy.Swap(false) {
+						r.logError("relay %s backend %s down: %+v", r.listen, b.addr, err)
+					}
+					return
+				}
+				b.healthy.Store(true)
+			}(b)
+		}
+		wg.Wait()
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Relay) serveTcp(listener net.Listener) {
+	defer r.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if nil != err {
+			if nil != r.ctx.Err() {
+				return
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(time.Duration(50) * time.Millisecond)
+				continue
+			}
+			r.logError("relay %s accept error: %+v", r.listen, err)
+			return
+		}
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.handleTcp(conn)
+		}()
+	}
+}
+
+func (r *Relay) handleTcp(conn net.Conn) {
+	if !r.track(conn) {
+		return
+	}
+	defer r.untrack(conn)
+	if ppConn, ok := conn.(*ProxyProtocolConn); ok && nil == ppConn.Header() {
+		r.rejected.Add(1)
+		return
+	}
+
+	backend, remote, err := r.connect("tcp", conn.RemoteAddr())
+	if nil != err {
+		r.rejected.Add(1)
+		return
+	}
+	if !r.track(remote) {
+		return
+	}
+	defer r.untrack(remote)
+
+	r.connections.Add(1)
+	r.active.Add(1)
+	defer r.active.Add(-1)
+	backend.connections.Add(1)
+	backend.active.Add(1)
+	defer backend.active.Add(-1)
+
+	if 0 != r.proxyProtocolSend {
+		header, e := NewProxyProtocolHeader("tcp", conn.RemoteAddr(), conn.LocalAddr()).Bytes(r.proxyProtocolSend)
+		if nil == e {
+			_, e = remote.Write(header)
+		}
+		if nil != e {
+			r.logError("relay %s send proxy protocol error: %+v", r.listen, e)
+			return
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.copyIdle(remote, conn, conn, remote, &r.bytesIn, &backend.bytesIn)
+	}()
+	go func() {
+		defer wg.Done()
+		r.copyIdle(conn, remote, conn, remote, &r.bytesOut, &backend.bytesOut)
+	}()
+	wg.Wait()
+}
+
+// copyIdle 任一方向有数据时刷新两个连接的读超时
+func (r *Relay) copyIdle(dst net.Conn, src net.Conn, a net.Conn, b net.Conn, total *atomic.Int64, backendTotal *atomic.Int64) {
+	buf := make([]byte, 32*1024)
+	for {
+		if r.idleTimeout > 0 {
+			deadline := time.Now().Add(r.idleTimeout)
+			_ = a.SetReadDeadline(deadline)
+			_ = b.SetReadDeadline(deadline)
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, e := dst.Write(buf[:n]); nil != e {
+				_ = src.Close()
+				return
+			}
+			total.Add(int64(n))
+			backendTotal.Add(int64(n))
+		}
+		if nil != err {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				_ = a.Close()
+				_ = b.Close()
+				return
+			}
+			if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+				_ = cw.CloseWrite()
+			} else {
+				_ = dst.Close()
+			}
+			return
+		}
+	}
+}
+
+// relayUdpSession 后端连接建立之前收到的数据包暂存在 pending 中，超过 relayUdpMaxPending 个时丢弃
+type relayUdpSession struct {
+	client   net.Addr
+	backend  *relayBackend
+	conn     net.Conn
+	header   []byte
+	pending  [][]byte
+	lastSeen atomic.Int64
+	locker   sync.Mutex
+}
+
+// write 发送到后端，连接还没有建立时暂存
+func (s *relayUdpSession) write(r *Relay, packet []byte) {
+	s.lastSeen.Store(time.Now().UnixNano())
+	s.locker.Lock()
+	if nil == s.conn {
+		if len(s.pending) < relayUdpMaxPending {
+			s.pending = append(s.pending, append([]byte{}, packet...))
+		}
+		s.locker.Unlock()
+		return
+	}
+	s.locker.Unlock()
+	s.send(r, packet)
+}
+
+// ready 连接建立后发送暂存的数据包
+func (s *relayUdpSession) ready(r *Relay, backend *relayBackend, conn net.Conn) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.backend, s.conn = backend, conn
+	for _, packet := range s.pending {
+		s.send(r, packet)
+	}
+	s.pending = nil
+}
+
+func (s *relayUdpSession) send(r *Relay, packet []byte) {
+	n := len(packet)
+	if nil != s.header {
+		packet = append(append([]byte{}, s.header...), packet...)
+	}
+	if _, err := s.conn.Write(packet); nil == err {
+		r.bytesIn.Add(int64(n))
+		s.backend.bytesIn.Add(int64(n))
+	}
+}
+
+func (r *Relay) serveUdp(packetConn net.PacketConn) {
+	defer r.wg.Done()
+	sessions := map[string]*relayUdpSession{}
+	var locker sync.Mutex
+	remove := func(key string, s *relayUdpSession) {
+		locker.Lock()
+		if sessions[key] == s {
+			delete(sessions, key)
+		}
+		locker.Unlock()
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, client, err := packetConn.ReadFrom(buf)
+		if nil != err {
+			if nil == r.ctx.Err() {
+				r.logError("relay %s read error: %+v", r.listen, err)
+			}
+			return
+		}
+		key := client.String()
+		locker.Lock()
+		session := sessions[key]
+		if nil == session {
+			if r.maxUdpSessions > 0 && len(sessions) >= r.maxUdpSessions {
+				locker.Unlock()
+				r.rejected.Add(1)
+				continue
+			}
+			session = &relayUdpSession{client: client}
+			if ProxyProtocolV2 == r.proxyProtocolSend {
+				session.header, _ = NewProxyProtocolHeader("udp", client, packetConn.LocalAddr()).Bytes(ProxyProtocolV2)
+			}
+			sessions[key] = session
+			// 在单独的协程中连接后端，不阻塞其它客户端的数据包
+			r.wg.Add(1)
+			go func() {
+				defer r.wg.Done()
+				defer remove(key, session)
+				r.udpSession(packetConn, session)
+			}()
+		}
+		locker.Unlock()
+		session.write(r, buf[:n])
+	}
+}
+
+// udpSession 连接后端并转发响应，直到会话空闲超时
+func (r *Relay) udpSession(packetConn net.PacketConn, session *relayUdpSession) {
+	backend, conn, err := r.connect("udp", session.client)
+	if nil != err {
+		r.rejected.Add(1)
+		return
+	}
+	if !r.track(conn) {
+		return
+	}
+	defer r.untrack(conn)
+	r.connections.Add(1)
+	r.active.Add(1)
+	backend.connections.Add(1)
+	backend.active.Add(1)
+	defer func() {
+		r.active.Add(-1)
+		backend.active.Add(-1)
+	}()
+	session.ready(r, backend, conn)
+	r.udpReplies(packetConn, session)
+}
+
+// udpReplies 把后端的响应发回客户端，会话空闲超时后结束
+func (r *Relay) udpReplies(packetConn net.PacketConn, session *relayUdpSession) {
+	buf := make([]byte, 65535)
+	for {
+		if r.idleTimeout > 0 {
+			_ = session.conn.SetReadDeadline(time.Now().Add(r.idleTimeout))
+		}
+		n, err := session.conn.Read(buf)
+		if nil != err {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && nil == r.ctx.Err() &&
+				time.Since(time.Unix(0, session.lastSeen.Load())) < r.idleTimeout {
+				// 客户端仍在发送数据
+				continue
+			}
+			return
+		}
+		if _, err = packetConn.WriteTo(buf[:n], session.client); nil == err {
+			r.bytesOut.Add(int64(n))
+			session.backend.bytesOut.Add(int64(n))
+		}
+	}
+}
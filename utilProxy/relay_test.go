@@ -0,0 +1,248 @@
+package utilProxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestUdpEcho 回显收到的数据，prefix 不为空时在回显前加上
+func startTestUdpEcho(t *testing.T, prefix string) *net.UDPConn {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Fatalf("listen udp: %+v", err)
+	}
+	t.Cleanup(func() { _ = echo.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, e := echo.ReadFromUDP(buf)
+			if nil != e {
+				return
+			}
+			_, _ = echo.WriteToUDP(append([]byte(prefix), buf[:n]...), from)
+		}
+	}()
+	return echo
+}
+
+func startTestRelay(t *testing.T, relay *Relay) string {
+	if err := relay.Start(context.Background()); nil != err {
+		t.Fatalf("start: %+v", err)
+	}
+	t.Cleanup(func() { _ = relay.Close() })
+	return relay.Addr().String()
+}
+
+func udpRoundTrip(t *testing.T, conn net.Conn, data string) string {
+	if _, err := conn.Write([]byte(data)); nil != err {
+		t.Fatalf("write: %+v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if nil != err {
+		t.Fatalf("read: %+v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestRelayUdpLoopback(t *testing.T) {
+	echo := startTestUdpEcho(t, "")
+	relay, err := NewRelay("udp", "127.0.0.1:0", echo.LocalAddr().String())
+	if nil != err {
+		t.Fatalf("relay: %+v", err)
+	}
+	addr := startTestRelay(t, relay)
+
+	for _, name := range []string{"a", "b"} {
+		conn, e := net.Dial("udp", addr)
+		if nil != e {
+			t.Fatalf("dial: %+v", e)
+		}
+		defer conn.Close()
+		for i := 0; i < 3; i++ {
+			if got := udpRoundTrip(t, conn, name); name != got {
+				t.Fatalf("reply = %q", got)
+			}
+		}
+	}
+	stats := relay.Stats()
+	if 2 != stats.Connections || 6 != stats.BytesIn || 6 != stats.BytesOut || 2 != stats.Backends[0].Connections {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestRelayUdpProxyProtocolV2(t *testing.T) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Fatalf("listen udp: %+v", err)
+	}
+	defer backend.Close()
+	relay, _ := NewRelay("udp", "127.0.0.1:0", backend.LocalAddr().String())
+	addr := startTestRelay(t, relay.SetProxyProtocolSend(ProxyProtocolV2))
+
+	conn, err := net.Dial("udp", addr)
+	if nil != err {
+		t.Fatalf("dial: %+v", err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("ping"))
+	_ = backend.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 65535)
+	n, _, err := backend.ReadFromUDP(buf)
+	if nil != err {
+		t.Fatalf("read: %+v", err)
+	}
+	reader := bufio.NewReader(bytes.NewReader(buf[:n]))
+	header, err := ReadProxyProtocol(reader)
+	if nil != err || "udp" != header.Network || conn.LocalAddr().String() != header.SourceAddr().String() {
+		t.Fatalf("header = %+v %+v", header, err)
+	}
+	if rest, _ := io.ReadAll(reader); "ping" != string(rest) {
+		t.Fatalf("payload = %q", rest)
+	}
+}
+
+func TestRelayTcpProxyProtocol(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatalf("listen: %+v", err)
+	}
+	ppBackend := NewProxyProtocolListener(backend)
+	defer ppBackend.Close()
+	go func() {
+		for {
+			conn, e := ppBackend.Accept()
+			if nil != e {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.WriteString(conn, conn.RemoteAddr().String()+"\n")
+			}()
+		}
+	}()
+
+	for _, version := range []int{ProxyProtocolV1, ProxyProtocolV2} {
+		relay, _ := NewRelay("tcp", "127.0.0.1:0", backend.Addr().String())
+		addr := startTestRelay(t, relay.SetProxyProtocolSend(version))
+		conn, e := net.Dial("tcp", addr)
+		if nil != e {
+			t.Fatalf("dial: %+v", e)
+		}
+		_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+		line, e := bufio.NewReader(conn).ReadString('\n')
+		_ = conn.Close()
+		if nil != e || conn.LocalAddr().String()+"\n" != line {
+			t.Fatalf("v%d 后端看到的客户端地址 = %q %+v, want %s", version, line, e, conn.LocalAddr().String())
+		}
+	}
+}
+
+// testGateDialer 第一次连接等待 gate 关闭后才返回
+type testGateDialer struct {
+	gate  chan struct{}
+	calls atomic.Int32
+}
+
+func (d *testGateDialer) NewConn(network string, addr string, timeout ...time.Duration) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+func (d *testGateDialer) Dial(network string, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+func (d *testGateDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if 1 == d.calls.Add(1) {
+		select {
+		case <-d.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return (&net.Dialer{}).DialContext(ctx, network, addr)
+}
+
+func TestRelayUdpSlowDialDoesNotBlockOtherClients(t *testing.T) {
+	echo := startTestUdpEcho(t, "")
+	dialer := &testGateDialer{gate: make(chan struct{})}
+	relay, _ := NewRelay("udp", "127.0.0.1:0", echo.LocalAddr().String())
+	addr := startTestRelay(t, relay.SetDialer(dialer))
+
+	slow, err := net.Dial("udp", addr)
+	if nil != err {
+		t.Fatalf("dial: %+v", err)
+	}
+	defer slow.Close()
+	for _, data := range []string{"1", "2", "3"} {
+		_, _ = slow.Write([]byte(data))
+	}
+	for dialer.calls.Load() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 第一个客户端的后端连接还没有建立，其它客户端不受影响
+	fast, err := net.Dial("udp", addr)
+	if nil != err {
+		t.Fatalf("dial: %+v", err)
+	}
+	defer fast.Close()
+	if got := udpRoundTrip(t, fast, "fast"); "fast" != got {
+		t.Fatalf("reply = %q", got)
+	}
+
+	// 连接建立后发送暂存的数据包
+	close(dialer.gate)
+	buf := make([]byte, 64)
+	var got []string
+	for i := 0; i < 3; i++ {
+		_ = slow.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, e := slow.Read(buf)
+		if nil != e {
+			t.Fatalf("read: %+v", e)
+		}
+		got = append(got, string(buf[:n]))
+	}
+	if "1,2,3" != strings.Join(got, ",") {
+		t.Fatalf("暂存的数据包 = %v", got)
+	}
+}
+
+func TestRelayUdpMaxSessions(t *testing.T) {
+	echo := startTestUdpEcho(t, "")
+	relay, _ := NewRelay("udp", "127.0.0.1:0", echo.LocalAddr().String())
+	addr := startTestRelay(t, relay.SetMaxUdpSessions(1).SetIdleTimeout(200*time.Millisecond))
+
+	first, _ := net.Dial("udp", addr)
+	defer first.Close()
+	if got := udpRoundTrip(t, first, "first"); "first" != got {
+		t.Fatalf("reply = %q", got)
+	}
+
+	second, _ := net.Dial("udp", addr)
+	defer second.Close()
+	_, _ = second.Write([]byte("second"))
+	_ = second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := second.Read(make([]byte, 64)); nil == err {
+		t.Fatalf("超过最大会话数时不应转发")
+	}
+	if 1 != relay.Stats().Rejected {
+		t.Fatalf("rejected = %d", relay.Stats().Rejected)
+	}
+
+	// 第一个会话空闲超时后可以建立新会话
+	deadline := time.Now().Add(3 * time.Second)
+	for 0 != relay.Stats().Active && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := udpRoundTrip(t, second, "second"); "second" != got {
+		t.Fatalf("reply = %q", got)
+	}
+}
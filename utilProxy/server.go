@@ -0,0 +1,465 @@
+package utilProxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/hilaoyu/go-utils/utilLogger"
+	"github.com/hilaoyu/go-utils/utilNetwork"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProxyAuthenticator 校验用户名密码，返回 false 时拒绝
+type ProxyAuthenticator func(ctx context.Context, user string, password string) bool
+
+// NewStaticAuthenticator 使用固定的用户名密码表
+func NewStaticAuthenticator(users map[string]string) ProxyAuthenticator {
+	return func(ctx context.Context, user string, password string) bool {
+		expected, ok := users[user]
+		if !ok {
+			return false
+		}
+		return 1 == subtle.ConstantTimeCompare([]byte(expected), []byte(password))
+	}
+}
+
+// ProxyAcl 按目标地址和端口控制访问，deny 优先；allow 为空时允许所有地址，allowPorts 为空时允许所有端口
+// 回环、内网、链路本地等地址默认禁止，需要在 allow 中明确列出或调用 AllowPrivate
+// 目标为域名时先解析，所有解析结果都通过检查才允许，并直接连接检查过的地址，避免 DNS 重绑定
+type ProxyAcl struct {
+	allow        *utilNetwork.PrefixSet
+	deny         *utilNetwork.PrefixSet
+	allowPorts   map[int]bool
+	denyPorts    map[int]bool
+	allowPrivate bool
+	resolver     *net.Resolver
+	locker       sync.RWMutex
+}
+
+var proxyPrivatePrefixes = []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4", "::/128", "::1/128", "64:ff9b::/96", "64:ff9b:1::/48", "fc00::/7", "fe80::/10", "ff00::/8"}
+
+var proxyPrivateSet, _ = utilNetwork.NewPrefixSet(proxyPrivatePrefixes...)
+
+// proxyDefaultAcl 没有设置 ACL 时使用，只禁止内网地址
+var proxyDefaultAcl = NewProxyAcl()
+
+func NewProxyAcl() *ProxyAcl {
+	return &ProxyAcl{
+		allow:      &utilNetwork.PrefixSet{},
+		deny:       &utilNetwork.PrefixSet{},
+		allowPorts: map[int]bool{},
+		denyPorts:  map[int]bool{},
+		resolver:   net.DefaultResolver,
+	}
+}
+
+// Allow cidrs 支持单个地址、CIDR 和 a-b 范围
+func (a *ProxyAcl) Allow(cidrs ...string) (err error) {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	return a.allow.Add(cidrs...)
+}
+
+func (a *ProxyAcl) Deny(cidrs ...string) (err error) {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	return a.deny.Add(cidrs...)
+}
+
+// AllowPorts ports 格式如 "80,443,8000-8100"
+func (a *ProxyAcl) AllowPorts(ports string) (err error) {
+	return a.addPorts(a.allowPorts, ports)
+}
+
+func (a *ProxyAcl) DenyPorts(ports string) (err error) {
+	return a.addPorts(a.denyPorts, ports)
+}
+
+func (a *ProxyAcl) addPorts(m map[int]bool, ports string) (err error) {
+	list, err := utilNetwork.ParsePorts(ports)
+	if nil != err {
+		return
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	for _, port := range list {
+		m[port] = true
+	}
+	return
+}
+
+// DenyPrivate 禁止访问回环、内网、链路本地等地址，allow 中列出的内网地址也会被禁止
+func (a *ProxyAcl) DenyPrivate() *ProxyAcl {
+	_ = a.Deny(proxyPrivatePrefixes...)
+	return a
+}
+
+// AllowPrivate 允许访问未被 deny 的内网地址，用于内网代理
+func (a *ProxyAcl) AllowPrivate(allow bool) *ProxyAcl {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	a.allowPrivate = allow
+	return a
+}
+
+func (a *ProxyAcl) SetResolver(resolver *net.Resolver) *ProxyAcl {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	a.resolver = resolver
+	return a
+}
+
+// CheckAddr 检查单个地址
+func (a *ProxyAcl) CheckAddr(addr netip.Addr, port int) (err error) {
+	a.locker.RLock()
+	defer a.locker.RUnlock()
+	return a.check(addr.Unmap(), port)
+}
+
+func (a *ProxyAcl) check(addr netip.Addr, port int) (err error) {
+	if a.denyPorts[port] || (len(a.allowPorts) > 0 && !a.allowPorts[port]) {
+		return fmt.Errorf("port %d not allowed", port)
+	}
+	if a.deny.Contains(addr) || (!a.allow.IsEmpty() && !a.allow.Contains(addr)) {
+		return fmt.Errorf("address %s not allowed", addr.String())
+	}
+	if !a.allowPrivate && proxyPrivateSet.Contains(addr) && !a.allow.Contains(addr) {
+		return fmt.Errorf("private address %s not allowed", addr.String())
+	}
+	return
+}
+
+// Resolve 检查目标 host:port，返回应该连接的地址
+func (a *ProxyAcl) Resolve(ctx context.Context, target string) (dialAddr string, err error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if nil != err {
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if nil != err || port < 0 || port > 65535 {
+		return "", fmt.Errorf("invalid port %s", portStr)
+	}
+	if addr, e := netip.ParseAddr(host); nil == e {
+		if err = a.CheckAddr(addr, port); nil != err {
+			return
+		}
+		return target, nil
+	}
+
+	a.locker.RLock()
+	resolver := a.resolver
+	a.locker.RUnlock()
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if nil != err {
+		return "", fmt.Errorf("resolve %s error: %+v", host, err)
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("resolve %s: no address", host)
+	}
+	a.locker.RLock()
+	defer a.locker.RUnlock()
+	for _, addr := range addrs {
+		if err = a.check(addr.Unmap(), port); nil != err {
+			return "", fmt.Errorf("%s: %+v", host, err)
+		}
+	}
+	return net.JoinHostPort(addrs[0].Unmap().String(), portStr), nil
+}
+
+// ProxyUserTraffic 用户流量统计，Upload 为客户端发往目标的字节数
+type ProxyUserTraffic struct {
+	User              string `json:"user"`
+	Upload            int64  `json:"upload"`
+	Download          int64  `json:"download"`
+	Connections       int64  `json:"connections"`
+	ActiveConnections int64  `json:"active_connections"`
+}
+
+type proxyUserCounter struct {
+	upload      atomic.Int64
+	download    atomic.Int64
+	connections atomic.Int64
+	active      atomic.Int64
+}
+
+// ProxyTraffic 按用户统计流量，未认证的连接记在空用户名下
+type ProxyTraffic struct {
+	users  map[string]*proxyUserCounter
+	locker sync.Mutex
+}
+
+func NewProxyTraffic() *ProxyTraffic {
+	return &ProxyTraffic{users: map[string]*proxyUserCounter{}}
+}
+
+func (t *ProxyTraffic) counter(user string) *proxyUserCounter {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	c, ok := t.users[user]
+	if !ok {
+		c = &proxyUserCounter{}
+		t.users[user] = c
+	}
+	return c
+}
+
+func (t *ProxyTraffic) Get(user string) (traffic ProxyUserTraffic) {
+	t.locker.Lock()
+	c, ok := t.users[user]
+	t.locker.Unlock()
+	traffic.User = user
+	if !ok {
+		return
+	}
+	traffic.Upload = c.upload.Load()
+	traffic.Download = c.download.Load()
+	traffic.Connections = c.connections.Load()
+	traffic.ActiveConnections = c.active.Load()
+	return
+}
+
+func (t *ProxyTraffic) All() (all []ProxyUserTraffic) {
+	t.locker.Lock()
+	users := make([]string, 0, len(t.users))
+	for user := range t.users {
+		users = append(users, user)
+	}
+	t.locker.Unlock()
+	sort.Strings(users)
+	for _, user := range users {
+		all = append(all, t.Get(user))
+	}
+	return
+}
+
+// Reset 返回并清零用户的流量计数，用于定期上报
+func (t *ProxyTraffic) Reset(user string) (traffic ProxyUserTraffic) {
+	t.locker.Lock()
+	c, ok := t.users[user]
+	t.locker.Unlock()
+	traffic.User = user
+	if !ok {
+		return
+	}
+	traffic.Upload = c.upload.Swap(0)
+	traffic.Download = c.download.Swap(0)
+	traffic.Connections = c.connections.Swap(0)
+	traffic.ActiveConnections = c.active.Load()
+	return
+}
+
+// proxyCountConn 统计经过连接的字节，读为下载，写为上传
+type proxyCountConn struct {
+	net.Conn
+	counter   *proxyUserCounter
+	closeOnce sync.Once
+}
+
+func newProxyCountConn(conn net.Conn, counter *proxyUserCounter) *proxyCountConn {
+	counter.connections.Add(1)
+	counter.active.Add(1)
+	return &proxyCountConn{Conn: conn, counter: counter}
+}
+
+func (c *proxyCountConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.counter.download.Add(int64(n))
+	return
+}
+
+func (c *proxyCountConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	c.counter.upload.Add(int64(n))
+	return
+}
+
+func (c *proxyCountConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+func (c *proxyCountConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.counter.active.Add(-1)
+	})
+	return c.Conn.Close()
+}
+
+// proxyServer SOCKS5 与 HTTP 代理服务共用的认证、ACL、上游和连接管理
+type proxyServer struct {
+	auth        ProxyAuthenticator
+	acl         *ProxyAcl
+	aclDisabled bool
+	upstream    UtilProxy
+	traffic     *ProxyTraffic
+	dialTimeout time.Duration
+	logger      *utilLogger.Logger
+
+	listener net.Listener
+	ctx      context.Context
+	cancel   context.CancelFunc
+	conns    map[io.Closer]struct{}
+	locker   sync.Mutex
+	done     chan struct{}
+}
+
+func newProxyServer() proxyServer {
+	return proxyServer{
+		traffic:     NewProxyTraffic(),
+		dialTimeout: time.Duration(10) * time.Second,
+		conns:       map[io.Closer]struct{}{},
+	}
+}
+
+func (s *proxyServer) start(ctx context.Context, listener net.Listener) (err error) {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if nil != s.listener {
+		return fmt.Errorf("proxy server already started")
+	}
+	s.listener = listener
+	s.done = make(chan struct{})
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	go func() {
+		<-s.ctx.Done()
+		_ = listener.Close()
+	}()
+	return
+}
+
+func (s *proxyServer) stop() {
+	s.locker.Lock()
+	if nil == s.cancel {
+		s.locker.Unlock()
+		return
+	}
+	s.cancel()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.locker.Unlock()
+}
+
+func (s *proxyServer) wait() {
+	s.locker.Lock()
+	done := s.done
+	s.locker.Unlock()
+	if nil != done {
+		<-done
+	}
+}
+
+func (s *proxyServer) addr() net.Addr {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if nil == s.listener {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *proxyServer) track(conn io.Closer) bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if nil != s.ctx.Err() {
+		_ = conn.Close()
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *proxyServer) untrack(conn io.Closer) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	_ = conn.Close()
+	delete(s.conns, conn)
+}
+
+func (s *proxyServer) authenticate(ctx context.Context, user string, password string) bool {
+	if nil == s.auth {
+		return true
+	}
+	return s.auth(ctx, user, password)
+}
+
+// proxyAcl 没有设置 ACL 时使用默认 ACL 禁止访问内网，有上游时同样生效，除非调用 SetAclDisabled
+func (s *proxyServer) proxyAcl() *ProxyAcl {
+	if nil != s.acl {
+		return s.acl
+	}
+	if s.aclDisabled {
+		return nil
+	}
+	return proxyDefaultAcl
+}
+
+// dial 检查 ACL 后连接目标，返回的连接会统计 user 的流量
+func (s *proxyServer) dial(ctx context.Context, user string, target string) (conn net.Conn, err error) {
+	dialAddr := target
+	if acl := s.proxyAcl(); nil != acl {
+		if dialAddr, err = acl.Resolve(ctx, target); nil != err {
+			return nil, &proxyAclError{err: err}
+		}
+	}
+	if s.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.dialTimeout)
+		defer cancel()
+	}
+	if nil != s.upstream {
+		conn, err = s.upstream.DialContext(ctx, "tcp", dialAddr)
+	} else {
+		dialer := &net.Dialer{}
+		conn, err = dialer.DialContext(ctx, "tcp", dialAddr)
+	}
+	if nil != err {
+		return
+	}
+	return newProxyCountConn(conn, s.traffic.counter(user)), nil
+}
+
+func (s *proxyServer) logError(format string, a ...any) {
+	if nil == s.logger {
+		return
+	}
+	s.logger.ErrorF(format, a...)
+}
+
+type proxyAclError struct {
+	err error
+}
+
+func (e *proxyAclError) Error() string {
+	return "acl denied: " + e.err.Error()
+}
+
+func proxyPipe(a net.Conn, b net.Conn) {
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	cp := func(dst net.Conn, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	wg.Wait()
+}
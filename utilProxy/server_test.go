@@ -0,0 +1,195 @@
+package utilProxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestProxyAclPrivateDefault(t *testing.T) {
+	loopback := netip.MustParseAddr("127.0.0.1")
+	public := netip.MustParseAddr("203.0.113.10")
+
+	acl := NewProxyAcl()
+	if nil == acl.CheckAddr(loopback, 80) {
+		t.Fatalf("默认应禁止回环地址")
+	}
+	if nil == acl.CheckAddr(netip.MustParseAddr("192.168.1.1"), 80) {
+		t.Fatalf("默认应禁止内网地址")
+	}
+	if nil == acl.CheckAddr(netip.MustParseAddr("64:ff9b::7f00:1"), 80) {
+		t.Fatalf("默认应禁止映射到内网的 NAT64 地址")
+	}
+	if err := acl.CheckAddr(public, 80); nil != err {
+		t.Fatalf("公网地址应允许: %+v", err)
+	}
+
+	allowed := NewProxyAcl()
+	_ = allowed.Allow("127.0.0.0/8", "203.0.113.0/24")
+	if err := allowed.CheckAddr(loopback, 80); nil != err {
+		t.Fatalf("allow 中列出的内网地址应允许: %+v", err)
+	}
+	if nil == allowed.CheckAddr(netip.MustParseAddr("10.0.0.1"), 80) {
+		t.Fatalf("allow 中未列出的内网地址应禁止")
+	}
+
+	if err := NewProxyAcl().AllowPrivate(true).CheckAddr(loopback, 80); nil != err {
+		t.Fatalf("AllowPrivate 后应允许: %+v", err)
+	}
+	denied := NewProxyAcl().AllowPrivate(true).DenyPrivate()
+	_ = denied.Allow("127.0.0.1")
+	if nil == denied.CheckAddr(loopback, 80) {
+		t.Fatalf("DenyPrivate 优先于 allow")
+	}
+}
+
+func startTestSocks5(t *testing.T, server *Socks5Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatalf("listen: %+v", err)
+	}
+	go func() {
+		_ = server.Serve(context.Background(), listener)
+	}()
+	t.Cleanup(func() { _ = server.Close() })
+	return listener.Addr().String()
+}
+
+func TestSocks5ServerDeniesLoopbackByDefault(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatalf("listen: %+v", err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, e := target.Accept()
+			if nil != e {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	addr := startTestSocks5(t, NewSocks5Server())
+	client, err := NewProxySocks5(addr, "", "")
+	if nil != err {
+		t.Fatalf("client: %+v", err)
+	}
+	if conn, err := client.NewConn("tcp", target.Addr().String(), time.Second); nil == err {
+		_ = conn.Close()
+		t.Fatalf("没有 ACL 时不应允许访问回环地址")
+	}
+
+	addr = startTestSocks5(t, NewSocks5Server().SetAcl(NewProxyAcl().AllowPrivate(true)))
+	client, _ = NewProxySocks5(addr, "", "")
+	conn, err := client.NewConn("tcp", target.Addr().String(), time.Second)
+	if nil != err {
+		t.Fatalf("AllowPrivate 后应允许: %+v", err)
+	}
+	_ = conn.Close()
+}
+
+func TestSocks5ServerUpstreamKeepsDefaultAcl(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatalf("listen: %+v", err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, e := target.Accept()
+			if nil != e {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	upstream, err := NewProxySocks5(startTestSocks5(t, NewSocks5Server().SetAcl(NewProxyAcl().AllowPrivate(true))), "", "")
+	if nil != err {
+		t.Fatalf("upstream: %+v", err)
+	}
+
+	client, _ := NewProxySocks5(startTestSocks5(t, NewSocks5Server().SetUpstream(upstream)), "", "")
+	if conn, err := client.NewConn("tcp", target.Addr().String(), time.Second); nil == err {
+		_ = conn.Close()
+		t.Fatalf("设置上游后仍应使用默认 ACL 禁止访问回环地址")
+	}
+
+	client, _ = NewProxySocks5(startTestSocks5(t, NewSocks5Server().SetUpstream(upstream).SetAclDisabled(true)), "", "")
+	conn, err := client.NewConn("tcp", target.Addr().String(), time.Second)
+	if nil != err {
+		t.Fatalf("SetAclDisabled 后由上游限制: %+v", err)
+	}
+	_ = conn.Close()
+}
+
+func TestSocks5UdpZeroTimeoutUsesDefault(t *testing.T) {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if nil != err {
+		t.Fatalf("listen udp: %+v", err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, e := echo.ReadFromUDP(buf)
+			if nil != e {
+				return
+			}
+			_, _ = echo.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	server := NewSocks5Server().SetAcl(NewProxyAcl().AllowPrivate(true)).SetUdpTimeout(0)
+	addr := startTestSocks5(t, server)
+
+	control, err := net.Dial("tcp", addr)
+	if nil != err {
+		t.Fatalf("dial: %+v", err)
+	}
+	defer control.Close()
+	_ = control.SetDeadline(time.Now().Add(3 * time.Second))
+	reply := make([]byte, 10)
+	if _, err = control.Write([]byte{socks5Version, 1, socks5AuthNone}); nil != err {
+		t.Fatalf("write: %+v", err)
+	}
+	if _, err = io.ReadFull(control, reply[:2]); nil != err {
+		t.Fatalf("negotiate: %+v", err)
+	}
+	if _, err = control.Write([]byte{socks5Version, socks5CmdUdpAssociate, 0, socks5AtypIpv4, 0, 0, 0, 0, 0, 0}); nil != err {
+		t.Fatalf("write: %+v", err)
+	}
+	if _, err = io.ReadFull(control, reply); nil != err || socks5RepSuccess != reply[1] {
+		t.Fatalf("udp associate: %v %+v", reply, err)
+	}
+	relay := &net.UDPAddr{IP: net.IP(reply[4:8]), Port: int(binary.BigEndian.Uint16(reply[8:10]))}
+
+	conn, err := net.DialUDP("udp", nil, relay)
+	if nil != err {
+		t.Fatalf("dial udp: %+v", err)
+	}
+	defer conn.Close()
+	// 超时为 0 时关联会立即结束，等待一段时间后仍应可用
+	time.Sleep(100 * time.Millisecond)
+	packet := socks5AppendAddr([]byte{0, 0, 0}, echo.LocalAddr())
+	packet = append(packet, "ping"...)
+	buf := make([]byte, 1500)
+	for i := 0; i < 2; i++ {
+		if _, err = conn.Write(packet); nil != err {
+			t.Fatalf("write udp: %+v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(buf)
+		if nil != err {
+			t.Fatalf("read udp: %+v", err)
+		}
+		if "ping" != string(buf[n-4:n]) {
+			t.Fatalf("udp reply = %q", buf[:n])
+		}
+	}
+}
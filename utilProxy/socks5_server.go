@@ -0,0 +1,555 @@
+package utilProxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hilaoyu/go-utils/utilLogger"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	socks5Version          = 0x05
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+
+	socks5CmdConnect      = 0x01
+	socks5CmdUdpAssociate = 0x03
+
+	socks5AtypIpv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIpv6   = 0x04
+
+	socks5RepSuccess             = 0x00
+	socks5RepFailure             = 0x01
+	socks5RepNotAllowed          = 0x02
+	socks5RepHostUnreachable     = 0x04
+	socks5RepConnectionRefused   = 0x05
+	socks5RepCommandNotSupported = 0x07
+	socks5RepAddressNotSupported = 0x08
+
+	socks5UdpDefaultTimeout = time.Duration(120) * time.Second
+	// UDP 关联中每个目标的解析结果缓存时间和数量上限
+	socks5UdpResolveTtl   = time.Duration(60) * time.Second
+	socks5UdpResolveLimit = 1024
+)
+
+// Socks5Server 支持 CONNECT、UDP ASSOCIATE 和用户名密码认证(RFC 1928/1929)
+// 设置了上游代理时 UDP ASSOCIATE 不可用
+type Socks5Server struct {
+	proxyServer
+	udpDisabled bool
+	udpTimeout  time.Duration
+}
+
+func NewSocks5Server() *Socks5Server {
+	return &Socks5Server{
+		proxyServer: newProxyServer(),
+		udpTimeout:  socks5UdpDefaultTimeout,
+	}
+}
+
+// SetAuthenticator 为空时不需要认证
+func (s *Socks5Server) SetAuthenticator(auth ProxyAuthenticator) *Socks5Server {
+	s.auth = auth
+	return s
+}
+
+// SetAcl 为空时禁止访问内网地址
+func (s *Socks5Server) SetAcl(acl *ProxyAcl) *Socks5Server {
+	s.acl = acl
+	return s
+}
+
+// SetAclDisabled 没有设置 ACL 时不使用默认 ACL，目标不在本地解析，由上游代理限制
+func (s *Socks5Server) SetAclDisabled(disabled bool) *Socks5Server {
+	s.aclDisabled = disabled
+	return s
+}
+
+// SetUpstream 通过上游代理连接目标，如 NewProxySocks5 或 utilSsh.SshClient
+func (s *Socks5Server) SetUpstream(upstream UtilProxy) *Socks5Server {
+	s.upstream = upstream
+	return s
+}
+
+func (s *Socks5Server) SetDialTimeout(timeout time.Duration) *Socks5Server {
+	s.dialTimeout = timeout
+	return s
+}
+
+func (s *Socks5Server) SetLogger(logger *utilLogger.Logger) *Socks5Server {
+	s.logger = logger
+	return s
+}
+
+func (s *Socks5Server) SetUdpDisabled(disabled bool) *Socks5Server {
+	s.udpDisabled = disabled
+	return s
+}
+
+// SetUdpTimeout UDP 关联在没有数据时的超时时间，<= 0 时使用默认的 120s
+func (s *Socks5Server) SetUdpTimeout(timeout time.Duration) *Socks5Server {
+	if timeout <= 0 {
+		timeout = socks5UdpDefaultTimeout
+	}
+	s.udpTimeout = timeout
+	return s
+}
+
+func (s *Socks5Server) Traffic() *ProxyTraffic {
+	return s.traffic
+}
+
+func (s *Socks5Server) Addr() net.Addr {
+	return s.addr()
+}
+
+// ListenAndServe 阻塞直到 ctx 取消或 Close
+func (s *Socks5Server) ListenAndServe(ctx context.Context, addr string) (err error) {
+	listener, err := net.Listen("tcp", addr)
+	if nil != err {
+		return fmt.Errorf("socks5 listen %s error: %+v", addr, err)
+	}
+	return s.Serve(ctx, listener)
+}
+
+func (s *Socks5Server) Serve(ctx context.Context, listener net.Listener) (err error) {
+	if err = s.start(ctx, listener); nil != err {
+		return
+	}
+	defer close(s.done)
+	defer s.stop()
+	for {
+		conn, e := listener.Accept()
+		if nil != e {
+			if nil != s.ctx.Err() {
+				return
+			}
+			var ne net.Error
+			if errors.As(e, &ne) && ne.Timeout() {
+				time.Sleep(time.Duration(50) * time.Millisecond)
+				continue
+			}
+			return e
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close 停止监听并关闭所有连接
+func (s *Socks5Server) Close() error {
+	s.stop()
+	s.wait()
+	return nil
+}
+
+func (s *Socks5Server) handle(conn net.Conn) {
+	if !s.track(conn) {
+		return
+	}
+	defer s.untrack(conn)
+
+	_ = conn.SetDeadline(time.Now().Add(time.Duration(30) * time.Second))
+	user, err := s.negotiate(conn)
+	if nil != err {
+		return
+	}
+	cmd, target, err := socks5ReadRequest(conn)
+	if nil != err {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	switch cmd {
+	case socks5CmdConnect:
+		s.handleConnect(conn, user, target)
+	case socks5CmdUdpAssociate:
+		if s.udpDisabled || nil != s.upstream {
+			_ = socks5WriteReply(conn, socks5RepCommandNotSupported, nil)
+			return
+		}
+		s.handleUdpAssociate(conn, user, target)
+	default:
+		_ = socks5WriteReply(conn, socks5RepCommandNotSupported, nil)
+	}
+}
+
+func (s *Socks5Server) negotiate(conn net.Conn) (user string, err error) {
+	buf := make([]byte, 256)
+	if _, err = io.ReadFull(conn, buf[:2]); nil != err {
+		return
+	}
+	if socks5Version != buf[0] {
+		return "", fmt.Errorf("socks version %d not supported", buf[0])
+	}
+	methods := make([]byte, int(buf[1]))
+	if _, err = io.ReadFull(conn, methods); nil != err {
+		return
+	}
+	want := byte(socks5AuthNone)
+	if nil != s.auth {
+		want = socks5AuthPassword
+	}
+	found := false
+	for _, m := range methods {
+		if want == m {
+			found = true
+		}
+	}
+	if !found {
+		_, _ = conn.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+		return "", fmt.Errorf("no acceptable auth method")
+	}
+	if _, err = conn.Write([]byte{socks5Version, want}); nil != err {
+		return
+	}
+	if socks5AuthNone == want {
+		return
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	if _, err = io.ReadFull(conn, buf[:2]); nil != err {
+		return
+	}
+	if 0x01 != buf[0] {
+		return "", fmt.Errorf("auth version %d not supported", buf[0])
+	}
+	name := make([]byte, int(buf[1]))
+	if _, err = io.ReadFull(conn, name); nil != err {
+		return
+	}
+	if _, err = io.ReadFull(conn, buf[:1]); nil != err {
+		return
+	}
+	password := make([]byte, int(buf[0]))
+	if _, err = io.ReadFull(conn, password); nil != err {
+		return
+	}
+	if !s.authenticate(s.ctx, string(name), string(password)) {
+		_, _ = conn.Write([]byte{0x01, 0x01})
+		return "", fmt.Errorf("auth failed for %s", string(name))
+	}
+	if _, err = conn.Write([]byte{0x01, 0x00}); nil != err {
+		return
+	}
+	return string(name), nil
+}
+
+func (s *Socks5Server) handleConnect(conn net.Conn, user string, target string) {
+	remote, err := s.dial(s.ctx, user, target)
+	if nil != err {
+		_ = socks5WriteReply(conn, socks5ErrorReply(err), nil)
+		s.logError("socks5 %s connect %s error: %+v", user, target, err)
+		return
+	}
+	if !s.track(remote) {
+		return
+	}
+	defer s.untrack(remote)
+	if err = socks5WriteReply(conn, socks5RepSuccess, remote.LocalAddr()); nil != err {
+		return
+	}
+	proxyPipe(conn, remote)
+}
+
+// handleUdpAssociate UDP 中继在控制连接关闭时结束，只接受来自客户端 IP 的数据包
+func (s *Socks5Server) handleUdpAssociate(conn net.Conn, user string, target string) {
+	local := conn.LocalAddr().(*net.TCPAddr)
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP, Zone: local.Zone})
+	if nil != err {
+		_ = socks5WriteReply(conn, socks5RepFailure, nil)
+		return
+	}
+	if !s.track(relay) {
+		return
+	}
+	defer s.untrack(relay)
+	outbound, err := net.ListenUDP("udp", nil)
+	if nil != err {
+		_ = socks5WriteReply(conn, socks5RepFailure, nil)
+		return
+	}
+	if !s.track(outbound) {
+		return
+	}
+	defer s.untrack(outbound)
+	if err = socks5WriteReply(conn, socks5RepSuccess, relay.LocalAddr()); nil != err {
+		return
+	}
+
+	clientIp, _ := netip.AddrFromSlice(conn.RemoteAddr().(*net.TCPAddr).IP)
+	association := &socks5UdpAssociation{
+		server:   s,
+		control:  conn,
+		relay:    relay,
+		outbound: outbound,
+		clientIp: clientIp.Unmap(),
+		counter:  s.traffic.counter(user),
+		peers:    map[netip.AddrPort]bool{},
+		resolved: map[string]*socks5UdpTarget{},
+	}
+	// 客户端在请求中给出了发送地址时只接受该端口
+	if _, port, e := net.SplitHostPort(target); nil == e && "0" != port {
+		association.clientPort, _ = strconv.Atoi(port)
+	}
+	association.counter.connections.Add(1)
+	association.counter.active.Add(1)
+	defer association.counter.active.Add(-1)
+
+	go association.fromClient()
+	go association.fromRemote()
+	// 控制连接关闭即结束关联
+	_, _ = io.Copy(io.Discard, conn)
+}
+
+type socks5UdpAssociation struct {
+	server     *Socks5Server
+	control    net.Conn
+	relay      *net.UDPConn
+	outbound   *net.UDPConn
+	clientIp   netip.Addr
+	clientPort int
+	counter    *proxyUserCounter
+
+	client netip.AddrPort
+	peers  map[netip.AddrPort]bool
+	locker sync.Mutex
+
+	// resolved 只在 fromClient 中使用，不需要加锁
+	resolved map[string]*socks5UdpTarget
+}
+
+// socks5UdpTarget 目标的 ACL 检查和解析结果，拒绝的结果同样缓存
+type socks5UdpTarget struct {
+	dst     netip.AddrPort
+	err     error
+	expires time.Time
+}
+
+func (a *socks5UdpAssociation) fromClient() {
+	timeout := a.server.udpTimeout
+	if timeout <= 0 {
+		timeout = socks5UdpDefaultTimeout
+	}
+	buf := make([]byte, 65535)
+	for {
+		_ = a.relay.SetReadDeadline(time.Now().Add(timeout))
+		n, from, err := a.relay.ReadFromUDPAddrPort(buf)
+		if nil != err {
+			_ = a.outbound.Close()
+			_ = a.control.Close()
+			return
+		}
+		if from.Addr().Unmap() != a.clientIp || (a.clientPort > 0 && int(from.Port()) != a.clientPort) {
+			continue
+		}
+		// RSV(2) FRAG(1) ATYP DST.ADDR DST.PORT DATA，不支持分片
+		if n < 4 || 0 != buf[2] {
+			continue
+		}
+		target, headerLen, err := socks5ParseAddr(buf[3:n])
+		if nil != err {
+			continue
+		}
+		dst, err := a.resolve(target)
+		if nil != err {
+			a.server.logError("socks5 udp %s error: %+v", target, err)
+			continue
+		}
+		a.locker.Lock()
+		a.client = from
+		a.peers[dst] = true
+		a.locker.Unlock()
+		written, err := a.outbound.WriteToUDPAddrPort(buf[3+headerLen:n], dst)
+		if nil == err {
+			a.counter.upload.Add(int64(written))
+		}
+	}
+}
+
+// resolve 按目标缓存 ACL 检查和 DNS 解析的结果，避免每个数据包都解析
+func (a *socks5UdpAssociation) resolve(target string) (dst netip.AddrPort, err error) {
+	now := time.Now()
+	if cached, ok := a.resolved[target]; ok && now.Before(cached.expires) {
+		return cached.dst, cached.err
+	}
+	dst, err = a.lookup(target)
+	if len(a.resolved) >= socks5UdpResolveLimit {
+		clear(a.resolved)
+	}
+	a.resolved[target] = &socks5UdpTarget{dst: dst, err: err, expires: now.Add(socks5UdpResolveTtl)}
+	return
+}
+
+func (a *socks5UdpAssociation) lookup(target string) (dst netip.AddrPort, err error) {
+	dialAddr := target
+	if acl := a.server.proxyAcl(); nil != acl {
+		if dialAddr, err = acl.Resolve(a.server.ctx, target); nil != err {
+			return
+		}
+	}
+	addr, err := net.ResolveUDPAddr("udp", dialAddr)
+	if nil != err {
+		return
+	}
+	dst = addr.AddrPort()
+	return netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port()), nil
+}
+
+func (a *socks5UdpAssociation) fromRemote() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := a.outbound.ReadFromUDPAddrPort(buf)
+		if nil != err {
+			_ = a.relay.Close()
+			return
+		}
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+		a.locker.Lock()
+		client := a.client
+		allowed := a.peers[from]
+		a.locker.Unlock()
+		if !allowed || !client.IsValid() {
+			continue
+		}
+		a.counter.download.Add(int64(n))
+		packet := append([]byte{0, 0, 0}, socks5AppendAddr(nil, net.UDPAddrFromAddrPort(from))...)
+		packet = append(packet, buf[:n]...)
+		_, _ = a.relay.WriteToUDPAddrPort(packet, client)
+	}
+}
+
+func socks5ReadRequest(conn net.Conn) (cmd byte, target string, err error) {
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(conn, head); nil != err {
+		return
+	}
+	if socks5Version != head[0] {
+		err = fmt.Errorf("socks version %d not supported", head[0])
+		return
+	}
+	cmd = head[1]
+	var host string
+	switch head[3] {
+	case socks5AtypIpv4, socks5AtypIpv6:
+		ip := make([]byte, 4)
+		if socks5AtypIpv6 == head[3] {
+			ip = make([]byte, 16)
+		}
+		if _, err = io.ReadFull(conn, ip); nil != err {
+			return
+		}
+		host = net.IP(ip).String()
+	case socks5AtypDomain:
+		l := make([]byte, 1)
+		if _, err = io.ReadFull(conn, l); nil != err {
+			return
+		}
+		domain := make([]byte, int(l[0]))
+		if _, err = io.ReadFull(conn, domain); nil != err {
+			return
+		}
+		host = string(domain)
+	default:
+		_ = socks5WriteReply(conn, socks5RepAddressNotSupported, nil)
+		err = fmt.Errorf("socks address type %d not supported", head[3])
+		return
+	}
+	port := make([]byte, 2)
+	if _, err = io.ReadFull(conn, port); nil != err {
+		return
+	}
+	target = net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	return
+}
+
+// socks5ParseAddr 解析 ATYP DST.ADDR DST.PORT，返回占用的字节数
+func socks5ParseAddr(b []byte) (target string, n int, err error) {
+	if len(b) < 1 {
+		return "", 0, fmt.Errorf("short address")
+	}
+	var host string
+	switch b[0] {
+	case socks5AtypIpv4:
+		n = 1 + 4
+		if len(b) < n+2 {
+			return "", 0, fmt.Errorf("short address")
+		}
+		host = net.IP(b[1:n]).String()
+	case socks5AtypIpv6:
+		n = 1 + 16
+		if len(b) < n+2 {
+			return "", 0, fmt.Errorf("short address")
+		}
+		host = net.IP(b[1:n]).String()
+	case socks5AtypDomain:
+		if len(b) < 2 {
+			return "", 0, fmt.Errorf("short address")
+		}
+		n = 2 + int(b[1])
+		if len(b) < n+2 {
+			return "", 0, fmt.Errorf("short address")
+		}
+		host = string(b[2:n])
+	default:
+		return "", 0, fmt.Errorf("socks address type %d not supported", b[0])
+	}
+	target = net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(b[n:n+2]))))
+	return target, n + 2, nil
+}
+
+func socks5AppendAddr(b []byte, addr net.Addr) []byte {
+	var ip net.IP
+	port := 0
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	}
+	if ip4 := ip.To4(); nil != ip4 {
+		b = append(b, socks5AtypIpv4)
+		b = append(b, ip4...)
+	} else if ip6 := ip.To16(); nil != ip6 {
+		b = append(b, socks5AtypIpv6)
+		b = append(b, ip6...)
+	} else {
+		b = append(b, socks5AtypIpv4, 0, 0, 0, 0)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+func socks5WriteReply(conn net.Conn, rep byte, bind net.Addr) (err error) {
+	_, err = conn.Write(socks5AppendAddr([]byte{socks5Version, rep, 0x00}, bind))
+	return
+}
+
+func socks5ErrorReply(err error) byte {
+	var aclErr *proxyAclError
+	if errors.As(err, &aclErr) {
+		return socks5RepNotAllowed
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		if errors.Is(err, io.EOF) {
+			return socks5RepFailure
+		}
+		if opErr.Timeout() {
+			return socks5RepHostUnreachable
+		}
+		return socks5RepConnectionRefused
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return socks5RepHostUnreachable
+	}
+	return socks5RepFailure
+}
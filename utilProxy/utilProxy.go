@@ -4,7 +4,6 @@ import (
 	"context"
 	"golang.org/x/net/proxy"
 	"net"
-	"strings"
 	"time"
 )
 
@@ -19,22 +18,11 @@ type ProxySocks5 struct {
 }
 
 func NewProxySocks5(proxyAddress string, proxyUser string, proxyPassword string) (utilProxy UtilProxy, err error) {
-	var proxyAuth *proxy.Auth
-	proxyUser = strings.TrimSpace(proxyUser)
-	if "" != proxyUser {
-		proxyAuth = &proxy.Auth{
-			User:     proxyUser,
-			Password: proxyPassword,
-		}
-	}
-	proxyDialer, err := proxy.SOCKS5("tcp", proxyAddress, proxyAuth, proxy.Direct)
+	p, err := newProxySocks5(proxyAddress, proxyUser, proxyPassword, nil)
 	if nil != err {
 		return
 	}
-
-	utilProxy = &ProxySocks5{dialer: proxyDialer}
-
-	return
+	return p, nil
 }
 
 func (p *ProxySocks5) Dial(network string, addr string) (conn net.Conn, err error) {
@@ -0,0 +1,161 @@
+package utilRedis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	REDIS_MODE_STANDALONE = "standalone"
+	REDIS_MODE_SENTINEL   = "sentinel"
+	REDIS_MODE_CLUSTER    = "cluster"
+
+	// REDIS_READ_REPLICA 哨兵模式下只连接从节点(只读)，集群模式下读命令发到从节点
+	// RANDOM / LATENCY 读命令随机或按延迟路由到主从节点，哨兵模式下使用时 Db 只能为 0
+	REDIS_READ_MASTER          = ""
+	REDIS_READ_REPLICA         = "replica"
+	REDIS_READ_REPLICA_RANDOM  = "random"
+	REDIS_READ_REPLICA_LATENCY = "latency"
+)
+
+// RedisConfig 连接配置
+// 单机模式使用 Addrs[0]；哨兵模式 Addrs 为哨兵地址，MasterName 必填；集群模式 Addrs 为种子节点
+type RedisConfig struct {
+	Mode               string   `json:"mode,omitempty"`
+	Addrs              []string `json:"addrs"`
+	Username           string   `json:"username,omitempty"`
+	Password           string   `json:"password,omitempty"`
+	Db                 int      `json:"db,omitempty"`
+	DialTimeoutSeconds int      `json:"dial_timeout_seconds,omitempty"`
+	PoolConnections    int      `json:"pool_connections,omitempty"`
+
+	MasterName       string `json:"master_name,omitempty"`
+	SentinelUsername string `json:"sentinel_username,omitempty"`
+	SentinelPassword string `json:"sentinel_password,omitempty"`
+
+	// ReadFrom REDIS_READ_MASTER / REDIS_READ_REPLICA(只读从节点) / REDIS_READ_REPLICA_RANDOM / REDIS_READ_REPLICA_LATENCY
+	ReadFrom string `json:"read_from,omitempty"`
+
+	Tls                   bool   `json:"tls,omitempty"`
+	TlsCaFile             string `json:"tls_ca_file,omitempty"`
+	TlsCertFile           string `json:"tls_cert_file,omitempty"`
+	TlsKeyFile            string `json:"tls_key_file,omitempty"`
+	TlsServerName         string `json:"tls_server_name,omitempty"`
+	TlsInsecureSkipVerify bool   `json:"tls_insecure_skip_verify,omitempty"`
+	// TlsConfig 不为 nil 时优先使用，忽略上面的 Tls 文件配置
+	TlsConfig *tls.Config `json:"-"`
+}
+
+func (conf RedisConfig) mode() string {
+	if "" != conf.Mode {
+		return strings.ToLower(conf.Mode)
+	}
+	if "" != conf.MasterName {
+		return REDIS_MODE_SENTINEL
+	}
+	return REDIS_MODE_STANDALONE
+}
+
+func (conf RedisConfig) tlsConfig() (tlsConfig *tls.Config, err error) {
+	if nil != conf.TlsConfig {
+		return conf.TlsConfig.Clone(), nil
+	}
+	if !conf.Tls {
+		return nil, nil
+	}
+	tlsConfig = &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         conf.TlsServerName,
+		InsecureSkipVerify: conf.TlsInsecureSkipVerify,
+	}
+	if "" != conf.TlsCaFile {
+		ca, e := os.ReadFile(conf.TlsCaFile)
+		if nil != e {
+			return nil, fmt.Errorf("read redis tls ca file error: %+v", e)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("redis tls ca file %s has no certificate", conf.TlsCaFile)
+		}
+	}
+	if "" != conf.TlsCertFile || "" != conf.TlsKeyFile {
+		cert, e := tls.LoadX509KeyPair(conf.TlsCertFile, conf.TlsKeyFile)
+		if nil != e {
+			return nil, fmt.Errorf("load redis tls client certificate error: %+v", e)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return
+}
+
+func (conf RedisConfig) universalOptions() (opts *redis.UniversalOptions, err error) {
+	if len(conf.Addrs) <= 0 {
+		return nil, fmt.Errorf("redis addrs can't be empty")
+	}
+	poolConnections := conf.PoolConnections
+	if poolConnections < 1 {
+		poolConnections = 1
+	}
+	tlsConfig, err := conf.tlsConfig()
+	if nil != err {
+		return
+	}
+	opts = &redis.UniversalOptions{
+		Addrs:          conf.Addrs,
+		Username:       conf.Username,
+		Password:       conf.Password,
+		DB:             conf.Db,
+		DialTimeout:    time.Duration(conf.DialTimeoutSeconds) * time.Second,
+		MaxActiveConns: poolConnections,
+		MaxIdleConns:   poolConnections / 5,
+		TLSConfig:      tlsConfig,
+	}
+
+	readFrom := strings.ToLower(conf.ReadFrom)
+	switch readFrom {
+	case REDIS_READ_MASTER:
+	case REDIS_READ_REPLICA:
+		opts.ReadOnly = true
+	case REDIS_READ_REPLICA_RANDOM:
+		opts.RouteRandomly = true
+	case REDIS_READ_REPLICA_LATENCY:
+		opts.RouteByLatency = true
+	default:
+		return nil, fmt.Errorf("redis read from %s not supported", conf.ReadFrom)
+	}
+
+	switch conf.mode() {
+	case REDIS_MODE_STANDALONE:
+		if REDIS_READ_MASTER != readFrom {
+			return nil, fmt.Errorf("redis standalone mode can't read from replica")
+		}
+		opts.Addrs = conf.Addrs[:1]
+	case REDIS_MODE_SENTINEL:
+		if "" == conf.MasterName {
+			return nil, fmt.Errorf("redis sentinel master name can't be empty")
+		}
+		// RANDOM / LATENCY 使用 FailoverClusterClient，不支持 SELECT
+		if (opts.RouteRandomly || opts.RouteByLatency) && 0 != conf.Db {
+			return nil, fmt.Errorf("redis sentinel read from replica only supports db 0")
+		}
+		opts.MasterName = conf.MasterName
+		opts.SentinelUsername = conf.SentinelUsername
+		opts.SentinelPassword = conf.SentinelPassword
+	case REDIS_MODE_CLUSTER:
+		if 0 != conf.Db {
+			return nil, fmt.Errorf("redis cluster only supports db 0")
+		}
+		opts.IsClusterMode = true
+		// 集群模式下 RouteRandomly / RouteByLatency 需要 ReadOnly
+		opts.ReadOnly = REDIS_READ_MASTER != readFrom
+	default:
+		return nil, fmt.Errorf("redis mode %s not supported", conf.Mode)
+	}
+	return
+}
@@ -0,0 +1,188 @@
+package utilRedis
+
+import (
+	"context"
+	"crypto/tls"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestSentinel 只实现 go-redis 哨兵客户端用到的 SENTINEL 命令，主从节点为 miniredis
+func newTestSentinel(t *testing.T, masterName string, master *miniredis.Miniredis, replicas ...*miniredis.Miniredis) string {
+	srv, err := server.NewServer("127.0.0.1:0")
+	if nil != err {
+		t.Fatalf("sentinel: %+v", err)
+	}
+	t.Cleanup(srv.Close)
+	_ = srv.Register("PING", func(c *server.Peer, cmd string, args []string) {
+		c.WriteInline("PONG")
+	})
+	_ = srv.Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		if len(args) < 2 || masterName != args[1] {
+			c.WriteNull()
+			return
+		}
+		switch strings.ToLower(args[0]) {
+		case "get-master-addr-by-name":
+			c.WriteStrings([]string{master.Host(), master.Port()})
+		case "replicas", "slaves":
+			c.WriteLen(len(replicas))
+			for _, replica := range replicas {
+				c.WriteStrings([]string{"ip", replica.Host(), "port", replica.Port(), "flags", "slave"})
+			}
+		default:
+			c.WriteLen(0)
+		}
+	})
+	return srv.Addr().String()
+}
+
+func TestRedisConfigUniversalOptions(t *testing.T) {
+	cases := []struct {
+		name string
+		conf RedisConfig
+		err  string
+		// check 为 nil 时只检查错误
+		check func(opts *redis.UniversalOptions) bool
+	}{
+		{"empty addrs", RedisConfig{}, "addrs can't be empty", nil},
+		{"standalone", RedisConfig{Addrs: []string{"a:1", "b:1"}, PoolConnections: 10}, "", func(opts *redis.UniversalOptions) bool {
+			return 1 == len(opts.Addrs) && "a:1" == opts.Addrs[0] && 10 == opts.MaxActiveConns && 2 == opts.MaxIdleConns
+		}},
+		{"standalone replica", RedisConfig{Addrs: []string{"a:1"}, ReadFrom: REDIS_READ_REPLICA}, "can't read from replica", nil},
+		{"unknown read from", RedisConfig{Addrs: []string{"a:1"}, ReadFrom: "nearest"}, "not supported", nil},
+		{"unknown mode", RedisConfig{Mode: "proxy", Addrs: []string{"a:1"}}, "not supported", nil},
+		{"sentinel by master name", RedisConfig{Addrs: []string{"a:1", "b:1"}, MasterName: "m", SentinelPassword: "p"}, "", func(opts *redis.UniversalOptions) bool {
+			return "m" == opts.MasterName && "p" == opts.SentinelPassword && 2 == len(opts.Addrs) && !opts.IsClusterMode
+		}},
+		{"sentinel without master name", RedisConfig{Mode: REDIS_MODE_SENTINEL, Addrs: []string{"a:1"}}, "master name can't be empty", nil},
+		{"sentinel replica", RedisConfig{Addrs: []string{"a:1"}, MasterName: "m", ReadFrom: REDIS_READ_REPLICA, Db: 2}, "", func(opts *redis.UniversalOptions) bool {
+			return opts.ReadOnly && 2 == opts.DB
+		}},
+		{"sentinel random with db", RedisConfig{Addrs: []string{"a:1"}, MasterName: "m", ReadFrom: REDIS_READ_REPLICA_RANDOM, Db: 1}, "only supports db 0", nil},
+		{"cluster", RedisConfig{Mode: "Cluster", Addrs: []string{"a:1", "b:1"}}, "", func(opts *redis.UniversalOptions) bool {
+			return opts.IsClusterMode && !opts.ReadOnly && 2 == len(opts.Addrs)
+		}},
+		{"cluster latency", RedisConfig{Mode: REDIS_MODE_CLUSTER, Addrs: []string{"a:1"}, ReadFrom: REDIS_READ_REPLICA_LATENCY}, "", func(opts *redis.UniversalOptions) bool {
+			return opts.IsClusterMode && opts.ReadOnly && opts.RouteByLatency
+		}},
+		{"cluster with db", RedisConfig{Mode: REDIS_MODE_CLUSTER, Addrs: []string{"a:1"}, Db: 1}, "only supports db 0", nil},
+		{"tls", RedisConfig{Addrs: []string{"a:1"}, Tls: true, TlsServerName: "redis"}, "", func(opts *redis.UniversalOptions) bool {
+			return nil != opts.TLSConfig && "redis" == opts.TLSConfig.ServerName && tls.VersionTLS12 == opts.TLSConfig.MinVersion
+		}},
+		{"tls ca missing", RedisConfig{Addrs: []string{"a:1"}, Tls: true, TlsCaFile: filepath.Join(t.TempDir(), "ca.pem")}, "read redis tls ca file error", nil},
+	}
+	for _, c := range cases {
+		opts, err := c.conf.universalOptions()
+		if "" != c.err {
+			if nil == err || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("%s: err = %+v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if nil != err || !c.check(opts) {
+			t.Fatalf("%s: %+v %+v", c.name, opts, err)
+		}
+	}
+
+	// TlsConfig 优先使用，并且复制一份
+	conf := RedisConfig{Addrs: []string{"a:1"}, TlsConfig: &tls.Config{ServerName: "custom"}}
+	opts, err := conf.universalOptions()
+	if nil != err || "custom" != opts.TLSConfig.ServerName || conf.TlsConfig == opts.TLSConfig {
+		t.Fatalf("tls config: %+v", err)
+	}
+}
+
+func TestRedisClientStandalone(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc, err := NewRedisClientWithConfig(RedisConfig{Addrs: []string{mr.Addr()}, Db: 3})
+	if nil != err {
+		t.Fatalf("redis: %+v", err)
+	}
+	defer rc.Close()
+	if _, err = rc.Set("k", "v"); nil != err {
+		t.Fatalf("set: %+v", err)
+	}
+	mr.Select(3)
+	if v, _ := mr.Get("k"); "v" != v || REDIS_MODE_STANDALONE != rc.Mode() {
+		t.Fatalf("应写入 db 3: %q", v)
+	}
+	if c, ok := rc.StandaloneClient(); !ok || 3 != c.Options().DB {
+		t.Fatalf("单机模式应返回 *redis.Client")
+	}
+
+	mr.RequireAuth("secret")
+	if _, err = NewRedisClientWithConfig(RedisConfig{Addrs: []string{mr.Addr()}, Password: "wrong"}); nil == err {
+		t.Fatalf("Ping 失败时应返回错误")
+	}
+}
+
+func TestRedisClientSentinel(t *testing.T) {
+	master := miniredis.RunT(t)
+	replica := miniredis.RunT(t)
+	sentinel := newTestSentinel(t, "mymaster", master, replica)
+
+	rc, err := NewRedisClientWithConfig(RedisConfig{Addrs: []string{sentinel}, MasterName: "mymaster"})
+	if nil != err {
+		t.Fatalf("sentinel: %+v", err)
+	}
+	defer rc.Close()
+	if _, err = rc.Set("k", "master"); nil != err {
+		t.Fatalf("set: %+v", err)
+	}
+	if v, _ := master.Get("k"); "master" != v || REDIS_MODE_SENTINEL != rc.Mode() {
+		t.Fatalf("应写入哨兵返回的主节点: %q", v)
+	}
+	if _, ok := rc.StandaloneClient(); !ok {
+		t.Fatalf("只连接主节点的哨兵模式应返回 *redis.Client")
+	}
+
+	// 只读从节点
+	_ = replica.Set("k", "replica")
+	ro, err := NewRedisClientWithConfig(RedisConfig{Addrs: []string{sentinel}, MasterName: "mymaster", ReadFrom: REDIS_READ_REPLICA})
+	if nil != err {
+		t.Fatalf("sentinel replica: %+v", err)
+	}
+	defer ro.Close()
+	if v, err := ro.Get("k"); nil != err || "replica" != v {
+		t.Fatalf("应从从节点读取: %q %+v", v, err)
+	}
+
+	if _, err = NewRedisClientWithConfig(RedisConfig{Addrs: []string{sentinel}, MasterName: "other", DialTimeoutSeconds: 1}); nil == err {
+		t.Fatalf("未知的主节点名应返回错误")
+	}
+}
+
+func TestRedisClientCluster(t *testing.T) {
+	// miniredis 作为持有所有槽的单节点集群
+	mr := miniredis.RunT(t)
+	rc, err := NewRedisClientWithConfig(RedisConfig{Mode: REDIS_MODE_CLUSTER, Addrs: []string{mr.Addr()}})
+	if nil != err {
+		t.Fatalf("cluster: %+v", err)
+	}
+	defer rc.Close()
+	if _, ok := rc.Client.(*redis.ClusterClient); !ok {
+		t.Fatalf("集群模式应使用 ClusterClient")
+	}
+	if _, ok := rc.StandaloneClient(); ok {
+		t.Fatalf("集群模式不应返回 *redis.Client")
+	}
+
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err = rc.SetContext(ctx, key, key); nil != err {
+			t.Fatalf("set: %+v", err)
+		}
+	}
+	if v, err := rc.GetContext(ctx, "b"); nil != err || "b" != v {
+		t.Fatalf("get: %q %+v", v, err)
+	}
+	if err = rc.DelByPatternContext(ctx, "*"); nil != err || 0 != len(mr.Keys()) {
+		t.Fatalf("集群模式下按模式删除: %v %+v", mr.Keys(), err)
+	}
+}
@@ -15,13 +15,13 @@ var (
 )
 
 type RedisGoConn struct {
-	client   *redis.Client
+	client   redis.UniversalClient
 	commands []*redis.StatusCmd
 	mu       sync.Mutex
 	err      error
 }
 
-func GoRedisToRedisGoConn(client *redis.Client) *RedisGoConn {
+func GoRedisToRedisGoConn(client redis.UniversalClient) *RedisGoConn {
 	return &RedisGoConn{
 		client: client,
 	}
@@ -0,0 +1,368 @@
+package utilRedis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mathRand "math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrLockNotAcquired = errors.New("redis lock not acquired")
+	ErrLockNotHeld     = errors.New("redis lock not held")
+)
+
+const (
+	redisLockWrite = "w"
+	redisLockRead  = "r"
+)
+
+// 锁数据：{key} 为 hash，w/wc/wexp 为写锁持有者、重入次数和过期时间(毫秒)，r:<owner> 为读锁重入次数
+// {key}:r 为读锁持有者的 zset，score 为过期时间(毫秒)；{key} 和 {key}:r 的过期时间按仍有效的持有者中最晚的设置
+// {key}:fence 为递增的 fencing token，不设置过期时间，否则过期后重新从 1 开始，token 不再单调递增
+// 所有 key 使用相同的 hash tag，集群模式下在同一个槽
+// key 为 utilRedisLock_{key}，与 GetLocker / Lock 等旧接口(redsync，key 为 utilRedisLock_key)不是同一个 key，
+// 同一个名字的新锁和旧锁之间不互斥，迁移时所有进程需要同时切换
+
+// 各脚本共用：清理过期的持有者，按剩余持有者设置过期时间
+const redisLockScriptCommon = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local function cleanup()
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now)
+	for _, f in ipairs(redis.call('HKEYS', KEYS[1])) do
+		if string.sub(f, 1, 2) == 'r:' and not redis.call('ZSCORE', KEYS[2], string.sub(f, 3)) then
+			redis.call('HDEL', KEYS[1], f)
+		end
+	end
+	local wexp = redis.call('HGET', KEYS[1], 'wexp')
+	if wexp and tonumber(wexp) <= now then
+		redis.call('HDEL', KEYS[1], 'w', 'wc', 'wexp')
+	end
+end
+local function expire()
+	local latest = tonumber(redis.call('HGET', KEYS[1], 'wexp') or 0)
+	local last = redis.call('ZRANGE', KEYS[2], -1, -1, 'WITHSCORES')
+	if #last > 0 then
+		local score = tonumber(last[2])
+		redis.call('PEXPIRE', KEYS[2], score - now)
+		if score > latest then
+			latest = score
+		end
+	else
+		redis.call('DEL', KEYS[2])
+	end
+	if latest <= now or redis.call('HLEN', KEYS[1]) == 0 then
+		redis.call('DEL', KEYS[1])
+	else
+		redis.call('PEXPIRE', KEYS[1], latest - now)
+	end
+end
+cleanup()
+`
+
+var (
+	redisLockAcquireScript = redis.NewScript(redisLockScriptCommon + `
+local ttl = tonumber(ARGV[3])
+local owner = ARGV[1]
+local w = redis.call('HGET', KEYS[1], 'w')
+if w and w ~= owner then
+	return -1
+end
+if ARGV[2] == 'w' then
+	local readers = redis.call('ZRANGE', KEYS[2], 0, -1)
+	for _, r in ipairs(readers) do
+		if r ~= owner then
+			return -1
+		end
+	end
+	local wexp = tonumber(redis.call('HGET', KEYS[1], 'wexp') or 0)
+	redis.call('HSET', KEYS[1], 'w', owner, 'wexp', math.max(wexp, now + ttl))
+	redis.call('HINCRBY', KEYS[1], 'wc', 1)
+else
+	local score = tonumber(redis.call('ZSCORE', KEYS[2], owner) or 0)
+	redis.call('HINCRBY', KEYS[1], 'r:' .. owner, 1)
+	redis.call('ZADD', KEYS[2], math.max(score, now + ttl), owner)
+end
+expire()
+return redis.call('INCR', KEYS[3])
+`)
+
+	redisLockRenewScript = redis.NewScript(redisLockScriptCommon + `
+local ttl = tonumber(ARGV[3])
+local owner = ARGV[1]
+if ARGV[2] == 'w' then
+	if redis.call('HGET', KEYS[1], 'w') ~= owner then
+		return 0
+	end
+	local wexp = tonumber(redis.call('HGET', KEYS[1], 'wexp'))
+	redis.call('HSET', KEYS[1], 'wexp', math.max(wexp, now + ttl))
+else
+	local score = redis.call('ZSCORE', KEYS[2], owner)
+	if not score then
+		return 0
+	end
+	redis.call('ZADD', KEYS[2], math.max(tonumber(score), now + ttl), owner)
+end
+expire()
+return 1
+`)
+
+	redisLockReleaseScript = redis.NewScript(redisLockScriptCommon + `
+local owner = ARGV[1]
+local held = 1
+if ARGV[2] == 'w' then
+	if redis.call('HGET', KEYS[1], 'w') ~= owner then
+		held = 0
+	elseif redis.call('HINCRBY', KEYS[1], 'wc', -1) <= 0 then
+		redis.call('HDEL', KEYS[1], 'w', 'wc', 'wexp')
+	end
+else
+	local field = 'r:' .. owner
+	if redis.call('HEXISTS', KEYS[1], field) == 0 then
+		held = 0
+	elseif redis.call('HINCRBY', KEYS[1], field, -1) <= 0 then
+		redis.call('HDEL', KEYS[1], field)
+		redis.call('ZREM', KEYS[2], owner)
+	end
+end
+expire()
+return held
+`)
+)
+
+// RedisLockOptions Owner 为空时使用 context 中的 owner(RedisLockOwnerContext)，都没有时每次获取生成新的 owner，即不可重入
+type RedisLockOptions struct {
+	Ttl        time.Duration
+	RetryDelay time.Duration
+	AutoRenew  bool
+	Owner      string
+}
+
+type redisLockOwnerKey struct{}
+
+// RedisLockOwnerContext 在 ctx 中设置锁的 owner，相同 owner 获取同一个锁时可重入
+func RedisLockOwnerContext(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, redisLockOwnerKey{}, owner)
+}
+
+func redisLockOwner(ctx context.Context) string {
+	owner, _ := ctx.Value(redisLockOwnerKey{}).(string)
+	return owner
+}
+
+func newRedisLockOwner() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RedisLock 一次获取锁得到的句柄，Release 只释放这一次获取
+type RedisLock struct {
+	rc     *RedisClient
+	key    string
+	keys   []string
+	mode   string
+	owner  string
+	token  int64
+	ttl    time.Duration
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+
+	released bool
+	locker   sync.Mutex
+}
+
+func (l *RedisLock) Key() string {
+	return l.key
+}
+
+func (l *RedisLock) Owner() string {
+	return l.owner
+}
+
+// Token fencing token，每次成功获取都会递增，写入受保护的资源时带上并拒绝比已见过的更小的 token
+func (l *RedisLock) Token() int64 {
+	return l.token
+}
+
+// Context 锁释放、续期失败(锁丢失)或获取时的 ctx 取消后取消，context.Cause 为 ErrLockNotHeld 表示锁丢失
+func (l *RedisLock) Context() context.Context {
+	return l.ctx
+}
+
+// Refresh 手动续期，锁已丢失时返回 ErrLockNotHeld
+func (l *RedisLock) Refresh(ctx context.Context, ttl time.Duration) (err error) {
+	if ttl <= 0 {
+		ttl = l.ttl
+	}
+	n, err := redisLockRenewScript.Run(ctx, l.rc.Client, l.keys, l.owner, l.mode, ttl.Milliseconds()).Int64()
+	if nil != err {
+		return fmt.Errorf("redis lock %s refresh error: %+v", l.key, err)
+	}
+	if 0 == n {
+		l.cancel(ErrLockNotHeld)
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Release 释放锁，重复释放返回 ErrLockNotHeld；获取时的 ctx 已取消也可以释放
+func (l *RedisLock) Release() (err error) {
+	l.locker.Lock()
+	if l.released {
+		l.locker.Unlock()
+		return ErrLockNotHeld
+	}
+	l.released = true
+	l.locker.Unlock()
+	l.cancel(context.Canceled)
+	<-l.done
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(l.ctx), time.Duration(5)*time.Second)
+	defer cancel()
+	n, err := redisLockReleaseScript.Run(ctx, l.rc.Client, l.keys, l.owner, l.mode).Int64()
+	if nil != err {
+		return fmt.Errorf("redis lock %s release error: %+v", l.key, err)
+	}
+	if 0 == n {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// watchdog 每 ttl/3 续期一次，续期失败或超过 ttl 没有成功续期时取消锁的 context
+func (l *RedisLock) watchdog() {
+	defer close(l.done)
+	interval := max(l.ttl/3, time.Millisecond*10)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(l.ctx, interval)
+		err := l.Refresh(ctx, l.ttl)
+		cancel()
+		switch {
+		case nil == err:
+			lastRenew = time.Now()
+		case errors.Is(err, ErrLockNotHeld):
+			return
+		case time.Since(lastRenew) >= l.ttl:
+			l.cancel(ErrLockNotHeld)
+			return
+		}
+	}
+}
+
+func (rc *RedisClient) lockKeys(key string) []string {
+	base := LockerKeyPrefix + "{" + key + "}"
+	return []string{base, base + ":r", base + ":fence"}
+}
+
+func (rc *RedisClient) acquireLock(ctx context.Context, key string, mode string, wait bool, options []RedisLockOptions) (l *RedisLock, err error) {
+	if "" == key {
+		return nil, fmt.Errorf("redis lock key can't be empty")
+	}
+	opts := RedisLockOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.Ttl <= 0 {
+		opts.Ttl = time.Duration(30) * time.Second
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Duration(50) * time.Millisecond
+	}
+	owner := opts.Owner
+	if "" == owner {
+		owner = redisLockOwner(ctx)
+	}
+	if "" == owner {
+		owner = newRedisLockOwner()
+	}
+	keys := rc.lockKeys(key)
+
+	for {
+		token, e := redisLockAcquireScript.Run(ctx, rc.Client, keys, owner, mode, opts.Ttl.Milliseconds()).Int64()
+		if nil != e {
+			return nil, fmt.Errorf("redis lock %s acquire error: %+v", key, e)
+		}
+		if token > 0 {
+			l = &RedisLock{rc: rc, key: key, keys: keys, mode: mode, owner: owner, token: token, ttl: opts.Ttl, done: make(chan struct{})}
+			l.ctx, l.cancel = context.WithCancelCause(RedisLockOwnerContext(ctx, owner))
+			if opts.AutoRenew {
+				go l.watchdog()
+			} else {
+				close(l.done)
+			}
+			return l, nil
+		}
+		if !wait {
+			return nil, ErrLockNotAcquired
+		}
+		// 随机等待，避免多个等待者同时重试
+		delay := opts.RetryDelay/2 + mathRand.N(opts.RetryDelay)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %s %+v", ErrLockNotAcquired, key, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// AcquireLock 获取写锁(排它)，等待直到成功或 ctx 取消；与 GetLocker 获取的同名锁不互斥
+func (rc *RedisClient) AcquireLock(ctx context.Context, key string, options ...RedisLockOptions) (*RedisLock, error) {
+	return rc.acquireLock(ctx, key, redisLockWrite, true, options)
+}
+
+// TryAcquireLock 只尝试一次，被占用时返回 ErrLockNotAcquired
+func (rc *RedisClient) TryAcquireLock(ctx context.Context, key string, options ...RedisLockOptions) (*RedisLock, error) {
+	return rc.acquireLock(ctx, key, redisLockWrite, false, options)
+}
+
+// AcquireReadLock 获取读锁，多个读锁可以同时持有，与其它 owner 的写锁互斥
+func (rc *RedisClient) AcquireReadLock(ctx context.Context, key string, options ...RedisLockOptions) (*RedisLock, error) {
+	return rc.acquireLock(ctx, key, redisLockRead, true, options)
+}
+
+func (rc *RedisClient) TryAcquireReadLock(ctx context.Context, key string, options ...RedisLockOptions) (*RedisLock, error) {
+	return rc.acquireLock(ctx, key, redisLockRead, false, options)
+}
+
+// WithLock 获取写锁并自动续期，执行 fn 后释放；fn 的 ctx 带有 owner，在其中对同一个 key 再次 WithLock 可重入
+// 锁丢失时 fn 的 ctx 被取消，返回的错误包含 ErrLockNotHeld
+func (rc *RedisClient) WithLock(ctx context.Context, key string, fn func(ctx context.Context, lock *RedisLock) error, options ...RedisLockOptions) (err error) {
+	opts := RedisLockOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	opts.AutoRenew = true
+	l, err := rc.AcquireLock(ctx, key, opts)
+	if nil != err {
+		return
+	}
+	defer func() {
+		lost := errors.Is(context.Cause(l.Context()), ErrLockNotHeld)
+		releaseErr := l.Release()
+		if lost {
+			err = errors.Join(err, ErrLockNotHeld)
+			return
+		}
+		if nil == err && nil != releaseErr {
+			err = releaseErr
+		}
+	}()
+	return fn(l.Context(), l)
+}
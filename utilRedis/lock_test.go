@@ -0,0 +1,183 @@
+package utilRedis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRedisLockReentrant(t *testing.T) {
+	_, rc := newTestRedis(t)
+	ctx := context.Background()
+
+	err := rc.WithLock(ctx, "k", func(ctx context.Context, outer *RedisLock) error {
+		if _, e := rc.TryAcquireLock(context.Background(), "k"); !errors.Is(e, ErrLockNotAcquired) {
+			t.Fatalf("其他 owner 不应获取到: %+v", e)
+		}
+		return rc.WithLock(ctx, "k", func(ctx context.Context, inner *RedisLock) error {
+			if inner.Owner() != outer.Owner() {
+				t.Fatalf("重入时 owner 应相同")
+			}
+			return nil
+		})
+	})
+	if nil != err {
+		t.Fatalf("with lock: %+v", err)
+	}
+
+	l, err := rc.TryAcquireLock(ctx, "k")
+	if nil != err {
+		t.Fatalf("全部释放后应可获取: %+v", err)
+	}
+	if err = l.Release(); nil != err {
+		t.Fatalf("release: %+v", err)
+	}
+	if err = l.Release(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("重复释放应返回 ErrLockNotHeld: %+v", err)
+	}
+}
+
+func TestRedisLockReadWrite(t *testing.T) {
+	_, rc := newTestRedis(t)
+	ctx := context.Background()
+
+	r1, err := rc.TryAcquireReadLock(ctx, "k")
+	if nil != err {
+		t.Fatalf("read: %+v", err)
+	}
+	r2, err := rc.TryAcquireReadLock(ctx, "k")
+	if nil != err {
+		t.Fatalf("多个读锁应可同时持有: %+v", err)
+	}
+	if _, err = rc.TryAcquireLock(ctx, "k"); !errors.Is(err, ErrLockNotAcquired) {
+		t.Fatalf("有读锁时不应获取到写锁: %+v", err)
+	}
+	_ = r1.Release()
+	if _, err = rc.TryAcquireLock(ctx, "k"); !errors.Is(err, ErrLockNotAcquired) {
+		t.Fatalf("仍有读锁时不应获取到写锁: %+v", err)
+	}
+	_ = r2.Release()
+
+	w, err := rc.TryAcquireLock(ctx, "k")
+	if nil != err {
+		t.Fatalf("读锁全部释放后应可获取写锁: %+v", err)
+	}
+	if _, err = rc.TryAcquireReadLock(ctx, "k"); !errors.Is(err, ErrLockNotAcquired) {
+		t.Fatalf("有写锁时不应获取到读锁: %+v", err)
+	}
+	// 同一个 owner 持有写锁时可以再获取读锁
+	r3, err := rc.TryAcquireReadLock(ctx, "k", RedisLockOptions{Owner: w.Owner()})
+	if nil != err {
+		t.Fatalf("同一个 owner 应可获取读锁: %+v", err)
+	}
+	_ = r3.Release()
+	_ = w.Release()
+}
+
+func TestRedisLockFencingToken(t *testing.T) {
+	mr, rc := newTestRedis(t)
+	ctx := context.Background()
+
+	var last int64
+	for i := 0; i < 5; i++ {
+		l, err := rc.TryAcquireLock(ctx, "k")
+		if nil != err {
+			t.Fatalf("acquire: %+v", err)
+		}
+		if l.Token() <= last {
+			t.Fatalf("token %d 没有递增(上一次 %d)", l.Token(), last)
+		}
+		last = l.Token()
+		_ = l.Release()
+	}
+	// fence key 不能过期，否则 token 会重新从 1 开始
+	fence := rc.lockKeys("k")[2]
+	if !mr.Exists(fence) || 0 != mr.TTL(fence) {
+		t.Fatalf("fence key 不应设置过期时间: %v", mr.TTL(fence))
+	}
+	mr.FastForward(365 * 24 * time.Hour)
+	l, err := rc.TryAcquireLock(ctx, "k")
+	if nil != err || l.Token() <= last {
+		t.Fatalf("token 应继续递增: %+v", err)
+	}
+	_ = l.Release()
+}
+
+func TestRedisLockExpiry(t *testing.T) {
+	mr, rc := newTestRedis(t)
+	ctx := context.Background()
+	now := time.Now()
+	mr.SetTime(now)
+
+	old, err := rc.TryAcquireLock(ctx, "k", RedisLockOptions{Ttl: time.Second})
+	if nil != err {
+		t.Fatalf("acquire: %+v", err)
+	}
+	mr.SetTime(now.Add(2 * time.Second))
+	mr.FastForward(2 * time.Second)
+
+	l, err := rc.TryAcquireLock(ctx, "k", RedisLockOptions{Ttl: time.Minute})
+	if nil != err {
+		t.Fatalf("过期后应可被其他 owner 获取: %+v", err)
+	}
+	if err = old.Refresh(ctx, 0); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("过期后续期应返回 ErrLockNotHeld: %+v", err)
+	}
+	if !errors.Is(context.Cause(old.Context()), ErrLockNotHeld) {
+		t.Fatalf("锁丢失后 ctx 应取消")
+	}
+	if err = old.Release(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("过期后释放应返回 ErrLockNotHeld: %+v", err)
+	}
+	if err = l.Refresh(ctx, 0); nil != err {
+		t.Fatalf("旧句柄不应影响新的持有者: %+v", err)
+	}
+	_ = l.Release()
+}
+
+func TestRedisLockWriterExpiresBeforeReader(t *testing.T) {
+	mr, rc := newTestRedis(t)
+	ctx := context.Background()
+	now := time.Now()
+	mr.SetTime(now)
+
+	// 同一个 owner 持有较长的读锁和较短的写锁，写锁不能继承读锁的过期时间
+	r, err := rc.TryAcquireReadLock(ctx, "k", RedisLockOptions{Owner: "a", Ttl: time.Hour})
+	if nil != err {
+		t.Fatalf("read: %+v", err)
+	}
+	w, err := rc.TryAcquireLock(ctx, "k", RedisLockOptions{Owner: "a", Ttl: time.Second})
+	if nil != err {
+		t.Fatalf("write: %+v", err)
+	}
+	mr.SetTime(now.Add(2 * time.Second))
+
+	other, err := rc.TryAcquireReadLock(ctx, "k", RedisLockOptions{Owner: "b"})
+	if nil != err {
+		t.Fatalf("写锁过期后其他 owner 应可获取读锁: %+v", err)
+	}
+	if err = w.Refresh(ctx, 0); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("写锁过期后续期应返回 ErrLockNotHeld: %+v", err)
+	}
+	_ = other.Release()
+	_ = r.Release()
+}
+
+func TestWithLockReleasesOnPanic(t *testing.T) {
+	_, rc := newTestRedis(t)
+	ctx := context.Background()
+
+	func() {
+		defer func() { _ = recover() }()
+		_ = rc.WithLock(ctx, "k", func(ctx context.Context, lock *RedisLock) error {
+			panic("boom")
+		})
+	}()
+
+	l, err := rc.TryAcquireLock(ctx, "k")
+	if nil != err {
+		t.Fatalf("fn panic 后锁应已释放: %+v", err)
+	}
+	_ = l.Release()
+}
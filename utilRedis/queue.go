@@ -0,0 +1,533 @@
+package utilRedis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathRand "math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hilaoyu/go-utils/utilLogger"
+	"github.com/redis/go-redis/v9"
+)
+
+const RedisQueueKeyPrefix = "utilRedisQueue_"
+
+var (
+	ErrRedisJobDuplicate = errors.New("redis job duplicate")
+	// ErrRedisJobNoRetry handler 返回包含它的错误时不再重试，直接进入死信
+	ErrRedisJobNoRetry = errors.New("redis job no retry")
+	// ErrRedisJobLost 执行中的任务被其它 worker 接管，handler 的 ctx 以它为 cause 取消
+	ErrRedisJobLost = errors.New("redis job lost")
+)
+
+// RedisQueueEncryptor 与 utilEnc.ApiDataEncryptor 的方法相同，utilEnc 的加密器可以直接使用
+// utilEnc 依赖 utilSsl，utilSsl 依赖 utilRedis，这里不能直接引用 utilEnc
+type RedisQueueEncryptor interface {
+	ApiDataEncrypt(data interface{}) (enStr string, err error)
+	ApiDataDecrypt(enStr string, v interface{}) (err error)
+}
+
+// 队列数据：{name}:stream 为待处理的任务，{name}:delayed 为延时和等待重试的任务(zset，score 为执行时间毫秒)
+// {name}:dead 为死信，{name}:unique:<key> 为唯一任务的占用标记，任务完成或进入死信后删除
+var (
+	redisQueueEnqueueScript = redis.NewScript(`
+if #KEYS > 2 then
+	if not redis.call('SET', KEYS[3], ARGV[4], 'NX', 'PX', ARGV[3]) then
+		return 0
+	end
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+else
+	redis.call('XADD', KEYS[1], '*', 'job', ARGV[1])
+end
+return 1
+`)
+
+	redisQueuePromoteScript = redis.NewScript(`
+local jobs = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, job in ipairs(jobs) do
+	redis.call('ZREM', KEYS[2], job)
+	redis.call('XADD', KEYS[1], '*', 'job', job)
+end
+return #jobs
+`)
+
+	redisQueueFinishScript = redis.NewScript(`
+if redis.call('XACK', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call('XDEL', KEYS[1], ARGV[2])
+if ARGV[3] == 'retry' then
+	redis.call('ZADD', KEYS[2], ARGV[5], ARGV[4])
+	if #KEYS > 3 and redis.call('GET', KEYS[4]) == ARGV[6] then
+		local ttl = tonumber(ARGV[8])
+		local pttl = redis.call('PTTL', KEYS[4])
+		if pttl >= 0 and pttl < ttl then
+			redis.call('PEXPIRE', KEYS[4], ttl)
+		end
+	end
+	return 1
+end
+if ARGV[3] == 'dead' then
+	if tonumber(ARGV[7]) > 0 then
+		redis.call('XADD', KEYS[3], 'MAXLEN', '~', ARGV[7], '*', 'job', ARGV[4])
+	else
+		redis.call('XADD', KEYS[3], '*', 'job', ARGV[4])
+	end
+end
+if #KEYS > 3 and redis.call('GET', KEYS[4]) == ARGV[6] then
+	redis.call('DEL', KEYS[4])
+end
+return 1
+`)
+
+	redisQueueRequeueScript = redis.NewScript(`
+if #redis.call('XRANGE', KEYS[1], ARGV[1], ARGV[1]) == 0 then
+	return 0
+end
+if #KEYS > 2 then
+	if not redis.call('SET', KEYS[3], ARGV[4], 'NX', 'PX', ARGV[3]) then
+		return -1
+	end
+end
+redis.call('XDEL', KEYS[1], ARGV[1])
+redis.call('XADD', KEYS[2], '*', 'job', ARGV[2])
+return 1
+`)
+
+	// 只刷新仍属于当前消费者的任务，已被其它 worker 接管或已确认时返回 0
+	// 保持原来的投递次数，接管时按投递次数计算崩溃次数
+	redisQueueHeartbeatScript = redis.NewScript(`
+local pending = redis.call('XPENDING', KEYS[1], ARGV[1], ARGV[3], ARGV[3], 1)
+if #pending == 0 or pending[1][2] ~= ARGV[2] then
+	return 0
+end
+redis.call('XCLAIM', KEYS[1], ARGV[1], ARGV[2], 0, ARGV[3], 'RETRYCOUNT', pending[1][4], 'JUSTID')
+return 1
+`)
+)
+
+const (
+	redisJobAck   = "ack"
+	redisJobRetry = "retry"
+	redisJobDead  = "dead"
+)
+
+// redisJobData 保存在 Redis 中的任务，Payload 为 JSON，设置了加密器时为密文
+type redisJobData struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Payload    string `json:"payload"`
+	Encrypted  bool   `json:"encrypted,omitempty"`
+	Attempts   int    `json:"attempts"`
+	MaxRetries int    `json:"max_retries"`
+	UniqueKey  string `json:"unique_key,omitempty"`
+	UniqueTtl  int64  `json:"unique_ttl,omitempty"`
+	EnqueuedAt int64  `json:"enqueued_at"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+// RedisJob Attempts 为已经失败的次数，MessageId 为 stream 中的 id
+type RedisJob struct {
+	Id         string
+	Name       string
+	Payload    []byte
+	Attempts   int
+	MaxRetries int
+	UniqueKey  string
+	EnqueuedAt time.Time
+	LastError  string
+	MessageId  string
+
+	data redisJobData
+}
+
+// Decode 将 JSON payload 解析到 v
+func (j *RedisJob) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// RedisJobOptions Delay 和 RunAt 都设置时使用较晚的时间；UniqueKey 相同的任务未完成前不能重复加入
+// UniqueTtl 默认 24 小时，从执行时间开始计算，延时任务和等待重试的任务在执行前不会释放 UniqueKey
+type RedisJobOptions struct {
+	Delay      time.Duration
+	RunAt      time.Time
+	UniqueKey  string
+	UniqueTtl  time.Duration
+	MaxRetries int
+}
+
+// RedisQueueStats Waiting 为未被读取的任务数量，Processing 为已读取未确认的任务数量
+type RedisQueueStats struct {
+	Waiting    int64
+	Processing int64
+	Delayed    int64
+	Dead       int64
+}
+
+// RedisQueue 基于 Redis Streams 的任务队列，所有 key 使用相同的 hash tag，支持集群模式
+type RedisQueue struct {
+	rc          *RedisClient
+	name        string
+	group       string
+	maxRetries  int
+	backoffMin  time.Duration
+	backoffMax  time.Duration
+	claimIdle   time.Duration
+	deadMaxLen  int64
+	encryptor   RedisQueueEncryptor
+	logger      *utilLogger.Logger
+	streamKey   string
+	delayedKey  string
+	deadKey     string
+	uniqueKey   string
+	promoteSize int64
+}
+
+func (rc *RedisClient) NewQueue(name string) *RedisQueue {
+	base := RedisQueueKeyPrefix + "{" + name + "}"
+	return &RedisQueue{
+		rc:          rc,
+		name:        name,
+		group:       "workers",
+		maxRetries:  3,
+		backoffMin:  time.Duration(1) * time.Second,
+		backoffMax:  time.Duration(5) * time.Minute,
+		claimIdle:   time.Duration(5) * time.Minute,
+		deadMaxLen:  10000,
+		streamKey:   base + ":stream",
+		delayedKey:  base + ":delayed",
+		deadKey:     base + ":dead",
+		uniqueKey:   base + ":unique:",
+		promoteSize: 100,
+	}
+}
+
+func (q *RedisQueue) Name() string {
+	return q.name
+}
+
+func (q *RedisQueue) SetGroup(group string) *RedisQueue {
+	q.group = group
+	return q
+}
+
+// SetMaxRetries 任务失败后的最大重试次数，可以被 RedisJobOptions.MaxRetries 覆盖
+func (q *RedisQueue) SetMaxRetries(maxRetries int) *RedisQueue {
+	q.maxRetries = maxRetries
+	return q
+}
+
+// SetBackoff 重试间隔从 min 开始每次翻倍，不超过 max
+func (q *RedisQueue) SetBackoff(min time.Duration, max time.Duration) *RedisQueue {
+	q.backoffMin = min
+	q.backoffMax = max
+	return q
+}
+
+// SetClaimIdle 已读取的任务超过这个时间没有确认(worker 崩溃)时由其它 worker 接管
+// 任务执行期间会定期刷新，长时间运行的任务不会被接管
+func (q *RedisQueue) SetClaimIdle(idle time.Duration) *RedisQueue {
+	q.claimIdle = idle
+	return q
+}
+
+// SetDeadMaxLen 死信的大约最大数量，<= 0 不限制
+func (q *RedisQueue) SetDeadMaxLen(maxLen int64) *RedisQueue {
+	q.deadMaxLen = maxLen
+	return q
+}
+
+// SetEncryptor 设置后 payload 加密保存，生产者和消费者需要使用相同的密钥
+func (q *RedisQueue) SetEncryptor(encryptor RedisQueueEncryptor) *RedisQueue {
+	q.encryptor = encryptor
+	return q
+}
+
+// SetLogger 不设置时使用 utilLogger 的默认 logger
+func (q *RedisQueue) SetLogger(logger *utilLogger.Logger) *RedisQueue {
+	q.logger = logger
+	return q
+}
+
+func redisQueueUniqueTtl(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return time.Duration(24) * time.Hour
+	}
+	return ttl
+}
+
+// redisQueueUniqueExpire UniqueKey 的过期时间(毫秒)，延时到 runAt 执行的任务加上等待的时间
+func redisQueueUniqueExpire(uniqueTtl int64, runAt int64) int64 {
+	ttl := redisQueueUniqueTtl(time.Duration(uniqueTtl) * time.Millisecond).Milliseconds()
+	if runAt > 0 {
+		ttl += max(runAt-time.Now().UnixMilli(), 0)
+	}
+	return ttl
+}
+
+func newRedisJobId() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Enqueue 加入任务，payload 编码为 JSON；UniqueKey 已被占用时返回 ErrRedisJobDuplicate
+func (q *RedisQueue) Enqueue(ctx context.Context, name string, payload interface{}, options ...RedisJobOptions) (id string, err error) {
+	opts := RedisJobOptions{}
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	data, err := json.Marshal(payload)
+	if nil != err {
+		return "", fmt.Errorf("redis job payload json error: %+v", err)
+	}
+	job := redisJobData{
+		Id:         newRedisJobId(),
+		Name:       name,
+		Payload:    string(data),
+		MaxRetries: q.maxRetries,
+		UniqueKey:  opts.UniqueKey,
+		EnqueuedAt: time.Now().UnixMilli(),
+	}
+	if opts.MaxRetries > 0 {
+		job.MaxRetries = opts.MaxRetries
+	}
+	if "" != opts.UniqueKey {
+		job.UniqueTtl = redisQueueUniqueTtl(opts.UniqueTtl).Milliseconds()
+	}
+	if nil != q.encryptor {
+		job.Payload, err = q.encryptor.ApiDataEncrypt(job.Payload)
+		if nil != err {
+			return "", fmt.Errorf("redis job payload encrypt error: %+v", err)
+		}
+		job.Encrypted = true
+	}
+	enData, err := json.Marshal(job)
+	if nil != err {
+		return "", fmt.Errorf("redis job json error: %+v", err)
+	}
+
+	var runAt int64
+	at := opts.RunAt
+	if opts.Delay > 0 && time.Now().Add(opts.Delay).After(at) {
+		at = time.Now().Add(opts.Delay)
+	}
+	if at.After(time.Now()) {
+		runAt = at.UnixMilli()
+	}
+	keys := []string{q.streamKey, q.delayedKey}
+	if "" != opts.UniqueKey {
+		keys = append(keys, q.uniqueKey+opts.UniqueKey)
+	}
+	n, err := redisQueueEnqueueScript.Run(ctx, q.rc.Client, keys, enData, runAt, redisQueueUniqueExpire(job.UniqueTtl, runAt), job.Id).Int64()
+	if nil != err {
+		return "", fmt.Errorf("redis queue %s enqueue error: %+v", q.name, err)
+	}
+	if 0 == n {
+		return "", fmt.Errorf("%w: %s", ErrRedisJobDuplicate, opts.UniqueKey)
+	}
+	return job.Id, nil
+}
+
+// promote 将到期的延时任务移到 stream
+func (q *RedisQueue) promote(ctx context.Context) (n int64, err error) {
+	return redisQueuePromoteScript.Run(ctx, q.rc.Client, []string{q.streamKey, q.delayedKey}, time.Now().UnixMilli(), q.promoteSize).Int64()
+}
+
+func (q *RedisQueue) decodeJob(messageId string, enData string) (job *RedisJob, err error) {
+	data := redisJobData{}
+	if err = json.Unmarshal([]byte(enData), &data); nil != err {
+		return nil, fmt.Errorf("redis job json error: %+v", err)
+	}
+	job = &RedisJob{
+		Id:         data.Id,
+		Name:       data.Name,
+		Payload:    []byte(data.Payload),
+		Attempts:   data.Attempts,
+		MaxRetries: data.MaxRetries,
+		UniqueKey:  data.UniqueKey,
+		EnqueuedAt: time.UnixMilli(data.EnqueuedAt),
+		LastError:  data.LastError,
+		MessageId:  messageId,
+		data:       data,
+	}
+	if data.Encrypted {
+		if nil == q.encryptor {
+			return job, fmt.Errorf("redis job %s payload is encrypted but queue has no encryptor", data.Id)
+		}
+		payload := ""
+		if err = q.encryptor.ApiDataDecrypt(data.Payload, &payload); nil != err {
+			return job, fmt.Errorf("redis job %s payload decrypt error: %+v", data.Id, err)
+		}
+		job.Payload = []byte(payload)
+	}
+	return job, nil
+}
+
+// encode 重新编码任务，payload 保持原样(密文不重新加密)
+func (j *RedisJob) encode() string {
+	j.data.Attempts = j.Attempts
+	j.data.LastError = j.LastError
+	enData, _ := json.Marshal(j.data)
+	return string(enData)
+}
+
+func (q *RedisQueue) backoff(attempts int) time.Duration {
+	d := q.backoffMin
+	for i := 1; i < attempts && d < q.backoffMax; i++ {
+		d *= 2
+	}
+	d = min(d, q.backoffMax)
+	if d <= 0 {
+		return 0
+	}
+	// 随机抖动，避免同时失败的任务同时重试
+	return d/2 + mathRand.N(d/2+1)
+}
+
+// finish 重试时 UniqueKey 至少保留 uniqueExpire 毫秒
+func (q *RedisQueue) finish(ctx context.Context, messageId string, action string, enData string, runAt int64, jobId string, uniqueKey string, uniqueExpire int64) (ok bool, err error) {
+	keys := []string{q.streamKey, q.delayedKey, q.deadKey}
+	if "" != uniqueKey {
+		keys = append(keys, q.uniqueKey+uniqueKey)
+	}
+	n, err := redisQueueFinishScript.Run(ctx, q.rc.Client, keys, q.group, messageId, action, enData, runAt, jobId, q.deadMaxLen, uniqueExpire).Int64()
+	if nil != err {
+		return false, fmt.Errorf("redis queue %s %s job %s error: %+v", q.name, action, jobId, err)
+	}
+	return n > 0, nil
+}
+
+// ensureGroup 创建消费组，stream 不存在时一起创建
+func (q *RedisQueue) ensureGroup(ctx context.Context) error {
+	err := q.rc.Client.XGroupCreateMkStream(ctx, q.streamKey, q.group, "0").Err()
+	if nil != err && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("redis queue %s create group error: %+v", q.name, err)
+	}
+	return nil
+}
+
+func (q *RedisQueue) Stats(ctx context.Context) (stats RedisQueueStats, err error) {
+	pipe := q.rc.Client.Pipeline()
+	streamLen := pipe.XLen(ctx, q.streamKey)
+	pending := pipe.XPending(ctx, q.streamKey, q.group)
+	delayed := pipe.ZCard(ctx, q.delayedKey)
+	dead := pipe.XLen(ctx, q.deadKey)
+	_, _ = pipe.Exec(ctx)
+
+	if err = streamLen.Err(); nil != err {
+		return stats, fmt.Errorf("redis queue %s stats error: %+v", q.name, err)
+	}
+	// 还没有 worker 时消费组不存在
+	if e := pending.Err(); nil != e && !strings.HasPrefix(e.Error(), "NOGROUP") {
+		return stats, fmt.Errorf("redis queue %s stats error: %+v", q.name, e)
+	} else if nil == e {
+		stats.Processing = pending.Val().Count
+	}
+	if err = delayed.Err(); nil != err {
+		return stats, fmt.Errorf("redis queue %s stats error: %+v", q.name, err)
+	}
+	if err = dead.Err(); nil != err {
+		return stats, fmt.Errorf("redis queue %s stats error: %+v", q.name, err)
+	}
+	stats.Waiting = max(streamLen.Val()-stats.Processing, 0)
+	stats.Delayed = delayed.Val()
+	stats.Dead = dead.Val()
+	return
+}
+
+// DeadJobs 返回最新的 count 个死信，MessageId 用于 RetryDeadJob / DeleteDeadJob
+func (q *RedisQueue) DeadJobs(ctx context.Context, count int64) (jobs []*RedisJob, err error) {
+	messages, err := q.rc.Client.XRevRangeN(ctx, q.deadKey, "+", "-", count).Result()
+	if nil != err {
+		return nil, fmt.Errorf("redis queue %s dead jobs error: %+v", q.name, err)
+	}
+	for _, msg := range messages {
+		enData, _ := msg.Values["job"].(string)
+		job, e := q.decodeJob(msg.ID, enData)
+		if nil == job {
+			// 无法解析的任务也返回，方便查看和删除
+			job = &RedisJob{MessageId: msg.ID, Payload: []byte(enData)}
+		}
+		if nil != e && "" == job.LastError {
+			job.LastError = e.Error()
+		}
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+// RetryDeadJob 将死信重新加入队列，失败次数清零；唯一任务重新占用 UniqueKey，已被其它任务占用时返回 ErrRedisJobDuplicate
+func (q *RedisQueue) RetryDeadJob(ctx context.Context, messageId string) error {
+	messages, err := q.rc.Client.XRangeN(ctx, q.deadKey, messageId, messageId, 1).Result()
+	if nil != err {
+		return fmt.Errorf("redis queue %s retry dead job error: %+v", q.name, err)
+	}
+	if len(messages) <= 0 {
+		return fmt.Errorf("redis queue %s dead job %s not found", q.name, messageId)
+	}
+	enData, _ := messages[0].Values["job"].(string)
+	data := redisJobData{}
+	if err = json.Unmarshal([]byte(enData), &data); nil != err {
+		return fmt.Errorf("redis job json error: %+v", err)
+	}
+	data.Attempts = 0
+	b, _ := json.Marshal(data)
+	keys := []string{q.deadKey, q.streamKey}
+	if "" != data.UniqueKey {
+		keys = append(keys, q.uniqueKey+data.UniqueKey)
+	}
+	uniqueTtl := redisQueueUniqueTtl(time.Duration(data.UniqueTtl) * time.Millisecond)
+	n, err := redisQueueRequeueScript.Run(ctx, q.rc.Client, keys, messageId, b, uniqueTtl.Milliseconds(), data.Id).Int64()
+	if nil != err {
+		return fmt.Errorf("redis queue %s retry dead job error: %+v", q.name, err)
+	}
+	if 0 == n {
+		return fmt.Errorf("redis queue %s dead job %s not found", q.name, messageId)
+	}
+	if n < 0 {
+		return fmt.Errorf("%w: %s", ErrRedisJobDuplicate, data.UniqueKey)
+	}
+	return nil
+}
+
+func (q *RedisQueue) DeleteDeadJob(ctx context.Context, messageId string) error {
+	return q.rc.Client.XDel(ctx, q.deadKey, messageId).Err()
+}
+
+func (q *RedisQueue) logInfo(format string, a ...any) {
+	if nil == q.logger {
+		utilLogger.InfoF(format, a...)
+		return
+	}
+	q.logger.InfoF(format, a...)
+}
+
+func (q *RedisQueue) logWarn(format string, a ...any) {
+	if nil == q.logger {
+		utilLogger.WarnF(format, a...)
+		return
+	}
+	q.logger.WarnF(format, a...)
+}
+
+func (q *RedisQueue) logError(format string, a ...any) {
+	if nil == q.logger {
+		utilLogger.ErrorF(format, a...)
+		return
+	}
+	q.logger.ErrorF(format, a...)
+}
+
+func defaultRedisQueueConsumer() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), newRedisJobId()[:8])
+}
@@ -0,0 +1,310 @@
+package utilRedis
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startTestWorker(t *testing.T, w *RedisQueueWorker) {
+	w.SetPollInterval(time.Millisecond * 20).SetShutdownTimeout(time.Second)
+	if err := w.Start(context.Background()); nil != err {
+		t.Fatalf("start: %+v", err)
+	}
+	t.Cleanup(func() { _ = w.Stop() })
+}
+
+func waitFor(t *testing.T, timeout time.Duration, msg string, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("超时: %s", msg)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestRedisQueueRetryThenDead(t *testing.T) {
+	_, rc := newTestRedis(t)
+	ctx := context.Background()
+	q := rc.NewQueue("q").SetMaxRetries(2).SetBackoff(time.Millisecond*30, time.Millisecond*30)
+
+	var calls atomic.Int32
+	var sawDelayed atomic.Bool
+	w := q.NewWorker().Handle("job", func(ctx context.Context, job *RedisJob) error {
+		if int(calls.Add(1)) != job.Attempts+1 {
+			t.Errorf("第 %d 次执行时 Attempts = %d", calls.Load(), job.Attempts)
+		}
+		return errors.New("fail")
+	})
+	if _, err := q.Enqueue(ctx, "job", map[string]int{"n": 1}); nil != err {
+		t.Fatalf("enqueue: %+v", err)
+	}
+	startTestWorker(t, w)
+
+	waitFor(t, 3*time.Second, "任务进入死信", func() bool {
+		stats, _ := q.Stats(ctx)
+		if stats.Delayed > 0 {
+			sawDelayed.Store(true)
+		}
+		return stats.Dead > 0
+	})
+	if 3 != calls.Load() {
+		t.Fatalf("重试 2 次后应执行 3 次，实际 %d", calls.Load())
+	}
+	if !sawDelayed.Load() {
+		t.Fatalf("重试前应进入延时队列")
+	}
+	dead, err := q.DeadJobs(ctx, 10)
+	if nil != err || 1 != len(dead) {
+		t.Fatalf("dead jobs: %+v %+v", dead, err)
+	}
+	if 3 != dead[0].Attempts || "fail" != dead[0].LastError {
+		t.Fatalf("死信 = %+v", dead[0])
+	}
+	stats, _ := q.Stats(ctx)
+	if 0 != stats.Waiting || 0 != stats.Processing || 0 != stats.Delayed {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestRedisQueueUniqueJob(t *testing.T) {
+	_, rc := newTestRedis(t)
+	ctx := context.Background()
+	q := rc.NewQueue("q")
+	opts := RedisJobOptions{UniqueKey: "u", Delay: time.Hour}
+
+	if _, err := q.Enqueue(ctx, "job", 1, opts); nil != err {
+		t.Fatalf("enqueue: %+v", err)
+	}
+	if _, err := q.Enqueue(ctx, "job", 2, opts); !errors.Is(err, ErrRedisJobDuplicate) {
+		t.Fatalf("未完成前重复加入应返回 ErrRedisJobDuplicate: %+v", err)
+	}
+
+	// 任务进入死信后释放 UniqueKey，重试死信时需要重新占用
+	q2 := rc.NewQueue("q2")
+	done := make(chan string, 4)
+	w := q2.NewWorker().Handle("job", func(ctx context.Context, job *RedisJob) error {
+		done <- job.Id
+		var n int
+		_ = job.Decode(&n)
+		if 1 == n {
+			return ErrRedisJobNoRetry
+		}
+		return nil
+	})
+	startTestWorker(t, w)
+	first, err := q2.Enqueue(ctx, "job", 1, RedisJobOptions{UniqueKey: "u"})
+	if nil != err {
+		t.Fatalf("enqueue: %+v", err)
+	}
+	<-done
+	var dead []*RedisJob
+	waitFor(t, 3*time.Second, "任务进入死信", func() bool {
+		dead, _ = q2.DeadJobs(ctx, 10)
+		return len(dead) > 0
+	})
+
+	// 另一个相同 UniqueKey 的任务占用期间不能重试死信
+	_ = w.Stop()
+	second, err := q2.Enqueue(ctx, "job", 2, RedisJobOptions{UniqueKey: "u"})
+	if nil != err {
+		t.Fatalf("进入死信后应可再次加入: %+v", err)
+	}
+	if err = q2.RetryDeadJob(ctx, dead[0].MessageId); !errors.Is(err, ErrRedisJobDuplicate) {
+		t.Fatalf("UniqueKey 被占用时重试死信应返回 ErrRedisJobDuplicate: %+v", err)
+	}
+	if jobs, _ := q2.DeadJobs(ctx, 10); 1 != len(jobs) {
+		t.Fatalf("重试失败时死信应保留")
+	}
+
+	w = q2.NewWorker().Handle("job", func(ctx context.Context, job *RedisJob) error {
+		done <- job.Id
+		return nil
+	})
+	startTestWorker(t, w)
+	if id := <-done; second != id {
+		t.Fatalf("执行了 %s, 应为 %s", id, second)
+	}
+	waitFor(t, 3*time.Second, "唯一任务完成后释放", func() bool {
+		return 0 == rc.Client.Exists(ctx, q2.uniqueKey+"u").Val()
+	})
+	if err = q2.RetryDeadJob(ctx, dead[0].MessageId); nil != err {
+		t.Fatalf("retry dead job: %+v", err)
+	}
+	if id := <-done; first != id {
+		t.Fatalf("执行了 %s, 应为 %s", id, first)
+	}
+}
+
+func TestRedisQueueClaimIdleJob(t *testing.T) {
+	_, rc := newTestRedis(t)
+	ctx := context.Background()
+	q := rc.NewQueue("q").SetClaimIdle(time.Millisecond * 100)
+	if err := q.ensureGroup(ctx); nil != err {
+		t.Fatalf("group: %+v", err)
+	}
+	id, err := q.Enqueue(ctx, "job", 1)
+	if nil != err {
+		t.Fatalf("enqueue: %+v", err)
+	}
+	// 模拟读取后崩溃的 worker
+	if err = rc.Client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: q.group, Consumer: "crashed", Streams: []string{q.streamKey, ">"}, Count: 1}).Err(); nil != err {
+		t.Fatalf("read: %+v", err)
+	}
+
+	jobs := make(chan *RedisJob, 1)
+	w := q.NewWorker().Handle("job", func(ctx context.Context, job *RedisJob) error {
+		jobs <- job
+		return nil
+	})
+	startTestWorker(t, w)
+	select {
+	case job := <-jobs:
+		if id != job.Id || 1 != job.Attempts {
+			t.Fatalf("接管的任务 = %+v", job)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("没有接管空闲的任务")
+	}
+	waitFor(t, 3*time.Second, "接管的任务被确认", func() bool {
+		stats, _ := q.Stats(ctx)
+		return 0 == stats.Processing
+	})
+}
+
+func TestRedisQueueHeartbeatDoesNotStealBack(t *testing.T) {
+	_, rc := newTestRedis(t)
+	ctx := context.Background()
+	q := rc.NewQueue("q").SetClaimIdle(time.Millisecond * 300)
+
+	started := make(chan *RedisJob, 1)
+	causes := make(chan error, 1)
+	w := q.NewWorker().Handle("job", func(ctx context.Context, job *RedisJob) error {
+		started <- job
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		return ctx.Err()
+	})
+	startTestWorker(t, w)
+	if _, err := q.Enqueue(ctx, "job", 1); nil != err {
+		t.Fatalf("enqueue: %+v", err)
+	}
+	job := <-started
+
+	// 其它 worker 接管了执行中的任务
+	err := rc.Client.XClaimJustID(ctx, &redis.XClaimArgs{Stream: q.streamKey, Group: q.group, Consumer: "other", Messages: []string{job.MessageId}}).Err()
+	if nil != err {
+		t.Fatalf("claim: %+v", err)
+	}
+	select {
+	case cause := <-causes:
+		if !errors.Is(cause, ErrRedisJobLost) {
+			t.Fatalf("cause = %+v", cause)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("任务被接管后 handler 的 ctx 应取消")
+	}
+	pending, err := rc.Client.XPendingExt(ctx, &redis.XPendingExtArgs{Stream: q.streamKey, Group: q.group, Start: job.MessageId, End: job.MessageId, Count: 1}).Result()
+	if nil != err || 1 != len(pending) || "other" != pending[0].Consumer {
+		t.Fatalf("任务应仍属于接管的 worker: %+v %+v", pending, err)
+	}
+}
+
+func TestRedisQueueClaimedJobHeartbeatWhileWaiting(t *testing.T) {
+	_, rc := newTestRedis(t)
+	ctx := context.Background()
+	q := rc.NewQueue("q").SetClaimIdle(time.Millisecond * 300)
+	if err := q.ensureGroup(ctx); nil != err {
+		t.Fatalf("group: %+v", err)
+	}
+	_, err := q.Enqueue(ctx, "ghost", 1)
+	if nil != err {
+		t.Fatalf("enqueue: %+v", err)
+	}
+	if err = rc.Client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: q.group, Consumer: "crashed", Streams: []string{q.streamKey, ">"}, Count: 1}).Err(); nil != err {
+		t.Fatalf("read: %+v", err)
+	}
+
+	runs := make(chan string, 4)
+	release := make(chan struct{})
+	a := q.NewWorker().SetConsumer("a").Handle("", func(ctx context.Context, job *RedisJob) error {
+		if "slow" == job.Name {
+			<-release
+			return nil
+		}
+		runs <- "a"
+		return nil
+	})
+	startTestWorker(t, a)
+	if _, err = q.Enqueue(ctx, "slow", 1); nil != err {
+		t.Fatalf("enqueue: %+v", err)
+	}
+
+	// a 唯一的 fetcher 在执行 slow，接管的 ghost 在 channel 中等待
+	waitFor(t, 3*time.Second, "a 接管 ghost", func() bool {
+		pending, _ := rc.Client.XPendingExt(ctx, &redis.XPendingExtArgs{Stream: q.streamKey, Group: q.group, Start: "-", End: "+", Count: 10, Consumer: "a"}).Result()
+		return 2 == len(pending)
+	})
+	b := q.NewWorker().SetConsumer("b").Handle("", func(ctx context.Context, job *RedisJob) error {
+		runs <- "b"
+		return nil
+	})
+	startTestWorker(t, b)
+
+	// 等待时间超过 claimIdle，心跳使 b 不能接管
+	time.Sleep(time.Millisecond * 900)
+	close(release)
+	select {
+	case consumer := <-runs:
+		if "a" != consumer {
+			t.Fatalf("ghost 由 %s 执行，应由接管它的 a 执行", consumer)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("ghost 没有执行")
+	}
+	time.Sleep(time.Millisecond * 100)
+	if 0 != len(runs) {
+		t.Fatalf("ghost 被重复执行")
+	}
+}
+
+func TestRedisQueueUniqueKeyCoversRunAt(t *testing.T) {
+	mr, rc := newTestRedis(t)
+	ctx := context.Background()
+	q := rc.NewQueue("q").SetBackoff(time.Hour, time.Hour)
+
+	_, err := q.Enqueue(ctx, "job", 1, RedisJobOptions{UniqueKey: "delayed", UniqueTtl: time.Minute, RunAt: time.Now().Add(2 * time.Hour)})
+	if nil != err {
+		t.Fatalf("enqueue: %+v", err)
+	}
+	if ttl := mr.TTL(q.uniqueKey + "delayed"); ttl <= 2*time.Hour || ttl > 2*time.Hour+time.Minute {
+		t.Fatalf("延时任务的 UniqueKey 应保留到执行时间之后: %s", ttl)
+	}
+
+	// 失败后等待重试期间也不释放
+	failed := make(chan struct{}, 1)
+	w := q.NewWorker().Handle("job", func(ctx context.Context, job *RedisJob) error {
+		failed <- struct{}{}
+		return errors.New("fail")
+	})
+	startTestWorker(t, w)
+	if _, err = q.Enqueue(ctx, "job", 2, RedisJobOptions{UniqueKey: "retry", UniqueTtl: time.Second}); nil != err {
+		t.Fatalf("enqueue: %+v", err)
+	}
+	<-failed
+	waitFor(t, 3*time.Second, "任务进入重试", func() bool {
+		stats, _ := q.Stats(ctx)
+		return 2 == stats.Delayed
+	})
+	if ttl := mr.TTL(q.uniqueKey + "retry"); ttl < 30*time.Minute {
+		t.Fatalf("等待重试的任务的 UniqueKey 应保留到重试之后: %s", ttl)
+	}
+	mr.FastForward(2 * time.Second)
+	if _, err = q.Enqueue(ctx, "job", 3, RedisJobOptions{UniqueKey: "retry"}); !errors.Is(err, ErrRedisJobDuplicate) {
+		t.Fatalf("等待重试期间重复加入应返回 ErrRedisJobDuplicate: %+v", err)
+	}
+}
@@ -0,0 +1,444 @@
+package utilRedis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisJobHandler 返回 nil 表示任务完成，返回错误时按退避重试，超过重试次数后进入死信
+type RedisJobHandler func(ctx context.Context, job *RedisJob) error
+
+// redisQueueMessage 读取或接管的任务，心跳从这时开始，接管后在 channel 中等待执行期间也不会被其它 worker 再次接管
+type redisQueueMessage struct {
+	msg     redis.XMessage
+	claimed bool
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+}
+
+// RedisQueueWorker 从队列读取任务并发执行，同时负责把到期的延时任务移到 stream、接管崩溃 worker 未确认的任务
+type RedisQueueWorker struct {
+	queue           *RedisQueue
+	handlers        map[string]RedisJobHandler
+	consumer        string
+	concurrency     int
+	block           time.Duration
+	pollInterval    time.Duration
+	shutdownTimeout time.Duration
+
+	baseCtx   context.Context
+	jobCtx    context.Context
+	jobCancel context.CancelFunc
+	stopping  chan struct{}
+	claimed   chan *redisQueueMessage
+	wg        sync.WaitGroup
+	started   bool
+	stopped   bool
+	locker    sync.Mutex
+}
+
+func (q *RedisQueue) NewWorker() *RedisQueueWorker {
+	return &RedisQueueWorker{
+		queue:           q,
+		handlers:        map[string]RedisJobHandler{},
+		consumer:        defaultRedisQueueConsumer(),
+		concurrency:     1,
+		block:           time.Duration(1) * time.Second,
+		pollInterval:    time.Duration(1) * time.Second,
+		shutdownTimeout: time.Duration(30) * time.Second,
+	}
+}
+
+// Handle 注册任务处理函数，name 为空时处理所有没有注册的任务
+func (w *RedisQueueWorker) Handle(name string, handler RedisJobHandler) *RedisQueueWorker {
+	w.handlers[name] = handler
+	return w
+}
+
+// SetConsumer 消费者名称，默认为 主机名-pid-随机数
+func (w *RedisQueueWorker) SetConsumer(consumer string) *RedisQueueWorker {
+	w.consumer = consumer
+	return w
+}
+
+func (w *RedisQueueWorker) SetConcurrency(concurrency int) *RedisQueueWorker {
+	w.concurrency = max(concurrency, 1)
+	return w
+}
+
+// SetPollInterval 检查延时任务和未确认任务的间隔，同时也是读取任务时最长的阻塞时间
+func (w *RedisQueueWorker) SetPollInterval(interval time.Duration) *RedisQueueWorker {
+	if interval > 0 {
+		w.pollInterval = interval
+		w.block = interval
+	}
+	return w
+}
+
+// SetShutdownTimeout Stop 时等待执行中的任务的时间，超时后取消任务的 ctx，<= 0 时一直等待
+func (w *RedisQueueWorker) SetShutdownTimeout(timeout time.Duration) *RedisQueueWorker {
+	w.shutdownTimeout = timeout
+	return w
+}
+
+func (w *RedisQueueWorker) Consumer() string {
+	return w.consumer
+}
+
+// Start 启动 worker，ctx 取消时等同于调用 Stop
+func (w *RedisQueueWorker) Start(ctx context.Context) (err error) {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	if w.started {
+		return fmt.Errorf("redis queue %s worker already started", w.queue.name)
+	}
+	if len(w.handlers) <= 0 {
+		return fmt.Errorf("redis queue %s worker has no handler", w.queue.name)
+	}
+	// redis 操作不随 ctx 取消，停止时读取中的任务可以正常返回
+	w.baseCtx = context.WithoutCancel(ctx)
+	if err = w.queue.ensureGroup(w.baseCtx); nil != err {
+		return
+	}
+	w.jobCtx, w.jobCancel = context.WithCancel(w.baseCtx)
+	w.stopping = make(chan struct{})
+	// 有缓冲，接管时不阻塞 maintain，缓冲满时留到下一次接管
+	w.claimed = make(chan *redisQueueMessage, w.concurrency)
+	w.started = true
+
+	for i := 0; i < w.concurrency; i++ {
+		w.wg.Go(w.fetch)
+	}
+	w.wg.Go(w.maintain)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = w.Stop()
+		case <-w.stopping:
+		}
+	}()
+	w.queue.logInfo("redis queue %s worker %s started, concurrency %d", w.queue.name, w.consumer, w.concurrency)
+	return nil
+}
+
+// Stop 停止读取新任务并等待执行中的任务完成，超过 shutdownTimeout 时取消任务的 ctx 并继续等待
+func (w *RedisQueueWorker) Stop() error {
+	w.locker.Lock()
+	if !w.started || w.stopped {
+		w.locker.Unlock()
+		return nil
+	}
+	w.stopped = true
+	close(w.stopping)
+	w.locker.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	var err error
+	if w.shutdownTimeout > 0 {
+		select {
+		case <-done:
+		case <-time.After(w.shutdownTimeout):
+			err = fmt.Errorf("redis queue %s worker %s shutdown timeout, running jobs canceled", w.queue.name, w.consumer)
+			w.queue.logWarn("%+v", err)
+			w.jobCancel()
+		}
+	}
+	<-done
+	w.jobCancel()
+	w.queue.logInfo("redis queue %s worker %s stopped", w.queue.name, w.consumer)
+	return err
+}
+
+// Wait 等待 worker 停止
+func (w *RedisQueueWorker) Wait() {
+	w.locker.Lock()
+	started := w.started
+	w.locker.Unlock()
+	if !started {
+		return
+	}
+	<-w.stopping
+	w.wg.Wait()
+}
+
+func (w *RedisQueueWorker) isStopping() bool {
+	select {
+	case <-w.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *RedisQueueWorker) sleep(d time.Duration) {
+	select {
+	case <-w.stopping:
+	case <-time.After(d):
+	}
+}
+
+func (w *RedisQueueWorker) fetch() {
+	q := w.queue
+	for !w.isStopping() {
+		select {
+		case m := <-w.claimed:
+			w.process(m)
+			continue
+		default:
+		}
+		streams, err := q.rc.Client.XReadGroup(w.baseCtx, &redis.XReadGroupArgs{
+			Group:    q.group,
+			Consumer: w.consumer,
+			Streams:  []string{q.streamKey, ">"},
+			Count:    1,
+			Block:    w.block,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if nil != err {
+			// stream 被删除后消费组也不存在，重新创建
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				err = q.ensureGroup(w.baseCtx)
+			}
+			if nil != err {
+				q.logError("redis queue %s read error: %+v", q.name, err)
+				w.sleep(w.pollInterval)
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				w.process(w.newMessage(msg, false))
+			}
+		}
+	}
+}
+
+// maintain 定期移动到期的延时任务，接管空闲超过 claimIdle 的未确认任务
+func (w *RedisQueueWorker) maintain() {
+	q := w.queue
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	lastClaim := time.Time{}
+	for {
+		for {
+			n, err := q.promote(w.baseCtx)
+			if nil != err {
+				q.logError("redis queue %s promote delayed jobs error: %+v", q.name, err)
+			}
+			if n < q.promoteSize {
+				break
+			}
+		}
+		if q.claimIdle > 0 && time.Since(lastClaim) >= q.claimIdle/2 {
+			lastClaim = time.Now()
+			w.claim()
+		}
+		select {
+		case <-w.stopping:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *RedisQueueWorker) claim() {
+	q := w.queue
+	start := "0-0"
+	for {
+		free := cap(w.claimed) - len(w.claimed)
+		if free <= 0 {
+			return
+		}
+		messages, next, err := q.rc.Client.XAutoClaim(w.baseCtx, &redis.XAutoClaimArgs{
+			Stream:   q.streamKey,
+			Group:    q.group,
+			Consumer: w.consumer,
+			MinIdle:  q.claimIdle,
+			Start:    start,
+			Count:    int64(free),
+		}).Result()
+		if nil != err {
+			if !strings.HasPrefix(err.Error(), "NOGROUP") {
+				q.logError("redis queue %s claim jobs error: %+v", q.name, err)
+			}
+			return
+		}
+		for _, msg := range messages {
+			q.logWarn("redis queue %s claimed idle job message %s", q.name, msg.ID)
+			m := w.newMessage(msg, true)
+			select {
+			case w.claimed <- m:
+			default:
+				// 只有 maintain 写入，按剩余容量接管不会走到这里；已接管未执行的任务留在待确认列表中，之后再被接管
+				m.cancel(context.Canceled)
+				return
+			}
+		}
+		if "0-0" == next || "" == next {
+			return
+		}
+		start = next
+	}
+}
+
+// newMessage 创建任务的 ctx 并开始心跳
+func (w *RedisQueueWorker) newMessage(msg redis.XMessage, claimed bool) *redisQueueMessage {
+	ctx, cancel := context.WithCancelCause(w.jobCtx)
+	if w.queue.claimIdle > 0 {
+		go w.heartbeat(ctx, cancel, msg.ID)
+	}
+	return &redisQueueMessage{msg: msg, claimed: claimed, ctx: ctx, cancel: cancel}
+}
+
+// heartbeat 等待和执行期间定期刷新空闲时间，避免长时间运行的任务被其它 worker 接管
+// 任务已被其它 worker 接管时不再抢回，以 ErrRedisJobLost 取消 handler 的 ctx
+func (w *RedisQueueWorker) heartbeat(ctx context.Context, cancel context.CancelCauseFunc, messageId string) {
+	q := w.queue
+	interval := max(q.claimIdle/3, time.Millisecond*10)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, err := redisQueueHeartbeatScript.Run(w.baseCtx, q.rc.Client, []string{q.streamKey}, q.group, w.consumer, messageId).Int64()
+		if nil != err {
+			q.logWarn("redis queue %s job message %s heartbeat error: %+v", q.name, messageId, err)
+			continue
+		}
+		if 0 == n {
+			q.logWarn("redis queue %s job message %s was claimed by another worker, canceling", q.name, messageId)
+			cancel(ErrRedisJobLost)
+			return
+		}
+	}
+}
+
+// process 确认或重试前先停止心跳，避免把已确认的任务当作被其它 worker 接管
+func (w *RedisQueueWorker) process(m *redisQueueMessage) {
+	q := w.queue
+	// 等待执行期间已被其它 worker 接管，由接管的 worker 处理
+	if errors.Is(context.Cause(m.ctx), ErrRedisJobLost) {
+		return
+	}
+	msg := m.msg
+	enData, _ := msg.Values["job"].(string)
+	job, err := q.decodeJob(msg.ID, enData)
+	if nil != err {
+		m.cancel(context.Canceled)
+		q.logError("redis queue %s job message %s moved to dead: %+v", q.name, msg.ID, err)
+		jobId, uniqueKey := "", ""
+		if nil != job {
+			job.LastError = err.Error()
+			enData = job.encode()
+			jobId, uniqueKey = job.Id, job.UniqueKey
+		}
+		if _, err = q.finish(w.baseCtx, msg.ID, redisJobDead, enData, 0, jobId, uniqueKey, 0); nil != err {
+			q.logError("%+v", err)
+		}
+		return
+	}
+
+	if m.claimed {
+		// 被接管说明之前执行的 worker 没有完成，每次接管按一次失败计算，投递次数减 1 为接管次数
+		crashes := 1
+		pending, e := q.rc.Client.XPendingExt(w.baseCtx, &redis.XPendingExtArgs{
+			Stream: q.streamKey,
+			Group:  q.group,
+			Start:  msg.ID,
+			End:    msg.ID,
+			Count:  1,
+		}).Result()
+		if nil == e && len(pending) > 0 {
+			crashes = max(int(pending[0].RetryCount)-1, 1)
+		}
+		err = fmt.Errorf("job claimed after consumer idle %s", q.claimIdle)
+		if job.Attempts+crashes > job.MaxRetries {
+			job.Attempts += crashes - 1
+			m.cancel(context.Canceled)
+			w.fail(job, err)
+			return
+		}
+		job.Attempts += crashes
+		job.LastError = err.Error()
+	}
+
+	handler, ok := w.handlers[job.Name]
+	if !ok {
+		handler, ok = w.handlers[""]
+	}
+	if !ok {
+		m.cancel(context.Canceled)
+		w.fail(job, fmt.Errorf("%w: no handler for job %s", ErrRedisJobNoRetry, job.Name))
+		return
+	}
+
+	err = w.run(m, handler, job)
+	if errors.Is(err, ErrRedisJobLost) {
+		// 由接管的 worker 负责确认或重试
+		return
+	}
+	if nil != err {
+		w.fail(job, err)
+		return
+	}
+	ok, err = q.finish(w.baseCtx, job.MessageId, redisJobAck, "", 0, job.Id, job.UniqueKey, 0)
+	if nil != err {
+		q.logError("%+v", err)
+	} else if !ok {
+		q.logWarn("redis queue %s job %s was already acknowledged, it may have been claimed by another worker", q.name, job.Id)
+	}
+}
+
+func (w *RedisQueueWorker) run(m *redisQueueMessage, handler RedisJobHandler, job *RedisJob) (err error) {
+	ctx := m.ctx
+	defer m.cancel(context.Canceled)
+	defer func() {
+		if r := recover(); nil != r {
+			err = fmt.Errorf("redis job %s panic: %v\n%s", job.Id, r, debug.Stack())
+		}
+		if errors.Is(context.Cause(ctx), ErrRedisJobLost) {
+			err = ErrRedisJobLost
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// fail 失败次数超过 MaxRetries 或错误包含 ErrRedisJobNoRetry 时进入死信，否则按退避时间重新加入延时队列
+func (w *RedisQueueWorker) fail(job *RedisJob, err error) {
+	q := w.queue
+	job.Attempts++
+	job.LastError = err.Error()
+	action := redisJobRetry
+	var runAt, uniqueExpire int64
+	if job.Attempts > job.MaxRetries || errors.Is(err, ErrRedisJobNoRetry) {
+		action = redisJobDead
+		q.logError("redis queue %s job %s(%s) failed %d times, moved to dead: %+v", q.name, job.Name, job.Id, job.Attempts, err)
+	} else {
+		delay := q.backoff(job.Attempts)
+		runAt = time.Now().Add(delay).UnixMilli()
+		uniqueExpire = redisQueueUniqueExpire(job.data.UniqueTtl, runAt)
+		q.logWarn("redis queue %s job %s(%s) failed %d times, retry after %s: %+v", q.name, job.Name, job.Id, job.Attempts, delay, err)
+	}
+	ok, e := q.finish(w.baseCtx, job.MessageId, action, job.encode(), runAt, job.Id, job.UniqueKey, uniqueExpire)
+	if nil != e {
+		q.logError("%+v", e)
+	} else if !ok {
+		q.logWarn("redis queue %s job %s was already acknowledged, it may have been claimed by another worker", q.name, job.Id)
+	}
+}
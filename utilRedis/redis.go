@@ -17,16 +17,17 @@ import (
 const ErrRedisNil = redis.Nil
 const LockerKeyPrefix = "utilRedisLock_"
 
+// Client go-redis 客户端，单机为 *redis.Client，哨兵为 *redis.Client 或 FailoverClusterClient，集群为 *redis.ClusterClient
+type Client = redis.UniversalClient
+
+// RedisClient 嵌入的字段由 *redis.Client 改为 Client(redis.UniversalClient)，这是不兼容的改动：
+// 直接使用 rc.Client 作为 *redis.Client 的代码需要改为 StandaloneClient，或者只使用 UniversalClient 的方法
 type RedisClient struct {
-	*redis.Client
-	ctx                      context.Context
-	lockManager              *redsync.Redsync
-	maxIdleConn              int
-	configAddr               string
-	configPassword           string
-	configDbNum              int
-	configDialTimeoutSeconds int
-	configPoolConnections    int
+	Client
+	ctx         context.Context
+	lockManager *redsync.Redsync
+	maxIdleConn int
+	config      RedisConfig
 }
 
 var (
@@ -56,50 +57,78 @@ func syncLockersCacheGet(name string) (locker *redsync.Mutex) {
 }
 
 func NewRedisClient(addr string, password string, db int, dialTimeoutSeconds int, poolConnections int) (rc *RedisClient, err error) {
-	if poolConnections < 1 {
-		poolConnections = 1
-	}
-	maxIdleConn := int(poolConnections / 5)
-
-	opts := redis.Options{
-		Addr:           addr,
-		Password:       password,
-		DB:             db,
-		DialTimeout:    time.Duration(dialTimeoutSeconds) * time.Second,
-		MaxActiveConns: poolConnections,
-		MaxIdleConns:   maxIdleConn,
+	return NewRedisClientWithConfig(RedisConfig{
+		Mode:               REDIS_MODE_STANDALONE,
+		Addrs:              []string{addr},
+		Password:           password,
+		Db:                 db,
+		DialTimeoutSeconds: dialTimeoutSeconds,
+		PoolConnections:    poolConnections,
+	})
+}
+
+// NewRedisClientWithConfig 支持单机、哨兵、集群和 TLS，创建后 Ping 检查连接
+func NewRedisClientWithConfig(conf RedisConfig) (rc *RedisClient, err error) {
+	opts, err := conf.universalOptions()
+	if nil != err {
+		return
 	}
-	c := redis.NewClient(&opts)
+	c := redis.NewUniversalClient(opts)
 
 	err = c.Ping(redisCtx).Err()
 	if nil != err {
+		_ = c.Close()
 		return
 	}
 
 	rc = &RedisClient{
-		Client:                   c,
-		ctx:                      redisCtx,
-		maxIdleConn:              maxIdleConn,
-		configAddr:               addr,
-		configPassword:           password,
-		configDbNum:              db,
-		configDialTimeoutSeconds: dialTimeoutSeconds,
-		configPoolConnections:    poolConnections,
+		Client:      c,
+		ctx:         redisCtx,
+		maxIdleConn: opts.MaxIdleConns,
+		config:      conf,
 	}
 	return
 }
 func (rc *RedisClient) Clone() (*RedisClient, error) {
-	return NewRedisClient(rc.configAddr, rc.configPassword, rc.configDbNum, rc.configDialTimeoutSeconds, rc.configPoolConnections)
+	return NewRedisClientWithConfig(rc.config)
 }
 
+// Conf 单机模式的地址、密码和 db，哨兵和集群模式返回第一个地址
 func (rc *RedisClient) Conf() (addr string, password string, db int) {
-	return rc.configAddr, rc.configPassword, rc.configDbNum
+	if len(rc.config.Addrs) > 0 {
+		addr = rc.config.Addrs[0]
+	}
+	return addr, rc.config.Password, rc.config.Db
+}
+
+func (rc *RedisClient) Config() RedisConfig {
+	return rc.config
+}
+
+func (rc *RedisClient) Mode() string {
+	return rc.config.mode()
+}
+
+// StandaloneClient 底层为 *redis.Client 时返回 true，包括单机模式和只连接主节点或从节点的哨兵模式
+func (rc *RedisClient) StandaloneClient() (*redis.Client, bool) {
+	c, ok := rc.Client.(*redis.Client)
+	return c, ok
 }
 
 func (rc *RedisClient) CtxDefault() context.Context {
 	return rc.ctx
 }
 
+// WithContext 返回使用 ctx 作为默认 context 的副本，不带 Context 后缀的方法都会使用它
+func (rc *RedisClient) WithContext(ctx context.Context) *RedisClient {
+	c := *rc
+	c.ctx = ctx
+	return &c
+}
+
+// GetLocker 同一进程内相同 key 共用一个 Mutex
+//
+// Deprecated: 使用 AcquireLock / WithLock，每次获取得到独立的句柄；两者使用不同的 key，同名的新旧锁之间不互斥
 func (rc *RedisClient) GetLocker(key string, duration time.Duration, tries int) (locker *redsync.Mutex) {
 	key = strings.TrimSpace(key)
 	if "" == key {
@@ -126,61 +155,85 @@ func (rc *RedisClient) GetLocker(key string, duration time.Duration, tries int)
 }
 
 func (rc *RedisClient) TryLock(key string, duration time.Duration) error {
-	return rc.GetLocker(key, duration, 1).TryLock()
+	return rc.TryLockContext(rc.ctx, key, duration)
+}
+func (rc *RedisClient) TryLockContext(ctx context.Context, key string, duration time.Duration) error {
+	return rc.GetLocker(key, duration, 1).TryLockContext(ctx)
 }
 func (rc *RedisClient) Lock(key string, duration time.Duration, tries int) error {
-	return rc.GetLocker(key, duration, tries).Lock()
+	return rc.LockContext(rc.ctx, key, duration, tries)
+}
+func (rc *RedisClient) LockContext(ctx context.Context, key string, duration time.Duration, tries int) error {
+	return rc.GetLocker(key, duration, tries).LockContext(ctx)
 }
 func (rc *RedisClient) Unlock(key string) (bool, error) {
-	return rc.GetLocker(key, 0, 1).Unlock()
+	return rc.UnlockContext(rc.ctx, key)
+}
+func (rc *RedisClient) UnlockContext(ctx context.Context, key string) (bool, error) {
+	return rc.GetLocker(key, 0, 1).UnlockContext(ctx)
 }
 func (rc *RedisClient) LockExtend(key string, duration time.Duration) (bool, error) {
-	return rc.GetLocker(key, duration, 1).Extend()
+	return rc.LockExtendContext(rc.ctx, key, duration)
+}
+func (rc *RedisClient) LockExtendContext(ctx context.Context, key string, duration time.Duration) (bool, error) {
+	return rc.GetLocker(key, duration, 1).ExtendContext(ctx)
 }
 
 func (rc *RedisClient) Del(key ...string) (err error) {
-	status := rc.Client.Del(rc.ctx, key...)
+	return rc.DelContext(rc.ctx, key...)
+}
+func (rc *RedisClient) DelContext(ctx context.Context, key ...string) (err error) {
+	status := rc.Client.Del(ctx, key...)
 	return status.Err()
 }
+
+// DelByPattern 使用 SCAN + UNLINK 分批删除，不会阻塞 Redis
 func (rc *RedisClient) DelByPattern(pattern string) (err error) {
-	script := redis.NewScript(`
-local keys = redis.call('KEYS', ARGV[1])
-local i 
-for i=1,#keys do 
-	redis.call('DEL', keys[i])
-end
-return i
-`)
-	err = script.Eval(rc.CtxDefault(), rc, []string{}, pattern).Err()
-	if ErrorIsNil(err) {
-		err = nil
-	}
+	return rc.DelByPatternContext(rc.ctx, pattern)
+}
+func (rc *RedisClient) DelByPatternContext(ctx context.Context, pattern string) (err error) {
+	_, err = rc.UnlinkByPatternContext(ctx, RedisScanOptions{Match: pattern}, nil)
 	return
 }
 
 func (rc *RedisClient) Set(key string, value interface{}, expirations ...time.Duration) (string, error) {
+	return rc.SetContext(rc.ctx, key, value, expirations...)
+}
+func (rc *RedisClient) SetContext(ctx context.Context, key string, value interface{}, expirations ...time.Duration) (string, error) {
 	expiration := time.Duration(0)
 	if len(expirations) > 0 {
 		expiration = expirations[0]
 	}
-	status := rc.Client.Set(rc.ctx, key, value, expiration)
+	status := rc.Client.Set(ctx, key, value, expiration)
 	return status.Result()
 }
 func (rc *RedisClient) Get(key string) (value string, err error) {
-	status := rc.Client.Get(rc.ctx, key)
+	return rc.GetContext(rc.ctx, key)
+}
+func (rc *RedisClient) GetContext(ctx context.Context, key string) (value string, err error) {
+	status := rc.Client.Get(ctx, key)
 	return status.Result()
 }
 func (rc *RedisClient) GetDel(key string) (value string, err error) {
-	status := rc.Client.GetDel(rc.ctx, key)
+	return rc.GetDelContext(rc.ctx, key)
+}
+func (rc *RedisClient) GetDelContext(ctx context.Context, key string) (value string, err error) {
+	status := rc.Client.GetDel(ctx, key)
 	return status.Result()
 }
 func (rc *RedisClient) GetString(key string) (value string, err error) {
-	value, err = rc.Get(key)
+	return rc.GetStringContext(rc.ctx, key)
+}
+func (rc *RedisClient) GetStringContext(ctx context.Context, key string) (value string, err error) {
+	value, err = rc.GetContext(ctx, key)
 
 	return
 }
 func (rc *RedisClient) GetInt(key string) (value int, err error) {
-	v, err := rc.Get(key)
+	return rc.GetIntContext(rc.ctx, key)
+}
+func (rc *RedisClient) GetIntContext(ctx context.Context, key string) (value int, err error) {
+	v, err := rc.GetContext(ctx, key)
 	if nil != err {
 		return
 	}
@@ -202,11 +255,16 @@ func (rc *RedisClient) RedigoPool() (pool *redigo.Pool) {
 	}, MaxIdle: rc.maxIdleConn}
 	return
 }
+
+// GookitRedis 只支持不使用 TLS 和 ACL 用户名的单机模式
 func (rc *RedisClient) GookitRedis() *gookitRedis.GoRedis {
 	return gookitRedis.Connect(rc.Conf())
 }
 
 func (rc *RedisClient) BitFill(key string, val int8, start int64, length int64) (err error) {
+	return rc.BitFillContext(rc.ctx, key, val, start, length)
+}
+func (rc *RedisClient) BitFillContext(ctx context.Context, key string, val int8, start int64, length int64) (err error) {
 
 	script := redis.NewScript(`
 local v = 0
@@ -218,12 +276,15 @@ for i=0,ARGV[3]-1 do
 end
 return ARGV[3]
 `)
-	err = script.Eval(rc.CtxDefault(), rc, []string{key}, val, start, length).Err()
+	err = script.Eval(ctx, rc, []string{key}, val, start, length).Err()
 
 	return
 }
 
 func (rc *RedisClient) BitFindSpaceStep(key string, length int64, val int8, start int64, end int64, step int64) (position int64, err error) {
+	return rc.BitFindSpaceStepContext(rc.ctx, key, length, val, start, end, step)
+}
+func (rc *RedisClient) BitFindSpaceStepContext(ctx context.Context, key string, length int64, val int8, start int64, end int64, step int64) (position int64, err error) {
 	script := redis.NewScript(`
 local p = -1
 local v = 0
@@ -238,7 +299,7 @@ for i=ARGV[3],ARGV[4]-1,ARGV[5] do
 end
 return -1
 `)
-	cmd := script.Eval(rc.CtxDefault(), rc, []string{key}, val, length, start, end, step)
+	cmd := script.Eval(ctx, rc, []string{key}, val, length, start, end, step)
 	if nil != cmd.Err() {
 		err = cmd.Err()
 		return
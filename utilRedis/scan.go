@@ -0,0 +1,129 @@
+package utilRedis
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisScanOptions SCAN 参数，Count 为每次 SCAN 的建议数量，Type 为 string / list / set / zset / hash / stream
+type RedisScanOptions struct {
+	Match string
+	Count int64
+	Type  string
+}
+
+var errScanStop = errors.New("scan stopped")
+
+// RedisUnlinkProgress 删除进度回调，scanned 为已扫描的 key 数量，deleted 为已删除的 key 数量
+type RedisUnlinkProgress func(scanned int64, deleted int64)
+
+// scanClients 集群模式返回所有主节点，其它模式返回客户端本身
+func (rc *RedisClient) scanClients(ctx context.Context) (clients []redis.UniversalClient, err error) {
+	cluster, ok := rc.Client.(*redis.ClusterClient)
+	if !ok {
+		return []redis.UniversalClient{rc.Client}, nil
+	}
+	locker := sync.Mutex{}
+	err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		locker.Lock()
+		defer locker.Unlock()
+		clients = append(clients, client)
+		return nil
+	})
+	return
+}
+
+// scanBatches 逐批返回 SCAN 的结果，fn 返回错误时停止
+func (rc *RedisClient) scanBatches(ctx context.Context, opts RedisScanOptions, fn func(client redis.UniversalClient, keys []string) error) error {
+	clients, err := rc.scanClients(ctx)
+	if nil != err {
+		return err
+	}
+	for _, client := range clients {
+		var cursor uint64
+		for {
+			var keys []string
+			if "" != opts.Type {
+				keys, cursor, err = client.ScanType(ctx, cursor, opts.Match, opts.Count, opts.Type).Result()
+			} else {
+				keys, cursor, err = client.Scan(ctx, cursor, opts.Match, opts.Count).Result()
+			}
+			if nil != err {
+				return err
+			}
+			if len(keys) > 0 {
+				if err = fn(client, keys); nil != err {
+					return err
+				}
+			}
+			if 0 == cursor {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// ScanKeys 使用 SCAN 遍历 key，集群模式下依次遍历所有主节点
+// SCAN 可能返回重复的 key，遍历过程中新增或删除的 key 不保证出现
+func (rc *RedisClient) ScanKeys(ctx context.Context, opts RedisScanOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		err := rc.scanBatches(ctx, opts, func(client redis.UniversalClient, keys []string) error {
+			for _, key := range keys {
+				if !yield(key, nil) {
+					return errScanStop
+				}
+			}
+			return nil
+		})
+		if nil != err && !errors.Is(err, errScanStop) {
+			yield("", err)
+		}
+	}
+}
+
+// UnlinkContext 使用 UNLINK 在后台释放内存，多个 key 通过 pipeline 逐个删除，集群模式下 key 可以在不同的槽
+func (rc *RedisClient) UnlinkContext(ctx context.Context, keys ...string) (deleted int64, err error) {
+	return unlinkKeys(ctx, rc.Client, keys)
+}
+
+func unlinkKeys(ctx context.Context, client redis.UniversalClient, keys []string) (deleted int64, err error) {
+	if len(keys) <= 0 {
+		return
+	}
+	pipe := client.Pipeline()
+	cmds := make([]*redis.IntCmd, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, pipe.Unlink(ctx, key))
+	}
+	_, err = pipe.Exec(ctx)
+	for _, cmd := range cmds {
+		deleted += cmd.Val()
+	}
+	return
+}
+
+// UnlinkByPatternContext 使用 SCAN 查找匹配的 key 并按批 UNLINK，progress 在每批删除后调用，可以为 nil
+func (rc *RedisClient) UnlinkByPatternContext(ctx context.Context, opts RedisScanOptions, progress RedisUnlinkProgress) (deleted int64, err error) {
+	if opts.Count <= 0 {
+		opts.Count = 500
+	}
+	var scanned int64
+	err = rc.scanBatches(ctx, opts, func(client redis.UniversalClient, keys []string) error {
+		scanned += int64(len(keys))
+		n, e := unlinkKeys(ctx, client, keys)
+		deleted += n
+		if nil != e {
+			return e
+		}
+		if nil != progress {
+			progress(scanned, deleted)
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,104 @@
+package utilRedis
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *RedisClient) {
+	mr := miniredis.RunT(t)
+	rc, err := NewRedisClient(mr.Addr(), "", 0, 5, 5)
+	if nil != err {
+		t.Fatalf("redis: %+v", err)
+	}
+	t.Cleanup(func() { _ = rc.Close() })
+	return mr, rc
+}
+
+func TestRedisScanKeys(t *testing.T) {
+	mr, rc := newTestRedis(t)
+	for i := 0; i < 30; i++ {
+		_ = mr.Set("user:"+strconv.Itoa(i), "v")
+	}
+	_, _ = mr.Lpush("user:list", "v")
+	_ = mr.Set("order:1", "v")
+
+	cases := []struct {
+		opts RedisScanOptions
+		want int
+	}{
+		{RedisScanOptions{}, 32},
+		{RedisScanOptions{Match: "user:*"}, 31},
+		{RedisScanOptions{Match: "user:*", Count: 5}, 31},
+		{RedisScanOptions{Match: "user:*", Type: "list"}, 1},
+		{RedisScanOptions{Match: "none:*"}, 0},
+	}
+	for _, c := range cases {
+		keys := map[string]bool{}
+		for key, err := range rc.ScanKeys(context.Background(), c.opts) {
+			if nil != err {
+				t.Fatalf("%+v: %+v", c.opts, err)
+			}
+			keys[key] = true
+		}
+		if c.want != len(keys) {
+			t.Fatalf("ScanKeys(%+v) = %d 个, want %d", c.opts, len(keys), c.want)
+		}
+	}
+
+	// 提前 break 时停止遍历
+	count := 0
+	for range rc.ScanKeys(context.Background(), RedisScanOptions{Count: 5}) {
+		count++
+		if 3 == count {
+			break
+		}
+	}
+	if 3 != count {
+		t.Fatalf("break 后仍在遍历: %d", count)
+	}
+}
+
+func TestRedisUnlinkByPattern(t *testing.T) {
+	cases := []struct {
+		keys    []string
+		opts    RedisScanOptions
+		deleted int64
+		left    string
+	}{
+		{[]string{"a:1", "a:2", "b:1"}, RedisScanOptions{Match: "a:*"}, 2, "b:1"},
+		{[]string{"a:1", "a:2", "a:3", "a:4", "a:5"}, RedisScanOptions{Match: "a:*", Count: 2}, 5, ""},
+		{[]string{"b:1"}, RedisScanOptions{Match: "a:*"}, 0, "b:1"},
+	}
+	for _, c := range cases {
+		mr, rc := newTestRedis(t)
+		for _, key := range c.keys {
+			_ = mr.Set(key, "v")
+		}
+		var progressDeleted int64
+		deleted, err := rc.UnlinkByPatternContext(context.Background(), c.opts, func(scanned int64, deleted int64) {
+			if deleted > scanned {
+				t.Fatalf("deleted %d > scanned %d", deleted, scanned)
+			}
+			progressDeleted = deleted
+		})
+		if nil != err || c.deleted != deleted || c.deleted != progressDeleted {
+			t.Fatalf("%v %+v deleted = %d(progress %d) %+v, want %d", c.keys, c.opts, deleted, progressDeleted, err, c.deleted)
+		}
+		left := mr.Keys()
+		sort.Strings(left)
+		if c.left != strings.Join(left, ",") {
+			t.Fatalf("剩余 key = %v, want %s", left, c.left)
+		}
+	}
+
+	_, rc := newTestRedis(t)
+	if deleted, err := rc.UnlinkContext(context.Background()); nil != err || 0 != deleted {
+		t.Fatalf("没有 key 时不应执行: %d %+v", deleted, err)
+	}
+}
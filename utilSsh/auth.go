@@ -0,0 +1,276 @@
+package utilSsh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// 认证方式按添加顺序尝试，服务端要求多重认证(如 publickey,password)时依次完成
+
+func (c *SshClient) UsePassword(password string) *SshClient {
+	c.authMethods = append(c.authMethods, ssh.Password(password))
+	return c
+}
+
+// UsePrivateKey 支持 PEM/OpenSSH 格式私钥，passphrase 为空时按未加密私钥解析
+func (c *SshClient) UsePrivateKey(key []byte, passphrase string) (err error) {
+	signer, err := ParsePrivateKey(key, passphrase)
+	if nil != err {
+		return
+	}
+	c.authMethods = append(c.authMethods, ssh.PublicKeys(signer))
+	return
+}
+func (c *SshClient) UsePrivateKeyFile(path string, passphrase string) (err error) {
+	key, err := os.ReadFile(path)
+	if nil != err {
+		err = fmt.Errorf("read private key file error: %+v", err)
+		return
+	}
+	return c.UsePrivateKey(key, passphrase)
+}
+
+// UseCertificate 使用 OpenSSH 证书(如 id_ed25519-cert.pub)和对应私钥认证
+func (c *SshClient) UseCertificate(cert []byte, key []byte, passphrase string) (err error) {
+	signer, err := ParsePrivateKey(key, passphrase)
+	if nil != err {
+		return
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(cert)
+	if nil != err {
+		err = fmt.Errorf("parse certificate error: %+v", err)
+		return
+	}
+	sshCert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		err = fmt.Errorf("not a ssh certificate")
+		return
+	}
+	certSigner, err := ssh.NewCertSigner(sshCert, signer)
+	if nil != err {
+		err = fmt.Errorf("certificate signer error: %+v", err)
+		return
+	}
+	c.authMethods = append(c.authMethods, ssh.PublicKeys(certSigner))
+	return
+}
+func (c *SshClient) UseCertificateFile(certPath string, keyPath string, passphrase string) (err error) {
+	cert, err := os.ReadFile(certPath)
+	if nil != err {
+		err = fmt.Errorf("read certificate file error: %+v", err)
+		return
+	}
+	key, err := os.ReadFile(keyPath)
+	if nil != err {
+		err = fmt.Errorf("read private key file error: %+v", err)
+		return
+	}
+	return c.UseCertificate(cert, key, passphrase)
+}
+
+// UseAgent 连接 ssh-agent，socket 为空时使用 SSH_AUTH_SOCK
+func (c *SshClient) UseAgent(socket ...string) (err error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if len(socket) > 0 && "" != socket[0] {
+		sock = socket[0]
+	}
+	if "" == sock {
+		err = fmt.Errorf("ssh agent socket can't be empty")
+		return
+	}
+	a := &sshAgent{sock: sock}
+	if _, err = a.client(); nil != err {
+		return
+	}
+	c.agents = append(c.agents, a)
+	c.authMethods = append(c.authMethods, ssh.PublicKeysCallback(a.Signers))
+	return
+}
+
+// sshAgent Close 后再次认证时重新连接 ssh-agent，SshClient 关闭后可以重新 Connect
+type sshAgent struct {
+	sock   string
+	conn   net.Conn
+	agent  agent.ExtendedAgent
+	locker sync.Mutex
+}
+
+func (a *sshAgent) client() (agent.ExtendedAgent, error) {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	if nil == a.conn {
+		conn, err := net.Dial("unix", a.sock)
+		if nil != err {
+			return nil, fmt.Errorf("ssh agent connect error: %+v", err)
+		}
+		a.conn = conn
+		a.agent = agent.NewClient(conn)
+	}
+	return a.agent, nil
+}
+
+func (a *sshAgent) Signers() (signers []ssh.Signer, err error) {
+	client, err := a.client()
+	if nil != err {
+		return
+	}
+	signers, err = client.Signers()
+	if nil != err {
+		// 连接已断开(如 agent 重启)时重连一次
+		a.close()
+		if client, err = a.client(); nil != err {
+			return
+		}
+		signers, err = client.Signers()
+	}
+	return
+}
+
+func (a *sshAgent) close() {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	if nil != a.conn {
+		_ = a.conn.Close()
+		a.conn = nil
+		a.agent = nil
+	}
+}
+
+func (c *SshClient) UseKeyboardInteractive(challenge ssh.KeyboardInteractiveChallenge) *SshClient {
+	c.authMethods = append(c.authMethods, ssh.KeyboardInteractive(challenge))
+	return c
+}
+
+// UseKeyboardInteractivePassword 用密码回答所有不回显的问题，兼容只开放 keyboard-interactive 的服务器
+func (c *SshClient) UseKeyboardInteractivePassword(password string) *SshClient {
+	return c.UseKeyboardInteractive(func(name, instruction string, questions []string, echos []bool) (answers []string, err error) {
+		answers = make([]string, len(questions))
+		for i := range questions {
+			if !echos[i] {
+				answers[i] = password
+			}
+		}
+		return
+	})
+}
+
+func (c *SshClient) UseAuthMethods(methods ...ssh.AuthMethod) *SshClient {
+	c.authMethods = append(c.authMethods, methods...)
+	return c
+}
+func (c *SshClient) ClearAuthMethods() *SshClient {
+	c.authMethods = nil
+	c.closeAgentConns()
+	c.agents = nil
+	return c
+}
+
+// UseKnownHosts 按 known_hosts 文件校验主机公钥
+func (c *SshClient) UseKnownHosts(files ...string) (err error) {
+	if len(files) <= 0 {
+		home, _ := os.UserHomeDir()
+		files = []string{filepath.Join(home, ".ssh", "known_hosts")}
+	}
+	callback, err := knownhosts.New(files...)
+	if nil != err {
+		err = fmt.Errorf("load known_hosts error: %+v", err)
+		return
+	}
+	c.hostKeyCallback = callback
+	return
+}
+
+// UseKnownHostsTofu 首次连接时把主机公钥写入 file，之后按 file 校验，公钥变化时拒绝连接
+func (c *SshClient) UseKnownHostsTofu(file string) (err error) {
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if nil != err {
+		return
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDONLY, 0600)
+	if nil != err {
+		return
+	}
+	_ = f.Close()
+
+	locker := &sync.Mutex{}
+	c.hostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		locker.Lock()
+		defer locker.Unlock()
+		callback, err1 := knownhosts.New(file)
+		if nil != err1 {
+			return fmt.Errorf("load known_hosts error: %+v", err1)
+		}
+		err1 = callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if nil == err1 || !errors.As(err1, &keyErr) || len(keyErr.Want) > 0 {
+			return err1
+		}
+
+		addresses := []string{knownhosts.Normalize(hostname)}
+		if nil != remote && knownhosts.Normalize(remote.String()) != addresses[0] {
+			addresses = append(addresses, knownhosts.Normalize(remote.String()))
+		}
+		w, err1 := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
+		if nil != err1 {
+			return err1
+		}
+		defer w.Close()
+		_, err1 = w.WriteString(knownhosts.Line(addresses, key) + "\n")
+		return err1
+	}
+	return
+}
+
+// UseHostKeyFingerprint 固定主机公钥指纹，支持 SHA256:xxx 和 MD5 (aa:bb:...) 两种格式
+func (c *SshClient) UseHostKeyFingerprint(fingerprints ...string) *SshClient {
+	c.hostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		sha256Fp := ssh.FingerprintSHA256(key)
+		md5Fp := ssh.FingerprintLegacyMD5(key)
+		for _, fp := range fingerprints {
+			fp = strings.TrimSpace(fp)
+			if fp == sha256Fp || strings.TrimPrefix(strings.ToLower(fp), "md5:") == md5Fp {
+				return nil
+			}
+		}
+		return fmt.Errorf("ssh host key fingerprint mismatch, host: %s ,fingerprint: %s", hostname, sha256Fp)
+	}
+	return c
+}
+
+// UseInsecureHostKey 不校验主机公钥，容易受到中间人攻击，仅用于测试环境；未设置任何校验方式时 Connect 返回错误
+func (c *SshClient) UseInsecureHostKey() *SshClient {
+	c.hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	return c
+}
+func (c *SshClient) UseHostKeyCallback(callback ssh.HostKeyCallback) *SshClient {
+	c.hostKeyCallback = callback
+	return c
+}
+
+// closeAgentConns 只关闭连接，认证方式保留，下次认证时重新连接
+func (c *SshClient) closeAgentConns() {
+	for _, a := range c.agents {
+		a.close()
+	}
+}
+
+func ParsePrivateKey(key []byte, passphrase string) (signer ssh.Signer, err error) {
+	if "" == passphrase {
+		signer, err = ssh.ParsePrivateKey(key)
+	} else {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	}
+	if nil != err {
+		err = fmt.Errorf("parse private key error: %+v", err)
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package utilSsh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSshKnownHosts(t *testing.T) {
+	s := newTestSshServer(t)
+	client := s.connect(t)
+	if out, err := client.Exec("echo ok"); nil != err || "ok\n" != out {
+		t.Fatalf("exec: %q %+v", out, err)
+	}
+
+	// known_hosts 中的公钥与服务端不一致
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	other, _ := ssh.NewPublicKey(pub)
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	_ = os.WriteFile(file, []byte(knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, other)+"\n"), 0600)
+	client = NewSshClient()
+	if err := client.UseKnownHosts(file); nil != err {
+		t.Fatalf("known_hosts: %+v", err)
+	}
+	if err := client.Connect(s.addr, testSshUser, testSshPassword); nil == err {
+		client.Close()
+		t.Fatalf("公钥不一致时应拒绝连接")
+	}
+}
+
+func TestSshKnownHostsTofu(t *testing.T) {
+	s := newTestSshServer(t)
+	file := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	for i := 0; i < 2; i++ {
+		client := NewSshClient()
+		if err := client.UseKnownHostsTofu(file); nil != err {
+			t.Fatalf("tofu: %+v", err)
+		}
+		if err := client.Connect(s.addr, testSshUser, testSshPassword); nil != err {
+			t.Fatalf("第 %d 次连接: %+v", i+1, err)
+		}
+		client.Close()
+	}
+	content, _ := os.ReadFile(file)
+	if 1 != strings.Count(string(content), "\n") {
+		t.Fatalf("首次连接后应只写入一行: %q", content)
+	}
+
+	// 服务端公钥变化
+	changed := newTestSshServer(t)
+	line := knownhosts.Line([]string{knownhosts.Normalize(changed.addr)}, s.hostKey.PublicKey())
+	_ = os.WriteFile(file, []byte(line+"\n"), 0600)
+	client := NewSshClient()
+	_ = client.UseKnownHostsTofu(file)
+	if err := client.Connect(changed.addr, testSshUser, testSshPassword); nil == err {
+		client.Close()
+		t.Fatalf("公钥变化时应拒绝连接")
+	}
+}
+
+func TestSshHostKeyFingerprint(t *testing.T) {
+	s := newTestSshServer(t)
+	client := NewSshClient().UseHostKeyFingerprint(ssh.FingerprintSHA256(s.hostKey.PublicKey()))
+	if err := client.Connect(s.addr, testSshUser, testSshPassword); nil != err {
+		t.Fatalf("指纹一致时应连接成功: %+v", err)
+	}
+	client.Close()
+
+	client = NewSshClient().UseHostKeyFingerprint("SHA256:invalid")
+	if err := client.Connect(s.addr, testSshUser, testSshPassword); nil == err {
+		client.Close()
+		t.Fatalf("指纹不一致时应拒绝连接")
+	}
+
+	client = NewSshClient().UseHostKeyFingerprint(ssh.FingerprintSHA256(s.hostKey.PublicKey()))
+	if err := client.Connect(s.addr, testSshUser, "wrong"); nil == err {
+		client.Close()
+		t.Fatalf("密码错误时应认证失败")
+	}
+}
+
+func TestSshConnectRequiresHostKeyPolicy(t *testing.T) {
+	s := newTestSshServer(t)
+	client := NewSshClient()
+	if err := client.Connect(s.addr, testSshUser, testSshPassword); nil == err {
+		client.Close()
+		t.Fatalf("没有设置主机公钥校验时应拒绝连接")
+	}
+	if 0 != s.connects.Load() {
+		t.Fatalf("没有设置主机公钥校验时不应发起连接")
+	}
+
+	client = NewSshClient().UseInsecureHostKey()
+	if err := client.Connect(s.addr, testSshUser, testSshPassword); nil != err {
+		t.Fatalf("UseInsecureHostKey 后应连接成功: %+v", err)
+	}
+	client.Close()
+
+	host := &SshHost{Addr: s.addr, User: testSshUser, Password: testSshPassword}
+	if client, err := host.Connect(); nil == err {
+		client.Close()
+		t.Fatalf("SshHost 没有 KnownHostsFile 时应拒绝连接")
+	}
+	host.InsecureHostKey = true
+	client, err := host.Connect()
+	if nil != err {
+		t.Fatalf("SshHost.InsecureHostKey 后应连接成功: %+v", err)
+	}
+	client.Close()
+}
@@ -0,0 +1,301 @@
+package utilSsh
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hilaoyu/go-utils/utilProxy"
+)
+
+var _ utilProxy.UtilProxy = (*SshClient)(nil)
+
+// UseJumpHost 通过已连接的 jump 连接目标主机(类似 ProxyJump)，jump 自身也可以设置 jump 形成多级跳转
+func (c *SshClient) UseJumpHost(jump *SshClient) *SshClient {
+	c.jumpHost = jump
+	return c
+}
+
+// Dial 通过 ssh 连接建立到 addr 的 tcp 连接(direct-tcpip)，可作为 utilProxy.UtilProxy 使用
+func (c *SshClient) Dial(network string, addr string) (conn net.Conn, err error) {
+	if nil == c.sshClient {
+		err = fmt.Errorf("ssh not connected")
+		return
+	}
+	return c.sshClient.Dial(network, addr)
+}
+func (c *SshClient) DialContext(ctx context.Context, network string, addr string) (conn net.Conn, err error) {
+	if nil == c.sshClient {
+		err = fmt.Errorf("ssh not connected")
+		return
+	}
+	return c.sshClient.DialContext(ctx, network, addr)
+}
+func (c *SshClient) NewConn(network string, addr string, timeout ...time.Duration) (conn net.Conn, err error) {
+	connTimeout := time.Duration(10) * time.Second
+	if len(timeout) > 0 && timeout[0] > 0 {
+		connTimeout = timeout[0]
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+	return c.DialContext(ctx, network, addr)
+}
+
+// SshForward 一个端口转发，Close 或 ctx 取消时停止监听并关闭已建立的连接
+type SshForward struct {
+	listener net.Listener
+	dial     func(ctx context.Context, addr string) (net.Conn, error)
+	target   string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	conns  map[net.Conn]struct{}
+	locker sync.Mutex
+	done   chan struct{}
+	err    error
+}
+
+func newSshForward(ctx context.Context, listener net.Listener, target string, dial func(ctx context.Context, addr string) (net.Conn, error)) *SshForward {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	f := &SshForward{
+		listener: listener,
+		dial:     dial,
+		target:   target,
+		conns:    map[net.Conn]struct{}{},
+		done:     make(chan struct{}),
+	}
+	f.ctx, f.cancel = context.WithCancel(ctx)
+	go func() {
+		<-f.ctx.Done()
+		_ = f.listener.Close()
+	}()
+	go f.serve()
+	return f
+}
+
+func (f *SshForward) Addr() net.Addr {
+	return f.listener.Addr()
+}
+func (f *SshForward) Done() <-chan struct{} {
+	return f.done
+}
+func (f *SshForward) Err() error {
+	<-f.done
+	return f.err
+}
+func (f *SshForward) Close() error {
+	f.cancel()
+	<-f.done
+	return nil
+}
+
+func (f *SshForward) serve() {
+	defer func() {
+		f.cancel()
+		f.locker.Lock()
+		for conn := range f.conns {
+			_ = conn.Close()
+		}
+		f.locker.Unlock()
+		close(f.done)
+	}()
+	for {
+		conn, err := f.listener.Accept()
+		if nil != err {
+			if nil == f.ctx.Err() {
+				f.err = err
+			}
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *SshForward) handle(conn net.Conn) {
+	if !f.track(conn) {
+		return
+	}
+	defer f.untrack(conn)
+
+	target := f.target
+	if "" == target {
+		var err error
+		target, err = socks5Handshake(conn)
+		if nil != err {
+			return
+		}
+	}
+	remote, err := f.dial(f.ctx, target)
+	if "" == f.target {
+		socks5Reply(conn, err)
+	}
+	if nil != err {
+		return
+	}
+	if !f.track(remote) {
+		return
+	}
+	defer f.untrack(remote)
+	pipeConn(conn, remote)
+}
+
+func (f *SshForward) track(conn net.Conn) bool {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	if nil != f.ctx.Err() {
+		_ = conn.Close()
+		return false
+	}
+	f.conns[conn] = struct{}{}
+	return true
+}
+func (f *SshForward) untrack(conn net.Conn) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	_ = conn.Close()
+	delete(f.conns, conn)
+}
+
+// LocalForward 本地监听 localAddr，连接经 ssh 转发到 remoteAddr (ssh -L)
+func (c *SshClient) LocalForward(ctx context.Context, localAddr string, remoteAddr string) (forward *SshForward, err error) {
+	if nil == c.sshClient {
+		err = fmt.Errorf("ssh not connected")
+		return
+	}
+	listener, err := net.Listen("tcp", localAddr)
+	if nil != err {
+		err = fmt.Errorf("local forward listen %s error: %+v", localAddr, err)
+		return
+	}
+	forward = newSshForward(ctx, listener, remoteAddr, func(ctx context.Context, addr string) (net.Conn, error) {
+		return c.DialContext(ctx, "tcp", addr)
+	})
+	return
+}
+
+// RemoteForward 远程主机监听 remoteAddr，连接转发到本地可访问的 localAddr (ssh -R)
+func (c *SshClient) RemoteForward(ctx context.Context, remoteAddr string, localAddr string) (forward *SshForward, err error) {
+	if nil == c.sshClient {
+		err = fmt.Errorf("ssh not connected")
+		return
+	}
+	listener, err := c.sshClient.Listen("tcp", remoteAddr)
+	if nil != err {
+		err = fmt.Errorf("remote forward listen %s error: %+v", remoteAddr, err)
+		return
+	}
+	dialer := &net.Dialer{Timeout: time.Duration(10) * time.Second}
+	forward = newSshForward(ctx, listener, localAddr, func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", addr)
+	})
+	return
+}
+
+// DynamicForward 本地启动 SOCKS5 服务(仅 CONNECT，无认证)，连接经 ssh 转发 (ssh -D)
+func (c *SshClient) DynamicForward(ctx context.Context, localAddr string) (forward *SshForward, err error) {
+	if nil == c.sshClient {
+		err = fmt.Errorf("ssh not connected")
+		return
+	}
+	listener, err := net.Listen("tcp", localAddr)
+	if nil != err {
+		err = fmt.Errorf("dynamic forward listen %s error: %+v", localAddr, err)
+		return
+	}
+	forward = newSshForward(ctx, listener, "", func(ctx context.Context, addr string) (net.Conn, error) {
+		return c.DialContext(ctx, "tcp", addr)
+	})
+	return
+}
+
+func pipeConn(a net.Conn, b net.Conn) {
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	cp := func(dst net.Conn, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	wg.Wait()
+}
+
+func socks5Handshake(conn net.Conn) (addr string, err error) {
+	_ = conn.SetDeadline(time.Now().Add(time.Duration(30) * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	buf := make([]byte, 262)
+	if _, err = io.ReadFull(conn, buf[:2]); nil != err {
+		return
+	}
+	if 0x05 != buf[0] {
+		err = fmt.Errorf("socks version %d not supported", buf[0])
+		return
+	}
+	if _, err = io.ReadFull(conn, buf[:int(buf[1])]); nil != err {
+		return
+	}
+	if _, err = conn.Write([]byte{0x05, 0x00}); nil != err {
+		return
+	}
+
+	if _, err = io.ReadFull(conn, buf[:4]); nil != err {
+		return
+	}
+	if 0x01 != buf[1] {
+		_, _ = conn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		err = fmt.Errorf("socks command %d not supported", buf[1])
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 0x01:
+		if _, err = io.ReadFull(conn, buf[:4]); nil != err {
+			return
+		}
+		host = net.IP(buf[:4]).String()
+	case 0x04:
+		if _, err = io.ReadFull(conn, buf[:16]); nil != err {
+			return
+		}
+		host = net.IP(buf[:16]).String()
+	case 0x03:
+		if _, err = io.ReadFull(conn, buf[:1]); nil != err {
+			return
+		}
+		l := int(buf[0])
+		if _, err = io.ReadFull(conn, buf[:l]); nil != err {
+			return
+		}
+		host = string(buf[:l])
+	default:
+		_, _ = conn.Write([]byte{0x05, 0x08, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		err = fmt.Errorf("socks address type %d not supported", buf[3])
+		return
+	}
+	if _, err = io.ReadFull(conn, buf[:2]); nil != err {
+		return
+	}
+	addr = net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+	return
+}
+
+func socks5Reply(conn net.Conn, err error) {
+	rep := byte(0x00)
+	if nil != err {
+		rep = 0x05
+	}
+	_, _ = conn.Write([]byte{0x05, rep, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+}
@@ -0,0 +1,164 @@
+package utilSsh
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type SshConnectFunc func() (client *SshClient, err error)
+
+// SshPool 按 key (如 user@host:port) 复用 ssh 连接，多个 goroutine 可共享同一连接
+// 后台定时发送 keepalive，失败的连接会被关闭，下次 Get 时重新连接
+// Get 只在连接空闲超过 keepAlive 时才同步检查一次，频繁使用的连接不需要每次等待一个往返
+type SshPool struct {
+	clients   map[string]*sshPoolEntry
+	locker    sync.Mutex
+	keepAlive time.Duration
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type sshPoolEntry struct {
+	client   *SshClient
+	connect  SshConnectFunc
+	lastUsed time.Time
+	locker   sync.Mutex
+}
+
+func NewSshPool(keepAlive time.Duration) *SshPool {
+	if keepAlive <= 0 {
+		keepAlive = time.Duration(30) * time.Second
+	}
+	p := &SshPool{
+		clients:   map[string]*sshPoolEntry{},
+		keepAlive: keepAlive,
+		stop:      make(chan struct{}),
+	}
+	go p.keepAliveLoop()
+	return p
+}
+
+// Get 返回 key 对应的可用连接，不存在或已断开时用 connect 建立新连接
+func (p *SshPool) Get(key string, connect SshConnectFunc) (client *SshClient, err error) {
+	p.locker.Lock()
+	entry, ok := p.clients[key]
+	if !ok {
+		if nil == connect {
+			p.locker.Unlock()
+			err = fmt.Errorf("ssh pool: no connect func for %s", key)
+			return
+		}
+		entry = &sshPoolEntry{connect: connect}
+		p.clients[key] = entry
+	} else if nil != connect {
+		entry.connect = connect
+	}
+	p.locker.Unlock()
+
+	entry.locker.Lock()
+	defer entry.locker.Unlock()
+	if nil != entry.client && (time.Since(entry.lastUsed) < p.keepAlive || entry.client.IsAlive()) {
+		entry.lastUsed = time.Now()
+		client = entry.client
+		return
+	}
+	if nil != entry.client {
+		entry.client.Close()
+		entry.client = nil
+	}
+	client, err = entry.connect()
+	if nil != err {
+		err = fmt.Errorf("ssh pool: connect %s error: %+v", key, err)
+		return
+	}
+	entry.client = client
+	entry.lastUsed = time.Now()
+	return
+}
+
+func (p *SshPool) Remove(key string) {
+	p.locker.Lock()
+	entry, ok := p.clients[key]
+	delete(p.clients, key)
+	p.locker.Unlock()
+	if !ok {
+		return
+	}
+	entry.locker.Lock()
+	defer entry.locker.Unlock()
+	if nil != entry.client {
+		entry.client.Close()
+		entry.client = nil
+	}
+}
+
+func (p *SshPool) Keys() (keys []string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	for k := range p.clients {
+		keys = append(keys, k)
+	}
+	return
+}
+
+func (p *SshPool) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+	for _, key := range p.Keys() {
+		p.Remove(key)
+	}
+}
+
+func (p *SshPool) keepAliveLoop() {
+	ticker := time.NewTicker(p.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		p.locker.Lock()
+		entries := make([]*sshPoolEntry, 0, len(p.clients))
+		for _, entry := range p.clients {
+			entries = append(entries, entry)
+		}
+		p.locker.Unlock()
+
+		for _, entry := range entries {
+			// 检查时不持有 entry 的锁，服务端无响应时不阻塞 Get
+			entry.locker.Lock()
+			client := entry.client
+			entry.locker.Unlock()
+			if nil == client || client.IsAlive() {
+				continue
+			}
+			entry.locker.Lock()
+			if client == entry.client {
+				entry.client = nil
+			}
+			entry.locker.Unlock()
+			client.Close()
+		}
+	}
+}
+
+// IsAlive 发送 keepalive@openssh.com 请求检测连接是否可用，10 秒无响应视为断开
+func (c *SshClient) IsAlive() bool {
+	if nil == c.sshClient {
+		return false
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := c.sshClient.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return nil == err
+	case <-time.After(time.Duration(10) * time.Second):
+		return false
+	}
+}
@@ -0,0 +1,109 @@
+package utilSsh
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSshPoolReuseAndReconnect(t *testing.T) {
+	s := newTestSshServer(t)
+	knownHosts := s.knownHosts(t)
+	connect := func() (*SshClient, error) {
+		client := NewSshClient()
+		if err := client.UseKnownHosts(knownHosts); nil != err {
+			return nil, err
+		}
+		return client, client.Connect(s.addr, testSshUser, testSshPassword)
+	}
+	pool := NewSshPool(time.Millisecond * 50)
+	defer pool.Close()
+
+	first, err := pool.Get("k", connect)
+	if nil != err {
+		t.Fatalf("get: %+v", err)
+	}
+	second, err := pool.Get("k", nil)
+	if nil != err || first != second {
+		t.Fatalf("同一个 key 应复用连接: %+v", err)
+	}
+	if 1 != s.connects.Load() {
+		t.Fatalf("connects = %d", s.connects.Load())
+	}
+
+	// 服务端断开后，后台 keepalive 关闭失效的连接，再次 Get 时重新连接
+	s.dropConns()
+	time.Sleep(time.Millisecond * 200)
+	third, err := pool.Get("k", nil)
+	if nil != err || third == first {
+		t.Fatalf("断开后应重新连接: %+v", err)
+	}
+	if out, err := third.Exec("echo ok"); nil != err || "ok\n" != out {
+		t.Fatalf("exec: %q %+v", out, err)
+	}
+	if 2 != s.connects.Load() {
+		t.Fatalf("connects = %d", s.connects.Load())
+	}
+}
+
+func TestSshLocalForward(t *testing.T) {
+	client := newTestSshServer(t).connect(t)
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatalf("listen: %+v", err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, e := echo.Accept()
+			if nil != e {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	forward, err := client.LocalForward(context.Background(), "127.0.0.1:0", echo.Addr().String())
+	if nil != err {
+		t.Fatalf("forward: %+v", err)
+	}
+	defer forward.Close()
+	conn, err := net.Dial("tcp", forward.Addr().String())
+	if nil != err {
+		t.Fatalf("dial: %+v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err = conn.Write([]byte("ping")); nil != err {
+		t.Fatalf("write: %+v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(conn, buf); nil != err || "ping" != string(buf) {
+		t.Fatalf("read: %q %+v", buf, err)
+	}
+}
+
+func TestSshPoolGetSkipsKeepAliveWhenRecentlyUsed(t *testing.T) {
+	s := newTestSshServer(t)
+	knownHosts := s.knownHosts(t)
+	pool := NewSshPool(time.Hour)
+	defer pool.Close()
+	connect := func() (*SshClient, error) {
+		client := NewSshClient()
+		_ = client.UseKnownHosts(knownHosts)
+		return client, client.Connect(s.addr, testSshUser, testSshPassword)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := pool.Get("k", connect); nil != err {
+			t.Fatalf("get: %+v", err)
+		}
+	}
+	if n := s.keepAlives.Load(); 0 != n {
+		t.Fatalf("连接刚使用过时 Get 不应发送 keepalive，实际 %d 次", n)
+	}
+}
@@ -0,0 +1,432 @@
+package utilSsh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hilaoyu/go-utils/utilFile"
+	"github.com/hilaoyu/go-utils/utils"
+)
+
+type SshHost struct {
+	Name           string `json:"name" yaml:"name"`
+	Addr           string `json:"addr" yaml:"addr"`
+	User           string `json:"user" yaml:"user"`
+	Password       string `json:"password,omitempty" yaml:"password,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty" yaml:"private_key_file,omitempty"`
+	Passphrase     string `json:"passphrase,omitempty" yaml:"passphrase,omitempty"`
+	KnownHostsFile string `json:"known_hosts_file,omitempty" yaml:"known_hosts_file,omitempty"`
+	// InsecureHostKey 没有 KnownHostsFile 时是否跳过主机公钥校验，默认不跳过，连接失败
+	InsecureHostKey bool              `json:"insecure_host_key,omitempty" yaml:"insecure_host_key,omitempty"`
+	Tags            []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Env             map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+}
+
+func (h *SshHost) DisplayName() string {
+	if "" != h.Name {
+		return h.Name
+	}
+	return h.Addr
+}
+
+// Connect 按主机配置的密码/私钥/known_hosts 建立连接，既没有 KnownHostsFile 也没有 InsecureHostKey 时返回错误
+func (h *SshHost) Connect(timeout ...time.Duration) (client *SshClient, err error) {
+	client = NewSshClient()
+	if "" != h.PrivateKeyFile {
+		if err = client.UsePrivateKeyFile(h.PrivateKeyFile, h.Passphrase); nil != err {
+			return
+		}
+	}
+	if "" != h.KnownHostsFile {
+		if err = client.UseKnownHosts(h.KnownHostsFile); nil != err {
+			return
+		}
+	} else if h.InsecureHostKey {
+		client.UseInsecureHostKey()
+	}
+	err = client.Connect(h.Addr, h.User, h.Password, timeout...)
+	if nil != err {
+		client.Close()
+		client = nil
+	}
+	return
+}
+
+type SshInventory struct {
+	Hosts []*SshHost `json:"hosts" yaml:"hosts"`
+}
+
+// LoadSshInventoryFile 按扩展名读取 json 或 yaml 格式的主机清单
+func LoadSshInventoryFile(path string) (inventory *SshInventory, err error) {
+	inventory = &SshInventory{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = utilFile.ReadYaml(path, inventory)
+	default:
+		err = utilFile.ReadJSON(path, inventory)
+	}
+	return
+}
+
+// Filter 返回同时包含所有 tags 的主机，tags 为空时返回全部
+func (inv *SshInventory) Filter(tags ...string) (hosts []*SshHost) {
+	for _, host := range inv.Hosts {
+		matched := true
+		for _, tag := range tags {
+			if !utils.SliceContains(host.Tags, tag) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			hosts = append(hosts, host)
+		}
+	}
+	return
+}
+
+type SshPushFile struct {
+	Local  string `json:"local" yaml:"local"`
+	Remote string `json:"remote" yaml:"remote"`
+}
+
+// SshTask 先推送文件再执行命令，两者都可为空
+type SshTask struct {
+	Command   string        `json:"command,omitempty" yaml:"command,omitempty"`
+	PushFiles []SshPushFile `json:"push_files,omitempty" yaml:"push_files,omitempty"`
+}
+
+type SshRunnerOptions struct {
+	Concurrency    int
+	HostTimeout    time.Duration
+	ConnectTimeout time.Duration
+	// BatchSize 大于 0 时按批滚动执行，上一批全部完成后才开始下一批
+	BatchSize int
+	// MaxFailures 大于 0 时失败主机数达到该值后不再开始新的主机，正在执行的主机继续完成
+	MaxFailures int
+	// MaxFailPercent 大于 0 时失败比例(已执行主机)达到该值后不再开始新的主机
+	MaxFailPercent float64
+	// Connect 自定义连接方式，为空时使用 SshHost.Connect
+	Connect func(host *SshHost) (*SshClient, error)
+}
+
+type SshHostResult struct {
+	Host       string    `json:"host"`
+	Addr       string    `json:"addr"`
+	ExitCode   int       `json:"exit_code"`
+	Stdout     string    `json:"stdout,omitempty"`
+	Stderr     string    `json:"stderr,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Skipped    bool      `json:"skipped,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Duration   string    `json:"duration"`
+}
+
+func (r *SshHostResult) Success() bool {
+	return !r.Skipped && "" == r.Error && 0 == r.ExitCode
+}
+
+type SshRunReport struct {
+	Results    []*SshHostResult `json:"results"`
+	Total      int              `json:"total"`
+	Succeeded  int              `json:"succeeded"`
+	Failed     int              `json:"failed"`
+	Skipped    int              `json:"skipped"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+}
+
+func (r *SshRunReport) add(result *SshHostResult) {
+	r.Results = append(r.Results, result)
+	r.Total++
+	switch {
+	case result.Skipped:
+		r.Skipped++
+	case result.Success():
+		r.Succeeded++
+	default:
+		r.Failed++
+	}
+}
+
+func (r *SshRunReport) Json() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+func (r *SshRunReport) Text() string {
+	results := append([]*SshHostResult{}, r.Results...)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Host < results[j].Host
+	})
+	b := strings.Builder{}
+	for _, result := range results {
+		status := "OK"
+		switch {
+		case result.Skipped:
+			status = "SKIPPED"
+		case !result.Success():
+			status = "FAILED"
+		}
+		b.WriteString(fmt.Sprintf("==== %s (%s) %s exit=%d duration=%s\n", result.Host, result.Addr, status, result.ExitCode, result.Duration))
+		if "" != result.Error {
+			b.WriteString("error: " + result.Error + "\n")
+		}
+		if "" != result.Stdout {
+			b.WriteString(strings.TrimRight(result.Stdout, "\n") + "\n")
+		}
+		if "" != result.Stderr {
+			b.WriteString("stderr: " + strings.TrimRight(result.Stderr, "\n") + "\n")
+		}
+	}
+	b.WriteString(fmt.Sprintf("total: %d ,succeeded: %d ,failed: %d ,skipped: %d ,duration: %s\n", r.Total, r.Succeeded, r.Failed, r.Skipped, r.FinishedAt.Sub(r.StartedAt)))
+	return b.String()
+}
+
+type SshRunner struct {
+	opts SshRunnerOptions
+}
+
+func NewSshRunner(opts SshRunnerOptions) *SshRunner {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 10
+	}
+	return &SshRunner{opts: opts}
+}
+
+// Run 执行任务并汇总结果，onResult 不为空时每台主机完成后立即回调
+func (r *SshRunner) Run(ctx context.Context, hosts []*SshHost, task *SshTask, onResult ...func(result *SshHostResult)) (report *SshRunReport) {
+	report = &SshRunReport{StartedAt: time.Now()}
+	for result := range r.RunStream(ctx, hosts, task) {
+		report.add(result)
+		for _, fn := range onResult {
+			fn(result)
+		}
+	}
+	report.FinishedAt = time.Now()
+	return
+}
+
+// RunStream 执行任务并逐个返回主机结果，全部完成后关闭 channel
+func (r *SshRunner) RunStream(ctx context.Context, hosts []*SshHost, task *SshTask) <-chan *SshHostResult {
+	results := make(chan *SshHostResult, r.opts.Concurrency)
+	go func() {
+		defer close(results)
+		batchSize := r.opts.BatchSize
+		if batchSize <= 0 {
+			batchSize = len(hosts)
+		}
+		state := &sshRunState{}
+		for start := 0; start < len(hosts); start += batchSize {
+			end := start + batchSize
+			if end > len(hosts) {
+				end = len(hosts)
+			}
+			if nil != ctx.Err() || state.exceeded(r.opts) {
+				for _, host := range hosts[start:] {
+					results <- &SshHostResult{Host: host.DisplayName(), Addr: host.Addr, Skipped: true, Error: "skipped"}
+				}
+				return
+			}
+			for result := range r.runBatch(ctx, hosts[start:end], task, state) {
+				results <- result
+			}
+		}
+	}()
+	return results
+}
+
+// sshRunState 已执行和失败的主机数，每台主机完成时立即更新，跳过的主机不计入失败数和失败比例
+type sshRunState struct {
+	done   int
+	failed int
+	locker sync.Mutex
+}
+
+func (s *sshRunState) add(result *SshHostResult) {
+	if result.Skipped {
+		return
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.done++
+	if !result.Success() {
+		s.failed++
+	}
+}
+
+func (s *sshRunState) exceeded(opts SshRunnerOptions) bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if opts.MaxFailures > 0 && s.failed >= opts.MaxFailures {
+		return true
+	}
+	if opts.MaxFailPercent > 0 && s.done > 0 && float64(s.failed)*100/float64(s.done) >= opts.MaxFailPercent {
+		return true
+	}
+	return false
+}
+
+func (r *SshRunner) runBatch(ctx context.Context, hosts []*SshHost, task *SshTask, state *sshRunState) <-chan *SshHostResult {
+	results := make(chan *SshHostResult, len(hosts))
+	sem := make(chan struct{}, r.opts.Concurrency)
+	wg := sync.WaitGroup{}
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host *SshHost) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results <- &SshHostResult{Host: host.DisplayName(), Addr: host.Addr, Skipped: true, Error: ctx.Err().Error()}
+				return
+			}
+			defer func() { <-sem }()
+			// 同一批中等待执行的主机也要检查，达到失败阈值后不再开始
+			if state.exceeded(r.opts) {
+				results <- &SshHostResult{Host: host.DisplayName(), Addr: host.Addr, Skipped: true, Error: "skipped"}
+				return
+			}
+			result := r.runHost(ctx, host, task)
+			state.add(result)
+			results <- result
+		}(host)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+func (r *SshRunner) runHost(ctx context.Context, host *SshHost, task *SshTask) (result *SshHostResult) {
+	result = &SshHostResult{Host: host.DisplayName(), Addr: host.Addr, StartedAt: time.Now()}
+	defer func() {
+		result.FinishedAt = time.Now()
+		result.Duration = result.FinishedAt.Sub(result.StartedAt).String()
+	}()
+
+	if r.opts.HostTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.opts.HostTimeout)
+		defer cancel()
+	}
+
+	var client *SshClient
+	var err error
+	if nil != r.opts.Connect {
+		client, err = r.opts.Connect(host)
+	} else {
+		client, err = host.Connect(r.opts.ConnectTimeout)
+	}
+	if nil != err {
+		result.ExitCode = -1
+		result.Error = err.Error()
+		return
+	}
+	defer client.Close()
+	// 超时后关闭连接，中断推送文件
+	stop := context.AfterFunc(ctx, func() {
+		if nil != client.sshClient {
+			_ = client.sshClient.Close()
+		}
+	})
+	defer stop()
+
+	for _, f := range task.PushFiles {
+		if err = client.UploadFile(f.Local, f.Remote); nil != err {
+			result.ExitCode = -1
+			result.Error = err.Error()
+			if nil != ctx.Err() {
+				result.Error = ctx.Err().Error()
+			}
+			return
+		}
+	}
+	if "" == task.Command {
+		return
+	}
+
+	command, err := envCommand(host.Env, task.Command)
+	if nil != err {
+		result.ExitCode = -1
+		result.Error = err.Error()
+		return
+	}
+	cmd, err := client.StartCommand(ctx, command)
+	if nil != err {
+		result.ExitCode = -1
+		result.Error = err.Error()
+		return
+	}
+	_ = cmd.Stdin.Close()
+	stdout, stderr := strings.Builder{}, strings.Builder{}
+	var outErr, errErr error
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		outErr = scanLines(cmd.Stdout, func(line string) { stdout.WriteString(line + "\n") })
+	}()
+	go func() {
+		defer wg.Done()
+		errErr = scanLines(cmd.Stderr, func(line string) { stderr.WriteString(line + "\n") })
+	}()
+	wg.Wait()
+	err = cmd.Wait()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+
+	var exitErr *SshExitError
+	switch {
+	case nil == err:
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode
+		if "" != exitErr.Signal {
+			result.Error = exitErr.Error()
+		}
+	default:
+		result.ExitCode = -1
+		result.Error = err.Error()
+	}
+	if nil != ctx.Err() && "" == result.Error {
+		result.Error = ctx.Err().Error()
+	}
+	if nil != outErr && "" == result.Error {
+		result.Error = fmt.Sprintf("read stdout error: %+v", outErr)
+	}
+	if nil != errErr && "" == result.Error {
+		result.Error = fmt.Sprintf("read stderr error: %+v", errErr)
+	}
+	return
+}
+
+// envCommand 以 export K='v'; command 的方式传递环境变量，sshd 默认不接受 setenv 请求
+// 不使用 K='v' command 的形式，它只对第一个简单命令生效，a && b、管道和 cd x; y 中后面的命令看不到
+func envCommand(env map[string]string, command string) (string, error) {
+	if len(env) <= 0 {
+		return command, nil
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		if !shellEnvNameRegexp.MatchString(k) {
+			return "", fmt.Errorf("invalid env name: %q", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := strings.Builder{}
+	for _, k := range keys {
+		b.WriteString("export " + k + "='" + strings.ReplaceAll(env[k], "'", `'\''`) + "'; ")
+	}
+	return b.String() + command, nil
+}
+
+var shellEnvNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
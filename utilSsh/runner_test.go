@@ -0,0 +1,73 @@
+package utilSsh
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestSshHosts(s *testSshServer, knownHosts string, names ...string) (hosts []*SshHost) {
+	for _, name := range names {
+		hosts = append(hosts, &SshHost{
+			Name:           name,
+			Addr:           s.addr,
+			User:           testSshUser,
+			Password:       testSshPassword,
+			KnownHostsFile: knownHosts,
+			Env:            map[string]string{"HOST_NAME": name},
+		})
+	}
+	return
+}
+
+func TestSshRunnerRun(t *testing.T) {
+	s := newTestSshServer(t)
+	hosts := newTestSshHosts(s, s.knownHosts(t), "h1", "h2", "h3")
+	local := filepath.Join(t.TempDir(), "push.txt")
+	_ = os.WriteFile(local, []byte("pushed"), 0644)
+	remote := filepath.Join(t.TempDir(), "push.txt")
+
+	// 所有主机是同一个测试服务端，推送的是同一个文件，不能并发
+	report := NewSshRunner(SshRunnerOptions{Concurrency: 1}).Run(context.Background(), hosts, &SshTask{
+		Command:   "printenv HOST_NAME; cat " + remote,
+		PushFiles: []SshPushFile{{Local: local, Remote: remote}},
+	})
+	if 3 != report.Total || 3 != report.Succeeded {
+		t.Fatalf("report: %s", report.Text())
+	}
+	for _, result := range report.Results {
+		if result.Host+"\npushed" != strings.TrimSpace(result.Stdout) {
+			t.Fatalf("%s stdout = %q", result.Host, result.Stdout)
+		}
+	}
+}
+
+func TestSshRunnerBatchFailureLimit(t *testing.T) {
+	s := newTestSshServer(t)
+	hosts := newTestSshHosts(s, s.knownHosts(t), "h1", "h2", "h3")
+	report := NewSshRunner(SshRunnerOptions{Concurrency: 1, BatchSize: 1, MaxFailures: 1}).Run(context.Background(), hosts, &SshTask{Command: "exit 2"})
+	if 1 != report.Failed || 2 != report.Skipped {
+		t.Fatalf("第一批失败后应跳过后续批次: %s", report.Text())
+	}
+	for _, result := range report.Results {
+		if !result.Skipped && 2 != result.ExitCode {
+			t.Fatalf("exit code = %d", result.ExitCode)
+		}
+	}
+}
+
+func TestSshRunnerFailureLimitWithinBatch(t *testing.T) {
+	s := newTestSshServer(t)
+	hosts := newTestSshHosts(s, s.knownHosts(t), "h1", "h2", "h3", "h4")
+	report := NewSshRunner(SshRunnerOptions{Concurrency: 1, MaxFailures: 1}).Run(context.Background(), hosts, &SshTask{Command: "exit 2"})
+	if 1 != report.Failed || 3 != report.Skipped {
+		t.Fatalf("同一批中达到失败阈值后应跳过剩余主机: %s", report.Text())
+	}
+
+	report = NewSshRunner(SshRunnerOptions{Concurrency: 1, MaxFailPercent: 50}).Run(context.Background(), hosts, &SshTask{Command: "exit 2"})
+	if 1 != report.Failed || 3 != report.Skipped {
+		t.Fatalf("失败比例达到阈值后应跳过剩余主机: %s", report.Text())
+	}
+}
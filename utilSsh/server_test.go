@@ -0,0 +1,244 @@
+package utilSsh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	testSshUser     = "tester"
+	testSshPassword = "secret"
+)
+
+// testSshServer 进程内的 ssh 服务端，支持密码认证、exec(本机 sh -c 执行)、sftp 子系统、direct-tcpip 和 keepalive
+type testSshServer struct {
+	addr       string
+	hostKey    ssh.Signer
+	listener   net.Listener
+	connects   atomic.Int32
+	keepAlives atomic.Int32
+
+	conns  map[*ssh.ServerConn]struct{}
+	locker sync.Mutex
+}
+
+func newTestSshServer(t *testing.T) *testSshServer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if nil != err {
+		t.Fatalf("host key: %+v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if nil != err {
+		t.Fatalf("host key: %+v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatalf("listen: %+v", err)
+	}
+	s := &testSshServer{addr: listener.Addr().String(), hostKey: signer, listener: listener, conns: map[*ssh.ServerConn]struct{}{}}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if testSshUser == conn.User() && testSshPassword == string(password) {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(signer)
+	go func() {
+		for {
+			conn, e := listener.Accept()
+			if nil != e {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *testSshServer) close() {
+	_ = s.listener.Close()
+	s.dropConns()
+}
+
+// dropConns 断开所有已建立的连接，模拟服务端重启
+func (s *testSshServer) dropConns() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+// knownHosts 写入包含服务端公钥的 known_hosts 文件
+func (s *testSshServer) knownHosts(t *testing.T) string {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, s.hostKey.PublicKey())
+	if err := os.WriteFile(file, []byte(line+"\n"), 0600); nil != err {
+		t.Fatalf("known_hosts: %+v", err)
+	}
+	return file
+}
+
+// connect 使用 known_hosts 连接
+func (s *testSshServer) connect(t *testing.T) *SshClient {
+	client := NewSshClient()
+	if err := client.UseKnownHosts(s.knownHosts(t)); nil != err {
+		t.Fatalf("known_hosts: %+v", err)
+	}
+	if err := client.Connect(s.addr, testSshUser, testSshPassword); nil != err {
+		t.Fatalf("connect: %+v", err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func (s *testSshServer) serve(nc net.Conn, config *ssh.ServerConfig) {
+	conn, chans, reqs, err := ssh.NewServerConn(nc, config)
+	if nil != err {
+		_ = nc.Close()
+		return
+	}
+	s.connects.Add(1)
+	s.locker.Lock()
+	s.conns[conn] = struct{}{}
+	s.locker.Unlock()
+	defer func() {
+		s.locker.Lock()
+		delete(s.conns, conn)
+		s.locker.Unlock()
+		_ = conn.Close()
+	}()
+
+	go func() {
+		for req := range reqs {
+			if "keepalive@openssh.com" == req.Type {
+				s.keepAlives.Add(1)
+			}
+			if req.WantReply {
+				_ = req.Reply("keepalive@openssh.com" == req.Type, nil)
+			}
+		}
+	}()
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go s.session(newChannel)
+		case "direct-tcpip":
+			go s.directTcpip(newChannel)
+		default:
+			_ = newChannel.Reject(ssh.UnknownChannelType, newChannel.ChannelType())
+		}
+	}
+}
+
+func (s *testSshServer) session(newChannel ssh.NewChannel) {
+	ch, reqs, err := newChannel.Accept()
+	if nil != err {
+		return
+	}
+	for req := range reqs {
+		switch req.Type {
+		case "exec":
+			payload := struct{ Command string }{}
+			if err = ssh.Unmarshal(req.Payload, &payload); nil != err {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			go s.exec(ch, payload.Command)
+		case "subsystem":
+			payload := struct{ Name string }{}
+			if err = ssh.Unmarshal(req.Payload, &payload); nil != err || "sftp" != payload.Name {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			go func() {
+				defer ch.Close()
+				server, e := sftp.NewServer(ch)
+				if nil != e {
+					return
+				}
+				_ = server.Serve()
+			}()
+		default:
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func (s *testSshServer) exec(ch ssh.Channel, command string) {
+	defer ch.Close()
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = ch
+	cmd.Stderr = ch.Stderr()
+	stdin, err := cmd.StdinPipe()
+	if nil != err {
+		return
+	}
+	status := uint32(0)
+	if err = cmd.Start(); nil != err {
+		status = 127
+	} else {
+		go func() {
+			_, _ = io.Copy(stdin, ch)
+			_ = stdin.Close()
+		}()
+		if err = cmd.Wait(); nil != err {
+			status = 1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				status = uint32(exitErr.ExitCode())
+			}
+		}
+	}
+	_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+}
+
+func (s *testSshServer) directTcpip(newChannel ssh.NewChannel) {
+	payload := struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}{}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); nil != err {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if nil != err {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newChannel.Accept()
+	if nil != err {
+		_ = target.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		_, _ = io.Copy(ch, target)
+		_ = ch.CloseWrite()
+	}()
+	_, _ = io.Copy(target, ch)
+	_ = target.Close()
+	_ = ch.Close()
+}
@@ -0,0 +1,305 @@
+package utilSsh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SshExitError 远程命令非 0 退出或被信号终止
+type SshExitError struct {
+	Command  string
+	ExitCode int
+	Signal   string
+	Message  string
+}
+
+func (e *SshExitError) Error() string {
+	if "" != e.Signal {
+		return fmt.Sprintf("ssh command exited by signal %s, command: %s ,message: %s", e.Signal, e.Command, e.Message)
+	}
+	return fmt.Sprintf("ssh command exited with code %d, command: %s ,message: %s", e.ExitCode, e.Command, e.Message)
+}
+
+type SshPtyOptions struct {
+	Term  string
+	Rows  int
+	Cols  int
+	Modes ssh.TerminalModes
+}
+
+// SshCommand 正在运行的远程命令，必须持续读取 Stdout/Stderr，否则远程命令会因窗口写满而阻塞
+type SshCommand struct {
+	Stdin  io.WriteCloser
+	Stdout io.Reader
+	Stderr io.Reader
+
+	command      string
+	session      *ssh.Session
+	cancelSignal ssh.Signal
+	cancelWait   time.Duration
+
+	done    chan struct{}
+	err     error
+	waitRun sync.Once
+}
+
+func (c *SshClient) newSession() (session *ssh.Session, err error) {
+	if nil == c.sshClient {
+		err = fmt.Errorf("ssh not connected")
+		return
+	}
+	session, err = c.sshClient.NewSession()
+	if err != nil {
+		err = fmt.Errorf("ssh NewSession error: %+v", err)
+	}
+	return
+}
+
+// StartCommand 启动命令并返回输入输出句柄，ctx 取消时向远程进程发送 SIGTERM，5 秒后关闭会话
+func (c *SshClient) StartCommand(ctx context.Context, command string, env ...map[string]string) (cmd *SshCommand, err error) {
+	return c.startCommand(ctx, command, nil, env...)
+}
+
+// StartPtyCommand 在伪终端中运行命令，stderr 合并到 Stdout
+func (c *SshClient) StartPtyCommand(ctx context.Context, command string, pty *SshPtyOptions, env ...map[string]string) (cmd *SshCommand, err error) {
+	if nil == pty {
+		pty = &SshPtyOptions{}
+	}
+	return c.startCommand(ctx, command, pty, env...)
+}
+
+// StartShell 打开交互式 shell，可用于 websocket 终端桥接
+func (c *SshClient) StartShell(ctx context.Context, pty *SshPtyOptions, env ...map[string]string) (cmd *SshCommand, err error) {
+	if nil == pty {
+		pty = &SshPtyOptions{}
+	}
+	return c.startCommand(ctx, "", pty, env...)
+}
+
+func (c *SshClient) startCommand(ctx context.Context, command string, pty *SshPtyOptions, env ...map[string]string) (cmd *SshCommand, err error) {
+	session, err := c.newSession()
+	if nil != err {
+		return
+	}
+	defer func() {
+		if nil != err {
+			_ = session.Close()
+		}
+	}()
+
+	for _, e := range env {
+		for k, v := range e {
+			if err1 := session.Setenv(k, v); nil != err1 {
+				err = fmt.Errorf("ssh setenv %s error: %+v", k, err1)
+				return
+			}
+		}
+	}
+
+	cmd = &SshCommand{
+		command:      command,
+		session:      session,
+		cancelSignal: ssh.SIGTERM,
+		cancelWait:   time.Duration(5) * time.Second,
+		done:         make(chan struct{}),
+	}
+	if cmd.Stdin, err = session.StdinPipe(); nil != err {
+		return
+	}
+	if cmd.Stdout, err = session.StdoutPipe(); nil != err {
+		return
+	}
+	if cmd.Stderr, err = session.StderrPipe(); nil != err {
+		return
+	}
+
+	if nil != pty {
+		if "" == pty.Term {
+			pty.Term = "xterm-256color"
+		}
+		if pty.Rows <= 0 {
+			pty.Rows = 24
+		}
+		if pty.Cols <= 0 {
+			pty.Cols = 80
+		}
+		modes := pty.Modes
+		if nil == modes {
+			modes = ssh.TerminalModes{
+				ssh.ECHO:          1,
+				ssh.TTY_OP_ISPEED: 14400,
+				ssh.TTY_OP_OSPEED: 14400,
+			}
+		}
+		err = session.RequestPty(pty.Term, pty.Rows, pty.Cols, modes)
+		if nil != err {
+			err = fmt.Errorf("ssh request pty error: %+v", err)
+			return
+		}
+	}
+
+	if nil != pty && "" == command {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if nil != err {
+		err = fmt.Errorf("ssh start command error: %+v", err)
+		return
+	}
+
+	go func() {
+		cmd.err = cmd.convertError(session.Wait())
+		close(cmd.done)
+	}()
+	if nil != ctx && nil != ctx.Done() {
+		go func() {
+			select {
+			case <-cmd.done:
+			case <-ctx.Done():
+				_ = session.Signal(cmd.cancelSignal)
+				select {
+				case <-cmd.done:
+				case <-time.After(cmd.cancelWait):
+					_ = session.Close()
+				}
+			}
+		}()
+	}
+	return
+}
+
+// Wait 等待命令结束，非 0 退出时返回 *SshExitError
+func (cmd *SshCommand) Wait() error {
+	<-cmd.done
+	cmd.waitRun.Do(func() {
+		_ = cmd.session.Close()
+	})
+	return cmd.err
+}
+func (cmd *SshCommand) Done() <-chan struct{} {
+	return cmd.done
+}
+func (cmd *SshCommand) Signal(sig ssh.Signal) error {
+	return cmd.session.Signal(sig)
+}
+
+// SetCancelSignal 设置 ctx 取消时发送的信号及等待退出的时间
+func (cmd *SshCommand) SetCancelSignal(sig ssh.Signal, wait time.Duration) *SshCommand {
+	cmd.cancelSignal = sig
+	cmd.cancelWait = wait
+	return cmd
+}
+func (cmd *SshCommand) WindowChange(rows int, cols int) error {
+	return cmd.session.WindowChange(rows, cols)
+}
+func (cmd *SshCommand) Close() error {
+	return cmd.session.Close()
+}
+
+func (cmd *SshCommand) convertError(err error) error {
+	if nil == err {
+		return nil
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return &SshExitError{
+			Command:  cmd.command,
+			ExitCode: exitErr.ExitStatus(),
+			Signal:   exitErr.Signal(),
+			Message:  exitErr.Msg(),
+		}
+	}
+	var missingErr *ssh.ExitMissingError
+	if errors.As(err, &missingErr) {
+		return &SshExitError{Command: cmd.command, ExitCode: -1, Message: missingErr.Error()}
+	}
+	return err
+}
+
+// ExecStream 逐行回调输出，stdin 可为 nil，返回 *SshExitError 表示命令非 0 退出
+func (c *SshClient) ExecStream(ctx context.Context, command string, stdin io.Reader, onStdout func(line string), onStderr func(line string)) (err error) {
+	cmd, err := c.StartCommand(ctx, command)
+	if nil != err {
+		return
+	}
+
+	go func() {
+		if nil != stdin {
+			_, _ = io.Copy(cmd.Stdin, stdin)
+		}
+		_ = cmd.Stdin.Close()
+	}()
+
+	var outErr, errErr error
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		outErr = scanLines(cmd.Stdout, onStdout)
+	}()
+	go func() {
+		defer wg.Done()
+		errErr = scanLines(cmd.Stderr, onStderr)
+	}()
+	wg.Wait()
+
+	err = cmd.Wait()
+	if nil == err && nil != outErr {
+		err = fmt.Errorf("ssh read stdout error: %+v", outErr)
+	}
+	if nil == err && nil != errErr {
+		err = fmt.Errorf("ssh read stderr error: %+v", errErr)
+	}
+	return
+}
+
+// ExecOutput 分别返回 stdout 和 stderr
+func (c *SshClient) ExecOutput(ctx context.Context, command string) (stdout string, stderr string, err error) {
+	cmd, err := c.StartCommand(ctx, command)
+	if nil != err {
+		return
+	}
+	_ = cmd.Stdin.Close()
+
+	var outBuf, errBuf []byte
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		outBuf, _ = io.ReadAll(cmd.Stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		errBuf, _ = io.ReadAll(cmd.Stderr)
+	}()
+	wg.Wait()
+
+	err = cmd.Wait()
+	stdout = string(outBuf)
+	stderr = string(errBuf)
+	return
+}
+
+// scanLines 逐行回调，单行超过 1MB 时停止回调并返回 bufio.ErrTooLong，剩余输出继续读取丢弃，避免远程命令阻塞
+func scanLines(r io.Reader, fn func(line string)) (err error) {
+	if nil == fn {
+		_, _ = io.Copy(io.Discard, r)
+		return
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fn(scanner.Text())
+	}
+	err = scanner.Err()
+	_, _ = io.Copy(io.Discard, r)
+	return
+}
@@ -0,0 +1,52 @@
+package utilSsh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSshExecOutputAndExitError(t *testing.T) {
+	client := newTestSshServer(t).connect(t)
+	ctx := context.Background()
+
+	stdout, stderr, err := client.ExecOutput(ctx, "echo out; echo err >&2")
+	if nil != err || "out\n" != stdout || "err\n" != stderr {
+		t.Fatalf("exec output: %q %q %+v", stdout, stderr, err)
+	}
+
+	_, _, err = client.ExecOutput(ctx, "exit 3")
+	var exitErr *SshExitError
+	if !errors.As(err, &exitErr) || 3 != exitErr.ExitCode {
+		t.Fatalf("非 0 退出应返回 SshExitError: %+v", err)
+	}
+}
+
+func TestSshExecStream(t *testing.T) {
+	client := newTestSshServer(t).connect(t)
+	var stdout, stderr []string
+	err := client.ExecStream(context.Background(), "cat; echo done >&2", strings.NewReader("a\nb\n"),
+		func(line string) { stdout = append(stdout, line) },
+		func(line string) { stderr = append(stderr, line) })
+	if nil != err {
+		t.Fatalf("exec stream: %+v", err)
+	}
+	if "a,b" != strings.Join(stdout, ",") || "done" != strings.Join(stderr, ",") {
+		t.Fatalf("stdout %v ,stderr %v", stdout, stderr)
+	}
+}
+
+func TestSshExecStreamLineTooLong(t *testing.T) {
+	client := newTestSshServer(t).connect(t)
+	var lines []string
+	err := client.ExecStream(context.Background(), "echo short; head -c 2000000 /dev/zero | tr '\\0' x; echo", nil,
+		func(line string) { lines = append(lines, line) }, nil)
+	if nil == err || !strings.Contains(err.Error(), bufio.ErrTooLong.Error()) {
+		t.Fatalf("超过 1MB 的行应返回错误: %+v", err)
+	}
+	if 1 != len(lines) || "short" != lines[0] {
+		t.Fatalf("lines = %d", len(lines))
+	}
+}
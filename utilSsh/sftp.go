@@ -0,0 +1,641 @@
+package utilSsh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hilaoyu/go-utils/utilBuf"
+	"github.com/hilaoyu/go-utils/utilFile"
+	"github.com/pkg/sftp"
+)
+
+const (
+	SftpSkipNone        = iota // 总是传输
+	SftpSkipSizeModTime        // 大小和修改时间(秒)都相同时跳过
+	SftpSkipChecksum           // 大小和 md5 都相同时跳过
+)
+
+const (
+	SftpSyncUpload   = iota // 本地 -> 远程
+	SftpSyncDownload        // 远程 -> 本地
+	SftpSyncBoth            // 双向，较新的一方覆盖较旧的一方，不删除文件
+)
+
+const (
+	SftpActionTransfer = "transfer"
+	SftpActionSkip     = "skip"
+	SftpActionDelete   = "delete"
+
+	sftpPartSuffix     = ".part"
+	sftpPartMetaSuffix = ".meta"
+)
+
+type SftpTransferOptions struct {
+	// Filter 返回 false 时忽略该文件，目录返回 false 时忽略整个目录，path 为源端完整路径
+	Filter        utilFile.FilterFunc
+	PreservePerm  bool
+	PreserveTimes bool
+	// Resume 传输先写入 .part 临时文件，中断后再次传输时从 .part 的长度处续传
+	// 源文件的大小和修改时间记录在 .part.meta 中，变化时重新传输
+	Resume   bool
+	SkipMode int
+	// Progress 参数为本次调用累计写入的字节数
+	Progress utilBuf.BufCopyProgressFunc
+	OnFile   func(action string, src string, dst string)
+}
+
+type SftpSyncOptions struct {
+	SftpTransferOptions
+	Direction int
+	// Delete 删除目标端多余的文件(被 Filter 忽略的文件保留)，SftpSyncBoth 时无效
+	Delete bool
+}
+
+type SftpSyncResult struct {
+	Transferred []string `json:"transferred"`
+	Skipped     []string `json:"skipped"`
+	Deleted     []string `json:"deleted"`
+	Bytes       int64    `json:"bytes"`
+}
+
+func (c *SshClient) sftp() (client *sftp.Client, err error) {
+	if nil == c.sftpClient {
+		err = fmt.Errorf("sftp not connected")
+		return
+	}
+	client = c.sftpClient
+	return
+}
+
+func (c *SshClient) UploadFile(localFile string, remoteFile string, opts ...*SftpTransferOptions) (err error) {
+	t, err := c.newSftpTransfer(opts...)
+	if nil != err {
+		return
+	}
+	return t.copyPath(t.local, localFile, t.remote, remoteFile)
+}
+func (c *SshClient) DownloadFile(remoteFile string, localFile string, opts ...*SftpTransferOptions) (err error) {
+	t, err := c.newSftpTransfer(opts...)
+	if nil != err {
+		return
+	}
+	return t.copyPath(t.remote, remoteFile, t.local, localFile)
+}
+
+// UploadDir 递归上传目录
+func (c *SshClient) UploadDir(localDir string, remoteDir string, opts ...*SftpTransferOptions) (err error) {
+	t, err := c.newSftpTransfer(opts...)
+	if nil != err {
+		return
+	}
+	return t.copyPath(t.local, localDir, t.remote, remoteDir)
+}
+
+// DownloadDir 递归下载目录
+func (c *SshClient) DownloadDir(remoteDir string, localDir string, opts ...*SftpTransferOptions) (err error) {
+	t, err := c.newSftpTransfer(opts...)
+	if nil != err {
+		return
+	}
+	return t.copyPath(t.remote, remoteDir, t.local, localDir)
+}
+
+// Sync 类似 rsync 同步目录，SkipMode 为 SftpSkipSizeModTime 时自动保留修改时间
+func (c *SshClient) Sync(localDir string, remoteDir string, opts *SftpSyncOptions) (result *SftpSyncResult, err error) {
+	if nil == opts {
+		opts = &SftpSyncOptions{}
+		opts.SkipMode = SftpSkipSizeModTime
+	}
+	transferOpts := opts.SftpTransferOptions
+	if SftpSkipSizeModTime == transferOpts.SkipMode {
+		transferOpts.PreserveTimes = true
+	}
+	t, err := c.newSftpTransfer(&transferOpts)
+	if nil != err {
+		return
+	}
+	result = t.result
+
+	switch opts.Direction {
+	case SftpSyncUpload:
+		err = t.syncOneWay(t.local, localDir, t.remote, remoteDir, opts.Delete)
+	case SftpSyncDownload:
+		err = t.syncOneWay(t.remote, remoteDir, t.local, localDir, opts.Delete)
+	case SftpSyncBoth:
+		err = t.syncBoth(localDir, remoteDir)
+	default:
+		err = fmt.Errorf("unsupported sync direction: %d", opts.Direction)
+	}
+	return
+}
+
+type sftpTransfer struct {
+	opts    *SftpTransferOptions
+	local   sftpFs
+	remote  sftpFs
+	bufCopy *utilBuf.BufCopy
+	result  *SftpSyncResult
+}
+
+func (c *SshClient) newSftpTransfer(opts ...*SftpTransferOptions) (t *sftpTransfer, err error) {
+	client, err := c.sftp()
+	if nil != err {
+		return
+	}
+	t = &sftpTransfer{
+		opts:    &SftpTransferOptions{},
+		local:   &sftpLocalFs{},
+		remote:  &sftpRemoteFs{client: client},
+		bufCopy: utilBuf.NewBufCopy(),
+		result:  &SftpSyncResult{},
+	}
+	if len(opts) > 0 && nil != opts[0] {
+		t.opts = opts[0]
+	}
+	return
+}
+
+func (t *sftpTransfer) included(info os.FileInfo, p string) bool {
+	if strings.HasSuffix(p, sftpPartSuffix) || strings.HasSuffix(p, sftpPartSuffix+sftpPartMetaSuffix) {
+		return false
+	}
+	return nil == t.opts.Filter || t.opts.Filter(info, p)
+}
+
+// walk 返回 root 下所有未被过滤的文件和目录，key 为 / 分隔的相对路径
+func (t *sftpTransfer) walk(fs sftpFs, root string) (entries map[string]os.FileInfo, err error) {
+	entries = map[string]os.FileInfo{}
+	err = fs.Walk(root, func(p string, info os.FileInfo) error {
+		rel := fs.Rel(root, p)
+		if "" == rel {
+			return nil
+		}
+		if !t.included(info, p) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entries[rel] = info
+		return nil
+	})
+	return
+}
+
+func (t *sftpTransfer) copyPath(src sftpFs, srcPath string, dst sftpFs, dstPath string) (err error) {
+	info, err := src.Stat(srcPath)
+	if nil != err {
+		err = fmt.Errorf("stat %s error: %+v", srcPath, err)
+		return
+	}
+	if !info.IsDir() {
+		return t.copyFile(src, srcPath, info, dst, dstPath)
+	}
+	return t.syncOneWay(src, srcPath, dst, dstPath, false)
+}
+
+func (t *sftpTransfer) syncOneWay(src sftpFs, srcRoot string, dst sftpFs, dstRoot string, del bool) (err error) {
+	srcEntries, err := t.walk(src, srcRoot)
+	if nil != err {
+		return
+	}
+	err = dst.MkdirAll(dstRoot)
+	if nil != err {
+		return
+	}
+
+	var dirs []string
+	for _, rel := range sortedKeys(srcEntries) {
+		info := srcEntries[rel]
+		srcPath := src.Join(srcRoot, rel)
+		dstPath := dst.Join(dstRoot, rel)
+		if info.IsDir() {
+			err = dst.MkdirAll(dstPath)
+			if nil != err {
+				return
+			}
+			dirs = append(dirs, rel)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		err = t.copyFile(src, srcPath, info, dst, dstPath)
+		if nil != err {
+			return
+		}
+	}
+	// 目录属性在目录内文件写完后再设置，否则修改时间会被覆盖
+	for i := len(dirs) - 1; i >= 0; i-- {
+		t.preserve(dst, dst.Join(dstRoot, dirs[i]), srcEntries[dirs[i]])
+	}
+
+	if !del {
+		return
+	}
+	dstEntries, err := t.walk(dst, dstRoot)
+	if nil != err {
+		return
+	}
+	for _, rel := range sortedKeys(dstEntries) {
+		if _, ok := srcEntries[rel]; ok {
+			continue
+		}
+		if parent := path.Dir(rel); "." != parent {
+			if _, ok := dstEntries[parent]; ok {
+				if _, ok := srcEntries[parent]; !ok {
+					// 父目录已被删除
+					continue
+				}
+			}
+		}
+		dstPath := dst.Join(dstRoot, rel)
+		err = dst.RemoveAll(dstPath)
+		if nil != err {
+			err = fmt.Errorf("delete %s error: %+v", dstPath, err)
+			return
+		}
+		t.result.Deleted = append(t.result.Deleted, dstPath)
+		t.onFile(SftpActionDelete, "", dstPath)
+	}
+	return
+}
+
+func (t *sftpTransfer) syncBoth(localRoot string, remoteRoot string) (err error) {
+	localEntries, err := t.walk(t.local, localRoot)
+	if nil != err {
+		return
+	}
+	if err = t.remote.MkdirAll(remoteRoot); nil != err {
+		return
+	}
+	remoteEntries, err := t.walk(t.remote, remoteRoot)
+	if nil != err {
+		return
+	}
+
+	all := map[string]os.FileInfo{}
+	for rel, info := range localEntries {
+		all[rel] = info
+	}
+	for rel, info := range remoteEntries {
+		all[rel] = info
+	}
+	for _, rel := range sortedKeys(all) {
+		localInfo, localOk := localEntries[rel]
+		remoteInfo, remoteOk := remoteEntries[rel]
+		localPath := t.local.Join(localRoot, rel)
+		remotePath := t.remote.Join(remoteRoot, rel)
+
+		switch {
+		case localOk && localInfo.IsDir():
+			err = t.remote.MkdirAll(remotePath)
+		case remoteOk && remoteInfo.IsDir():
+			err = t.local.MkdirAll(localPath)
+		case localOk && !localInfo.Mode().IsRegular(), remoteOk && !remoteInfo.Mode().IsRegular():
+		case !remoteOk || (localOk && localInfo.ModTime().Unix() > remoteInfo.ModTime().Unix()):
+			err = t.copyFile(t.local, localPath, localInfo, t.remote, remotePath)
+		case !localOk || remoteInfo.ModTime().Unix() > localInfo.ModTime().Unix():
+			err = t.copyFile(t.remote, remotePath, remoteInfo, t.local, localPath)
+		default:
+			t.result.Skipped = append(t.result.Skipped, remotePath)
+			t.onFile(SftpActionSkip, localPath, remotePath)
+		}
+		if nil != err {
+			return
+		}
+	}
+	return
+}
+
+func (t *sftpTransfer) copyFile(src sftpFs, srcPath string, srcInfo os.FileInfo, dst sftpFs, dstPath string) (err error) {
+	skip, err := t.shouldSkip(src, srcPath, srcInfo, dst, dstPath)
+	if nil != err {
+		return
+	}
+	if skip {
+		t.result.Skipped = append(t.result.Skipped, dstPath)
+		t.onFile(SftpActionSkip, srcPath, dstPath)
+		return
+	}
+
+	err = dst.MkdirAll(dst.Dir(dstPath))
+	if nil != err {
+		return
+	}
+
+	partPath := dstPath + sftpPartSuffix
+	metaPath := partPath + sftpPartMetaSuffix
+	var offset int64
+	if t.opts.Resume {
+		// 源文件的大小和修改时间与 .part 开始时记录的一致才续传，否则重新传输
+		srcMeta := fmt.Sprintf("%d %d", srcInfo.Size(), srcInfo.ModTime().Unix())
+		if partInfo, err1 := dst.Stat(partPath); nil == err1 && partInfo.Size() <= srcInfo.Size() && srcMeta == readSftpPartMeta(dst, metaPath) {
+			offset = partInfo.Size()
+		}
+		if offset <= 0 {
+			if err = writeSftpPartMeta(dst, metaPath, srcMeta); nil != err {
+				err = fmt.Errorf("write %s error: %+v", metaPath, err)
+				return
+			}
+		}
+	}
+
+	r, err := src.Open(srcPath)
+	if nil != err {
+		err = fmt.Errorf("open %s error: %+v", srcPath, err)
+		return
+	}
+	defer r.Close()
+	if offset > 0 {
+		if _, err = r.Seek(offset, io.SeekStart); nil != err {
+			return
+		}
+	}
+	w, err := dst.OpenWrite(partPath, offset)
+	if nil != err {
+		err = fmt.Errorf("create %s error: %+v", partPath, err)
+		return
+	}
+
+	var written int64
+	if nil != t.opts.Progress {
+		base := t.result.Bytes
+		// 隐藏 ReaderFrom/WriterTo，保证 BufCopy 逐块回调进度
+		written, err = t.bufCopy.Copy(struct{ io.Writer }{w}, struct{ io.Reader }{r}, func(n int64) error {
+			return t.opts.Progress(base + n)
+		})
+	} else {
+		written, err = t.bufCopy.Copy(w, r)
+	}
+	t.result.Bytes += written
+	if errClose := w.Close(); nil == err {
+		err = errClose
+	}
+	if nil != err {
+		err = fmt.Errorf("copy %s to %s error: %+v", srcPath, dstPath, err)
+		return
+	}
+
+	err = dst.Rename(partPath, dstPath)
+	if nil != err {
+		err = fmt.Errorf("rename %s error: %+v", partPath, err)
+		return
+	}
+	if t.opts.Resume {
+		_ = dst.RemoveAll(metaPath)
+	}
+	t.preserve(dst, dstPath, srcInfo)
+	t.result.Transferred = append(t.result.Transferred, dstPath)
+	t.onFile(SftpActionTransfer, srcPath, dstPath)
+	return
+}
+
+func readSftpPartMeta(fs sftpFs, metaPath string) string {
+	r, err := fs.Open(metaPath)
+	if nil != err {
+		return ""
+	}
+	defer r.Close()
+	b, _ := io.ReadAll(io.LimitReader(r, 128))
+	return strings.TrimSpace(string(b))
+}
+
+func writeSftpPartMeta(fs sftpFs, metaPath string, meta string) (err error) {
+	w, err := fs.OpenWrite(metaPath, 0)
+	if nil != err {
+		return
+	}
+	_, err = io.WriteString(w, meta)
+	if errClose := w.Close(); nil == err {
+		err = errClose
+	}
+	return
+}
+
+func (t *sftpTransfer) shouldSkip(src sftpFs, srcPath string, srcInfo os.FileInfo, dst sftpFs, dstPath string) (skip bool, err error) {
+	if SftpSkipNone == t.opts.SkipMode {
+		return
+	}
+	dstInfo, err1 := dst.Stat(dstPath)
+	if nil != err1 || dstInfo.IsDir() || dstInfo.Size() != srcInfo.Size() {
+		return
+	}
+	switch t.opts.SkipMode {
+	case SftpSkipSizeModTime:
+		skip = dstInfo.ModTime().Unix() == srcInfo.ModTime().Unix()
+	case SftpSkipChecksum:
+		var srcMd5, dstMd5 string
+		if srcMd5, err = fsMd5(src, srcPath); nil != err {
+			return
+		}
+		if dstMd5, err = fsMd5(dst, dstPath); nil != err {
+			return
+		}
+		skip = srcMd5 == dstMd5
+	}
+	return
+}
+
+func (t *sftpTransfer) preserve(dst sftpFs, dstPath string, srcInfo os.FileInfo) {
+	if t.opts.PreservePerm {
+		_ = dst.Chmod(dstPath, srcInfo.Mode().Perm())
+	}
+	if t.opts.PreserveTimes {
+		_ = dst.Chtimes(dstPath, srcInfo.ModTime(), srcInfo.ModTime())
+	}
+}
+
+func (t *sftpTransfer) onFile(action string, src string, dst string) {
+	if nil != t.opts.OnFile {
+		t.opts.OnFile(action, src, dst)
+	}
+}
+
+func fsMd5(fs sftpFs, p string) (sum string, err error) {
+	r, err := fs.Open(p)
+	if nil != err {
+		return
+	}
+	defer r.Close()
+	return utilFile.Md5FromReader(r)
+}
+
+func sortedKeys(m map[string]os.FileInfo) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// sftpFs 统一本地和远程文件操作，上传下载共用同一套逻辑
+type sftpFs interface {
+	Stat(p string) (os.FileInfo, error)
+	Open(p string) (io.ReadSeekCloser, error)
+	OpenWrite(p string, offset int64) (io.WriteCloser, error)
+	MkdirAll(p string) error
+	Rename(oldPath string, newPath string) error
+	RemoveAll(p string) error
+	Chmod(p string, mode os.FileMode) error
+	Chtimes(p string, atime time.Time, mtime time.Time) error
+	Walk(root string, fn func(p string, info os.FileInfo) error) error
+	Join(root string, rel string) string
+	Rel(root string, p string) string
+	Dir(p string) string
+}
+
+type sftpLocalFs struct{}
+
+func (fs *sftpLocalFs) Stat(p string) (os.FileInfo, error) {
+	return os.Stat(p)
+}
+func (fs *sftpLocalFs) Open(p string) (io.ReadSeekCloser, error) {
+	return os.Open(p)
+}
+func (fs *sftpLocalFs) OpenWrite(p string, offset int64) (w io.WriteCloser, err error) {
+	flag := os.O_WRONLY | os.O_CREATE
+	if offset <= 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(p, flag, 0644)
+	if nil != err {
+		return
+	}
+	if _, err = f.Seek(offset, io.SeekStart); nil != err {
+		_ = f.Close()
+		return
+	}
+	w = f
+	return
+}
+func (fs *sftpLocalFs) MkdirAll(p string) error {
+	return os.MkdirAll(p, 0755)
+}
+func (fs *sftpLocalFs) Rename(oldPath string, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+func (fs *sftpLocalFs) RemoveAll(p string) error {
+	return os.RemoveAll(p)
+}
+func (fs *sftpLocalFs) Chmod(p string, mode os.FileMode) error {
+	return os.Chmod(p, mode)
+}
+func (fs *sftpLocalFs) Chtimes(p string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(p, atime, mtime)
+}
+func (fs *sftpLocalFs) Walk(root string, fn func(p string, info os.FileInfo) error) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		return fn(p, info)
+	})
+}
+func (fs *sftpLocalFs) Join(root string, rel string) string {
+	return filepath.Join(root, filepath.FromSlash(rel))
+}
+func (fs *sftpLocalFs) Rel(root string, p string) string {
+	rel, err := filepath.Rel(root, p)
+	if nil != err || "." == rel {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+func (fs *sftpLocalFs) Dir(p string) string {
+	return filepath.Dir(p)
+}
+
+type sftpRemoteFs struct {
+	client *sftp.Client
+}
+
+func (fs *sftpRemoteFs) Stat(p string) (os.FileInfo, error) {
+	return fs.client.Stat(p)
+}
+func (fs *sftpRemoteFs) Open(p string) (io.ReadSeekCloser, error) {
+	return fs.client.Open(p)
+}
+func (fs *sftpRemoteFs) OpenWrite(p string, offset int64) (w io.WriteCloser, err error) {
+	flag := os.O_WRONLY | os.O_CREATE
+	if offset <= 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := fs.client.OpenFile(p, flag)
+	if nil != err {
+		return
+	}
+	if _, err = f.Seek(offset, io.SeekStart); nil != err {
+		_ = f.Close()
+		return
+	}
+	w = f
+	return
+}
+func (fs *sftpRemoteFs) MkdirAll(p string) error {
+	return fs.client.MkdirAll(p)
+}
+func (fs *sftpRemoteFs) Rename(oldPath string, newPath string) (err error) {
+	if _, ok := fs.client.HasExtension("posix-rename@openssh.com"); ok {
+		return fs.client.PosixRename(oldPath, newPath)
+	}
+	if _, err1 := fs.client.Stat(newPath); nil == err1 {
+		if err = fs.client.Remove(newPath); nil != err {
+			return
+		}
+	}
+	return fs.client.Rename(oldPath, newPath)
+}
+func (fs *sftpRemoteFs) RemoveAll(p string) error {
+	return fs.client.RemoveAll(p)
+}
+func (fs *sftpRemoteFs) Chmod(p string, mode os.FileMode) error {
+	return fs.client.Chmod(p, mode)
+}
+func (fs *sftpRemoteFs) Chtimes(p string, atime time.Time, mtime time.Time) error {
+	return fs.client.Chtimes(p, atime, mtime)
+}
+func (fs *sftpRemoteFs) Walk(root string, fn func(p string, info os.FileInfo) error) error {
+	walker := fs.client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); nil != err {
+			return err
+		}
+		err := fn(walker.Path(), walker.Stat())
+		if filepath.SkipDir == err {
+			walker.SkipDir()
+			continue
+		}
+		if nil != err {
+			return err
+		}
+	}
+	return nil
+}
+func (fs *sftpRemoteFs) Join(root string, rel string) string {
+	return path.Join(root, rel)
+}
+func (fs *sftpRemoteFs) Rel(root string, p string) string {
+	root = path.Clean(root)
+	p = path.Clean(p)
+	switch {
+	case p == root:
+		return ""
+	case "." == root && !strings.HasPrefix(p, "../") && !path.IsAbs(p):
+		return p
+	case "/" == root:
+		return strings.TrimPrefix(p, "/")
+	case strings.HasPrefix(p, root+"/"):
+		return p[len(root)+1:]
+	}
+	return ""
+}
+func (fs *sftpRemoteFs) Dir(p string) string {
+	return path.Dir(p)
+}
@@ -0,0 +1,98 @@
+package utilSsh
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); nil != err {
+			t.Fatalf("mkdir: %+v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); nil != err {
+			t.Fatalf("write: %+v", err)
+		}
+	}
+}
+
+func readTestFiles(t *testing.T, root string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if nil != err || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		content, err := os.ReadFile(p)
+		files[filepath.ToSlash(rel)] = string(content)
+		return err
+	})
+	if nil != err {
+		t.Fatalf("walk: %+v", err)
+	}
+	return files
+}
+
+func TestSftpUploadDownloadDir(t *testing.T) {
+	client := newTestSshServer(t).connect(t)
+	files := map[string]string{"a.txt": "a", "sub/b.txt": "bb", "sub/deep/c.txt": "ccc"}
+	local := t.TempDir()
+	writeTestFiles(t, local, files)
+
+	// 测试服务端直接操作本机文件系统
+	remote := filepath.Join(t.TempDir(), "remote")
+	if err := client.UploadDir(local, remote); nil != err {
+		t.Fatalf("upload: %+v", err)
+	}
+	if got := readTestFiles(t, remote); len(got) != len(files) || "ccc" != got["sub/deep/c.txt"] {
+		t.Fatalf("上传结果 = %v", got)
+	}
+
+	back := filepath.Join(t.TempDir(), "back")
+	if err := client.DownloadDir(remote, back); nil != err {
+		t.Fatalf("download: %+v", err)
+	}
+	got := readTestFiles(t, back)
+	for name, content := range files {
+		if got[name] != content {
+			t.Fatalf("下载结果 %s = %q, want %q", name, got[name], content)
+		}
+	}
+}
+
+func TestSftpSyncUpload(t *testing.T) {
+	client := newTestSshServer(t).connect(t)
+	local := t.TempDir()
+	remote := filepath.Join(t.TempDir(), "remote")
+	writeTestFiles(t, local, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+
+	opts := &SftpSyncOptions{Direction: SftpSyncUpload, Delete: true}
+	opts.SkipMode = SftpSkipSizeModTime
+	result, err := client.Sync(local, remote, opts)
+	if nil != err || 2 != len(result.Transferred) {
+		t.Fatalf("首次同步: %+v %+v", result, err)
+	}
+
+	result, err = client.Sync(local, remote, opts)
+	if nil != err || 0 != len(result.Transferred) || 2 != len(result.Skipped) {
+		t.Fatalf("未变化的文件应跳过: %+v %+v", result, err)
+	}
+
+	_ = os.Remove(filepath.Join(local, "a.txt"))
+	writeTestFiles(t, local, map[string]string{"sub/c.txt": "c"})
+	result, err = client.Sync(local, remote, opts)
+	if nil != err || 1 != len(result.Transferred) || 1 != len(result.Deleted) {
+		t.Fatalf("第三次同步: %+v %+v", result, err)
+	}
+	var names []string
+	for name := range readTestFiles(t, remote) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if 2 != len(names) || "sub/b.txt" != names[0] || "sub/c.txt" != names[1] {
+		t.Fatalf("远程文件 = %v", names)
+	}
+}
@@ -0,0 +1,598 @@
+package utilSsl
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hilaoyu/go-utils/utilLogger"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	AcmeDirectoryLetsEncrypt        = acme.LetsEncryptURL
+	AcmeDirectoryLetsEncryptStaging = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+	AcmeChallengeHttp01 = "http-01"
+	AcmeChallengeDns01  = "dns-01"
+
+	AcmeHttpChallengePathPrefix = "/.well-known/acme-challenge/"
+
+	acmeAccountKeyName = "acme_account+key"
+)
+
+// AcmeDnsProvider 用于 dns-01 验证，fqdn 形如 _acme-challenge.example.com. ，value 为 TXT 记录值
+type AcmeDnsProvider interface {
+	Present(ctx context.Context, domain string, fqdn string, value string) error
+	CleanUp(ctx context.Context, domain string, fqdn string, value string) error
+}
+
+type AcmeClient struct {
+	directoryUrl string
+	email        string
+	store        AcmeCertStore
+	httpClient   *http.Client
+
+	httpChallenge     bool
+	dnsProvider       AcmeDnsProvider
+	dnsPropagation    time.Duration
+	renewBefore       time.Duration
+	certDomains       [][]string
+	certDomainsLocker sync.RWMutex
+
+	client       *acme.Client
+	clientLocker sync.Mutex
+
+	certs       map[string]*tls.Certificate
+	certsLocker sync.RWMutex
+
+	obtainLockers       map[string]*sync.Mutex
+	obtainLockersLocker sync.Mutex
+
+	httpTokens       map[string]string
+	httpTokensLocker sync.RWMutex
+
+	renewStop   chan struct{}
+	renewLocker sync.Mutex
+	logger      *utilLogger.Logger
+}
+
+func NewAcmeClient(directoryUrl string, email string, store AcmeCertStore) *AcmeClient {
+	if "" == directoryUrl {
+		directoryUrl = AcmeDirectoryLetsEncrypt
+	}
+	if nil == store {
+		store = newAcmeMemoryStore()
+	}
+	return &AcmeClient{
+		directoryUrl:  directoryUrl,
+		email:         strings.TrimSpace(email),
+		store:         store,
+		httpChallenge: true,
+		renewBefore:   time.Duration(30*24) * time.Hour,
+		certs:         map[string]*tls.Certificate{},
+		obtainLockers: map[string]*sync.Mutex{},
+		httpTokens:    map[string]string{},
+	}
+}
+
+// AddCertificate 登记一张证书，第一个域名作为证书名，支持 *.example.com (需要 dns-01)
+func (ac *AcmeClient) AddCertificate(domains ...string) *AcmeClient {
+	var names []string
+	for _, domain := range domains {
+		domain = acmeNormalizeName(domain)
+		if "" != domain {
+			names = append(names, domain)
+		}
+	}
+	if len(names) <= 0 {
+		return ac
+	}
+	ac.certDomainsLocker.Lock()
+	defer ac.certDomainsLocker.Unlock()
+	ac.certDomains = append(ac.certDomains, names)
+	return ac
+}
+
+// SetHttpClient 用于对接 Pebble 等自签名的 ACME 服务
+func (ac *AcmeClient) SetHttpClient(client *http.Client) *AcmeClient {
+	ac.httpClient = client
+	return ac
+}
+func (ac *AcmeClient) SetHttpChallenge(enable bool) *AcmeClient {
+	ac.httpChallenge = enable
+	return ac
+}
+func (ac *AcmeClient) SetDnsProvider(provider AcmeDnsProvider, propagation ...time.Duration) *AcmeClient {
+	ac.dnsProvider = provider
+	if len(propagation) > 0 {
+		ac.dnsPropagation = propagation[0]
+	}
+	return ac
+}
+func (ac *AcmeClient) SetRenewBefore(d time.Duration) *AcmeClient {
+	ac.renewBefore = d
+	return ac
+}
+func (ac *AcmeClient) SetLogger(logger *utilLogger.Logger) *AcmeClient {
+	ac.logger = logger
+	return ac
+}
+
+// HttpHandler 响应 http-01 验证请求，其他请求交给 fallback
+func (ac *AcmeClient) HttpHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, AcmeHttpChallengePathPrefix) {
+			if nil == fallback {
+				http.NotFound(w, r)
+				return
+			}
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.URL.Path, AcmeHttpChallengePathPrefix)
+		ac.httpTokensLocker.RLock()
+		keyAuth, ok := ac.httpTokens[token]
+		ac.httpTokensLocker.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(keyAuth))
+	})
+}
+
+func (ac *AcmeClient) TlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: ac.GetCertificate,
+		NextProtos:     []string{"http/1.1"},
+	}
+}
+
+func (ac *AcmeClient) GetCertificate(hello *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
+	name := acmeNormalizeName(hello.ServerName)
+	domains := ac.matchDomains(name)
+	if len(domains) <= 0 {
+		err = fmt.Errorf("acme: host %q not configured", hello.ServerName)
+		return
+	}
+	ctx, cancel := context.WithTimeout(hello.Context(), time.Duration(5)*time.Minute)
+	defer cancel()
+	return ac.Certificate(ctx, domains...)
+}
+
+// Certificate 依次从内存、存储中读取证书，都没有可用证书时向 ACME 服务申请
+func (ac *AcmeClient) Certificate(ctx context.Context, domains ...string) (cert *tls.Certificate, err error) {
+	if len(domains) <= 0 {
+		err = fmt.Errorf("acme: domains can't be empty")
+		return
+	}
+	name := domains[0]
+	cert = ac.cachedCert(name)
+	if nil != cert {
+		return
+	}
+
+	locker := ac.obtainLocker(name)
+	locker.Lock()
+	defer locker.Unlock()
+
+	cert = ac.cachedCert(name)
+	if nil != cert {
+		return
+	}
+	cert, err = ac.loadCert(ctx, name)
+	if nil == err && time.Now().Before(cert.Leaf.NotAfter) {
+		ac.cacheCert(name, cert)
+		return
+	}
+
+	return ac.obtain(ctx, domains...)
+}
+
+// ObtainCertificate 立即申请新证书，不论已有证书是否有效
+func (ac *AcmeClient) ObtainCertificate(ctx context.Context, domains ...string) (cert *tls.Certificate, err error) {
+	if len(domains) <= 0 {
+		err = fmt.Errorf("acme: domains can't be empty")
+		return
+	}
+	locker := ac.obtainLocker(domains[0])
+	locker.Lock()
+	defer locker.Unlock()
+	return ac.obtain(ctx, domains...)
+}
+
+// RenewCertificates 续期所有即将过期(renewBefore 以内)或不存在的证书
+func (ac *AcmeClient) RenewCertificates(ctx context.Context) (err error) {
+	ac.certDomainsLocker.RLock()
+	certDomains := append([][]string{}, ac.certDomains...)
+	ac.certDomainsLocker.RUnlock()
+
+	var errs []error
+	for _, domains := range certDomains {
+		name := domains[0]
+		cert := ac.cachedCert(name)
+		if nil == cert {
+			cert, _ = ac.loadCert(ctx, name)
+		}
+		if nil != cert && time.Now().Add(ac.renewBefore).Before(cert.Leaf.NotAfter) {
+			ac.cacheCert(name, cert)
+			continue
+		}
+		ac.logInfo(fmt.Sprintf("acme: renew certificate %s", name))
+		_, err1 := ac.ObtainCertificate(ctx, domains...)
+		if nil != err1 {
+			ac.logError(fmt.Sprintf("acme: renew certificate %s error: %v", name, err1))
+			errs = append(errs, err1)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StartAutoRenew 启动后台续期，已经启动时不做任何事，需要修改间隔时先 StopAutoRenew
+func (ac *AcmeClient) StartAutoRenew(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Duration(12) * time.Hour
+	}
+	ac.renewLocker.Lock()
+	defer ac.renewLocker.Unlock()
+	if nil != ac.renewStop {
+		return
+	}
+	stop := make(chan struct{})
+	ac.renewStop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10)*time.Minute)
+			_ = ac.RenewCertificates(ctx)
+			cancel()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+func (ac *AcmeClient) StopAutoRenew() {
+	ac.renewLocker.Lock()
+	defer ac.renewLocker.Unlock()
+	if nil != ac.renewStop {
+		close(ac.renewStop)
+		ac.renewStop = nil
+	}
+}
+
+func (ac *AcmeClient) obtain(ctx context.Context, domains ...string) (cert *tls.Certificate, err error) {
+	client, err := ac.acmeClient(ctx)
+	if nil != err {
+		return
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if nil != err {
+		err = fmt.Errorf("acme: authorize order error: %v", err)
+		return
+	}
+	for _, authzUrl := range order.AuthzURLs {
+		err = ac.authorize(ctx, client, authzUrl)
+		if nil != err {
+			return
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if nil != err {
+		err = fmt.Errorf("acme: wait order error: %v", err)
+		return
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != err {
+		return
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if nil != err {
+		return
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if nil != err {
+		err = fmt.Errorf("acme: create cert error: %v", err)
+		return
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if nil != err {
+		return
+	}
+	content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	for _, b := range der {
+		content = append(content, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	cert, err = ParseTlsCertificateContent(content)
+	if nil != err {
+		return
+	}
+	err = ac.store.Put(ctx, domains[0], content)
+	if nil != err {
+		ac.logError(fmt.Sprintf("acme: store certificate %s error: %v", domains[0], err))
+		err = nil
+	}
+	ac.cacheCert(domains[0], cert)
+	ac.logInfo(fmt.Sprintf("acme: certificate %s obtained, expire at %s", domains[0], cert.Leaf.NotAfter))
+	return
+}
+
+func (ac *AcmeClient) authorize(ctx context.Context, client *acme.Client, authzUrl string) (err error) {
+	authz, err := client.GetAuthorization(ctx, authzUrl)
+	if nil != err {
+		return
+	}
+	if acme.StatusValid == authz.Status {
+		return
+	}
+	domain := authz.Identifier.Value
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if AcmeChallengeDns01 == c.Type && nil != ac.dnsProvider {
+			chal = c
+			break
+		}
+		if AcmeChallengeHttp01 == c.Type && ac.httpChallenge && !authz.Wildcard {
+			chal = c
+		}
+	}
+	if nil == chal {
+		err = fmt.Errorf("acme: no supported challenge for %s", domain)
+		return
+	}
+
+	switch chal.Type {
+	case AcmeChallengeHttp01:
+		keyAuth, err1 := client.HTTP01ChallengeResponse(chal.Token)
+		if nil != err1 {
+			err = err1
+			return
+		}
+		ac.httpTokensLocker.Lock()
+		ac.httpTokens[chal.Token] = keyAuth
+		ac.httpTokensLocker.Unlock()
+		defer func() {
+			ac.httpTokensLocker.Lock()
+			delete(ac.httpTokens, chal.Token)
+			ac.httpTokensLocker.Unlock()
+		}()
+	case AcmeChallengeDns01:
+		value, err1 := client.DNS01ChallengeRecord(chal.Token)
+		if nil != err1 {
+			err = err1
+			return
+		}
+		fqdn := "_acme-challenge." + strings.TrimPrefix(domain, "*.") + "."
+		err = ac.dnsProvider.Present(ctx, domain, fqdn, value)
+		if nil != err {
+			err = fmt.Errorf("acme: dns provider present %s error: %v", fqdn, err)
+			return
+		}
+		defer func() {
+			if err1 := ac.dnsProvider.CleanUp(context.Background(), domain, fqdn, value); nil != err1 {
+				ac.logError(fmt.Sprintf("acme: dns provider clean up %s error: %v", fqdn, err1))
+			}
+		}()
+		if ac.dnsPropagation > 0 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			case <-time.After(ac.dnsPropagation):
+			}
+		}
+	}
+
+	_, err = client.Accept(ctx, chal)
+	if nil != err {
+		err = fmt.Errorf("acme: accept %s challenge for %s error: %v", chal.Type, domain, err)
+		return
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	if nil != err {
+		_ = client.RevokeAuthorization(context.Background(), authz.URI)
+		err = fmt.Errorf("acme: %s challenge for %s error: %v", chal.Type, domain, err)
+		return
+	}
+	return
+}
+
+func (ac *AcmeClient) acmeClient(ctx context.Context) (client *acme.Client, err error) {
+	ac.clientLocker.Lock()
+	defer ac.clientLocker.Unlock()
+	if nil != ac.client {
+		client = ac.client
+		return
+	}
+
+	key, err := ac.accountKey(ctx)
+	if nil != err {
+		return
+	}
+	client = &acme.Client{
+		Key:          key,
+		DirectoryURL: ac.directoryUrl,
+		HTTPClient:   ac.httpClient,
+		UserAgent:    "go-utils-acme",
+	}
+	account := &acme.Account{}
+	if "" != ac.email {
+		account.Contact = []string{"mailto:" + ac.email}
+	}
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if nil != err && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		err = fmt.Errorf("acme: register account error: %v", err)
+		return
+	}
+	err = nil
+	ac.client = client
+	return
+}
+
+func (ac *AcmeClient) accountKey(ctx context.Context) (key crypto.Signer, err error) {
+	content, err := ac.store.Get(ctx, acmeAccountKeyName)
+	if nil == err {
+		block, _ := pem.Decode(content)
+		if nil == block {
+			err = fmt.Errorf("acme: account key decode error")
+			return
+		}
+		k, err1 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if nil != err1 {
+			err = fmt.Errorf("acme: account key parse error: %v", err1)
+			return
+		}
+		signer, ok := k.(crypto.Signer)
+		if !ok {
+			err = fmt.Errorf("acme: account key type error")
+			return
+		}
+		key = signer
+		return
+	}
+	if !errors.Is(err, ErrAcmeStoreMiss) {
+		return
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != err {
+		return
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if nil != err {
+		return
+	}
+	err = ac.store.Put(ctx, acmeAccountKeyName, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if nil != err {
+		return
+	}
+	key = ecKey
+	return
+}
+
+func (ac *AcmeClient) matchDomains(name string) (domains []string) {
+	ac.certDomainsLocker.RLock()
+	defer ac.certDomainsLocker.RUnlock()
+	if "" == name {
+		if len(ac.certDomains) > 0 {
+			domains = ac.certDomains[0]
+		}
+		return
+	}
+	wildcard := ""
+	if i := strings.Index(name, "."); i > 0 {
+		wildcard = "*" + name[i:]
+	}
+	for _, group := range ac.certDomains {
+		for _, domain := range group {
+			if domain == name {
+				return group
+			}
+			if "" != wildcard && domain == wildcard && nil == domains {
+				domains = group
+			}
+		}
+	}
+	return
+}
+
+func (ac *AcmeClient) cachedCert(name string) (cert *tls.Certificate) {
+	ac.certsLocker.RLock()
+	defer ac.certsLocker.RUnlock()
+	cert = ac.certs[name]
+	if nil != cert && time.Now().After(cert.Leaf.NotAfter) {
+		cert = nil
+	}
+	return
+}
+func (ac *AcmeClient) cacheCert(name string, cert *tls.Certificate) {
+	ac.certsLocker.Lock()
+	defer ac.certsLocker.Unlock()
+	ac.certs[name] = cert
+}
+func (ac *AcmeClient) loadCert(ctx context.Context, name string) (cert *tls.Certificate, err error) {
+	content, err := ac.store.Get(ctx, name)
+	if nil != err {
+		return
+	}
+	return ParseTlsCertificateContent(content)
+}
+func (ac *AcmeClient) obtainLocker(name string) *sync.Mutex {
+	ac.obtainLockersLocker.Lock()
+	defer ac.obtainLockersLocker.Unlock()
+	locker, ok := ac.obtainLockers[name]
+	if !ok {
+		locker = &sync.Mutex{}
+		ac.obtainLockers[name] = locker
+	}
+	return locker
+}
+
+func (ac *AcmeClient) logInfo(msg interface{}) {
+	if nil == ac.logger {
+		return
+	}
+	ac.logger.Info(msg)
+}
+func (ac *AcmeClient) logError(msg interface{}) {
+	if nil == ac.logger {
+		return
+	}
+	ac.logger.Error(msg)
+}
+
+// ParseTlsCertificateContent 解析 私钥+证书链 的 PEM 内容
+func ParseTlsCertificateContent(content []byte) (cert *tls.Certificate, err error) {
+	var keyPem, certPem []byte
+	var block *pem.Block
+	for {
+		block, content = pem.Decode(content)
+		if nil == block {
+			break
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			keyPem = pem.EncodeToMemory(block)
+		} else {
+			certPem = append(certPem, pem.EncodeToMemory(block)...)
+		}
+	}
+	tlsCert, err := tls.X509KeyPair(certPem, keyPem)
+	if nil != err {
+		return
+	}
+	if nil == tlsCert.Leaf {
+		tlsCert.Leaf, err = x509.ParseCertificate(tlsCert.Certificate[0])
+		if nil != err {
+			return
+		}
+	}
+	cert = &tlsCert
+	return
+}
+
+func acmeNormalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
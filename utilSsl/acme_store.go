@@ -0,0 +1,145 @@
+package utilSsl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hilaoyu/go-utils/utilCache"
+	"github.com/hilaoyu/go-utils/utilRedis"
+)
+
+var ErrAcmeStoreMiss = errors.New("acme store: miss")
+
+// AcmeCertStore 保存 ACME 账户私钥与证书，Get 未找到时返回 ErrAcmeStoreMiss
+type AcmeCertStore interface {
+	Get(ctx context.Context, name string) (data []byte, err error)
+	Put(ctx context.Context, name string, data []byte) (err error)
+	Delete(ctx context.Context, name string) (err error)
+}
+
+type AcmeFileStore struct {
+	dir string
+}
+
+func NewAcmeFileStore(dir string) *AcmeFileStore {
+	return &AcmeFileStore{dir: dir}
+}
+
+func (s *AcmeFileStore) path(name string) string {
+	return filepath.Join(s.dir, acmeStoreName(name))
+}
+func (s *AcmeFileStore) Get(ctx context.Context, name string) (data []byte, err error) {
+	data, err = os.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		err = ErrAcmeStoreMiss
+	}
+	return
+}
+func (s *AcmeFileStore) Put(ctx context.Context, name string, data []byte) (err error) {
+	err = os.MkdirAll(s.dir, 0700)
+	if nil != err {
+		return
+	}
+	tmp := s.path(name) + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if nil != err {
+		return
+	}
+	return os.Rename(tmp, s.path(name))
+}
+func (s *AcmeFileStore) Delete(ctx context.Context, name string) (err error) {
+	err = os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+type AcmeCacheStore struct {
+	cache *utilCache.Cache
+	ttl   time.Duration
+}
+
+func NewAcmeCacheStore(cache *utilCache.Cache, ttl time.Duration) *AcmeCacheStore {
+	return &AcmeCacheStore{cache: cache, ttl: ttl}
+}
+
+func (s *AcmeCacheStore) Get(ctx context.Context, name string) (data []byte, err error) {
+	v, ok := s.cache.GetString(acmeStoreName(name))
+	if !ok || "" == v {
+		err = ErrAcmeStoreMiss
+		return
+	}
+	data = []byte(v)
+	return
+}
+func (s *AcmeCacheStore) Put(ctx context.Context, name string, data []byte) (err error) {
+	return s.cache.Set(acmeStoreName(name), string(data), s.ttl)
+}
+func (s *AcmeCacheStore) Delete(ctx context.Context, name string) (err error) {
+	return s.cache.Del(acmeStoreName(name))
+}
+
+type AcmeRedisStore struct {
+	redisClient *utilRedis.RedisClient
+	keyPrefix   string
+}
+
+func NewAcmeRedisStore(redisClient *utilRedis.RedisClient, keyPrefix string) *AcmeRedisStore {
+	return &AcmeRedisStore{redisClient: redisClient, keyPrefix: keyPrefix}
+}
+
+func (s *AcmeRedisStore) Get(ctx context.Context, name string) (data []byte, err error) {
+	data, err = s.redisClient.Client.Get(ctx, s.keyPrefix+acmeStoreName(name)).Bytes()
+	if utilRedis.ErrorIsNil(err) {
+		err = ErrAcmeStoreMiss
+	}
+	return
+}
+func (s *AcmeRedisStore) Put(ctx context.Context, name string, data []byte) (err error) {
+	return s.redisClient.Client.Set(ctx, s.keyPrefix+acmeStoreName(name), data, 0).Err()
+}
+func (s *AcmeRedisStore) Delete(ctx context.Context, name string) (err error) {
+	return s.redisClient.Client.Del(ctx, s.keyPrefix+acmeStoreName(name)).Err()
+}
+
+type acmeMemoryStore struct {
+	data   map[string][]byte
+	locker sync.RWMutex
+}
+
+func newAcmeMemoryStore() *acmeMemoryStore {
+	return &acmeMemoryStore{data: map[string][]byte{}}
+}
+
+func (s *acmeMemoryStore) Get(ctx context.Context, name string) (data []byte, err error) {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	data, ok := s.data[name]
+	if !ok {
+		err = ErrAcmeStoreMiss
+	}
+	return
+}
+func (s *acmeMemoryStore) Put(ctx context.Context, name string, data []byte) (err error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.data[name] = data
+	return
+}
+func (s *acmeMemoryStore) Delete(ctx context.Context, name string) (err error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	delete(s.data, name)
+	return
+}
+
+// 通配符域名的 * 不能出现在文件名中
+func acmeStoreName(name string) string {
+	return strings.ReplaceAll(name, "*", "_")
+}
@@ -0,0 +1,28 @@
+package utilSsl
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcmeAutoRenewStartStop(t *testing.T) {
+	ac := NewAcmeClient("", "", nil)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Go(func() { ac.StartAutoRenew(time.Hour) })
+		wg.Go(func() { ac.StopAutoRenew() })
+	}
+	wg.Wait()
+
+	ac.StartAutoRenew(time.Hour)
+	stop := ac.renewStop
+	ac.StartAutoRenew(time.Minute)
+	if stop != ac.renewStop {
+		t.Fatalf("已经启动时再次 StartAutoRenew 不应重新启动")
+	}
+	ac.StopAutoRenew()
+	if nil != ac.renewStop {
+		t.Fatalf("StopAutoRenew 后应清除")
+	}
+}